@@ -13,9 +13,10 @@ type HTTPConfig struct {
 	Listen  string `json:"listen"`
 }
 
-//ESConfig for dump
+//ESConfig for dump and the statements run by the shell
 type ESConfig struct {
 	Enabled     bool   `json:"enabled"`
+	URL         string `json:"url"`
 	IndexPrefix string `json:"indexPrefix"`
 	IndexSuffix string `json:"indexSuffix"`
 }
@@ -26,12 +27,19 @@ type RedisConfig struct {
 	Server  string `json:"server"`
 }
 
+//SchemaConfig for mapping details sql can not express
+type SchemaConfig struct {
+	Nested []string                     `json:"nested"`
+	Types  map[string]map[string]string `json:"types"`
+}
+
 //GlobalConfig ...
 type GlobalConfig struct {
-	Debug bool         `json:"debug"`
-	HTTP  *HTTPConfig  `json:"http"`
-	Redis *RedisConfig `json:"redis"`
-	ES    *ESConfig    `json:"es"`
+	Debug  bool          `json:"debug"`
+	HTTP   *HTTPConfig   `json:"http"`
+	Redis  *RedisConfig  `json:"redis"`
+	ES     *ESConfig     `json:"es"`
+	Schema *SchemaConfig `json:"schema"`
 }
 
 var (
@@ -3,11 +3,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/chenyoufu/esql/g"
 	"github.com/chenyoufu/esql/serv"
+	"github.com/chenyoufu/esql/sp"
 )
 
 func main() {
@@ -17,6 +20,16 @@ func main() {
 	version := flag.Bool("v", false, "show version")
 	pretty := flag.Bool("p", false, "show pretty")
 	sql := flag.String("s", "", "sql select statement")
+	format := flag.String("t", "dsl", "translation target of -s, -f and -i: dsl, sql, esql or msearch")
+	target := flag.String("target", "", "elasticsearch version the dsl of -s, -f and -i is written for, e.g. 7.x")
+	dsl := flag.String("d", "", "elasticsearch query body to convert into sql, @file reads it from file")
+	index := flag.String("index", "index", "index name used as the FROM source of -d")
+	batch := flag.String("f", "", "file of ;-separated statements to translate, - reads stdin, more files may follow the flags")
+	ndjson := flag.Bool("ndjson", false, "write the translations of -f one json object per line")
+	outDir := flag.String("o", "", "directory where -f also writes every translation to its own file")
+	interactive := flag.Bool("i", false, "start an interactive shell, statements run against es.url of -c when enabled")
+	dryRun := flag.Bool("dry-run", false, "print the requests of the statements of -i changing documents or indices instead of running them")
+	force := flag.Bool("force", false, "run DELETE and UPDATE statements of -i without WHERE and DROP TABLE")
 	flag.Parse()
 
 	if *version {
@@ -24,13 +37,71 @@ func main() {
 		os.Exit(0)
 	}
 
+	t, err := sp.ParseTarget(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	v, err := sp.ParseVersion(*target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	loadConfig(*cfg)
+
 	if len(*sql) != 0 {
-		s := serv.CmdTranslator(*sql, *pretty)
+		s := serv.CmdTranslator(*sql, *format, v, *pretty)
 		fmt.Println(s)
 		os.Exit(0)
 	}
 
-	g.ParseConfig(*cfg)
+	if len(*batch) != 0 {
+		files := append([]string{*batch}, flag.Args()...)
+		failed, err := serv.CmdBatch(os.Stdout, files, *format, v, *ndjson, *pretty, *outDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d statements failed\n", failed)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(*dsl) != 0 {
+		body := *dsl
+		if strings.HasPrefix(body, "@") {
+			bs, err := ioutil.ReadFile(body[1:])
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			body = string(bs)
+		}
+		fmt.Println(serv.CmdReverse(*index, body, *pretty))
+		os.Exit(0)
+	}
+
+	if *interactive {
+		var client *serv.Client
+		if c := g.Config(); c != nil && c.ES != nil && c.ES.Enabled && c.ES.URL != "" {
+			client = serv.NewClient(c.ES.URL)
+			client.DryRun, client.Force, client.Version = *dryRun, *force, v
+		}
+		sh := serv.NewShell(client)
+		sh.Format, sh.Version = t, v
+		if err := sh.Run(os.Stdin, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if g.Config() == nil {
+		// the server needs its configuration, this exits on a missing file
+		g.ParseConfig(*cfg)
+	}
 	fmt.Println(g.Config())
 
 	go serv.Start()
@@ -38,3 +109,19 @@ func main() {
 	select {}
 
 }
+
+// loadConfig reads the configuration file when there is one and declares
+// its nested paths and field types in the schema, so every mode translates
+// alike.
+func loadConfig(cfg string) {
+	if _, err := os.Stat(cfg); err != nil {
+		return
+	}
+	g.ParseConfig(cfg)
+	if schema := g.Config().Schema; schema != nil {
+		sp.DefaultSchema.AddNested(schema.Nested...)
+		for index, types := range schema.Types {
+			sp.DefaultSchema.AddTypes(index, types)
+		}
+	}
+}
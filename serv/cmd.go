@@ -2,24 +2,58 @@ package serv
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 
 	simplejson "github.com/bitly/go-simplejson"
 	"github.com/chenyoufu/esql/sp"
 )
 
 //CmdTranslator return string
-func CmdTranslator(sql string, pretty bool) string {
+func CmdTranslator(sql, target string, version sp.Version, pretty bool) string {
 	m := make(map[string]interface{}, 1)
 	var bs []byte
 	var err error
 
 	m["sql"] = sql
-	dsl, err := sp.EsDsl(sql)
+	if _, err = translateInto(m, sql, target, version, nil); err != nil {
+		m["err"] = errorJSON(sql, err)
+	} else if e, ok := m["explain"].(*sp.Explanation); ok {
+		var buf strings.Builder
+		writeExplain(&buf, e)
+		return strings.TrimRight(buf.String(), "\n")
+	}
+
+	if pretty {
+		bs, err = json.MarshalIndent(m, "", "  ")
+	} else {
+		bs, err = json.Marshal(m)
+	}
+	return string(bs)
+}
+
+//CmdReverse return string
+func CmdReverse(index, dsl string, pretty bool) string {
+	m := make(map[string]interface{}, 1)
+	var bs []byte
+	var err error
+
+	js, err := simplejson.NewJson([]byte(dsl))
 	if err != nil {
 		m["err"] = err.Error()
 	} else {
-		js, _ := simplejson.NewJson([]byte(dsl))
-		m["dsl"] = js.MustMap()
+		m["dsl"] = js.Interface()
+		sql, unsupported, err := sp.DslToSQL(index, dsl)
+		if err != nil {
+			m["err"] = err.Error()
+		} else {
+			m["sql"] = sql
+			m["unsupported"] = unsupported
+		}
 	}
 
 	if pretty {
@@ -29,3 +63,238 @@ func CmdTranslator(sql string, pretty bool) string {
 	}
 	return string(bs)
 }
+
+// errorJSON returns err as an object, parse errors of src carry their
+// line and column, counted from 1, and the snippet pointing at them.
+func errorJSON(src string, err error) map[string]interface{} {
+	m := map[string]interface{}{"message": err.Error()}
+	if e, ok := err.(*sp.ParseError); ok {
+		m["line"] = e.Pos.Line + 1
+		m["col"] = e.Pos.Char + 1
+		m["snippet"] = e.Snippet(src)
+		if e.Suggestion != "" {
+			m["suggestion"] = e.Suggestion
+		}
+	}
+	return m
+}
+
+// translation is a statement translated into a target: the dsl
+// translations rewritten for the elasticsearch version, one for every
+// statement of the msearch target, or the body of the other targets.
+type translation struct {
+	target sp.Target
+	dsl    []*sp.Translation
+	body   string
+}
+
+// translateStatement translates sql into the target, params are bound to the
+// placeholders of sql, the dsl is written for the elasticsearch version.
+func translateStatement(sql string, t sp.Target, version sp.Version, params sp.Params) (*translation, error) {
+	tr := &translation{target: t}
+	switch t {
+	case sp.TargetDSL:
+		dsl, err := sp.TranslateDSLParams(sql, params)
+		if err != nil {
+			return nil, err
+		}
+		tr.dsl = []*sp.Translation{dsl}
+	case sp.TargetMSearch:
+		ts, err := sp.TranslateDSLStatements(sql, params)
+		if err != nil {
+			return nil, err
+		}
+		tr.dsl = ts
+	default:
+		body, err := sp.TranslateParams(sql, t, params)
+		if err != nil {
+			return nil, err
+		}
+		tr.body = body
+		return tr, nil
+	}
+	for _, dsl := range tr.dsl {
+		if err := dsl.ForVersion(version); err != nil {
+			return nil, err
+		}
+	}
+	return tr, nil
+}
+
+// translateInto translates sql into the target and stores the output in m,
+// under "dsl", "sql_body" or "esql", as translateStatement does. The translation is
+// returned to be run as it was printed.
+func translateInto(m map[string]interface{}, sql, target string, version sp.Version, params sp.Params) (*translation, error) {
+	t, err := sp.ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := translateStatement(sql, t, version, params)
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case sp.TargetDSL:
+		dsl := tr.dsl[0]
+		m["index"] = dsl.Index
+		switch {
+		case dsl.Join != nil:
+			m["join"] = dsl.Join
+		case dsl.Union != nil:
+			m["union"] = dsl.Union
+		case dsl.Requests != nil:
+			m["requests"] = dsl.Requests
+		default:
+			m["dsl"] = dsl.Body
+		}
+		m["columns"] = dsl.Columns
+		if len(dsl.Params) > 0 {
+			m["params"] = dsl.Params
+		}
+		if len(dsl.Warnings) > 0 {
+			m["warnings"] = dsl.Warnings
+		}
+		if dsl.Pagination != nil {
+			m["pagination"] = dsl.Pagination
+		}
+		if len(dsl.Subqueries) > 0 {
+			m["subqueries"] = dsl.Subqueries
+		}
+		if dsl.Explain != nil {
+			m["explain"] = dsl.Explain
+		}
+	case sp.TargetMSearch:
+		out, err := sp.MSearch(tr.dsl)
+		if err != nil {
+			return nil, err
+		}
+		m[t.String()] = out
+	case sp.TargetSQL:
+		js, _ := simplejson.NewJson([]byte(tr.body))
+		m["sql_body"] = js.MustMap()
+	default:
+		m[t.String()] = tr.body
+	}
+	return tr, nil
+}
+
+// outputKey returns the key of the translation stored by translateInto.
+func outputKey(t sp.Target) string {
+	switch t {
+	case sp.TargetDSL:
+		return "dsl"
+	case sp.TargetSQL:
+		return "sql_body"
+	}
+	return t.String()
+}
+
+// output returns the translation stored by translateInto, the plan of a
+// JOIN, UNION ALL or EXPLAIN statement or the requests of a statement other
+// than a select translated into dsl.
+func output(m map[string]interface{}, t sp.Target) interface{} {
+	for _, key := range []string{"explain", "join", "union", "requests"} {
+		if plan, ok := m[key]; ok {
+			return plan
+		}
+	}
+	return m[outputKey(t)]
+}
+
+// CmdBatch translates the statements of files, "-" reads stdin, into the
+// target for the elasticsearch version and writes their {sql, dsl, err} objects to w as a json array or,
+// with ndjson, one per line. When outDir is set, every translation is also
+// written to its own file. It returns the number of failed statements.
+func CmdBatch(w io.Writer, files []string, target string, version sp.Version, ndjson, pretty bool, outDir string) (int, error) {
+	t, err := sp.ParseTarget(target)
+	if err != nil {
+		return 0, err
+	}
+	var results []map[string]interface{}
+	failed := 0
+	for _, name := range files {
+		var b []byte
+		if name == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			b, err = ioutil.ReadFile(name)
+		}
+		if err != nil {
+			return 0, err
+		}
+		stmts, starts := sp.SplitStatementsPos(string(b))
+		for i, sql := range stmts {
+			m := map[string]interface{}{"file": name, "statement": i + 1, "sql": sql}
+			if _, err := translateInto(m, sql, target, version, nil); err != nil {
+				// lines and columns of the file, not of the statement
+				if e, ok := err.(*sp.ParseError); ok {
+					e.Offset(starts[i])
+				}
+				m["err"] = errorJSON(string(b), err)
+				failed++
+			} else if outDir != "" {
+				path, err := writeTranslation(outDir, name, i+1, t, m, pretty)
+				if err != nil {
+					return failed, err
+				}
+				m["output"] = path
+			}
+			results = append(results, m)
+		}
+	}
+
+	if ndjson {
+		enc := json.NewEncoder(w)
+		for _, m := range results {
+			if err := enc.Encode(m); err != nil {
+				return failed, err
+			}
+		}
+		return failed, nil
+	}
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+	var bs []byte
+	if pretty {
+		bs, err = json.MarshalIndent(results, "", "  ")
+	} else {
+		bs, err = json.Marshal(results)
+	}
+	if err != nil {
+		return failed, err
+	}
+	_, err = fmt.Fprintln(w, string(bs))
+	return failed, err
+}
+
+// writeTranslation writes the translation of the n-th statement of file
+// into dir, named after the file and n, and returns its path.
+func writeTranslation(dir, file string, n int, t sp.Target, m map[string]interface{}, pretty bool) (string, error) {
+	base := "stdin"
+	if file != "-" {
+		base = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	var bs []byte
+	var err error
+	ext := ".json"
+	switch out := output(m, t).(type) {
+	case string:
+		bs, ext = []byte(out+"\n"), "."+t.String()
+	default:
+		if pretty {
+			bs, err = json.MarshalIndent(out, "", "  ")
+		} else {
+			bs, err = json.Marshal(out)
+		}
+		bs = append(bs, '\n')
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, n, ext))
+	return path, ioutil.WriteFile(path, bs, 0644)
+}
@@ -0,0 +1,570 @@
+package serv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// Client runs translated statements against an elasticsearch cluster.
+type Client struct {
+	URL  string
+	HTTP *http.Client
+	// DryRun returns the requests of the statements changing documents or
+	// indices instead of running them.
+	DryRun bool
+	// Force runs a DELETE or UPDATE without WHERE.
+	Force bool
+	// Version is the elasticsearch version of the cluster the dsl is
+	// written for.
+	Version sp.Version
+}
+
+// NewClient returns a client of the cluster at url.
+func NewClient(url string) *Client {
+	return &Client{URL: strings.TrimRight(url, "/"), HTTP: &http.Client{Timeout: time.Minute}}
+}
+
+// Result is a set of rows returned by the cluster.
+type Result struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Run translates sql into the target and returns the rows of its response,
+// one result for every statement of the msearch target.
+func (c *Client) Run(sql string, target sp.Target, params sp.Params) ([]*Result, error) {
+	tr, err := translateStatement(sql, target, c.Version, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(tr)
+}
+
+// run runs a translation and returns the rows of its responses.
+func (c *Client) run(tr *translation) ([]*Result, error) {
+	switch tr.target {
+	case sp.TargetDSL:
+		res, err := c.Search(tr.dsl[0])
+		if err != nil {
+			return nil, err
+		}
+		return []*Result{res}, nil
+	case sp.TargetMSearch:
+		return c.MSearch(tr.dsl)
+	}
+	target, body := tr.target, tr.body
+	if target == sp.TargetESQL {
+		b, _ := json.Marshal(map[string]string{"query": body})
+		body = string(b)
+	}
+	path := map[sp.Target]string{sp.TargetSQL: "/_sql?format=json", sp.TargetESQL: "/_query"}[target]
+	resp, err := c.do("POST", path, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+	res, cursor, err := tabularResult(resp)
+	if err != nil {
+		return nil, err
+	}
+	// _sql returns the following pages of the rows with a cursor
+	for cursor != "" {
+		b, _ := json.Marshal(map[string]string{"cursor": cursor})
+		if resp, err = c.do("POST", path, b); err != nil {
+			return nil, err
+		}
+		next, nextCursor, err := tabularResult(resp)
+		if err != nil {
+			return nil, err
+		}
+		res.Rows = append(res.Rows, next.Rows...)
+		cursor = nextCursor
+	}
+	return []*Result{res}, nil
+}
+
+// Search runs a dsl translation and reads its columns from the response,
+// or from every page of a LIMIT ALL translation.
+func (c *Client) Search(t *sp.Translation) (*Result, error) {
+	if t.Join != nil {
+		return c.join(t)
+	}
+	if t.Requests != nil {
+		return c.requests(t)
+	}
+	if t.Union != nil {
+		results, err := c.MSearch([]*sp.Translation{t})
+		if err != nil {
+			return nil, err
+		}
+		return results[0], nil
+	}
+	if err := c.resolve(t); err != nil {
+		return nil, err
+	}
+	res := &Result{}
+	for _, col := range t.Columns {
+		res.Columns = append(res.Columns, col.Name)
+	}
+	if t.Pagination != nil {
+		err := c.Scan(t, func(rows [][]interface{}) error {
+			res.Rows = append(res.Rows, rows...)
+			return nil
+		})
+		return res, err
+	}
+	body, err := json.Marshal(t.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do("POST", searchPath(t, nil), body)
+	if err != nil {
+		return nil, err
+	}
+	res.Rows, err = t.Rows(resp)
+	return res, err
+}
+
+// requests runs the requests of a statement other than a select in order
+// and reads its rows from their responses, or returns the requests of a
+// statement changing documents or indices in a dry run.
+func (c *Client) requests(t *sp.Translation) (*Result, error) {
+	if err := c.resolve(t); err != nil {
+		return nil, err
+	}
+	if c.DryRun && t.Writes() {
+		res := &Result{Columns: []string{"method", "path", "body"}}
+		for _, r := range t.Requests {
+			body, err := requestBody(r)
+			if err != nil {
+				return nil, err
+			}
+			res.Rows = append(res.Rows, []interface{}{r.Method, r.Path, string(body)})
+		}
+		return res, nil
+	}
+	if err := t.Check(c.Force); err != nil {
+		return nil, err
+	}
+	var resps [][]byte
+	for _, r := range t.Requests {
+		body, err := requestBody(r)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(r.Method, r.Path, body)
+		if err != nil {
+			return nil, err
+		}
+		resps = append(resps, resp)
+	}
+	rows, err := t.RequestRows(resps)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Rows: rows}
+	for _, col := range t.Columns {
+		res.Columns = append(res.Columns, col.Name)
+	}
+	return res, nil
+}
+
+// requestBody returns the body of r, a string body is sent as is.
+func requestBody(r *sp.Request) ([]byte, error) {
+	switch b := r.Body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(b), nil
+	}
+	return json.Marshal(r.Body)
+}
+
+// resolve runs the IN subqueries of t and inlines their values into its
+// terms filters.
+func (c *Client) resolve(t *sp.Translation) error {
+	for i, q := range t.Subqueries {
+		if err := c.inline(q); err != nil {
+			return fmt.Errorf("subquery %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+// inline runs the search of q and inlines its values, a subquery reading
+// every hit is inlined page by page, so it stops at its MaxTerms values.
+func (c *Client) inline(q *sp.Subquery) error {
+	if q.Search.Pagination == nil || q.Search.Join != nil {
+		res, err := c.Search(q.Search)
+		if err != nil {
+			return err
+		}
+		return q.Inline(res.Rows)
+	}
+	if err := c.resolve(q.Search); err != nil {
+		return err
+	}
+	return c.Scan(q.Search, q.Inline)
+}
+
+// errJoinDone stops reading the hits of a join once its limit is reached.
+var errJoinDone = errors.New("join done")
+
+// join runs the hash join plan of t: every hit of the side with fewer hits
+// is read into a hash table, then the hits of the other side with the same
+// keys are joined with its rows.
+func (c *Client) join(t *sp.Translation) (*Result, error) {
+	j := t.Join
+	var counts [2]int64
+	for i, side := range j.Sides {
+		if err := c.resolve(side.Search); err != nil {
+			return nil, err
+		}
+		n, err := c.count(side.Search)
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = n
+	}
+	build := 0
+	if counts[1] < counts[0] {
+		build = 1
+	}
+	table := j.NewTable(build)
+	if err := c.Scan(j.Sides[build].Search, table.Add); err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	for _, col := range j.Columns {
+		res.Columns = append(res.Columns, col.Name)
+	}
+	if len(table.Keys()) == 0 {
+		return res, nil
+	}
+	probe := j.Sides[1-build].Filter(table.Keys())
+	err := c.Scan(probe, func(rows [][]interface{}) error {
+		res.Rows = append(res.Rows, table.Join(rows)...)
+		if j.Limit > 0 && len(res.Rows) >= j.Offset+j.Limit {
+			return errJoinDone
+		}
+		return nil
+	})
+	if err != nil && err != errJoinDone {
+		return nil, err
+	}
+	if j.Offset >= len(res.Rows) {
+		res.Rows = nil
+	} else {
+		res.Rows = res.Rows[j.Offset:]
+	}
+	if j.Limit > 0 && len(res.Rows) > j.Limit {
+		res.Rows = res.Rows[:j.Limit]
+	}
+	return res, nil
+}
+
+// count returns the number of hits of the query of t.
+func (c *Client) count(t *sp.Translation) (int64, error) {
+	body := map[string]interface{}{}
+	if q, ok := t.Body["query"]; ok {
+		body["query"] = q
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	path := strings.Replace(searchPath(t, nil), "/_search", "/_count", 1)
+	resp, err := c.do("POST", path, b)
+	if err != nil {
+		return 0, err
+	}
+	var r struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.Unmarshal(resp, &r); err != nil {
+		return 0, fmt.Errorf("invalid count response, %s", err)
+	}
+	return r.Count, nil
+}
+
+// searchPath returns the _search path of t with its url params and q.
+func searchPath(t *sp.Translation, q url.Values) string {
+	if q == nil {
+		q = url.Values{}
+	}
+	for k, v := range t.Params {
+		q.Set(k, v)
+	}
+	path := "/" + url.PathEscape(t.Index) + "/_search"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	return path
+}
+
+// page is the part of a search response driving the pagination.
+type page struct {
+	PitID    string `json:"pit_id"`
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			Sort []interface{} `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func parsePage(resp []byte) (*page, error) {
+	d := json.NewDecoder(bytes.NewReader(resp))
+	d.UseNumber()
+	p := &page{}
+	if err := d.Decode(p); err != nil {
+		return nil, fmt.Errorf("invalid search response, %s", err)
+	}
+	return p, nil
+}
+
+// Scan reads every page of a LIMIT ALL translation, calling fn with the
+// rows of each page.
+func (c *Client) Scan(t *sp.Translation, fn func(rows [][]interface{}) error) error {
+	if t.Pagination.Mode == sp.PaginationScroll {
+		return c.scroll(t, fn)
+	}
+	return c.searchAfter(t, fn)
+}
+
+// searchAfter opens a point in time of the index and reads its pages, each
+// after the sort values of the last hit of the previous one.
+func (c *Client) searchAfter(t *sp.Translation, fn func(rows [][]interface{}) error) error {
+	p := t.Pagination
+	resp, err := c.do("POST", "/"+url.PathEscape(t.Index)+"/_pit?keep_alive="+url.QueryEscape(p.KeepAlive), nil)
+	if err != nil {
+		return err
+	}
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &pit); err != nil || pit.ID == "" {
+		return fmt.Errorf("invalid point in time response, %s", resp)
+	}
+	defer func() {
+		b, _ := json.Marshal(map[string]string{"id": pit.ID})
+		c.do("DELETE", "/_pit", b)
+	}()
+
+	// a point in time search has no index in its path
+	q := url.Values{}
+	for k, v := range t.Params {
+		q.Set(k, v)
+	}
+	path := "/_search"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	body := make(map[string]interface{}, len(t.Body)+2)
+	for k, v := range t.Body {
+		body[k] = v
+	}
+	for {
+		body["pit"] = map[string]string{"id": pit.ID, "keep_alive": p.KeepAlive}
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		resp, err := c.do("POST", path, b)
+		if err != nil {
+			return err
+		}
+		pg, err := parsePage(resp)
+		if err != nil {
+			return err
+		}
+		rows, err := t.Rows(resp)
+		if err != nil {
+			return err
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+		hits := pg.Hits.Hits
+		if len(hits) < p.PageSize || len(hits) == 0 {
+			return nil
+		}
+		if pg.PitID != "" {
+			pit.ID = pg.PitID
+		}
+		body["search_after"] = hits[len(hits)-1].Sort
+	}
+}
+
+// scroll reads the pages of a scroll context until one has no hits.
+func (c *Client) scroll(t *sp.Translation, fn func(rows [][]interface{}) error) error {
+	p := t.Pagination
+	b, err := json.Marshal(t.Body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do("POST", searchPath(t, url.Values{"scroll": {p.KeepAlive}}), b)
+	if err != nil {
+		return err
+	}
+	var scrollID string
+	defer func() {
+		if scrollID != "" {
+			b, _ := json.Marshal(map[string][]string{"scroll_id": {scrollID}})
+			c.do("DELETE", "/_search/scroll", b)
+		}
+	}()
+	for {
+		pg, err := parsePage(resp)
+		if err != nil {
+			return err
+		}
+		scrollID = pg.ScrollID
+		if len(pg.Hits.Hits) == 0 {
+			return nil
+		}
+		rows, err := t.Rows(resp)
+		if err != nil {
+			return err
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+		b, _ := json.Marshal(map[string]string{"scroll": p.KeepAlive, "scroll_id": scrollID})
+		if resp, err = c.do("POST", "/_search/scroll", b); err != nil {
+			return err
+		}
+	}
+}
+
+// MSearch runs the search requests in one _msearch round trip, the rows of
+// the searches of a UNION ALL are merged into one result.
+func (c *Client) MSearch(ts []*sp.Translation) ([]*Result, error) {
+	var searches []*sp.Translation
+	for _, t := range ts {
+		searches = append(searches, t.Searches()...)
+	}
+	for _, t := range searches {
+		if err := c.resolve(t); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := sp.MSearch(ts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do("POST", "/_msearch", []byte(payload))
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	if err := json.Unmarshal(resp, &r); err != nil {
+		return nil, fmt.Errorf("invalid msearch response, %s", err)
+	}
+	if len(r.Responses) != len(searches) {
+		return nil, fmt.Errorf("invalid msearch response, %d responses for %d searches", len(r.Responses), len(searches))
+	}
+	results := make([]*Result, len(ts))
+	n := 0
+	for i, t := range ts {
+		var rows [][][]interface{}
+		for _, s := range t.Searches() {
+			res, err := searchResult(s, r.Responses[n])
+			if n++; err != nil {
+				return nil, fmt.Errorf("search %d: %s", n, err)
+			}
+			results[i] = res
+			rows = append(rows, res.Rows)
+		}
+		if t.Union != nil {
+			results[i] = &Result{Rows: t.Union.Rows(rows...)}
+			for _, col := range t.Columns {
+				results[i].Columns = append(results[i].Columns, col.Name)
+			}
+		}
+	}
+	return results, nil
+}
+
+// searchResult reads the columns of t from its search response.
+func searchResult(t *sp.Translation, resp []byte) (*Result, error) {
+	rows, err := t.Rows(resp)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Rows: rows}
+	for _, col := range t.Columns {
+		res.Columns = append(res.Columns, col.Name)
+	}
+	return res, nil
+}
+
+// Mapping returns the fields of the mapping of index.
+func (c *Client) Mapping(index string) ([]*sp.MappingField, error) {
+	resp, err := c.do("GET", "/"+url.PathEscape(index)+"/_mapping", nil)
+	if err != nil {
+		return nil, err
+	}
+	return sp.ParseMapping(resp)
+}
+
+// do sends a request with a json body and returns the response body,
+// error statuses are returned as errors.
+func (c *Client) do(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, "/_msearch") || strings.HasSuffix(path, "/_bulk") {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s, %s", method, path, resp.Status, bytes.TrimSpace(b))
+	}
+	return b, nil
+}
+
+// tabularResult reads the rows of an _sql or ES|QL response, holding
+// columns and either rows or values, and the cursor of the next _sql page.
+func tabularResult(resp []byte) (*Result, string, error) {
+	d := json.NewDecoder(bytes.NewReader(resp))
+	d.UseNumber()
+	var r struct {
+		Columns []struct {
+			Name string `json:"name"`
+		} `json:"columns"`
+		Rows   [][]interface{} `json:"rows"`
+		Values [][]interface{} `json:"values"`
+		Cursor string          `json:"cursor"`
+	}
+	if err := d.Decode(&r); err != nil {
+		return nil, "", fmt.Errorf("invalid response, %s", err)
+	}
+	res := &Result{Rows: append(r.Rows, r.Values...)}
+	for _, c := range r.Columns {
+		res.Columns = append(res.Columns, c.Name)
+	}
+	return res, r.Cursor, nil
+}
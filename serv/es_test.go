@@ -0,0 +1,319 @@
+package serv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// fakeES is a cluster answering the requests of a method and path with
+// canned responses, in order, and recording the requests it was sent.
+// A response starting with ! is an error status.
+type fakeES struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses map[string][]string
+	requests  []string
+	bodies    []string
+}
+
+func newFakeES(t *testing.T, responses map[string][]string) *fakeES {
+	es := &fakeES{responses: responses}
+	es.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		es.mu.Lock()
+		defer es.mu.Unlock()
+		b, _ := ioutil.ReadAll(r.Body)
+		es.requests = append(es.requests, r.Method+" "+r.URL.RequestURI())
+		es.bodies = append(es.bodies, string(b))
+		key := r.Method + " " + r.URL.Path
+		queue := es.responses[key]
+		if len(queue) == 0 {
+			t.Errorf("unexpected request %s %s", key, b)
+			http.Error(w, "unexpected request", http.StatusNotFound)
+			return
+		}
+		resp := queue[0]
+		es.responses[key] = queue[1:]
+		if strings.HasPrefix(resp, "!") {
+			http.Error(w, resp[1:], http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, resp)
+	}))
+	t.Cleanup(es.Close)
+	return es
+}
+
+// hits returns a search response of the sources, sorted on their position.
+func hits(extra string, sources ...string) string {
+	var items []string
+	for i, src := range sources {
+		items = append(items, fmt.Sprintf(`{"_source": %s, "sort": [%d]}`, src, i))
+	}
+	return fmt.Sprintf(`{%s"hits": {"hits": [%s]}}`, extra, strings.Join(items, ", "))
+}
+
+// Ensure LIMIT ALL reads every page of a point in time and closes it, even on error.
+func TestClient_SearchAfter(t *testing.T) {
+	var tests = []struct {
+		pages    []string
+		rows     [][]interface{}
+		requests []string
+		err      string
+	}{
+		{
+			pages: []string{hits(`"pit_id": "p2", `, `{"name": "a"}`, `{"name": "b"}`), hits(``, `{"name": "c"}`)},
+			rows:  [][]interface{}{{"a"}, {"b"}, {"c"}},
+			requests: []string{
+				"POST /symbol/_pit?keep_alive=1m {}",
+				`POST /_search {"pit":{"id":"p1","keep_alive":"1m"},"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nyse"}}}}}},"size":2,"sort":[{"_shard_doc":"asc"}]}`,
+				`POST /_search {"pit":{"id":"p2","keep_alive":"1m"},"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nyse"}}}}}},"search_after":[1],"size":2,"sort":[{"_shard_doc":"asc"}]}`,
+				`DELETE /_pit {"id":"p2"}`,
+			},
+		},
+		{
+			pages: []string{hits(``, `{"name": "a"}`, `{"name": "b"}`), hits(``)},
+			rows:  [][]interface{}{{"a"}, {"b"}},
+		},
+		{
+			pages: []string{hits(``, `{"name": "a"}`, `{"name": "b"}`), "!search_context_missing_exception"},
+			err:   `POST /_search: 500 Internal Server Error, search_context_missing_exception`,
+		},
+	}
+
+	for i, tt := range tests {
+		es := newFakeES(t, map[string][]string{
+			"POST /symbol/_pit": {`{"id": "p1"}`},
+			"POST /_search":     tt.pages,
+			"DELETE /_pit":      {`{"succeeded": true}`},
+		})
+		res, err := NewClient(es.URL).Run(`select /*+ page_size(2) */ name from symbol where exchange = 'nyse' limit all`, sp.TargetDSL, nil)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(res[0].Rows, tt.rows) {
+			t.Errorf("%d. rows mismatch:\n  exp=%v\n  got=%v", i, tt.rows, res[0].Rows)
+		}
+		if last := es.requests[len(es.requests)-1]; !strings.HasPrefix(last, "DELETE /_pit") {
+			t.Errorf("%d. point in time not closed, last request %s", i, last)
+		}
+		if tt.requests != nil {
+			if requests := es.log(); !reflect.DeepEqual(requests, tt.requests) {
+				t.Errorf("%d. requests mismatch:\n\nexp=%q\n\ngot=%q", i, tt.requests, requests)
+			}
+		}
+	}
+}
+
+// Ensure LIMIT ALL reads every page of a scroll before 7.x and clears it, even on error.
+func TestClient_Scroll(t *testing.T) {
+	var tests = []struct {
+		pages    []string
+		rows     [][]interface{}
+		requests []string
+		err      string
+	}{
+		{
+			pages: []string{hits(`"_scroll_id": "s2", `, `{"name": "c"}`), hits(`"_scroll_id": "s3", `)},
+			rows:  [][]interface{}{{"a"}, {"b"}, {"c"}},
+			requests: []string{
+				`POST /symbol/_search?scroll=1m {"size":2,"sort":[{"_doc":"asc"}]}`,
+				`POST /_search/scroll {"scroll":"1m","scroll_id":"s1"}`,
+				`POST /_search/scroll {"scroll":"1m","scroll_id":"s2"}`,
+				`DELETE /_search/scroll {"scroll_id":["s3"]}`,
+			},
+		},
+		{
+			pages: []string{"!search_context_missing_exception"},
+			err:   `POST /_search/scroll: 500 Internal Server Error, search_context_missing_exception`,
+			requests: []string{
+				`POST /symbol/_search?scroll=1m {"size":2,"sort":[{"_doc":"asc"}]}`,
+				`POST /_search/scroll {"scroll":"1m","scroll_id":"s1"}`,
+				`DELETE /_search/scroll {"scroll_id":["s1"]}`,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		es := newFakeES(t, map[string][]string{
+			"POST /symbol/_search":   {hits(`"_scroll_id": "s1", `, `{"name": "a"}`, `{"name": "b"}`)},
+			"POST /_search/scroll":   tt.pages,
+			"DELETE /_search/scroll": {`{"succeeded": true}`},
+		})
+		c := NewClient(es.URL)
+		c.Version = sp.Version6
+		res, err := c.Run(`select /*+ page_size(2) */ name from symbol limit all`, sp.TargetDSL, nil)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(res[0].Rows, tt.rows) {
+			t.Errorf("%d. rows mismatch:\n  exp=%v\n  got=%v", i, tt.rows, res[0].Rows)
+		}
+		if requests := es.log(); !reflect.DeepEqual(requests, tt.requests) {
+			t.Errorf("%d. requests mismatch:\n\nexp=%q\n\ngot=%q", i, tt.requests, requests)
+		}
+	}
+}
+
+// Ensure joins read the smaller side into a hash table within its limits and probe the other one.
+func TestClient_Join(t *testing.T) {
+	symbols := hits(``, `{"name": "AAPL", "sector": "tech"}`, `{"name": "XOM", "sector": "energy"}`)
+	quotes := hits(``, `{"symbol": "AAPL", "close": 1}`, `{"symbol": "AAPL", "close": 2}`, `{"symbol": "IBM", "close": 3}`)
+	var tests = []struct {
+		sql      string
+		searches []string
+		rows     [][]interface{}
+		requests []string
+		err      string
+	}{
+		{
+			sql:      `select q.close, s.sector from quote q join symbol s on q.symbol = s.name`,
+			searches: []string{symbols, quotes},
+			rows:     [][]interface{}{{"1", "tech"}, {"2", "tech"}},
+			requests: []string{
+				`POST /quote/_count {}`,
+				`POST /symbol/_count {}`,
+				`POST /symbol/_pit?keep_alive=1m {}`,
+				`POST /_search {"pit":{"id":"p1","keep_alive":"1m"},"size":1000,"sort":[{"_shard_doc":"asc"}]}`,
+				`DELETE /_pit {"id":"p1"}`,
+				`POST /quote/_pit?keep_alive=1m {}`,
+				`POST /_search {"pit":{"id":"p1","keep_alive":"1m"},"query":{"bool":{"filter":[{"terms":{"symbol":["AAPL","XOM"]}}]}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`,
+				`DELETE /_pit {"id":"p1"}`,
+			},
+		},
+		{
+			sql:      `select q.close, s.sector from quote q join symbol s on q.symbol = s.name limit 1`,
+			searches: []string{symbols, quotes},
+			rows:     [][]interface{}{{"1", "tech"}},
+		},
+		{
+			sql:      `select /*+ join_max_rows(1) */ q.close, s.sector from quote q join symbol s on q.symbol = s.name`,
+			searches: []string{symbols},
+			err:      `join side s has more than 1 rows, raise the join_max_rows hint`,
+		},
+		{
+			sql:      `select /*+ join_max_memory(20b) */ q.close, s.sector from quote q join symbol s on q.symbol = s.name`,
+			searches: []string{symbols},
+			err:      `join side s holds more than 20 bytes, raise the join_max_memory hint`,
+		},
+	}
+
+	for i, tt := range tests {
+		es := newFakeES(t, map[string][]string{
+			"POST /quote/_count":  {`{"count": 3}`},
+			"POST /symbol/_count": {`{"count": 2}`},
+			"POST /symbol/_pit":   {`{"id": "p1"}`},
+			"POST /quote/_pit":    {`{"id": "p1"}`},
+			"POST /_search":       tt.searches,
+			"DELETE /_pit":        {`{}`, `{}`},
+		})
+		res, err := NewClient(es.URL).Run(tt.sql, sp.TargetDSL, nil)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s", i, tt.sql, tt.err, err)
+			continue
+		} else if err == nil && !reflect.DeepEqual(rowStrings(res[0].Rows), tt.rows) {
+			t.Errorf("%d. %s: rows mismatch:\n  exp=%v\n  got=%v", i, tt.sql, tt.rows, res[0].Rows)
+		}
+		if last := es.requests[len(es.requests)-1]; !strings.HasPrefix(last, "DELETE /_pit") {
+			t.Errorf("%d. %s: point in time not closed, last request %s", i, tt.sql, last)
+		}
+		if tt.requests != nil {
+			if requests := es.log(); !reflect.DeepEqual(requests, tt.requests) {
+				t.Errorf("%d. %s: requests mismatch:\n\nexp=%q\n\ngot=%q", i, tt.sql, tt.requests, requests)
+			}
+		}
+	}
+}
+
+// Ensure the pages of a subquery are inlined until it has more values than its limit.
+func TestClient_Subquery(t *testing.T) {
+	var tests = []struct {
+		sql      string
+		searches []string
+		rows     [][]interface{}
+		requests []string
+		err      string
+	}{
+		{
+			sql:      `select name from users where uid in (select /*+ page_size(2) */ user_id from vips) limit 10`,
+			searches: []string{hits(``, `{"user_id": "u1"}`, `{"user_id": "u2"}`), hits(``, `{"user_id": "u3"}`), hits(``, `{"name": "a"}`)},
+			rows:     [][]interface{}{{"a"}},
+			requests: []string{
+				`POST /vips/_pit?keep_alive=1m {}`,
+				`POST /_search {"pit":{"id":"p1","keep_alive":"1m"},"size":2,"sort":[{"_shard_doc":"asc"}]}`,
+				`POST /_search {"pit":{"id":"p1","keep_alive":"1m"},"search_after":[1],"size":2,"sort":[{"_shard_doc":"asc"}]}`,
+				`DELETE /_pit {"id":"p1"}`,
+				`POST /users/_search {"from":0,"query":{"bool":{"filter":{"and":[{"terms":{"uid":["u1","u2","u3"]}}]}}},"size":10,"sort":[]}`,
+			},
+		},
+		{
+			sql:      `select /*+ subquery_max_terms(2) */ name from users where uid in (select /*+ page_size(2) */ user_id from vips) limit 10`,
+			searches: []string{hits(``, `{"user_id": "u1"}`, `{"user_id": "u2"}`), hits(``, `{"user_id": "u3"}`)},
+			err:      `subquery 1: subquery of uid has more than 2 values, raise the subquery_max_terms hint and index.max_terms_count`,
+			requests: []string{
+				`POST /vips/_pit?keep_alive=1m {}`,
+				`POST /_search {"pit":{"id":"p1","keep_alive":"1m"},"size":2,"sort":[{"_shard_doc":"asc"}]}`,
+				`POST /_search {"pit":{"id":"p1","keep_alive":"1m"},"search_after":[1],"size":2,"sort":[{"_shard_doc":"asc"}]}`,
+				`DELETE /_pit {"id":"p1"}`,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		es := newFakeES(t, map[string][]string{
+			"POST /vips/_pit":     {`{"id": "p1"}`},
+			"POST /_search":       tt.searches[:2],
+			"DELETE /_pit":        {`{}`},
+			"POST /users/_search": tt.searches[2:],
+		})
+		res, err := NewClient(es.URL).Run(tt.sql, sp.TargetDSL, nil)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s", i, tt.sql, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(res[0].Rows, tt.rows) {
+			t.Errorf("%d. %s: rows mismatch:\n  exp=%v\n  got=%v", i, tt.sql, tt.rows, res[0].Rows)
+		}
+		if requests := es.log(); !reflect.DeepEqual(requests, tt.requests) {
+			t.Errorf("%d. %s: requests mismatch:\n\nexp=%q\n\ngot=%q", i, tt.sql, tt.requests, requests)
+		}
+	}
+}
+
+// log returns the requests sent, each followed by its body.
+func (es *fakeES) log() []string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	var log []string
+	for i, r := range es.requests {
+		b := es.bodies[i]
+		if b == "" {
+			b = "{}"
+		}
+		log = append(log, r+" "+b)
+	}
+	return log
+}
+
+// rowStrings returns the rows with their values printed.
+func rowStrings(rows [][]interface{}) [][]interface{} {
+	var printed [][]interface{}
+	for _, row := range rows {
+		var values []interface{}
+		for _, v := range row {
+			values = append(values, fmt.Sprint(v))
+		}
+		printed = append(printed, values)
+	}
+	return printed
+}
+
+func errstring(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
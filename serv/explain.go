@@ -0,0 +1,52 @@
+package serv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// writeExplain prints an explanation as a tree, the statements of a JOIN
+// or UNION ALL below their plan.
+func writeExplain(w io.Writer, e *sp.Explanation) {
+	writeExplainNode(w, e, "")
+}
+
+func writeExplainNode(w io.Writer, e *sp.Explanation, indent string) {
+	fmt.Fprintf(w, "%s%s %s\n", indent, e.Plan, e.Index)
+	indent += "  "
+	fmt.Fprintf(w, "%s%s\n", indent, e.Statement)
+	if len(e.Predicates) > 0 {
+		fmt.Fprintf(w, "%swhere\n", indent)
+		for _, p := range e.Predicates {
+			fmt.Fprintf(w, "%s  %s: %s", indent, p.Condition, p.Strategy)
+			if p.Query != p.Strategy {
+				fmt.Fprintf(w, " %s", p.Query)
+			}
+			if p.Path != "" {
+				fmt.Fprintf(w, " in %s", p.Path)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if len(e.Aggregations) > 0 {
+		fmt.Fprintf(w, "%saggregations\n", indent)
+		for _, a := range e.Aggregations {
+			fmt.Fprintf(w, "%s%s%s: %s, path %s", indent, strings.Repeat("  ", a.Level), a.Name, a.Type, a.Path)
+			if a.BucketsPath != nil {
+				b, _ := json.Marshal(a.BucketsPath)
+				fmt.Fprintf(w, ", buckets_path %s", b)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	for _, warning := range e.Warnings {
+		fmt.Fprintf(w, "%swarning: %s\n", indent, warning)
+	}
+	for _, sub := range e.Statements {
+		writeExplainNode(w, sub, indent)
+	}
+}
@@ -1,6 +1,7 @@
 package serv
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -8,7 +9,6 @@ import (
 
 	"io/ioutil"
 
-	simplejson "github.com/bitly/go-simplejson"
 	"github.com/chenyoufu/esql/g"
 	"github.com/chenyoufu/esql/sp"
 	"github.com/toolkits/file"
@@ -37,6 +37,9 @@ func translate(w http.ResponseWriter, r *http.Request) {
 	pretty = r.URL.Query().Get("sql")
 
 	var sql string
+	var params sp.Params
+	target := r.URL.Query().Get("target")
+	version := r.URL.Query().Get("version")
 	switch r.Method {
 	case "GET":
 		sql = r.URL.Query().Get("sql")
@@ -52,16 +55,30 @@ func translate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		sql = string(body)
+		if req, ok := parseRequest(body); ok {
+			sql = req.SQL
+			if req.Target != "" {
+				target = req.Target
+			}
+			if req.Version != "" {
+				version = req.Version
+			}
+			if params, err = req.params(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 	}
 
 	m["sql"] = sql
 
-	dsl, err := sp.EsDsl(sql)
+	v, err := sp.ParseVersion(version)
 	if err != nil {
-		m["err"] = err.Error()
-	} else {
-		js, _ := simplejson.NewJson([]byte(dsl))
-		m["dsl"] = js.MustMap()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := translateInto(m, sql, target, v, params); err != nil {
+		m["err"] = errorJSON(sql, err)
 	}
 
 	if pretty == "1" {
@@ -72,6 +89,50 @@ func translate(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// request is the json body of a translation request, params is either an
+// object binding :name placeholders or an array binding ? placeholders.
+type request struct {
+	SQL    string          `json:"sql"`
+	Params json.RawMessage `json:"params"`
+	Target string          `json:"target"`
+	// Version is the elasticsearch version the dsl is written for, e.g. 7.x.
+	Version string `json:"version"`
+}
+
+// parseRequest decodes a json request body, a plain sql body is not one.
+func parseRequest(body []byte) (*request, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return nil, false
+	}
+	req := &request{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, false
+	}
+	return req, true
+}
+
+func (req *request) params() (sp.Params, error) {
+	if len(req.Params) == 0 {
+		return nil, nil
+	}
+	d := json.NewDecoder(bytes.NewReader(req.Params))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid params, %s", err)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return sp.Params(v), nil
+	case []interface{}:
+		return sp.PositionalParams(v...), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("invalid params, expected an object or an array")
+}
+
 func configRoutes() {
 	http.HandleFunc("/", translate)
 
@@ -0,0 +1,246 @@
+package serv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// errInterrupt is returned by readLine when ctrl-c cancels the line.
+var errInterrupt = errors.New("interrupt")
+
+// lineEditor reads lines from a terminal with emacs style editing keys,
+// history and tab completion, or plain lines when input is not a terminal.
+type lineEditor struct {
+	fd int
+	// terminal is set when fd is a terminal, whose lines are edited.
+	terminal bool
+	in       *bufio.Reader
+	out      io.Writer
+	history  []string
+	// complete returns the candidates replacing word, the text before the
+	// cursor back to the previous separator.
+	complete func(word string) []string
+}
+
+// lineState is the line being edited.
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+	// hist is the history entry shown, len(history) for the new line.
+	hist    int
+	pending []rune
+}
+
+// readLine prints prompt and returns the line entered, io.EOF on ctrl-d
+// with an empty line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	var restore func()
+	var err error
+	if e.terminal {
+		restore, err = makeRaw(e.fd)
+	}
+	if !e.terminal || err != nil {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer restore()
+	return e.edit(prompt)
+}
+
+// edit reads the keys of a line from a terminal in raw mode.
+func (e *lineEditor) edit(prompt string) (string, error) {
+	s := &lineState{prompt: prompt, hist: len(e.history)}
+	fmt.Fprint(e.out, prompt)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\n")
+			return string(s.buf), nil
+		case 3: // ctrl-c
+			fmt.Fprint(e.out, "^C\n")
+			return "", errInterrupt
+		case 4: // ctrl-d
+			if len(s.buf) == 0 {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			}
+			s.delete()
+		case 1: // ctrl-a
+			s.pos = 0
+		case 5: // ctrl-e
+			s.pos = len(s.buf)
+		case 2: // ctrl-b
+			s.move(-1)
+		case 6: // ctrl-f
+			s.move(1)
+		case 127, 8: // backspace
+			if s.pos > 0 {
+				s.pos--
+				s.delete()
+			}
+		case 11: // ctrl-k
+			s.buf = s.buf[:s.pos]
+		case 21: // ctrl-u
+			s.buf = append([]rune{}, s.buf[s.pos:]...)
+			s.pos = 0
+		case 23: // ctrl-w
+			start := s.pos
+			for start > 0 && unicode.IsSpace(s.buf[start-1]) {
+				start--
+			}
+			start = wordStart(s.buf, start, unicode.IsSpace)
+			s.buf = append(s.buf[:start], s.buf[s.pos:]...)
+			s.pos = start
+		case 12: // ctrl-l
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // ctrl-p
+			e.showHistory(s, -1)
+		case 14: // ctrl-n
+			e.showHistory(s, 1)
+		case '\t':
+			e.completeWord(s)
+		case 27:
+			e.escape(s)
+		default:
+			if unicode.IsPrint(r) {
+				s.buf = append(s.buf[:s.pos], append([]rune{r}, s.buf[s.pos:]...)...)
+				s.pos++
+			}
+		}
+		e.refresh(s)
+	}
+}
+
+// escape handles the ansi sequences of the arrow, home, end and delete keys.
+func (e *lineEditor) escape(s *lineState) {
+	r, _, _ := e.in.ReadRune()
+	if r != '[' && r != 'O' {
+		return
+	}
+	r, _, _ = e.in.ReadRune()
+	if r >= '0' && r <= '9' {
+		if t, _, _ := e.in.ReadRune(); t != '~' {
+			return
+		}
+	}
+	switch r {
+	case 'A':
+		e.showHistory(s, -1)
+	case 'B':
+		e.showHistory(s, 1)
+	case 'C':
+		s.move(1)
+	case 'D':
+		s.move(-1)
+	case 'H', '1', '7':
+		s.pos = 0
+	case 'F', '4', '8':
+		s.pos = len(s.buf)
+	case '3':
+		s.delete()
+	}
+}
+
+func (s *lineState) move(n int) {
+	if p := s.pos + n; p >= 0 && p <= len(s.buf) {
+		s.pos = p
+	}
+}
+
+// delete removes the rune under the cursor.
+func (s *lineState) delete() {
+	if s.pos < len(s.buf) {
+		s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+	}
+}
+
+// showHistory replaces the line by the history entry dir away from the shown one.
+func (e *lineEditor) showHistory(s *lineState, dir int) {
+	i := s.hist + dir
+	if i < 0 || i > len(e.history) {
+		return
+	}
+	if s.hist == len(e.history) {
+		s.pending = append([]rune{}, s.buf...)
+	}
+	s.hist = i
+	if i == len(e.history) {
+		s.buf = append([]rune{}, s.pending...)
+	} else {
+		s.buf = []rune(e.history[i])
+	}
+	s.pos = len(s.buf)
+}
+
+// completeWord completes the word before the cursor, listing the candidates
+// when they share no longer prefix.
+func (e *lineEditor) completeWord(s *lineState) {
+	if e.complete == nil {
+		return
+	}
+	start := wordStart(s.buf, s.pos, isWordSeparator)
+	word := string(s.buf[start:s.pos])
+	cands := e.complete(word)
+	if len(cands) == 0 {
+		return
+	}
+	repl := commonPrefix(cands)
+	if len(cands) == 1 && !strings.HasSuffix(repl, "(") {
+		repl += " "
+	}
+	if len([]rune(repl)) <= len([]rune(word)) {
+		fmt.Fprintf(e.out, "\n%s\n", strings.Join(cands, "  "))
+		return
+	}
+	tail := append([]rune(repl), s.buf[s.pos:]...)
+	s.buf = append(s.buf[:start], tail...)
+	s.pos = start + len([]rune(repl))
+}
+
+// refresh redraws the line and places the cursor.
+func (e *lineEditor) refresh(s *lineState) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", s.prompt, string(s.buf))
+	if back := len(s.buf) - s.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// wordStart returns the start of the word ending at pos, words are
+// delimited by the runes for which sep returns true.
+func wordStart(buf []rune, pos int, sep func(rune) bool) int {
+	i := pos
+	for i > 0 && !sep(buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func isWordSeparator(r rune) bool {
+	return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '@' || r == '\\')
+}
+
+// commonPrefix returns the longest prefix of all of a.
+func commonPrefix(a []string) string {
+	prefix := []rune(a[0])
+	for _, s := range a[1:] {
+		r := []rune(s)
+		n := 0
+		for n < len(prefix) && n < len(r) && prefix[n] == r[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
@@ -0,0 +1,392 @@
+package serv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// Shell is the interactive shell started by esql -i. Statements end with
+// ";" and may span lines, lines starting with \ are meta-commands.
+type Shell struct {
+	// Client runs the statements, nil only prints their translation.
+	Client *Client
+	// HistoryFile keeps the entered lines across sessions, "" disables it.
+	HistoryFile string
+	// Format is the translation target of the statements.
+	Format sp.Target
+	// Version is the elasticsearch version the dsl is written for.
+	Version sp.Version
+
+	pretty  bool
+	dsl     bool
+	explain bool
+	index   string
+	fields  []string
+
+	ed  *lineEditor
+	out io.Writer
+}
+
+// NewShell returns a shell running statements with client, which may be nil.
+func NewShell(client *Client) *Shell {
+	sh := &Shell{Client: client, pretty: true}
+	if home, err := os.UserHomeDir(); err == nil {
+		sh.HistoryFile = filepath.Join(home, ".esql_history")
+	}
+	return sh
+}
+
+var metaCommands = []string{`\dryrun`, `\dsl`, `\explain`, `\format`, `\help`, `\index`, `\pretty`, `\quit`, `\target`}
+
+const shellHelp = `statements end with ";" and may span lines, ctrl-c cancels one
+  \dsl              toggle printing the translation of the statements run
+  \dryrun           toggle printing the requests of DELETE and UPDATE instead of running them
+  \pretty           toggle indented json
+  \target [version] show or set the elasticsearch version of the dsl, e.g. 7.x
+  \format [name]    show or set the translation: dsl, sql, esql or msearch
+  \explain          toggle printing the index, columns and warnings of translations
+  \index [name]     show or load the mapping of an index for completion
+  \index name @file load the mapping of an index from a get mapping response file
+  \quit             exit, as does ctrl-d
+`
+
+// Run reads statements from in until ctrl-d or \quit, prompting and
+// editing lines when in is a terminal.
+func (sh *Shell) Run(in io.Reader, out io.Writer) error {
+	sh.out = out
+	sh.ed = &lineEditor{in: bufio.NewReader(in), out: out, complete: sh.complete}
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		sh.ed.fd, sh.ed.terminal = int(f.Fd()), true
+	}
+	sh.loadHistory()
+	terminal := sh.ed.terminal
+	if terminal {
+		fmt.Fprintf(out, "esql shell, format %s, target %s, \\help for help\n", sh.Format, sh.target())
+		if sh.Client == nil {
+			fmt.Fprintln(out, "no elasticsearch url configured, statements are translated only")
+		}
+	}
+
+	var lines []string
+	for {
+		prompt := ""
+		if terminal {
+			prompt = "esql> "
+			if len(lines) > 0 {
+				prompt = "   -> "
+			}
+		}
+		line, err := sh.ed.readLine(prompt)
+		switch err {
+		case nil:
+		case errInterrupt:
+			lines = nil
+			continue
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(line), `\`) {
+			sh.addHistory(line)
+			if quit := sh.meta(strings.Fields(line)); quit {
+				return nil
+			}
+			continue
+		}
+		lines = append(lines, line)
+		stmt := strings.TrimSpace(strings.Join(lines, "\n"))
+		if stmt == "" {
+			lines = nil
+			continue
+		}
+		if !strings.HasSuffix(stmt, ";") {
+			continue
+		}
+		lines = nil
+		sh.addHistory(stmt)
+		if sh.Format == sp.TargetMSearch {
+			sh.exec(stmt)
+			continue
+		}
+		for _, s := range sp.SplitStatements(stmt) {
+			sh.exec(s)
+		}
+	}
+}
+
+// meta runs a meta-command, it returns true to quit the shell.
+func (sh *Shell) meta(args []string) bool {
+	switch args[0] {
+	case `\q`, `\quit`:
+		return true
+	case `\h`, `\help`, `\?`:
+		fmt.Fprint(sh.out, shellHelp)
+	case `\dsl`:
+		sh.dsl = !sh.dsl
+		fmt.Fprintf(sh.out, "translation output is %s\n", onOff(sh.dsl))
+	case `\dryrun`:
+		if sh.Client == nil {
+			fmt.Fprintln(sh.out, "no elasticsearch url configured, statements are translated only")
+			return false
+		}
+		sh.Client.DryRun = !sh.Client.DryRun
+		fmt.Fprintf(sh.out, "dry run is %s\n", onOff(sh.Client.DryRun))
+	case `\pretty`:
+		sh.pretty = !sh.pretty
+		fmt.Fprintf(sh.out, "pretty output is %s\n", onOff(sh.pretty))
+	case `\explain`:
+		sh.explain = !sh.explain
+		fmt.Fprintf(sh.out, "explain output is %s\n", onOff(sh.explain))
+	case `\target`:
+		if len(args) > 1 {
+			v, err := sp.ParseVersion(args[1])
+			if err != nil {
+				fmt.Fprintf(sh.out, "ERROR: %s\n", err)
+				return false
+			}
+			sh.Version = v
+			if sh.Client != nil {
+				sh.Client.Version = v
+			}
+		}
+		fmt.Fprintf(sh.out, "target is %s\n", sh.target())
+	case `\format`:
+		if len(args) > 1 {
+			t, err := sp.ParseTarget(args[1])
+			if err != nil {
+				fmt.Fprintf(sh.out, "ERROR: %s\n", err)
+				return false
+			}
+			sh.Format = t
+		}
+		fmt.Fprintf(sh.out, "format is %s\n", sh.Format)
+	case `\index`:
+		sh.loadIndex(args[1:])
+	default:
+		msg := fmt.Sprintf("unknown command %s", args[0])
+		if s := closestCommand(args[0]); s != "" {
+			msg += fmt.Sprintf(", did you mean %s?", s)
+		}
+		fmt.Fprintf(sh.out, "ERROR: %s, \\help lists the commands\n", msg)
+	}
+	return false
+}
+
+// target returns the elasticsearch version of the dsl, none when the dsl
+// is not rewritten for one.
+func (sh *Shell) target() string {
+	if sh.Version == 0 {
+		return "none"
+	}
+	return sh.Version.String()
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func closestCommand(name string) string {
+	for _, c := range metaCommands {
+		if strings.HasPrefix(c, name) {
+			return c
+		}
+	}
+	return ""
+}
+
+// loadIndex loads the mapping of an index from the cluster or a file, its
+// fields are completed and its nested fields and field types are declared
+// in the schema.
+func (sh *Shell) loadIndex(args []string) {
+	if len(args) == 0 {
+		if sh.index == "" {
+			fmt.Fprintln(sh.out, "no index loaded")
+		} else {
+			fmt.Fprintf(sh.out, "index %s, %d fields\n", sh.index, len(sh.fields))
+		}
+		return
+	}
+	var fields []*sp.MappingField
+	var err error
+	switch {
+	case len(args) > 1 && strings.HasPrefix(args[1], "@"):
+		var b []byte
+		if b, err = ioutil.ReadFile(args[1][1:]); err == nil {
+			fields, err = sp.ParseMapping(b)
+		}
+	case sh.Client == nil:
+		err = fmt.Errorf("no elasticsearch url configured, use \\index %s @file", args[0])
+	default:
+		fields, err = sh.Client.Mapping(args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(sh.out, "ERROR: %s\n", err)
+		return
+	}
+	sh.index = args[0]
+	sh.fields = sh.fields[:0]
+	for _, f := range fields {
+		sh.fields = append(sh.fields, f.Name)
+	}
+	sp.DefaultSchema.AddNested(sp.NestedPaths(fields)...)
+	sp.DefaultSchema.AddTypes(sh.index, sp.MappingTypes(fields))
+	fmt.Fprintf(sh.out, "index %s, %d fields\n", sh.index, len(sh.fields))
+}
+
+// exec translates a statement, or all of them for the msearch target, and
+// runs the translation when the shell has a client.
+func (sh *Shell) exec(sql string) {
+	m := make(map[string]interface{})
+	tr, err := translateInto(m, sql, sh.Format.String(), sh.Version, nil)
+	if err != nil {
+		sh.printError(sql, err)
+		return
+	}
+	if e, ok := m["explain"].(*sp.Explanation); ok {
+		writeExplain(sh.out, e)
+		return
+	}
+	switch {
+	case sh.explain:
+		sh.printJSON(m)
+	case sh.Client == nil || sh.dsl:
+		switch out := output(m, sh.Format).(type) {
+		case string:
+			fmt.Fprintln(sh.out, strings.TrimRight(out, "\n"))
+		default:
+			sh.printJSON(out)
+		}
+	}
+	if sh.Client == nil {
+		return
+	}
+	// run what was printed, translating again may lift other params
+	results, err := sh.Client.run(tr)
+	if err != nil {
+		sh.printError(sql, err)
+		return
+	}
+	for _, res := range results {
+		writeTable(sh.out, res)
+	}
+}
+
+func (sh *Shell) printJSON(v interface{}) {
+	var b []byte
+	if sh.pretty {
+		b, _ = json.MarshalIndent(v, "", "  ")
+	} else {
+		b, _ = json.Marshal(v)
+	}
+	fmt.Fprintln(sh.out, string(b))
+}
+
+func (sh *Shell) printError(sql string, err error) {
+	fmt.Fprintf(sh.out, "ERROR: %s\n", err)
+	if e, ok := err.(*sp.ParseError); ok {
+		if snippet := e.Snippet(sql); snippet != "" {
+			fmt.Fprintln(sh.out, snippet)
+		}
+	}
+}
+
+// complete returns the meta-commands, keywords, functions and fields of
+// the loaded index starting with word, keywords in the case of word.
+func (sh *Shell) complete(word string) []string {
+	if word == "" {
+		return nil
+	}
+	if strings.HasPrefix(word, `\`) {
+		return prefixed(metaCommands, word)
+	}
+	keywords := sp.Keywords()
+	if strings.ToLower(word[:1]) == word[:1] {
+		for i, k := range keywords {
+			keywords[i] = strings.ToLower(k)
+		}
+	}
+	var functions []string
+	for _, f := range sp.FunctionNames() {
+		functions = append(functions, f+"(")
+	}
+	var cands []string
+	for _, list := range [][]string{keywords, functions, sh.fields} {
+		cands = append(cands, prefixed(list, word)...)
+	}
+	sort.Strings(cands)
+	return cands
+}
+
+// prefixed returns the items of a starting with prefix, ignoring case.
+func prefixed(a []string, prefix string) []string {
+	var items []string
+	for _, s := range a {
+		if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// maxHistory is the number of history lines loaded from the history file.
+const maxHistory = 1000
+
+func (sh *Shell) loadHistory() {
+	if sh.HistoryFile == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(sh.HistoryFile)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	for _, line := range lines {
+		// statements spanning lines are stored quoted
+		if stmt, err := strconv.Unquote(line); err == nil && strings.HasPrefix(line, `"`) {
+			line = stmt
+		}
+		if line != "" {
+			sh.ed.history = append(sh.ed.history, line)
+		}
+	}
+}
+
+// addHistory appends line to the history and the history file.
+func (sh *Shell) addHistory(line string) {
+	line = strings.TrimSpace(line)
+	h := sh.ed.history
+	if line == "" || (len(h) > 0 && h[len(h)-1] == line) {
+		return
+	}
+	sh.ed.history = append(h, line)
+	if sh.HistoryFile == "" {
+		return
+	}
+	f, err := os.OpenFile(sh.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if strings.Contains(line, "\n") {
+		line = strconv.Quote(line)
+	}
+	fmt.Fprintln(f, line)
+}
@@ -0,0 +1,206 @@
+package serv
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// useSchema replaces the default schema for the duration of the test.
+func useSchema(t *testing.T) {
+	saved := sp.DefaultSchema
+	sp.DefaultSchema = sp.NewSchema()
+	t.Cleanup(func() { sp.DefaultSchema = saved })
+}
+
+// runShell runs sh on input and returns what it printed.
+func runShell(t *testing.T, sh *Shell, input string) string {
+	var out bytes.Buffer
+	if err := sh.Run(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("shell error: %s", err)
+	}
+	return out.String()
+}
+
+// Ensure meta-commands change the settings of the shell and translations are printed.
+func TestShell_Meta(t *testing.T) {
+	var tests = []struct {
+		input  string
+		output string
+	}{
+		{
+			input: "\\pretty\n\\dsl\n\\explain\n\\explain\n\\dryrun\n",
+			output: "pretty output is off\n" +
+				"translation output is on\n" +
+				"explain output is on\n" +
+				"explain output is off\n" +
+				"no elasticsearch url configured, statements are translated only\n",
+		},
+		{
+			input: "\\target\n\\target 7.x\n\\target 3.x\n\\format\n\\format esql\n\\format xml\n",
+			output: "target is none\n" +
+				"target is 7.x\n" +
+				"ERROR: unknown elasticsearch version 3.x, expected one of 2.x, 5.x, 6.x, 7.x, 8.x\n" +
+				"format is dsl\n" +
+				"format is esql\n" +
+				"ERROR: unknown target xml, expected one of dsl, sql, esql, msearch\n",
+		},
+		{
+			input:  "\\dr\n\\foo\n\\quit\n\\dsl\n",
+			output: "ERROR: unknown command \\dr, did you mean \\dryrun?, \\help lists the commands\nERROR: unknown command \\foo, \\help lists the commands\n",
+		},
+		{
+			input:  "\\pretty\nselect a\n  from b\n  limit 1;\nselect c from ;\n",
+			output: "pretty output is off\n{\"from\":0,\"size\":1,\"sort\":[]}\nERROR: found EOF, expected identifier at line 1, char 15\nselect c from\n             ^\n",
+		},
+		{
+			input:  "\\format esql\nselect a from b limit 1; select c from d limit 2;\n",
+			output: "format is esql\nFROM b\n| LIMIT 1\n| KEEP a\nFROM d\n| LIMIT 2\n| KEEP c\n",
+		},
+	}
+
+	for i, tt := range tests {
+		sh := NewShell(nil)
+		sh.HistoryFile = ""
+		if out := runShell(t, sh, tt.input); out != tt.output {
+			t.Errorf("%d. %q: output mismatch:\n\nexp=\n%s\n\ngot=\n%s", i, tt.input, tt.output, out)
+		}
+	}
+}
+
+// Ensure the history keeps the statements as typed across sessions.
+func TestShell_History(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	sh := NewShell(nil)
+	sh.HistoryFile = file
+	runShell(t, sh, "\\pretty\nselect a\n  from b;\nselect a\n  from b;\n\n\\dsl\n")
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "\\pretty\n\"select a\\n  from b;\"\n\\dsl\n"; string(b) != exp {
+		t.Errorf("history file mismatch:\n\nexp=%q\n\ngot=%q", exp, b)
+	}
+
+	sh = NewShell(nil)
+	sh.HistoryFile = file
+	runShell(t, sh, "")
+	if exp := []string{`\pretty`, "select a\n  from b;", `\dsl`}; !reflect.DeepEqual(sh.ed.history, exp) {
+		t.Errorf("history mismatch:\n\nexp=%q\n\ngot=%q", exp, sh.ed.history)
+	}
+}
+
+// Ensure keywords, functions, meta-commands and the fields of the loaded index are completed.
+func TestShell_Complete(t *testing.T) {
+	useSchema(t)
+	mapping := filepath.Join(t.TempDir(), "mapping.json")
+	if err := ioutil.WriteFile(mapping, []byte(`{"blog": {"mappings": {"properties": {
+		"title": {"type": "text"},
+		"comments": {"type": "nested", "properties": {"stars": {"type": "integer"}}}
+	}}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sh := NewShell(nil)
+	sh.HistoryFile = ""
+	if out := runShell(t, sh, "\\index blog @"+mapping+"\n\\index\n"); out != "index blog, 3 fields\nindex blog, 3 fields\n" {
+		t.Errorf("unexpected index output %q", out)
+	}
+	if sp.DefaultSchema.NestedPath("comments.stars") != "comments" {
+		t.Errorf("nested path of the mapping not declared")
+	}
+
+	var tests = []struct {
+		word  string
+		cands []string
+	}{
+		{word: ``, cands: nil},
+		{word: `\d`, cands: []string{`\dryrun`, `\dsl`}},
+		{word: `sel`, cands: []string{`select`}},
+		{word: `SEL`, cands: []string{`SELECT`}},
+		{word: `date_h`, cands: []string{`date_histogram(`}},
+		{word: `comm`, cands: []string{`comments`, `comments.stars`}},
+		{word: `ti`, cands: []string{`title`}},
+	}
+	for i, tt := range tests {
+		if cands := sh.complete(tt.word); !reflect.DeepEqual(cands, tt.cands) {
+			t.Errorf("%d. %q: candidates mismatch:\n  exp=%q\n  got=%q", i, tt.word, tt.cands, cands)
+		}
+	}
+}
+
+// Ensure the keys of a terminal line edit it, walk the history and complete words.
+func TestLineEditor_Edit(t *testing.T) {
+	var tests = []struct {
+		keys string
+		line string
+	}{
+		{keys: "select a\r", line: "select a"},
+		{keys: "sel\ta fr\tb\r", line: "select a from b"},
+		{keys: "from b\x01select a \x05;\r", line: "select a from b;"},
+		{keys: "select abc\x7f\x7fx\r", line: "select ax"},
+		{keys: "select a from b\x17c\r", line: "select a from c"},
+		{keys: "abc\x1b[D\x1b[D\x0b\r", line: "a"},
+		{keys: "x\x10\x10\r", line: "select a\n  from b;"},
+		{keys: "\x10\x10\x0e\r", line: "show tables;"},
+		{keys: "x\x1b[A\x1b[B\r", line: "x"},
+	}
+
+	sh := NewShell(nil)
+	for i, tt := range tests {
+		e := &lineEditor{
+			in:       bufio.NewReader(strings.NewReader(tt.keys)),
+			out:      ioutil.Discard,
+			history:  []string{"select a\n  from b;", "show tables;"},
+			complete: sh.complete,
+		}
+		line, err := e.edit("esql> ")
+		if err != nil {
+			t.Errorf("%d. %q: error %s", i, tt.keys, err)
+			continue
+		}
+		if line != tt.line {
+			t.Errorf("%d. %q: line mismatch:\n  exp=%q\n  got=%q", i, tt.keys, tt.line, line)
+		}
+	}
+}
+
+// Ensure the rows of the statements run against a cluster are printed as a table.
+func TestShell_Table(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, r.URL.Path+" "+string(b))
+		w.Write([]byte(`{"hits": {"total": 2, "hits": [
+			{"_source": {"name": "AAPL", "exchange": "nasdaq"}},
+			{"_source": {"name": "BABA", "exchange": null}}
+		]}}`))
+	}))
+	defer ts.Close()
+
+	sh := NewShell(NewClient(ts.URL))
+	sh.HistoryFile = ""
+	out := runShell(t, sh, "\\dsl\n\\pretty\nselect name, exchange from symbol where exchange = 'nasdaq' limit 2;\n")
+	exp := "translation output is on\n" +
+		"pretty output is off\n" +
+		`{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nasdaq"}}}}}},"size":2,"sort":[]}` + "\n" +
+		" name | exchange\n" +
+		"------+----------\n" +
+		" AAPL | nasdaq\n" +
+		" BABA | NULL\n" +
+		"(2 rows)\n"
+	if out != exp {
+		t.Errorf("output mismatch:\n\nexp=\n%s\n\ngot=\n%s", exp, out)
+	}
+	if exp := []string{`/symbol/_search {"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nasdaq"}}}}}},"size":2,"sort":[]}`}; !reflect.DeepEqual(bodies, exp) {
+		t.Errorf("requests mismatch:\n\nexp=%q\n\ngot=%q", exp, bodies)
+	}
+}
@@ -0,0 +1,83 @@
+package serv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// writeTable prints the rows of res as a table with aligned columns.
+func writeTable(w io.Writer, res *Result) {
+	widths := make([]int, len(res.Columns))
+	for i, c := range res.Columns {
+		widths[i] = utf8.RuneCountInString(c)
+	}
+	cells := make([][]string, len(res.Rows))
+	for i, row := range res.Rows {
+		cells[i] = make([]string, len(res.Columns))
+		for j := range res.Columns {
+			var v interface{}
+			if j < len(row) {
+				v = row[j]
+			}
+			cells[i][j] = cellString(v)
+			if n := utf8.RuneCountInString(cells[i][j]); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	line := func(values []string) {
+		for i, v := range values {
+			if i > 0 {
+				buf.WriteString(" |")
+			}
+			buf.WriteString(" " + v)
+			if i < len(values)-1 {
+				buf.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(v)))
+			}
+		}
+		buf.WriteString("\n")
+	}
+	line(res.Columns)
+	for i, width := range widths {
+		if i > 0 {
+			buf.WriteString("+")
+		}
+		buf.WriteString(strings.Repeat("-", width+2))
+	}
+	buf.WriteString("\n")
+	for _, row := range cells {
+		line(row)
+	}
+	if len(res.Rows) == 1 {
+		buf.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(&buf, "(%d rows)\n", len(res.Rows))
+	}
+	w.Write(buf.Bytes())
+}
+
+// cellString returns the table text of a response value, objects and
+// arrays are printed as json.
+func cellString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool, float64:
+		return fmt.Sprint(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package serv
+
+import "errors"
+
+// isTerminal returns false, line editing is only supported on linux.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported")
+}
@@ -84,19 +84,36 @@ type Node interface {
 
 func (Statements) node() {}
 
-func (*SelectStatement) node() {}
+func (*CreateTableStatement) node()    {}
+func (*CreateTemplateStatement) node() {}
+func (*DeleteStatement) node()         {}
+func (*DescribeStatement) node()       {}
+func (*DropTableStatement) node()      {}
+func (*DropTemplateStatement) node()   {}
+func (*InsertStatement) node()         {}
+func (*SelectStatement) node()         {}
+func (*ShowColumnsStatement) node()    {}
+func (*ShowTablesStatement) node()     {}
+func (*UpdateStatement) node()         {}
 
 func (*BinaryExpr) node()     {}
 func (*BooleanLiteral) node() {}
+func (*BoundParameter) node() {}
 func (*Call) node()           {}
+func (*CastExpr) node()       {}
+func (*CaseExpr) node()       {}
+func (*CoalesceExpr) node()   {}
 func (*Dimension) node()      {}
 func (Dimensions) node()      {}
 func (*IntegerLiteral) node() {}
+func (*Join) node()           {}
 func (*Field) node()          {}
 func (Fields) node()          {}
+func (*IfExpr) node()         {}
 func (*Measurement) node()    {}
 func (Measurements) node()    {}
 func (*nilLiteral) node()     {}
+func (*NullIfExpr) node()     {}
 func (*NumberLiteral) node()  {}
 func (*ParenExpr) node()      {}
 func (*RegexLiteral) node()   {}
@@ -105,6 +122,7 @@ func (*SortField) node()      {}
 func (SortFields) node()      {}
 func (Sources) node()         {}
 func (*StringLiteral) node()  {}
+func (*SubqueryExpr) node()   {}
 func (*VarRef) node()         {}
 func (*Wildcard) node()       {}
 
@@ -133,7 +151,17 @@ type HasDefaultDatabase interface {
 	DefaultDatabase() string
 }
 
-func (*SelectStatement) stmt() {}
+func (*CreateTableStatement) stmt()    {}
+func (*CreateTemplateStatement) stmt() {}
+func (*DeleteStatement) stmt()         {}
+func (*DescribeStatement) stmt()       {}
+func (*DropTableStatement) stmt()      {}
+func (*DropTemplateStatement) stmt()   {}
+func (*InsertStatement) stmt()         {}
+func (*SelectStatement) stmt()         {}
+func (*ShowColumnsStatement) stmt()    {}
+func (*ShowTablesStatement) stmt()     {}
+func (*UpdateStatement) stmt()         {}
 
 // Expr represents an expression that can be evaluated to a value.
 type Expr interface {
@@ -143,14 +171,21 @@ type Expr interface {
 
 func (*BinaryExpr) expr()     {}
 func (*BooleanLiteral) expr() {}
+func (*BoundParameter) expr() {}
 func (*Call) expr()           {}
+func (*CastExpr) expr()       {}
+func (*CaseExpr) expr()       {}
+func (*CoalesceExpr) expr()   {}
+func (*IfExpr) expr()         {}
 func (*IntegerLiteral) expr() {}
 func (*nilLiteral) expr()     {}
+func (*NullIfExpr) expr()     {}
 func (*NumberLiteral) expr()  {}
 func (*ParenExpr) expr()      {}
 func (*RegexLiteral) expr()   {}
 func (*ListLiteral) expr()    {}
 func (*StringLiteral) expr()  {}
+func (*SubqueryExpr) expr()   {}
 func (*VarRef) expr()         {}
 func (*Wildcard) expr()       {}
 
@@ -211,6 +246,9 @@ type SortField struct {
 	// Name of the field
 	Name string
 
+	// Aggregate the buckets are sorted on, e.g. sum(x), Name is its string.
+	Call *Call
+
 	// Sort order.
 	Ascending bool
 }
@@ -244,12 +282,18 @@ func (a SortFields) String() string {
 
 // SelectStatement represents a command for extracting data from the database.
 type SelectStatement struct {
+	// Optimizer hints, from the /*+ */ comment after SELECT.
+	Hints Hints
+
 	// Expressions returned from the selection.
 	Fields Fields
 
 	// Data sources that fields are extracted from.
 	Sources Sources
 
+	// Index joined with the source, JOIN ... ON.
+	Join *Join
+
 	// An expression evaluated on data point.
 	Condition Expr
 
@@ -262,6 +306,9 @@ type SelectStatement struct {
 	// Returns rows starting at an offset from the first row.
 	Offset int
 
+	// Reads every row page by page, LIMIT ALL.
+	LimitAll bool
+
 	// Expressions used for grouping the selection.
 	Dimensions Dimensions
 
@@ -273,6 +320,12 @@ type SelectStatement struct {
 
 	// Removes duplicate rows from raw queries.
 	Dedupe bool
+
+	// Statements whose rows are appended to the rows of this one, UNION ALL.
+	Union *Union
+
+	// Returns how the statement is translated instead of its rows, EXPLAIN.
+	Explain bool
 }
 
 // HasDerivative returns true if one of the function calls in the statement is a
@@ -394,14 +447,29 @@ func (s *SelectStatement) ColumnNames() []string {
 
 // String returns a string representation of the select statement.
 func (s *SelectStatement) String() string {
+	if s.Explain {
+		stmt := *s
+		stmt.Explain = false
+		return "EXPLAIN " + stmt.String()
+	}
+	if s.Union != nil {
+		return s.Union.string(s)
+	}
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("SELECT ")
+	if len(s.Hints) > 0 {
+		_, _ = buf.WriteString(s.Hints.String() + " ")
+	}
 	_, _ = buf.WriteString(s.Fields.String())
 
 	if len(s.Sources) > 0 {
 		_, _ = buf.WriteString(" FROM ")
 		_, _ = buf.WriteString(s.Sources.String())
 	}
+	if s.Join != nil {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(s.Join.String())
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -418,6 +486,9 @@ func (s *SelectStatement) String() string {
 		_, _ = buf.WriteString(" ORDER BY ")
 		_, _ = buf.WriteString(s.SortFields.String())
 	}
+	if s.LimitAll {
+		_, _ = buf.WriteString(" LIMIT ALL")
+	}
 	if s.Limit > 0 {
 		_, _ = fmt.Fprintf(&buf, " LIMIT %d", s.Limit)
 	}
@@ -441,17 +512,79 @@ func (s *SelectStatement) validate() error {
 		return err
 	}
 
+	for _, d := range s.Dimensions {
+		if isConditional(d.Expr) {
+			if err := validateConditional(d.Expr); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.validateScalars(); err != nil {
+		return err
+	}
+
+	if err := s.validateSortFields(); err != nil {
+		return err
+	}
+
+	if s.Join != nil {
+		if err := s.validateJoin(); err != nil {
+			return err
+		}
+	}
+
+	if s.LimitAll && (!s.IsRawQuery || len(s.Dimensions) > 0) {
+		return errorAt(clause{s, LIMIT}, fmt.Errorf("LIMIT ALL is only supported by raw queries"))
+	}
+
 	return nil
 }
 
+// aggregateNode returns the first GROUP BY dimension, aggregate or HAVING
+// condition of the statement, where errors on aggregating it are located.
+func (s *SelectStatement) aggregateNode() Node {
+	if len(s.Dimensions) > 0 {
+		return s.Dimensions[0].Expr
+	}
+	for _, f := range s.Fields {
+		if calls := aggregateCalls(f.Expr); len(calls) > 0 {
+			return calls[0]
+		}
+	}
+	return s.Having
+}
+
 func (s *SelectStatement) validateConditions() error {
 	expr := s.Condition
 	if expr == nil {
 		return nil
 	}
+	if err := validateSubqueries(expr); err != nil {
+		return err
+	}
 	return validateCondition(expr, ILLEGAL)
 }
 
+// validateSortFields checks the aggregates the buckets are sorted on.
+func (s *SelectStatement) validateSortFields() error {
+	for _, sf := range s.SortFields {
+		if sf.Call == nil {
+			continue
+		}
+		if callKind(sf.Call) != MetricFunction {
+			return errorAt(sf.Call, fmt.Errorf("ORDER BY only supports fields and aggregate functions, got %s()", sf.Call.Name))
+		}
+		if err := validateCall(sf.Call); err != nil {
+			return err
+		}
+		if len(s.Dimensions) == 0 {
+			return errorAt(sf.Call, fmt.Errorf("ORDER BY %s needs a GROUP BY", sf.Name))
+		}
+	}
+	return nil
+}
+
 // valid condition expr.
 func validateCondition(expr Expr, op Token) error {
 	if expr == nil {
@@ -460,7 +593,24 @@ func validateCondition(expr Expr, op Token) error {
 
 	switch expr := expr.(type) {
 	case *Call:
-		return fmt.Errorf("invalid filter, unsupport function %s", expr.String())
+		switch callKind(expr) {
+		case ScalarFunction:
+			// compared by a script
+			return nil
+		case PredicateFunction:
+			if err := validateCall(expr); err != nil {
+				return err
+			}
+			query := lookupFunction(expr).Query
+			if query == nil || hasBoundParameter(expr) {
+				// checked once the values are bound
+				return nil
+			}
+			_, err := query(expr)
+			return errorAt(expr, err)
+		}
+		err := fmt.Errorf("invalid filter, unsupport function %s", expr.String())
+		return suggestFunction(errorAt(expr, err), expr.Name, conditionFunctions())
 	case *BinaryExpr:
 		err := validateCondition(expr.LHS, expr.Op)
 		if err != nil {
@@ -474,12 +624,12 @@ func validateCondition(expr Expr, op Token) error {
 		case EQREGEX, NEQREGEX:
 			return nil
 		default:
-			return fmt.Errorf("invalid filter, unsupport op %s for regex", op.String())
+			return errorAt(expr, fmt.Errorf("invalid filter, unsupport op %s for regex", op.String()))
 		}
 	case *StringLiteral:
 		switch op {
 		case LT, LTE, GT, GTE, SUB, MUL, DIV, ADD:
-			return fmt.Errorf("invalid filter, unsupport op %s for string", op.String())
+			return errorAt(expr, fmt.Errorf("invalid filter, unsupport op %s for string", op.String()))
 		default:
 			return nil
 		}
@@ -493,44 +643,150 @@ func (s *SelectStatement) validateFields() error {
 		var c validateField
 		Walk(&c, f.Expr)
 		if c.foundInvalid {
-			return fmt.Errorf("invalid operator %s in SELECT field, only support +-*/", c.badToken)
+			return errorAt(f.Expr, fmt.Errorf("invalid operator %s in SELECT field, only support +-*/", c.badToken))
 		}
 		switch expr := f.Expr.(type) {
 		case *BinaryExpr:
 			if err := expr.validate(); err != nil {
+				return errorAt(expr, err)
+			}
+		case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+			if err := validateConditional(expr); err != nil {
 				return err
 			}
-		case *ParenExpr, *Call, *VarRef, *Wildcard:
+		case *ParenExpr, *Call, *CastExpr, *VarRef, *Wildcard:
 		default:
-			return fmt.Errorf("invalid field %v in SELECT field", expr)
+			return errorAt(expr, fmt.Errorf("invalid field %v in SELECT field", expr))
 		}
 	}
 	return nil
 }
 
+// validateConditional checks a conditional expression, which is computed by a
+// script on every document, calls no function but the scalar ones.
+func validateConditional(expr Expr) error {
+	var err error
+	WalkFunc(expr, func(n Node) {
+		c, ok := n.(*Call)
+		if !ok || err != nil || isScalarCall(c) {
+			return
+		}
+		if isAggregateFunction(c.Name) {
+			err = errorAt(c, fmt.Errorf("aggregate function %s() can not be used in %s, use it as the argument of the aggregate instead", c.Name, conditionalName(expr)))
+			return
+		}
+		err = errorAt(c, fmt.Errorf("function %s() can not be used in %s", c.Name, conditionalName(expr)))
+	})
+	return err
+}
+
+// conditionalName returns the name of a conditional expression in errors.
+func conditionalName(expr Expr) string {
+	if _, ok := expr.(*CaseExpr); ok {
+		return "CASE"
+	}
+	f := Field{Expr: expr}
+	return f.Name() + "()"
+}
+
 func (s *SelectStatement) validateAggregates() error {
 	for _, f := range s.Fields {
-		for _, expr := range walkFunctionCalls(f.Expr) {
+		for _, expr := range aggregateCalls(f.Expr) {
+			if err := validateCall(expr); err != nil {
+				return err
+			}
 			if len(expr.Args) < 1 {
-				return fmt.Errorf("invalid number of arguments for %s, expected at least 1, got %d", expr.Name, len(expr.Args))
+				return errorAt(expr, fmt.Errorf("invalid number of arguments for %s, expected at least 1, got %d", expr.Name, len(expr.Args)))
+			}
+			if callKind(expr) == PipelineFunction {
+				if err := s.validatePipeline(expr); err != nil {
+					return err
+				}
+				continue
 			}
 			switch fc := expr.Args[0].(type) {
 			case *VarRef:
 				// do nothing
 			case *BinaryExpr:
 				if err := fc.validateArgs(); err != nil {
-					return err
+					return errorAt(fc, err)
 				}
 			case *Wildcard:
-			case *Call:
+			case *Call, *CastExpr:
+				if c, ok := fc.(*Call); ok && !isScalarCall(c) {
+					break
+				}
+				if calls := aggregateCalls(fc); len(calls) > 0 {
+					return errorAt(calls[0], fmt.Errorf("aggregate function %s() can not be used in %s()", calls[0].Name, expr.Name))
+				}
+			case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+				if err := validateConditional(fc); err != nil {
+					return err
+				}
 			default:
-				return fmt.Errorf("expected field argument in %s()", expr.Name)
+				return errorAt(expr, fmt.Errorf("expected field argument in %s()", expr.Name))
 			}
 		}
 	}
 	return nil
 }
 
+// validatePipeline checks a pipeline call reads a metric of histogram buckets.
+func (s *SelectStatement) validatePipeline(c *Call) error {
+	if err := validateCall(c.Args[0].(*Call)); err != nil {
+		return err
+	}
+	if len(s.Dimensions) > 0 {
+		if d, ok := s.Dimensions[len(s.Dimensions)-1].Expr.(*Call); ok && (d.Name == "histogram" || d.Name == "date_histogram") {
+			return nil
+		}
+	}
+	return errorAt(c, fmt.Errorf("%s() needs a GROUP BY histogram() or date_histogram()", c.Name))
+}
+
+// validateScalars checks the scalar and bucket function calls of the
+// statement and that HAVING can be computed by a lucene expression.
+func (s *SelectStatement) validateScalars() error {
+	nodes := []Node{s.Fields, s.Dimensions}
+	if s.Condition != nil {
+		nodes = append(nodes, s.Condition)
+	}
+	if s.Having != nil {
+		nodes = append(nodes, s.Having)
+	}
+	var err error
+	for _, node := range nodes {
+		WalkFunc(node, func(n Node) {
+			if c, ok := n.(*Call); ok && err == nil && isScalarCall(c) {
+				err = validateCall(c)
+			}
+		})
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range s.Dimensions {
+		if c, ok := d.Expr.(*Call); ok && callKind(c) == BucketFunction {
+			if err := validateCall(c); err != nil {
+				return err
+			}
+		}
+	}
+	// fields computed from aggregates are bucket_script lucene expressions
+	for _, f := range s.Fields {
+		if _, ok := f.Expr.(*Call); (ok && !isScalarCall(f.Expr)) || len(aggregateCalls(f.Expr)) == 0 {
+			continue
+		}
+		if _, err := expression(f.Expr, nil); err != nil {
+			return err
+		}
+	}
+	if s.Having != nil {
+		_, err = expression(s.Having, nil)
+	}
+	return err
+}
+
 // NamesInWhere returns the field and tag names (idents) referenced in the where clause
 func (s *SelectStatement) NamesInWhere() []string {
 	var a []string
@@ -592,6 +848,14 @@ func walkNames(exp Expr) []string {
 		return ret
 	case *ParenExpr:
 		return walkNames(expr.Expr)
+	case *CastExpr:
+		return walkNames(expr.Expr)
+	case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+		var a []string
+		for _, e := range conditionalArgs(expr) {
+			a = append(a, walkNames(e)...)
+		}
+		return a
 	}
 
 	return nil
@@ -764,6 +1028,16 @@ func (f *Field) Name() string {
 	switch expr := f.Expr.(type) {
 	case *Call:
 		return expr.Name
+	case *CaseExpr:
+		return "case"
+	case *CastExpr:
+		return "cast"
+	case *IfExpr:
+		return "if"
+	case *CoalesceExpr:
+		return "coalesce"
+	case *NullIfExpr:
+		return "nullif"
 	case *BinaryExpr:
 		return BinaryExprName(expr)
 	case *ParenExpr:
@@ -828,7 +1102,27 @@ type VarRef struct {
 
 // String returns a string representation of the variable reference.
 func (r *VarRef) String() string {
-	buf := bytes.NewBufferString(r.Val)
+	// rewritten references, e.g. doc['x'].value, are printed as is
+	quote := false
+	for _, seg := range r.Segments {
+		if IdentNeedsQuotes(seg) {
+			quote = true
+		}
+	}
+	if !quote || r.Val != strings.Join(r.Segments, ".") {
+		return r.Val
+	}
+	var buf bytes.Buffer
+	for i, seg := range r.Segments {
+		if i > 0 {
+			_ = buf.WriteByte('.')
+		}
+		if IdentNeedsQuotes(seg) {
+			_, _ = buf.WriteString("`" + strings.Replace(seg, "`", "``", -1) + "`")
+		} else {
+			_, _ = buf.WriteString(seg)
+		}
+	}
 	return buf.String()
 }
 
@@ -848,6 +1142,15 @@ func (a VarRefs) Strings() []string {
 type Call struct {
 	Name string
 	Args []Expr
+	// Options are the trailing name = value arguments of the aggregate,
+	// bucket and predicate functions, e.g. min_doc_count = 1.
+	Options []*CallOption
+}
+
+// CallOption is a named option of a function call.
+type CallOption struct {
+	Name  string
+	Value Expr
 }
 
 // String returns a string representation of the call.
@@ -857,11 +1160,135 @@ func (c *Call) String() string {
 	for _, arg := range c.Args {
 		str = append(str, arg.String())
 	}
+	for _, o := range c.Options {
+		str = append(str, fmt.Sprintf("%s = %s", o.Name, o.Value.String()))
+	}
 
 	// Write function name and args.
 	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(str, ", "))
 }
 
+// CaseExpr represents a CASE expression. With an Operand, the simple form,
+// the WHEN conditions are values compared to the operand.
+type CaseExpr struct {
+	Operand Expr
+	Whens   []*WhenClause
+	Else    Expr
+}
+
+// WhenClause represents a WHEN condition and its THEN result.
+type WhenClause struct {
+	Cond   Expr
+	Result Expr
+}
+
+// String returns a string representation of the case expression.
+func (e *CaseExpr) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("CASE")
+	if e.Operand != nil {
+		_, _ = buf.WriteString(" " + e.Operand.String())
+	}
+	for _, w := range e.Whens {
+		_, _ = fmt.Fprintf(&buf, " WHEN %s THEN %s", w.Cond.String(), w.Result.String())
+	}
+	if e.Else != nil {
+		_, _ = buf.WriteString(" ELSE " + e.Else.String())
+	}
+	_, _ = buf.WriteString(" END")
+	return buf.String()
+}
+
+// IfExpr represents an if(cond, then, else) call.
+type IfExpr struct {
+	Cond Expr
+	Then Expr
+	Else Expr
+}
+
+// String returns a string representation of the if expression.
+func (e *IfExpr) String() string {
+	return fmt.Sprintf("if(%s, %s, %s)", e.Cond.String(), e.Then.String(), e.Else.String())
+}
+
+// CoalesceExpr represents a coalesce() call, its first non null argument.
+type CoalesceExpr struct {
+	Args []Expr
+}
+
+// String returns a string representation of the coalesce expression.
+func (e *CoalesceExpr) String() string {
+	c := Call{Name: "coalesce", Args: e.Args}
+	return c.String()
+}
+
+// NullIfExpr represents a nullif(expr, value) call, null when expr equals value.
+type NullIfExpr struct {
+	Expr  Expr
+	Value Expr
+}
+
+// String returns a string representation of the nullif expression.
+func (e *NullIfExpr) String() string {
+	return fmt.Sprintf("nullif(%s, %s)", e.Expr.String(), e.Value.String())
+}
+
+// CastExpr represents a CAST(expr AS type) conversion.
+type CastExpr struct {
+	Expr Expr
+	Type string
+}
+
+// String returns a string representation of the cast expression.
+func (e *CastExpr) String() string {
+	return fmt.Sprintf("CAST(%s AS %s)", e.Expr.String(), e.Type)
+}
+
+// SubqueryExpr represents a select statement whose rows are the values of
+// an IN condition.
+type SubqueryExpr struct {
+	Statement *SelectStatement
+}
+
+// String returns a string representation of the subquery.
+func (e *SubqueryExpr) String() string {
+	return fmt.Sprintf("(%s)", e.Statement.String())
+}
+
+// isConditional returns true for CASE, if(), coalesce() and nullif().
+func isConditional(expr Expr) bool {
+	switch expr.(type) {
+	case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+		return true
+	}
+	return false
+}
+
+// conditionalArgs returns the sub expressions of a conditional expression.
+func conditionalArgs(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case *CaseExpr:
+		var args []Expr
+		if e.Operand != nil {
+			args = append(args, e.Operand)
+		}
+		for _, w := range e.Whens {
+			args = append(args, w.Cond, w.Result)
+		}
+		if e.Else != nil {
+			args = append(args, e.Else)
+		}
+		return args
+	case *IfExpr:
+		return []Expr{e.Cond, e.Then, e.Else}
+	case *CoalesceExpr:
+		return e.Args
+	case *NullIfExpr:
+		return []Expr{e.Expr, e.Value}
+	}
+	return nil
+}
+
 // NumberLiteral represents a numeric literal.
 type NumberLiteral struct {
 	Val float64
@@ -922,11 +1349,13 @@ func (s *ListLiteral) String() string {
 		}
 		switch v := tagKey.(type) {
 		case string:
-			_, _ = buf.WriteString(QuoteIdent(v))
+			_, _ = buf.WriteString(QuoteString(v))
 		case float64:
 			_, _ = buf.WriteString((fmt.Sprintf("%f", v)))
 		case int64:
 			_, _ = buf.WriteString((fmt.Sprintf("%d", v)))
+		case *BoundParameter:
+			_, _ = buf.WriteString(v.String())
 		}
 	}
 	_, _ = buf.WriteString("]")
@@ -1083,6 +1512,21 @@ func Walk(v Visitor, node Node) {
 			Walk(v, expr)
 		}
 
+	case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+		for _, expr := range conditionalArgs(n.(Expr)) {
+			Walk(v, expr)
+		}
+
+	case *CastExpr:
+		Walk(v, n.Expr)
+
+	case *ListLiteral:
+		for _, val := range n.Vals {
+			if p, ok := val.(*BoundParameter); ok {
+				Walk(v, p)
+			}
+		}
+
 	case *Dimension:
 		Walk(v, n.Expr)
 
@@ -1102,10 +1546,17 @@ func Walk(v Visitor, node Node) {
 	case *ParenExpr:
 		Walk(v, n.Expr)
 
+	case *Join:
+		Walk(v, n.Source)
+		Walk(v, n.Condition)
+
 	case *SelectStatement:
 		Walk(v, n.Fields)
 		Walk(v, n.Dimensions)
 		Walk(v, n.Sources)
+		if n.Join != nil {
+			Walk(v, n.Join)
+		}
 		Walk(v, n.Condition)
 		Walk(v, n.SortFields)
 
@@ -1136,6 +1587,60 @@ type walkFuncVisitor func(Node)
 
 func (fn walkFuncVisitor) Visit(n Node) Visitor { fn(n); return fn }
 
+// CloneExpr returns a deep copy of the expression, the statements of its
+// subqueries and its literals are shared.
+func CloneExpr(expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	cloneAll := func(exprs []Expr) []Expr {
+		if exprs == nil {
+			return nil
+		}
+		c := make([]Expr, len(exprs))
+		for i, e := range exprs {
+			c[i] = CloneExpr(e)
+		}
+		return c
+	}
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		return &BinaryExpr{Op: expr.Op, LHS: CloneExpr(expr.LHS), RHS: CloneExpr(expr.RHS)}
+	case *BoundParameter:
+		return &BoundParameter{Name: expr.Name, Value: expr.Value}
+	case *Call:
+		c := &Call{Name: expr.Name, Args: cloneAll(expr.Args)}
+		for _, o := range expr.Options {
+			c.Options = append(c.Options, &CallOption{Name: o.Name, Value: CloneExpr(o.Value)})
+		}
+		return c
+	case *CaseExpr:
+		c := &CaseExpr{Operand: CloneExpr(expr.Operand), Else: CloneExpr(expr.Else)}
+		for _, w := range expr.Whens {
+			c.Whens = append(c.Whens, &WhenClause{Cond: CloneExpr(w.Cond), Result: CloneExpr(w.Result)})
+		}
+		return c
+	case *CastExpr:
+		return &CastExpr{Expr: CloneExpr(expr.Expr), Type: expr.Type}
+	case *CoalesceExpr:
+		return &CoalesceExpr{Args: cloneAll(expr.Args)}
+	case *IfExpr:
+		return &IfExpr{Cond: CloneExpr(expr.Cond), Then: CloneExpr(expr.Then), Else: CloneExpr(expr.Else)}
+	case *NullIfExpr:
+		return &NullIfExpr{Expr: CloneExpr(expr.Expr), Value: CloneExpr(expr.Value)}
+	case *ParenExpr:
+		return &ParenExpr{Expr: CloneExpr(expr.Expr)}
+	case *SubqueryExpr:
+		return &SubqueryExpr{Statement: expr.Statement}
+	case *VarRef:
+		return &VarRef{Val: expr.Val, Segments: append([]string(nil), expr.Segments...)}
+	case *Wildcard:
+		return &Wildcard{Type: expr.Type}
+	}
+	// literals are not modified once parsed
+	return expr
+}
+
 // Valuer is the interface that wraps the Value() method.
 //
 // Value returns the value and existence flag for a given key.
@@ -1192,11 +1697,48 @@ func (a Measurements) String() string {
 // Measurement represents a single measurement used as a datasource.
 type Measurement struct {
 	Database string
+	// Alias qualifies the fields of the index in a JOIN, e.g. q.close.
+	Alias string
 }
 
 // String returns a string representation of the measurement.
 func (m *Measurement) String() string {
-	return m.Database
+	name := m.Database
+	if indexNeedsQuotes(m.Database) {
+		name = QuoteIdent(m.Database)
+	}
+	if m.Alias != "" {
+		return fmt.Sprintf("%s AS %s", name, QuoteIdent(m.Alias))
+	}
+	return name
+}
+
+// Join represents the JOIN of the source of a statement with another index
+// on the equality of their keys.
+type Join struct {
+	Source    *Measurement
+	Condition Expr
+}
+
+// String returns a string representation of the join.
+func (j *Join) String() string {
+	return fmt.Sprintf("JOIN %s ON %s", j.Source.String(), j.Condition.String())
+}
+
+// indexNeedsQuotes returns true if name can not be written as a bare index pattern.
+func indexNeedsQuotes(name string) bool {
+	if name == "" || Lookup(name) != IDENT {
+		return true
+	}
+	for i, r := range name {
+		if r == '-' && (i == 0 || strings.HasPrefix(name[i:], "--")) {
+			return true
+		}
+		if !isIdentChar(r) && r != '-' && r != '.' && r != '*' {
+			return true
+		}
+	}
+	return false
 }
 
 //ESString ...
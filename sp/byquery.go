@@ -0,0 +1,334 @@
+package sp
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DeleteStatement deletes the documents matching a condition,
+// DELETE FROM index [WHERE condition].
+type DeleteStatement struct {
+	Source    *Measurement
+	Condition Expr
+}
+
+// String returns a string representation of the statement.
+func (s *DeleteStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("DELETE FROM ")
+	_, _ = buf.WriteString(s.Source.String())
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	return buf.String()
+}
+
+// UpdateStatement sets fields of the documents matching a condition,
+// UPDATE index SET field = expr [, ...] [WHERE condition].
+type UpdateStatement struct {
+	Source      *Measurement
+	Assignments []*Assignment
+	Condition   Expr
+}
+
+// Assignment sets a field to the value of an expression, field = expr.
+type Assignment struct {
+	Field *VarRef
+	Expr  Expr
+}
+
+// String returns a string representation of the statement.
+func (s *UpdateStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("UPDATE ")
+	_, _ = buf.WriteString(s.Source.String())
+	_, _ = buf.WriteString(" SET ")
+	for i, a := range s.Assignments {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = fmt.Fprintf(&buf, "%s = %s", a.Field, a.Expr)
+	}
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	return buf.String()
+}
+
+// parseDeleteStatement parses "DELETE FROM index [WHERE condition]", the
+// DELETE token has already been consumed.
+func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+	}
+	stmt := &DeleteStatement{}
+	var err error
+	if stmt.Source, err = p.parseSource(); err != nil {
+		return nil, err
+	}
+	if stmt.Condition, err = p.parseCondition(); err != nil {
+		return nil, err
+	}
+	if err := p.parseEnd(); err != nil {
+		return nil, err
+	}
+	if err := stmt.query().validate(); err != nil {
+		return nil, p.locate(err)
+	}
+	return stmt, nil
+}
+
+// parseUpdateStatement parses "UPDATE index SET field = expr [, ...]
+// [WHERE condition]", the UPDATE token has already been consumed.
+func (p *Parser) parseUpdateStatement() (*UpdateStatement, error) {
+	stmt := &UpdateStatement{}
+	var err error
+	if stmt.Source, err = p.parseSource(); err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SET {
+		return nil, newParseError(tokstr(tok, lit), []string{"SET"}, pos)
+	}
+	for {
+		a := &Assignment{}
+		_, pos, _ := p.scanIgnoreWhitespace()
+		p.unscan()
+		if a.Field, err = p.parseVarRef(); err != nil {
+			return nil, err
+		}
+		p.setSpan(a.Field, pos)
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EQ {
+			return nil, newParseError(tokstr(tok, lit), []string{"="}, pos)
+		}
+		if a.Expr, err = p.ParseExpr(); err != nil {
+			return nil, err
+		}
+		stmt.Assignments = append(stmt.Assignments, a)
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	if stmt.Condition, err = p.parseCondition(); err != nil {
+		return nil, err
+	}
+	if err := p.parseEnd(); err != nil {
+		return nil, err
+	}
+	if err := stmt.validate(); err != nil {
+		return nil, p.locate(err)
+	}
+	return stmt, nil
+}
+
+// validate checks the fields are set once to scalar expressions and the
+// condition is one of a select statement.
+func (s *UpdateStatement) validate() error {
+	seen := make(map[string]bool)
+	for _, a := range s.Assignments {
+		if seen[a.Field.Val] {
+			return errorAt(a.Field, fmt.Errorf("%s is set twice", a.Field))
+		}
+		seen[a.Field.Val] = true
+		if calls := aggregateCalls(a.Expr); len(calls) > 0 {
+			return errorAt(calls[0], fmt.Errorf("%s() can not be used in SET, only scalar expressions can", calls[0].Name))
+		}
+		var err error
+		WalkFunc(a.Expr, func(n Node) {
+			if c, ok := n.(*Call); ok && err == nil {
+				err = validateCall(c)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if isConditional(a.Expr) {
+			if err := validateConditional(a.Expr); err != nil {
+				return err
+			}
+		}
+	}
+	return s.query().validate()
+}
+
+// query returns SELECT * FROM source WHERE condition, which validates,
+// binds and translates the condition of a DELETE.
+func (s *DeleteStatement) query() *SelectStatement {
+	return whereQuery(s.Source, s.Condition)
+}
+
+// query returns SELECT * FROM source WHERE condition, which validates,
+// binds and translates the condition of an UPDATE.
+func (s *UpdateStatement) query() *SelectStatement {
+	return whereQuery(s.Source, s.Condition)
+}
+
+func whereQuery(src *Measurement, cond Expr) *SelectStatement {
+	return &SelectStatement{
+		IsRawQuery: true,
+		Fields:     Fields{{Expr: &Wildcard{}}},
+		Sources:    Sources{src},
+		Condition:  cond,
+	}
+}
+
+func (s *DeleteStatement) validateFunctions() error { return s.query().validateFunctions() }
+func (s *UpdateStatement) validateFunctions() error { return s.query().validateFunctions() }
+
+// bind binds params to the placeholders of the condition.
+func (s *DeleteStatement) bind(params Params, used map[string]bool) error {
+	q := s.query()
+	if err := q.bind(params, used); err != nil {
+		return err
+	}
+	s.Condition = q.Condition
+	return nil
+}
+
+// bind binds params to the placeholders of the assigned expressions, kept
+// to be passed as script params, and of the condition.
+func (s *UpdateStatement) bind(params Params, used map[string]bool) error {
+	for _, a := range s.Assignments {
+		var err error
+		WalkFunc(a.Expr, func(n Node) {
+			if err != nil {
+				return
+			}
+			switch n := n.(type) {
+			case *BoundParameter:
+				err = n.bind(params, used)
+			case *ListLiteral:
+				err = n.bind(params, used)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	q := s.query()
+	if err := q.bind(params, used); err != nil {
+		return err
+	}
+	s.Condition = q.Condition
+	return nil
+}
+
+// translate returns the _delete_by_query request of the statement.
+func (s *DeleteStatement) translate() (*Translation, error) {
+	t, query, err := byQuery(s.Source, s.Condition, "DELETE")
+	if err != nil {
+		return nil, err
+	}
+	t.Requests = []*Request{{Method: "POST", Path: "/" + url.PathEscape(t.Index) + "/_delete_by_query", Body: map[string]interface{}{"query": query}}}
+	t.Columns = byQueryColumns("deleted")
+	return t, nil
+}
+
+// translate returns the _update_by_query request of the statement, its
+// assignments are a painless script setting the fields of ctx._source.
+func (s *UpdateStatement) translate() (*Translation, error) {
+	t, query, err := byQuery(s.Source, s.Condition, "UPDATE")
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{"query": query, "script": s.script()}
+	t.Requests = []*Request{{Method: "POST", Path: "/" + url.PathEscape(t.Index) + "/_update_by_query", Body: body}}
+	t.Columns = byQueryColumns("updated")
+	return t, nil
+}
+
+// byQuery returns the translation of a DELETE or UPDATE and its query, the
+// query of the select statement of its condition.
+func byQuery(src *Measurement, cond Expr, statement string) (*Translation, interface{}, error) {
+	st, err := whereQuery(src, cond).translate()
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &Translation{Index: st.Index, Subqueries: st.Subqueries, statement: statement, write: true}
+	query, ok := st.Body["query"]
+	if !ok {
+		t.unfiltered = true
+		query = map[string]interface{}{"match_all": map[string]interface{}{}}
+		t.Warnings = append(t.Warnings, fmt.Sprintf("%s without WHERE changes every document of %s", statement, t.Index))
+	}
+	t.rows = func(resps [][]byte) ([][]interface{}, error) {
+		return t.Rows(resps[0])
+	}
+	return t, query, nil
+}
+
+// byQueryColumns returns the columns of a by query or _reindex response.
+func byQueryColumns(changed ...string) []*Column {
+	var columns []*Column
+	names := append(append([]string{"total"}, changed...), "version_conflicts", "failures")
+	for _, name := range names {
+		columns = append(columns, &Column{Name: name, Path: []string{name}})
+	}
+	return columns
+}
+
+// script returns the painless script of the assignments, fields are read
+// and set in ctx._source and literals are lifted into params.
+func (s *UpdateStatement) script() map[string]interface{} {
+	p := &scriptPrinter{params: make(map[string]interface{}), reserved: make(map[string]bool)}
+	for _, a := range s.Assignments {
+		WalkFunc(a.Expr, func(n Node) {
+			if b, ok := n.(*BoundParameter); ok && !b.positional() {
+				p.reserved[b.Name] = true
+			}
+		})
+	}
+	var stmts []string
+	for _, a := range s.Assignments {
+		expr := CloneExpr(a.Expr)
+		WalkFunc(expr, func(n Node) {
+			if ref, ok := n.(*VarRef); ok {
+				ref.Val = sourceRef("ctx._source", ref)
+			}
+		})
+		stmts = append(stmts, fmt.Sprintf("%s = %s", sourceRef("ctx._source", a.Field), p.print(expr)))
+	}
+	m := map[string]interface{}{"inline": strings.Join(stmts, "; "), "lang": "painless"}
+	if len(p.params) > 0 {
+		m["params"] = p.params
+	}
+	return m
+}
+
+// sourceRef returns the painless reference of a field in the source map
+// root, e.g. ctx._source.
+func sourceRef(root string, ref *VarRef) string {
+	segments := ref.Segments
+	if len(segments) == 0 {
+		segments = strings.Split(ref.Val, ".")
+	}
+	var buf bytes.Buffer
+	_, _ = buf.WriteString(root)
+	for _, seg := range segments {
+		_, _ = fmt.Fprintf(&buf, "['%s']", qsReplacer.Replace(seg))
+	}
+	return buf.String()
+}
+
+// Check returns an error for a DELETE or UPDATE without WHERE, which
+// changes every document of its index, or a DROP TABLE, unless force is set.
+func (t *Translation) Check(force bool) error {
+	switch {
+	case force:
+		return nil
+	case t.unfiltered:
+		return fmt.Errorf("%s without WHERE changes every document of %s, add a WHERE or force it", t.statement, t.Index)
+	case t.drops:
+		return fmt.Errorf("%s deletes %s and every document of it, force it", t.statement, t.Index)
+	}
+	return nil
+}
+
+// Writes returns true for the statements changing documents or indices.
+func (t *Translation) Writes() bool {
+	return t.write
+}
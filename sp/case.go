@@ -0,0 +1,231 @@
+package sp
+
+import (
+	"fmt"
+	"math"
+)
+
+// caseBound is a range condition on a field, nil bounds are unbounded.
+type caseBound struct {
+	field string
+	// ops maps GT, GTE, LT and LTE to their literal bound.
+	ops map[Token]interface{}
+}
+
+// caseBuckets returns a native bucket aggregation for a CASE whose WHEN
+// conditions are ranges of one field and whose results are distinct
+// literals: a range aggregation when the ranges are half open and the
+// CASE has no ELSE, else a filters aggregation whose buckets exclude the
+// documents matched by the previous WHEN clauses, as CASE does.
+func caseBuckets(c *CaseExpr) (ESAgg, map[string]interface{}, bool) {
+	if c.Operand != nil {
+		return IllegalAgg, nil, false
+	}
+	keys := make([]string, 0, len(c.Whens)+1)
+	results := make([]Expr, 0, len(c.Whens)+1)
+	for _, w := range c.Whens {
+		results = append(results, w.Result)
+	}
+	if c.Else != nil {
+		results = append(results, c.Else)
+	}
+	for _, r := range results {
+		key, ok := bucketKey(r)
+		if !ok || containsString(keys, key) {
+			return IllegalAgg, nil, false
+		}
+		keys = append(keys, key)
+	}
+
+	bounds := make([]*caseBound, len(c.Whens))
+	for i, w := range c.Whens {
+		b, ok := rangeBound(w.Cond)
+		if !ok || (i > 0 && b.field != bounds[0].field) {
+			return IllegalAgg, nil, false
+		}
+		bounds[i] = b
+	}
+	field := bounds[0].field
+
+	if c.Else == nil {
+		if ranges, ok := disjointRanges(bounds, keys); ok {
+			return Range, map[string]interface{}{"field": field, "keyed": true, "ranges": ranges}, true
+		}
+	}
+
+	filters := make(map[string]interface{}, len(keys))
+	for i, key := range keys {
+		var mustNot []interface{}
+		for _, b := range bounds[:i] {
+			mustNot = append(mustNot, b.query())
+		}
+		var filter interface{}
+		if i < len(bounds) {
+			filter = bounds[i].query()
+		}
+		switch {
+		case len(mustNot) == 0:
+			filters[key] = filter
+		case filter == nil:
+			filters[key] = map[string]interface{}{"bool": map[string]interface{}{"must_not": mustNot}}
+		default:
+			filters[key] = map[string]interface{}{"bool": map[string]interface{}{"filter": filter, "must_not": mustNot}}
+		}
+	}
+	return Filters, map[string]interface{}{"filters": filters}, true
+}
+
+// bucketKey returns the bucket key of a CASE result literal.
+func bucketKey(expr Expr) (string, bool) {
+	switch lit := expr.(type) {
+	case *StringLiteral:
+		return lit.Val, true
+	case *IntegerLiteral:
+		return lit.String(), true
+	case *NumberLiteral:
+		return fmt.Sprint(lit.Val), true
+	}
+	return "", false
+}
+
+// rangeBound returns the range of a condition comparing one field to
+// literals, e.g. x >= 10 AND x < 100.
+func rangeBound(cond Expr) (*caseBound, bool) {
+	b := &caseBound{ops: make(map[Token]interface{})}
+	for _, c := range conjuncts(cond) {
+		e, ok := c.(*BinaryExpr)
+		if !ok {
+			return nil, false
+		}
+		ref, lit, op := e.LHS, e.RHS, e.Op
+		if _, ok := ref.(*VarRef); !ok {
+			// 10 <= x is x >= 10
+			ref, lit = e.RHS, e.LHS
+			op = map[Token]Token{LT: GT, LTE: GTE, GT: LT, GTE: LTE}[op]
+		}
+		r, ok := ref.(*VarRef)
+		if !ok {
+			return nil, false
+		}
+		switch op {
+		case LT, LTE, GT, GTE:
+		default:
+			return nil, false
+		}
+		v, ok := rangeValue(lit)
+		if !ok {
+			return nil, false
+		}
+		field := cleanDocString(r.Val)
+		if b.field != "" && b.field != field {
+			return nil, false
+		}
+		if _, dup := b.ops[op]; dup {
+			return nil, false
+		}
+		b.field = field
+		b.ops[op] = v
+	}
+	_, gt := b.ops[GT]
+	_, gte := b.ops[GTE]
+	_, lt := b.ops[LT]
+	_, lte := b.ops[LTE]
+	if (gt && gte) || (lt && lte) {
+		return nil, false
+	}
+	return b, true
+}
+
+// rangeValue returns the value of a range bound literal.
+func rangeValue(expr Expr) (interface{}, bool) {
+	switch lit := expr.(type) {
+	case *IntegerLiteral:
+		return lit.Val, true
+	case *NumberLiteral:
+		return lit.Val, true
+	case *StringLiteral:
+		// dates
+		return lit.Val, true
+	}
+	return nil, false
+}
+
+// query returns the range query of the bound.
+func (b *caseBound) query() map[string]interface{} {
+	r := make(map[string]interface{}, len(b.ops))
+	for op, v := range b.ops {
+		r[map[Token]string{GT: "gt", GTE: "gte", LT: "lt", LTE: "lte"}[op]] = v
+	}
+	return map[string]interface{}{"range": map[string]interface{}{b.field: r}}
+}
+
+// disjointRanges returns the keyed ranges of a range aggregation for
+// bounds with inclusive lower and exclusive upper bounds. A bound without
+// lower bound starts where the ranges before it end, as its CASE clause
+// only sees the values they did not match; other overlaps can not be
+// expressed by a range aggregation.
+func disjointRanges(bounds []*caseBound, keys []string) ([]map[string]interface{}, bool) {
+	var ranges []map[string]interface{}
+	var from, to []float64
+	// covered is the end of the ranges covering every value below it.
+	covered, coveredLit := math.Inf(-1), interface{}(nil)
+	for i, b := range bounds {
+		if _, ok := b.ops[GT]; ok {
+			return nil, false
+		}
+		if _, ok := b.ops[LTE]; ok {
+			return nil, false
+		}
+		loLit, hiLit := b.ops[GTE], b.ops[LT]
+		lo, hi := math.Inf(-1), math.Inf(1)
+		if loLit != nil {
+			v, ok := numberBound(loLit)
+			if !ok {
+				return nil, false
+			}
+			lo = v
+		} else if coveredLit != nil {
+			lo, loLit = covered, coveredLit
+		}
+		if hiLit != nil {
+			v, ok := numberBound(hiLit)
+			if !ok {
+				return nil, false
+			}
+			hi = v
+		}
+		if lo >= hi {
+			return nil, false
+		}
+		for j := range from {
+			if lo < to[j] && from[j] < hi {
+				return nil, false
+			}
+		}
+		from, to = append(from, lo), append(to, hi)
+		if lo <= covered && hi > covered {
+			covered, coveredLit = hi, hiLit
+		}
+
+		r := map[string]interface{}{"key": keys[i]}
+		if loLit != nil {
+			r["from"] = loLit
+		}
+		if hiLit != nil {
+			r["to"] = hiLit
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, true
+}
+
+// numberBound returns a numeric bound as a float.
+func numberBound(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,533 @@
+package sp
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CreateTableStatement creates an index with the mapping of its columns,
+// CREATE TABLE index (field type, ...) [WITH (option = value, ...)].
+type CreateTableStatement struct {
+	Source  *Measurement
+	Columns []*ColumnDef
+	Options []*TableOption
+}
+
+// CreateTemplateStatement creates an index template applied to the indices
+// matching its patterns, CREATE INDEX TEMPLATE name ON pattern [, ...]
+// (field type, ...) [WITH (option = value, ...)].
+type CreateTemplateStatement struct {
+	Name     string
+	Patterns []string
+	Columns  []*ColumnDef
+	Options  []*TableOption
+}
+
+// DropTableStatement deletes an index, DROP TABLE index.
+type DropTableStatement struct {
+	Source *Measurement
+}
+
+// DropTemplateStatement deletes an index template, DROP INDEX TEMPLATE name.
+type DropTemplateStatement struct {
+	Name string
+}
+
+// ColumnDef is a field of a mapping, name type [FORMAT 'format'], or
+// name object(field type, ...) and name nested(field type, ...) for the
+// fields of objects and nested documents.
+type ColumnDef struct {
+	Name    *VarRef
+	Type    string
+	Format  string
+	Columns []*ColumnDef
+}
+
+// TableOption is an index setting of CREATE TABLE, option = value.
+type TableOption struct {
+	Name  string
+	Value Literal
+}
+
+// String returns a string representation of the statement.
+func (s *CreateTableStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("CREATE TABLE ")
+	_, _ = buf.WriteString(s.Source.String())
+	writeTable(&buf, s.Columns, s.Options)
+	return buf.String()
+}
+
+// String returns a string representation of the statement.
+func (s *CreateTemplateStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("CREATE INDEX TEMPLATE ")
+	_, _ = buf.WriteString(QuoteIdent(s.Name))
+	_, _ = buf.WriteString(" ON ")
+	for i, pattern := range s.Patterns {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = buf.WriteString((&Measurement{Database: pattern}).String())
+	}
+	writeTable(&buf, s.Columns, s.Options)
+	return buf.String()
+}
+
+// String returns a string representation of the statement.
+func (s *DropTableStatement) String() string {
+	return "DROP TABLE " + s.Source.String()
+}
+
+// String returns a string representation of the statement.
+func (s *DropTemplateStatement) String() string {
+	return "DROP INDEX TEMPLATE " + QuoteIdent(s.Name)
+}
+
+// String returns a string representation of the column.
+func (c *ColumnDef) String() string {
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "%s %s", c.Name, c.Type)
+	if c.Columns != nil {
+		writeColumns(&buf, c.Columns)
+	}
+	if c.Format != "" {
+		_, _ = buf.WriteString(" FORMAT ")
+		_, _ = buf.WriteString(QuoteString(c.Format))
+	}
+	return buf.String()
+}
+
+func writeTable(buf *bytes.Buffer, columns []*ColumnDef, options []*TableOption) {
+	_, _ = buf.WriteString(" ")
+	writeColumns(buf, columns)
+	if len(options) == 0 {
+		return
+	}
+	_, _ = buf.WriteString(" WITH (")
+	for i, o := range options {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = fmt.Fprintf(buf, "%s = %s", o.Name, o.Value)
+	}
+	_, _ = buf.WriteString(")")
+}
+
+func writeColumns(buf *bytes.Buffer, columns []*ColumnDef) {
+	_, _ = buf.WriteString("(")
+	for i, c := range columns {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = buf.WriteString(c.String())
+	}
+	_, _ = buf.WriteString(")")
+}
+
+// Mapping types of the columns, object and nested columns hold fields.
+var columnTypes = []string{
+	"binary", "boolean", "byte", "constant_keyword", "date", "date_nanos", "double",
+	"float", "geo_point", "geo_shape", "half_float", "integer", "ip", "keyword",
+	"long", "nested", "object", "short", "text", "unsigned_long", "wildcard",
+}
+
+// tableOption is a WITH option, set in the index settings unless it has no
+// setting.
+type tableOption struct {
+	setting string
+	integer bool
+	// template is set for the options of index templates only.
+	template bool
+}
+
+var tableOptions = map[string]tableOption{
+	"shards":           {setting: "number_of_shards", integer: true},
+	"replicas":         {setting: "number_of_replicas", integer: true},
+	"refresh_interval": {setting: "refresh_interval"},
+	// type is the mapping type of clusters older than 7.0
+	"type":  {},
+	"order": {integer: true, template: true},
+}
+
+func tableOptionNames(template bool) []string {
+	var names []string
+	for name, o := range tableOptions {
+		if !o.template || template {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCreateStatement parses "CREATE TABLE ..." or "CREATE INDEX TEMPLATE
+// ...", the CREATE token has already been consumed.
+func (p *Parser) parseCreateStatement() (Statement, error) {
+	template, err := p.parseTableKind()
+	if err != nil {
+		return nil, err
+	}
+	if !template {
+		stmt := &CreateTableStatement{}
+		if stmt.Source, err = p.parseSource(); err != nil {
+			return nil, err
+		}
+		if stmt.Columns, stmt.Options, err = p.parseTable(false); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+
+	stmt := &CreateTemplateStatement{}
+	if stmt.Name, err = p.parseIdent(); err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != ON {
+		return nil, newParseError(tokstr(tok, lit), []string{"ON"}, pos)
+	}
+	for {
+		src, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Patterns = append(stmt.Patterns, src.Database)
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	if stmt.Columns, stmt.Options, err = p.parseTable(true); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseDropStatement parses "DROP TABLE index" or "DROP INDEX TEMPLATE
+// name", the DROP token has already been consumed.
+func (p *Parser) parseDropStatement() (Statement, error) {
+	template, err := p.parseTableKind()
+	if err != nil {
+		return nil, err
+	}
+	if template {
+		name, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &DropTemplateStatement{Name: name}, p.parseEnd()
+	}
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	src, err := p.parseSource()
+	if err != nil {
+		return nil, err
+	}
+	// patterns, lists and _all delete every index they match
+	if src.Database == "_all" || strings.ContainsAny(src.Database, "*?,") {
+		return nil, &ParseError{Message: fmt.Sprintf("DROP TABLE deletes one index, %s names many", src.Database), Pos: pos}
+	}
+	if tok, pos, _ := p.scanIgnoreWhitespace(); tok == COMMA {
+		return nil, &ParseError{Message: "DROP TABLE deletes one index, not a list", Pos: pos}
+	}
+	p.unscan()
+	return &DropTableStatement{Source: src}, p.parseEnd()
+}
+
+// parseTableKind parses "TABLE" or "INDEX TEMPLATE", and reports the latter.
+func (p *Parser) parseTableKind() (bool, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch {
+	case tok == IDENT && strings.EqualFold(lit, "table"):
+		return false, nil
+	case tok == IDENT && strings.EqualFold(lit, "index"):
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "template") {
+			return false, newParseError(tokstr(tok, lit), []string{"TEMPLATE"}, pos)
+		}
+		return true, nil
+	}
+	return false, newParseError(tokstr(tok, lit), []string{"TABLE", "INDEX"}, pos)
+}
+
+// parseTable parses "(field type, ...) [WITH (option = value, ...)]" up to
+// the end of the statement.
+func (p *Parser) parseTable(template bool) ([]*ColumnDef, []*TableOption, error) {
+	columns, err := p.parseColumnDefs()
+	if err != nil {
+		return nil, nil, err
+	}
+	var options []*TableOption
+	if tok, _, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.EqualFold(lit, "with") {
+		if options, err = p.parseTableOptions(template); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		p.unscan()
+	}
+	if err := p.parseEnd(); err != nil {
+		return nil, nil, err
+	}
+	if err := validateColumnDefs(columns); err != nil {
+		return nil, nil, p.locate(err)
+	}
+	return columns, options, nil
+}
+
+// parseColumnDefs parses "(field type [FORMAT 'format'], ...)".
+func (p *Parser) parseColumnDefs() ([]*ColumnDef, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	var columns []*ColumnDef
+	for {
+		c, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok == RPAREN {
+			return columns, nil
+		}
+		if tok != COMMA {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+	}
+}
+
+// parseColumnDef parses "field type [FORMAT 'format']", or
+// "field object(...)" and "field nested(...)".
+func (p *Parser) parseColumnDef() (*ColumnDef, error) {
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	c := &ColumnDef{}
+	var err error
+	if c.Name, err = p.parseVarRef(); err != nil {
+		return nil, err
+	}
+	p.setSpan(c.Name, pos)
+
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return nil, newParseError(tokstr(tok, lit), []string{"type"}, pos)
+	}
+	c.Type = strings.ToLower(lit)
+	if !containsString(columnTypes, c.Type) {
+		return nil, &ParseError{Message: fmt.Sprintf("unknown type %s", lit), Pos: pos, Suggestion: closest(c.Type, columnTypes)}
+	}
+	tok, pos, lit = p.scan()
+	p.unscan()
+	switch {
+	case c.Type == "object" || c.Type == "nested":
+		if c.Columns, err = p.parseColumnDefs(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case tok == LPAREN:
+		return nil, &ParseError{Message: fmt.Sprintf("%s columns have no fields, only object and nested ones have", c.Type), Pos: pos}
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.EqualFold(lit, "format") {
+		if c.Type != "date" && c.Type != "date_nanos" {
+			return nil, &ParseError{Message: fmt.Sprintf("FORMAT only applies to date columns, %s is a %s column", c.Name, c.Type), Pos: pos}
+		}
+		if c.Format, err = p.parseString(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+	return c, nil
+}
+
+// parseTableOptions parses "(option = value, ...)", the WITH token has
+// already been consumed.
+func (p *Parser) parseTableOptions(template bool) ([]*TableOption, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	var options []*TableOption
+	seen := make(map[string]bool)
+	for {
+		// an option may be a reserved word, e.g. order
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok != IDENT && !tok.isWord() {
+			return nil, newParseError(tokstr(tok, lit), []string{"option"}, pos)
+		}
+		name := strings.ToLower(tokstr(tok, lit))
+		spec, ok := tableOptions[name]
+		if !ok || (spec.template && !template) {
+			return nil, &ParseError{Message: fmt.Sprintf("unknown option %s", tokstr(tok, lit)), Pos: pos, Suggestion: closest(name, tableOptionNames(template))}
+		}
+		if seen[name] {
+			return nil, &ParseError{Message: fmt.Sprintf("duplicate option %s", name), Pos: pos}
+		}
+		seen[name] = true
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EQ {
+			return nil, newParseError(tokstr(tok, lit), []string{"="}, pos)
+		}
+
+		tok, pos, lit = p.scanIgnoreWhitespace()
+		o := &TableOption{Name: name}
+		switch {
+		case tok == INTEGER && spec.integer:
+			v, err := strconv.ParseInt(lit, 10, 64)
+			if err != nil {
+				return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
+			}
+			o.Value = &IntegerLiteral{Val: v}
+		case tok == STRING && !spec.integer:
+			o.Value = &StringLiteral{Val: lit}
+		case spec.integer:
+			return nil, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+		default:
+			return nil, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+		}
+		options = append(options, o)
+
+		tok, pos, lit = p.scanIgnoreWhitespace()
+		if tok == RPAREN {
+			return options, nil
+		}
+		if tok != COMMA {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+	}
+}
+
+// validateColumnDefs checks the columns of an object are listed once.
+func validateColumnDefs(columns []*ColumnDef) error {
+	seen := make(map[string]bool)
+	for _, c := range columns {
+		if seen[c.Name.Val] {
+			return errorAt(c.Name, fmt.Errorf("column %s is listed twice", c.Name))
+		}
+		seen[c.Name.Val] = true
+		if err := validateColumnDefs(c.Columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// properties returns the mapping properties of the columns, a dotted name
+// is a field of an object.
+func properties(columns []*ColumnDef) (map[string]interface{}, error) {
+	props := make(map[string]interface{})
+	for _, c := range columns {
+		m := map[string]interface{}{"type": c.Type}
+		if c.Format != "" {
+			m["format"] = c.Format
+		}
+		if c.Columns != nil {
+			sub, err := properties(c.Columns)
+			if err != nil {
+				return nil, err
+			}
+			m["properties"] = sub
+		}
+		if c.Type == "object" {
+			// object is the default type of fields with properties
+			delete(m, "type")
+		}
+
+		segments := c.Name.Segments
+		parent := props
+		for i, seg := range segments[:len(segments)-1] {
+			obj, ok := parent[seg].(map[string]interface{})
+			if !ok {
+				obj = map[string]interface{}{"properties": make(map[string]interface{})}
+				parent[seg] = obj
+			}
+			sub, ok := obj["properties"].(map[string]interface{})
+			if !ok {
+				return nil, errorAt(c.Name, fmt.Errorf("%s is not an object, %s can not be one of its fields", strings.Join(segments[:i+1], "."), c.Name))
+			}
+			parent = sub
+		}
+		name := segments[len(segments)-1]
+		if _, ok := parent[name]; ok {
+			return nil, errorAt(c.Name, fmt.Errorf("column %s is listed twice", c.Name))
+		}
+		parent[name] = m
+	}
+	return props, nil
+}
+
+// indexBody returns the mappings and settings of the columns and options.
+func indexBody(columns []*ColumnDef, options []*TableOption) (map[string]interface{}, error) {
+	props, err := properties(columns)
+	if err != nil {
+		return nil, err
+	}
+	var mappings interface{} = map[string]interface{}{"properties": props}
+	body := make(map[string]interface{})
+	settings := make(map[string]interface{})
+	for _, o := range options {
+		switch spec := tableOptions[o.Name]; {
+		case spec.setting != "":
+			settings[spec.setting] = literalValue(o.Value)
+		case o.Name == "type":
+			mappings = map[string]interface{}{literalValue(o.Value).(string): mappings}
+		default:
+			body[o.Name] = literalValue(o.Value)
+		}
+	}
+	body["mappings"] = mappings
+	if len(settings) > 0 {
+		body["settings"] = settings
+	}
+	return body, nil
+}
+
+// ddl returns the translation of a statement creating or deleting an index
+// or a template, its row acknowledges the request.
+func ddl(statement, index string, r *Request) *Translation {
+	t := &Translation{
+		Index:     index,
+		Columns:   []*Column{{Name: "acknowledged", Path: []string{"acknowledged"}}},
+		Requests:  []*Request{r},
+		statement: statement,
+		write:     true,
+	}
+	t.rows = func(resps [][]byte) ([][]interface{}, error) {
+		return t.Rows(resps[0])
+	}
+	return t
+}
+
+// translate returns the request creating the index with its mapping.
+func (s *CreateTableStatement) translate() (*Translation, error) {
+	body, err := indexBody(s.Columns, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	return ddl("CREATE TABLE", s.Source.Database, &Request{Method: "PUT", Path: "/" + url.PathEscape(s.Source.Database), Body: body}), nil
+}
+
+// translate returns the request creating the index template.
+func (s *CreateTemplateStatement) translate() (*Translation, error) {
+	body, err := indexBody(s.Columns, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	body["index_patterns"] = s.Patterns
+	return ddl("CREATE INDEX TEMPLATE", strings.Join(s.Patterns, ","), &Request{Method: "PUT", Path: "/_template/" + url.PathEscape(s.Name), Body: body}), nil
+}
+
+// translate returns the request deleting the index, which is only run
+// when forced.
+func (s *DropTableStatement) translate() (*Translation, error) {
+	t := ddl("DROP TABLE", s.Source.Database, &Request{Method: "DELETE", Path: "/" + url.PathEscape(s.Source.Database)})
+	t.drops = true
+	return t, nil
+}
+
+// translate returns the request deleting the index template.
+func (s *DropTemplateStatement) translate() (*Translation, error) {
+	return ddl("DROP INDEX TEMPLATE", "", &Request{Method: "DELETE", Path: "/_template/" + url.PathEscape(s.Name)}), nil
+}
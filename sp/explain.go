@@ -0,0 +1,235 @@
+package sp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plans of an explained statement.
+const (
+	// PlanSearch is one search request.
+	PlanSearch = "search"
+	// PlanScan reads every page of a LIMIT ALL search.
+	PlanScan = "scan"
+	// PlanJoin is the hash join of the searches of two indices.
+	PlanJoin = "join"
+	// PlanUnion appends the rows of the searches of a UNION ALL.
+	PlanUnion = "union"
+)
+
+// Strategies of the WHERE conditions.
+const (
+	// StrategyQuery is the native query of a predicate function.
+	StrategyQuery = "query"
+	// StrategyNested is a script within the nested query of the path of
+	// its fields.
+	StrategyNested = "nested"
+	// StrategyScript is a painless script run on every document, the
+	// fallback of the conditions without a native query.
+	StrategyScript = "script"
+	// StrategySubquery is a terms query on the values of an IN subquery
+	// run first.
+	StrategySubquery = "subquery"
+	// StrategyTermsLookup is a terms query reading its values from a
+	// document.
+	StrategyTermsLookup = "terms_lookup"
+	// StrategyExists is the exists query of a GROUP BY field.
+	StrategyExists = "exists"
+)
+
+// Explanation is how a statement is translated, the result of EXPLAIN.
+type Explanation struct {
+	// Statement is the normalized statement.
+	Statement string `json:"statement"`
+	Index     string `json:"index"`
+	Plan      string `json:"plan"`
+	// Predicates are the WHERE conditions and how they are searched.
+	Predicates []*PredicatePlan `json:"predicates,omitempty"`
+	// Aggregations are the aggregations of the body, outermost first.
+	Aggregations []*AggregationPlan `json:"aggregations,omitempty"`
+	Warnings     []string           `json:"warnings,omitempty"`
+	// Statements explain the sides of a JOIN or the statements of a UNION ALL.
+	Statements []*Explanation `json:"statements,omitempty"`
+}
+
+// PredicatePlan is a WHERE condition and how it is searched.
+type PredicatePlan struct {
+	Condition string   `json:"condition"`
+	Fields    []string `json:"fields,omitempty"`
+	Strategy  string   `json:"strategy"`
+	// Query is the type of the elasticsearch query.
+	Query string `json:"query"`
+	// Path is the nested path of the fields.
+	Path string `json:"path,omitempty"`
+}
+
+// AggregationPlan is an aggregation of the body.
+type AggregationPlan struct {
+	// Level is the depth of the aggregation, 1 at the top of the body.
+	Level int    `json:"level"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	// Path is the buckets_path of the aggregation from the top.
+	Path string `json:"path"`
+	// BucketsPath is what a pipeline aggregation reads.
+	BucketsPath interface{} `json:"buckets_path,omitempty"`
+}
+
+// translateExplain translates an EXPLAIN statement, the explanation is
+// made before the translation rewrites the conditions.
+func (s *SelectStatement) translateExplain() (*Translation, error) {
+	stmt := *s
+	stmt.Explain = false
+	e, err := stmt.explain()
+	if err != nil {
+		return nil, err
+	}
+	t, err := stmt.translate()
+	if err != nil {
+		return nil, err
+	}
+	e.describe(t)
+	t.Explain = e
+	return t, nil
+}
+
+// explain returns the plan and the predicates of the statement.
+func (s *SelectStatement) explain() (*Explanation, error) {
+	e := &Explanation{Statement: s.String(), Plan: PlanSearch}
+	var stmts []*SelectStatement
+	switch {
+	case s.Join != nil:
+		e.Plan = PlanJoin
+		_, sides, err := s.joinPlan()
+		if err != nil {
+			return nil, err
+		}
+		stmts = sides[:]
+	case s.Union != nil:
+		e.Plan = PlanUnion
+		stmts = s.unionStatements()
+	case s.LimitAll:
+		e.Plan = PlanScan
+	}
+	for _, stmt := range stmts {
+		sub, err := stmt.explain()
+		if err != nil {
+			return nil, err
+		}
+		e.Statements = append(e.Statements, sub)
+	}
+	if len(stmts) == 0 {
+		e.Predicates = s.predicatePlans()
+	}
+	return e, nil
+}
+
+// describe completes the explanation with the index, the aggregations and
+// the warnings of the translation.
+func (e *Explanation) describe(t *Translation) {
+	e.Index = t.Index
+	e.Warnings = append(e.Warnings, t.Warnings...)
+	var searches []*Translation
+	switch {
+	case t.Join != nil:
+		for _, side := range t.Join.Sides {
+			searches = append(searches, side.Search)
+		}
+	case t.Union != nil:
+		searches = t.Union.Searches
+	}
+	for i, sub := range e.Statements {
+		sub.describe(searches[i])
+	}
+
+	var path []string
+	for i, a := range t.baggs {
+		path = append(path, a.name)
+		e.Aggregations = append(e.Aggregations, &AggregationPlan{Level: i + 1, Name: a.name, Type: a.typeName(), Path: strings.Join(path, ">")})
+		if size, ok := a.params["size"].(int); ok && a.typ == Terms && size == 0 {
+			e.Warnings = append(e.Warnings, fmt.Sprintf("terms size 0 of %s returns every bucket on elasticsearch 2.x, it is invalid on 5.x and later, set a LIMIT", a.name))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, a := range t.maggs {
+		// a metric of a bucket script may be selected too, once in the body
+		if seen[a.name] {
+			continue
+		}
+		seen[a.name] = true
+		p := &AggregationPlan{Level: len(t.baggs) + 1, Name: a.name, Type: a.typeName(), Path: a.bucketsPath()}
+		if a.typ == StarCount {
+			p.Type, p.Path = "count", "_count"
+		}
+		if len(path) > 0 {
+			p.Path = strings.Join(path, ">") + ">" + p.Path
+		}
+		if a.typ > pipelineBegin && a.typ < pipelineEnd {
+			p.BucketsPath = a.params["buckets_path"]
+		}
+		e.Aggregations = append(e.Aggregations, p)
+	}
+}
+
+// predicatePlans returns the strategy of every WHERE condition, split as
+// queryFilters does, and the exists queries of the GROUP BY fields.
+func (s *SelectStatement) predicatePlans() []*PredicatePlan {
+	var plans, scripts []*PredicatePlan
+	split := false
+	for _, cond := range conjuncts(s.Condition) {
+		p := &PredicatePlan{Condition: cond.String(), Fields: uniqueStrings(walkNames(cond)), Strategy: StrategyScript, Query: "script"}
+		e, sub := subqueryCondition(cond)
+		c, _ := cond.(*Call)
+		switch {
+		case sub:
+			p.Fields = []string{e.LHS.String()}
+			p.Strategy, p.Query = StrategySubquery, "terms"
+			if _, ok := termsLookup(e.RHS.(*SubqueryExpr).Statement); ok {
+				p.Strategy = StrategyTermsLookup
+			}
+		case c != nil && c.Name == "nested":
+			p.Strategy, p.Query, p.Path = StrategyNested, "nested", nestedCallPath(c)
+		case c != nil && isPredicateCall(c):
+			p.Strategy, p.Query = StrategyQuery, c.Name
+			if q, err := lookupFunction(c).Query(c); err == nil {
+				for typ := range q {
+					p.Query = typ
+				}
+			}
+			p.Path = nestedScope(c)
+		case nestedScope(cond) != "":
+			p.Strategy, p.Query, p.Path = StrategyNested, "nested", nestedScope(cond)
+		default:
+			scripts = append(scripts, p)
+			continue
+		}
+		split = true
+		plans = append(plans, p)
+	}
+	if !split && s.Condition != nil {
+		// the condition is one script when nothing is split out
+		scripts = []*PredicatePlan{{Condition: s.Condition.String(), Fields: uniqueStrings(walkNames(s.Condition)), Strategy: StrategyScript, Query: "script"}}
+	}
+	plans = append(plans, scripts...)
+
+	for _, d := range s.Dimensions {
+		if isConditional(d.Expr) {
+			continue
+		}
+		for _, f := range walkNames(d.Expr) {
+			plans = append(plans, &PredicatePlan{Condition: f + " exists", Fields: []string{f}, Strategy: StrategyExists, Query: "exists", Path: DefaultSchema.NestedPath(f)})
+		}
+	}
+	return plans
+}
+
+// uniqueStrings returns a without its repeated strings, in order.
+func uniqueStrings(a []string) []string {
+	var unique []string
+	for _, s := range a {
+		if !containsString(unique, s) {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,458 @@
+package sp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FunctionKind is the role of a function in a statement.
+type FunctionKind int
+
+const (
+	// MetricFunction is an aggregate of the select fields, e.g. avg(x),
+	// translated into a metric aggregation.
+	MetricFunction FunctionKind = iota + 1
+	// BucketFunction is a GROUP BY function translated into a bucket
+	// aggregation, e.g. histogram(x, 10).
+	BucketFunction
+	// PipelineFunction is a select field computed from a metric of the
+	// histogram buckets, e.g. derivative(sum(x)).
+	PipelineFunction
+	// ScalarFunction is computed by a script from the values of a document.
+	ScalarFunction
+	// PredicateFunction is a WHERE condition translated into a filter query.
+	PredicateFunction
+)
+
+var functionKinds = [...]string{
+	MetricFunction:    "metric",
+	BucketFunction:    "bucket",
+	PipelineFunction:  "pipeline",
+	ScalarFunction:    "scalar",
+	PredicateFunction: "predicate",
+}
+
+// String returns the name of the kind.
+func (k FunctionKind) String() string {
+	if k > 0 && int(k) < len(functionKinds) {
+		return functionKinds[k]
+	}
+	return "unknown"
+}
+
+// Function describes a function of the language: how its calls are
+// checked and translated.
+type Function struct {
+	Name string
+	Kind FunctionKind
+	// Args are the names of the arguments shown in errors, e.g. field and
+	// interval. The last Optional arguments may be omitted, Variadic
+	// functions repeat their last argument.
+	Args     []string
+	Optional int
+	Variadic bool
+	// Options are the names of the options of metric, bucket, pipeline and
+	// predicate functions, given as trailing name = value arguments and
+	// merged into the params of their aggregation.
+	Options []string
+	// Check validates the arguments of a call, its arity and options are
+	// already checked.
+	Check func(c *Call) error
+	// Agg returns the type and the params of the aggregation of a metric,
+	// bucket or pipeline call. The buckets_path of a pipeline is set by the
+	// translator from its metric argument.
+	Agg func(c *Call) (string, map[string]interface{}, error)
+	// Query returns the filter query of a predicate call.
+	Query func(c *Call) (map[string]interface{}, error)
+	// Script returns the painless script of a scalar call from its printed
+	// arguments.
+	Script func(args []string) string
+
+	// scalar renders the scalar functions and holds their argument types.
+	scalar *scalarFunction
+	// translated is set for the predicates the translator splits itself,
+	// which have no Query, e.g. nested().
+	translated bool
+}
+
+// functions holds the registered functions by name, a name may be shared by
+// functions of different arities, e.g. the max() metric and scalar. The
+// overload slices are replaced, never modified, so they can be read after
+// the lock is released.
+var (
+	functionsMu sync.RWMutex
+	functions   = make(map[string][]*Function)
+)
+
+// RegisterFunction registers a function, replacing the function of the
+// same name and kind. It is safe to call while statements are translated.
+func RegisterFunction(f *Function) error {
+	if f.Name == "" {
+		return fmt.Errorf("function without name")
+	}
+	if f.Optional > len(f.Args) {
+		return fmt.Errorf("%s() has more optional arguments than arguments", f.Name)
+	}
+	switch f.Kind {
+	case MetricFunction, BucketFunction, PipelineFunction:
+		if f.Agg == nil {
+			return fmt.Errorf("%s function %s() needs an Agg translation", f.Kind, f.Name)
+		}
+	case PredicateFunction:
+		if f.Query == nil && !f.translated {
+			return fmt.Errorf("%s function %s() needs a Query translation", f.Kind, f.Name)
+		}
+	case ScalarFunction:
+		if f.Script == nil && f.scalar == nil {
+			return fmt.Errorf("%s function %s() needs a Script translation", f.Kind, f.Name)
+		}
+		if f.scalar == nil {
+			f.scalar = customScalar(f)
+		}
+	default:
+		return fmt.Errorf("invalid kind of function %s()", f.Name)
+	}
+	f.Name = strings.ToLower(f.Name)
+
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	overloads := append([]*Function(nil), functions[f.Name]...)
+	for i, o := range overloads {
+		if o.Kind == f.Kind {
+			overloads[i] = f
+			functions[f.Name] = overloads
+			return nil
+		}
+	}
+	functions[f.Name] = append(overloads, f)
+	return nil
+}
+
+// overloadsOf returns the registered functions named name.
+func overloadsOf(name string) []*Function {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	return functions[name]
+}
+
+// customScalar returns the scalar function of a function registered with a
+// painless Script, its arguments have any type.
+func customScalar(f *Function) *scalarFunction {
+	args := make([]DataType, len(f.Args))
+	return &scalarFunction{
+		args:     args,
+		optional: f.Optional,
+		variadic: f.Variadic,
+		ret:      Unknown,
+		painless: func(c *Call, print func(Expr) string) string {
+			return f.Script(printArgs(c, print))
+		},
+	}
+}
+
+func mustRegister(f *Function) {
+	if err := RegisterFunction(f); err != nil {
+		panic(err)
+	}
+}
+
+// lookupFunction returns the function called by c, the overload accepting
+// its number of arguments, nil for unknown functions.
+func lookupFunction(c *Call) *Function {
+	overloads := overloadsOf(c.Name)
+	for _, f := range overloads {
+		if f.accepts(len(c.Args)) {
+			return f
+		}
+	}
+	if len(overloads) > 0 {
+		return overloads[0]
+	}
+	return nil
+}
+
+// callKind returns the kind of the function called by c, 0 when unknown.
+func callKind(c *Call) FunctionKind {
+	if f := lookupFunction(c); f != nil {
+		return f.Kind
+	}
+	return 0
+}
+
+// functionNames returns the sorted names of the functions of the kinds.
+func functionNames(kinds ...FunctionKind) []string {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	var names []string
+	for name, overloads := range functions {
+		for _, f := range overloads {
+			if containsKind(kinds, f.Kind) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsKind(kinds []FunctionKind, k FunctionKind) bool {
+	for _, kind := range kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts returns true if f can be called with n arguments.
+func (f *Function) accepts(n int) bool {
+	min := len(f.Args) - f.Optional
+	if f.Variadic {
+		return n >= min
+	}
+	return n >= min && n <= len(f.Args)
+}
+
+// signature returns the arguments of f, e.g. (field[, wrap_longitude]).
+func (f *Function) signature() string {
+	required := f.Args[:len(f.Args)-f.Optional]
+	s := strings.Join(required, ", ")
+	for _, arg := range f.Args[len(required):] {
+		if s == "" {
+			s = "[" + arg + "]"
+			continue
+		}
+		s += "[, " + arg + "]"
+	}
+	if f.Variadic {
+		s += ", ..."
+	}
+	return "(" + s + ")"
+}
+
+// arityError returns the error of a call of name with n arguments, which
+// expects one of signatures.
+func arityError(name string, signatures []string, n int) error {
+	plural := "s"
+	if n == 1 {
+		plural = ""
+	}
+	return fmt.Errorf("%s expects %s, got %d argument%s", name, strings.Join(signatures, " or "), n, plural)
+}
+
+// validateCall checks the arity, the options and the arguments of a call
+// of a registered function.
+func validateCall(c *Call) error {
+	f := lookupFunction(c)
+	if f == nil {
+		return nil
+	}
+	if !f.accepts(len(c.Args)) {
+		var signatures []string
+		for _, o := range overloadsOf(c.Name) {
+			signatures = append(signatures, o.signature())
+		}
+		return errorAt(c, arityError(c.Name, signatures, len(c.Args)))
+	}
+	for _, o := range c.Options {
+		if !containsString(f.Options, o.Name) {
+			if len(f.Options) == 0 {
+				return errorAt(c, fmt.Errorf("%s() has no options, got %s", c.Name, o.Name))
+			}
+			err := errorAt(c, fmt.Errorf("unknown option %s for %s(), expected one of %s", o.Name, c.Name, strings.Join(f.Options, ", ")))
+			if e, ok := err.(*nodeError); ok {
+				e.suggestion = closest(o.Name, f.Options)
+			}
+			return err
+		}
+	}
+	if f.Kind == ScalarFunction {
+		if err := validateScalar(c); err != nil {
+			return err
+		}
+	}
+	if f.Check != nil {
+		return errorAt(c, f.Check(c))
+	}
+	return nil
+}
+
+// callAgg returns the aggregation type and params of a metric, bucket or
+// pipeline call, with its options.
+func callAgg(c *Call) (string, map[string]interface{}, error) {
+	f := lookupFunction(c)
+	if f == nil || f.Agg == nil {
+		return "", nil, fmt.Errorf("%s() is not an aggregation", c.Name)
+	}
+	typ, params, err := f.Agg(c)
+	if err != nil {
+		return "", nil, err
+	}
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	for _, o := range c.Options {
+		params[o.Name] = literalValue(o.Value)
+	}
+	return typ, params, nil
+}
+
+// takesOptions returns true if a function named name may have options.
+func takesOptions(name string) bool {
+	for _, f := range overloadsOf(name) {
+		if f.Kind != ScalarFunction {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOptions moves the trailing name = literal arguments of the calls of
+// functions taking options into their options.
+func splitOptions(c *Call) {
+	if !takesOptions(c.Name) {
+		return
+	}
+	n := len(c.Args)
+	for n > 0 {
+		e, ok := c.Args[n-1].(*BinaryExpr)
+		if !ok || e.Op != EQ {
+			break
+		}
+		ref, ok := e.LHS.(*VarRef)
+		if !ok || len(ref.Segments) > 1 || literalValue(e.RHS) == nil {
+			break
+		}
+		n--
+	}
+	for _, arg := range c.Args[n:] {
+		e := arg.(*BinaryExpr)
+		c.Options = append(c.Options, &CallOption{Name: e.LHS.(*VarRef).Val, Value: e.RHS})
+	}
+	c.Args = c.Args[:n]
+}
+
+// metricAgg translates the metric calls of the aggregation typ.
+func metricAgg(typ ESAgg) func(c *Call) (string, map[string]interface{}, error) {
+	return func(c *Call) (string, map[string]interface{}, error) {
+		return aggs[typ], c.metricAggParams(), nil
+	}
+}
+
+func histogramAgg(c *Call) (string, map[string]interface{}, error) {
+	return aggs[Histogram], map[string]interface{}{
+		"field":         cleanDocString(c.Args[0].String()),
+		"interval":      c.Args[1].String(),
+		"min_doc_count": 0,
+	}, nil
+}
+
+func dateHistogramAgg(c *Call) (string, map[string]interface{}, error) {
+	//support `year`, `quarter`, `month`, `week`, `day`, `hour`, `minute`, `second`
+	return aggs[DateHistogram], map[string]interface{}{
+		"field":    cleanDocString(strings.Trim(c.Args[0].String(), "'")),
+		"interval": strings.Trim(c.Args[1].String(), "'"),
+	}, nil
+}
+
+func rangeAgg(c *Call) (string, map[string]interface{}, error) {
+	params := map[string]interface{}{"keyed": true}
+	switch arg0 := c.Args[0].(type) {
+	case *BinaryExpr:
+		params["script"] = script(arg0)
+	default:
+		params["field"] = cleanDocString(arg0.String())
+	}
+	args := c.Args[1:]
+	ranges := make([]map[string]string, 0, len(c.Args))
+	for i, arg := range args {
+		m := make(map[string]string)
+		if i == 0 {
+			m["to"] = arg.String()
+		} else {
+			m["from"] = args[i-1].String()
+			m["to"] = arg.String()
+		}
+		ranges = append(ranges, m)
+	}
+	ranges = append(ranges, map[string]string{"from": args[len(args)-1].String()})
+	params["ranges"] = ranges
+	return aggs[Range], params, nil
+}
+
+// pipelineAgg translates the pipeline calls of the aggregation typ, the
+// buckets_path is set by the translator.
+func pipelineAgg(typ ESAgg) func(c *Call) (string, map[string]interface{}, error) {
+	return func(c *Call) (string, map[string]interface{}, error) {
+		return aggs[typ], make(map[string]interface{}), nil
+	}
+}
+
+// checkMetricArg checks the argument of a pipeline is a metric call.
+func checkMetricArg(c *Call) error {
+	if m, ok := c.Args[0].(*Call); !ok || callKind(m) != MetricFunction {
+		return fmt.Errorf("expected metric argument in %s(), e.g. %s(sum(x))", c.Name, c.Name)
+	}
+	return nil
+}
+
+// checkGeoAgg checks the arguments of the geo_bounds and geo_centroid metrics.
+func checkGeoAgg(c *Call) error {
+	_, err := geoAggParams(c)
+	return err
+}
+
+func init() {
+	addMathFunctions()
+
+	for _, typ := range []ESAgg{Avg, Cardinality, ExtendedStats, Max, Min, Percentiles, PercentileRanks, Stats, Sum, Top, ValueCount} {
+		f := &Function{Name: aggs[typ], Kind: MetricFunction, Args: []string{"field"}, Agg: metricAgg(typ)}
+		switch typ {
+		case Cardinality:
+			f.Options = []string{"precision_threshold"}
+		case ExtendedStats:
+			f.Options = []string{"sigma"}
+		case Percentiles:
+			f.Options = []string{"percents"}
+		case PercentileRanks:
+			f.Options = []string{"values"}
+		}
+		mustRegister(f)
+	}
+	// sql count() is the value_count aggregation, count(*) the doc count
+	mustRegister(&Function{Name: "count", Kind: MetricFunction, Args: []string{"field"}, Agg: metricAgg(ValueCount)})
+	mustRegister(&Function{Name: aggs[GeoBounds], Kind: MetricFunction, Args: []string{"field", "wrap_longitude"}, Optional: 1, Check: checkGeoAgg, Agg: metricAgg(GeoBounds)})
+	mustRegister(&Function{Name: aggs[GeoCentroid], Kind: MetricFunction, Args: []string{"field"}, Check: checkGeoAgg, Agg: metricAgg(GeoCentroid)})
+
+	mustRegister(&Function{Name: "date_histogram", Kind: BucketFunction, Args: []string{"field", "interval"}, Options: []string{"format", "min_doc_count", "offset", "time_zone"}, Agg: dateHistogramAgg})
+	mustRegister(&Function{Name: "histogram", Kind: BucketFunction, Args: []string{"field", "interval"}, Options: []string{"min_doc_count", "offset"}, Agg: histogramAgg})
+	mustRegister(&Function{Name: "range", Kind: BucketFunction, Args: []string{"field", "bound"}, Variadic: true, Agg: rangeAgg})
+
+	mustRegister(&Function{Name: "derivative", Kind: PipelineFunction, Args: []string{"metric"}, Options: []string{"gap_policy", "unit"}, Check: checkMetricArg, Agg: pipelineAgg(Derivative)})
+	mustRegister(&Function{Name: "cumulative_sum", Kind: PipelineFunction, Args: []string{"metric"}, Check: checkMetricArg, Agg: pipelineAgg(CumulativeSum)})
+
+	mustRegister(&Function{Name: "geo_distance", Kind: PredicateFunction, Args: []string{"field", "lat", "lon", "distance"}, Query: geoDistanceQuery})
+	bbox := []string{"field", "top_left_lat", "top_left_lon", "bottom_right_lat", "bottom_right_lon"}
+	mustRegister(&Function{Name: "geo_bbox", Kind: PredicateFunction, Args: bbox, Query: geoBoundingBoxQuery})
+	mustRegister(&Function{Name: "geo_bounding_box", Kind: PredicateFunction, Args: bbox, Query: geoBoundingBoxQuery})
+	mustRegister(&Function{Name: "geo_polygon", Kind: PredicateFunction, Args: []string{"field", "[points]"}, Query: geoPolygonQuery})
+	// nested() is split by queryFilters itself
+	mustRegister(&Function{Name: "nested", Kind: PredicateFunction, Args: []string{"path", "condition"}, Check: validateNestedCall, translated: true})
+
+	names := make([]string, 0, len(scalarFunctions))
+	for name := range scalarFunctions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sf := scalarFunctions[name]
+		args := make([]string, len(sf.args))
+		for i, t := range sf.args {
+			args[i] = typeName(t)
+		}
+		mustRegister(&Function{Name: name, Kind: ScalarFunction, Args: args, Optional: sf.optional, Variadic: sf.variadic, scalar: sf})
+	}
+}
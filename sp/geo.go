@@ -0,0 +1,194 @@
+package sp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isPredicateCall returns true if expr is a function call only usable as a
+// top level WHERE condition, the predicates are registered functions.
+func isPredicateCall(expr Expr) bool {
+	c, ok := expr.(*Call)
+	return ok && callKind(c) == PredicateFunction
+}
+
+// containsPredicateCall returns the first predicate function called in expr.
+func containsPredicateCall(expr Expr) *Call {
+	var found *Call
+	WalkFunc(expr, func(n Node) {
+		if c, ok := n.(*Call); ok && found == nil && isPredicateCall(c) {
+			found = c
+		}
+	})
+	return found
+}
+
+// geoField returns the geo_point field argument of a geo function.
+func geoField(c *Call) (string, error) {
+	ref, ok := c.Args[0].(*VarRef)
+	if !ok {
+		return "", fmt.Errorf("expected field argument in %s()", c.Name)
+	}
+	return cleanDocString(ref.Val), nil
+}
+
+// geoPoint returns the lat/lon point of the arguments at i and i+1.
+func geoPoint(c *Call, i int) (map[string]interface{}, error) {
+	lat, ok := numberArg(c.Args[i])
+	if !ok {
+		return nil, fmt.Errorf("expected latitude number in %s(), got %s", c.Name, c.Args[i].String())
+	}
+	lon, ok := numberArg(c.Args[i+1])
+	if !ok {
+		return nil, fmt.Errorf("expected longitude number in %s(), got %s", c.Name, c.Args[i+1].String())
+	}
+	if lat < -90 || lat > 90 {
+		return nil, fmt.Errorf("invalid latitude %v in %s(), must be -90 <= lat <= 90", lat, c.Name)
+	}
+	if lon < -180 || lon > 180 {
+		return nil, fmt.Errorf("invalid longitude %v in %s(), must be -180 <= lon <= 180", lon, c.Name)
+	}
+	return map[string]interface{}{"lat": lat, "lon": lon}, nil
+}
+
+// numberArg returns the value of a numeric literal, negative numbers included.
+func numberArg(expr Expr) (float64, bool) {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return e.Val, true
+	case *IntegerLiteral:
+		return float64(e.Val), true
+	case *ParenExpr:
+		return numberArg(e.Expr)
+	case *BinaryExpr:
+		// negative numbers are parsed as 0-x
+		if l, ok := e.LHS.(*IntegerLiteral); ok && l.Val == 0 && e.Op == SUB {
+			v, ok := numberArg(e.RHS)
+			return -v, ok
+		}
+	}
+	return 0, false
+}
+
+// geoDistanceQuery translates geo_distance(field, lat, lon, distance).
+func geoDistanceQuery(c *Call) (map[string]interface{}, error) {
+	field, err := geoField(c)
+	if err != nil {
+		return nil, err
+	}
+	point, err := geoPoint(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	var distance interface{}
+	switch arg := c.Args[3].(type) {
+	case *StringLiteral:
+		distance = arg.Val
+	default:
+		// a bare number is a distance in meters
+		v, ok := numberArg(arg)
+		if !ok || v < 0 {
+			return nil, fmt.Errorf("expected distance in %s(), got %s", c.Name, arg.String())
+		}
+		distance = fmt.Sprintf("%vm", v)
+	}
+	return map[string]interface{}{
+		"geo_distance": map[string]interface{}{
+			"distance": distance,
+			field:      point,
+		},
+	}, nil
+}
+
+// geoBoundingBoxQuery translates
+// geo_bbox(field, top_left_lat, top_left_lon, bottom_right_lat, bottom_right_lon).
+func geoBoundingBoxQuery(c *Call) (map[string]interface{}, error) {
+	field, err := geoField(c)
+	if err != nil {
+		return nil, err
+	}
+	topLeft, err := geoPoint(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	bottomRight, err := geoPoint(c, 3)
+	if err != nil {
+		return nil, err
+	}
+	if topLeft["lat"].(float64) < bottomRight["lat"].(float64) {
+		return nil, fmt.Errorf("invalid bounding box in %s(), top left is below bottom right", c.Name)
+	}
+	return map[string]interface{}{
+		"geo_bounding_box": map[string]interface{}{
+			field: map[string]interface{}{
+				"top_left":     topLeft,
+				"bottom_right": bottomRight,
+			},
+		},
+	}, nil
+}
+
+// geoPolygonQuery translates geo_polygon(field, [lat, lon, lat, lon, ...]),
+// points can also be given as 'lat,lon' strings or geohashes.
+func geoPolygonQuery(c *Call) (map[string]interface{}, error) {
+	field, err := geoField(c)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := c.Args[1].(*ListLiteral)
+	if !ok {
+		return nil, fmt.Errorf("expected points list in %s(), got %s", c.Name, c.Args[1].String())
+	}
+
+	var points []interface{}
+	var coords []Expr
+	for _, v := range list.Vals {
+		switch v := v.(type) {
+		case string:
+			points = append(points, strings.TrimSpace(v))
+		case float64:
+			coords = append(coords, &NumberLiteral{Val: v})
+		case int64:
+			coords = append(coords, &IntegerLiteral{Val: v})
+		}
+	}
+	if len(points) > 0 && len(coords) > 0 {
+		return nil, fmt.Errorf("can not mix string and number points in %s()", c.Name)
+	}
+	if len(coords)%2 != 0 {
+		return nil, fmt.Errorf("expected lat, lon pairs in %s(), got %d numbers", c.Name, len(coords))
+	}
+	pc := &Call{Name: c.Name, Args: coords}
+	for i := 0; i < len(coords); i += 2 {
+		point, err := geoPoint(pc, i)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	if len(points) < 3 {
+		return nil, fmt.Errorf("expected at least 3 points in %s(), got %d", c.Name, len(points))
+	}
+	return map[string]interface{}{
+		"geo_polygon": map[string]interface{}{
+			field: map[string]interface{}{"points": points},
+		},
+	}, nil
+}
+
+// geoAggParams returns the params of the geo_bounds and geo_centroid metrics.
+func geoAggParams(c *Call) (map[string]interface{}, error) {
+	field, err := geoField(c)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{"field": field}
+	if c.Name == "geo_bounds" && len(c.Args) == 2 {
+		wrap, ok := c.Args[1].(*BooleanLiteral)
+		if !ok {
+			return nil, fmt.Errorf("expected boolean wrap_longitude in geo_bounds(), got %s", c.Args[1].String())
+		}
+		params["wrap_longitude"] = wrap.Val
+	}
+	return params, nil
+}
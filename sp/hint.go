@@ -0,0 +1,225 @@
+package sp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	simplejson "github.com/bitly/go-simplejson"
+)
+
+// Hints are the optimizer hints of a select statement, written in a comment
+// right after SELECT, e.g. SELECT /*+ timeout(5s) routing(u1) */ ...
+// They map the hint name to its argument.
+type Hints map[string]string
+
+// String returns the hints comment, names are sorted.
+func (h Hints) String() string {
+	var items []string
+	for _, name := range h.names() {
+		items = append(items, fmt.Sprintf("%s(%s)", name, h[name]))
+	}
+	return "/*+ " + strings.Join(items, " ") + " */"
+}
+
+func (h Hints) names() []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hintPlace is where the translator applies a hint.
+type hintPlace int
+
+const (
+	// hintBody hints are set at the top level of the request body.
+	hintBody hintPlace = iota
+	// hintParam hints are url parameters of the search request.
+	hintParam
+	// hintTerms hints are set on every terms aggregation.
+	hintTerms
+	// hintExport hints set the pagination of LIMIT ALL statements.
+	hintExport
+	// hintJoin hints set the limits of the join plan of JOIN statements.
+	hintJoin
+	// hintSubquery hints set the limits of the IN subqueries.
+	hintSubquery
+)
+
+type hint struct {
+	place hintPlace
+	// value converts the hint argument into its json value.
+	value func(arg string) (interface{}, error)
+}
+
+var hints = map[string]hint{
+	"timeout":            {hintBody, hintTime},
+	"terminate_after":    {hintBody, hintInt},
+	"track_total_hits":   {hintBody, hintBool},
+	"request_cache":      {hintParam, hintBool},
+	"preference":         {hintParam, hintString},
+	"routing":            {hintParam, hintString},
+	"execution_hint":     {hintTerms, hintEnum("map", "global_ordinals", "global_ordinals_hash", "global_ordinals_low_cardinality")},
+	"shard_size":         {hintTerms, hintInt},
+	"pagination":         {hintExport, hintEnum(PaginationSearchAfter, PaginationScroll)},
+	"page_size":          {hintExport, hintInt},
+	"keep_alive":         {hintExport, hintTime},
+	"join_max_rows":      {hintJoin, hintInt},
+	"join_max_memory":    {hintJoin, hintBytes},
+	"subquery_max_terms": {hintSubquery, hintInt},
+}
+
+func hintNames() []string {
+	var names []string
+	for name := range hints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var hintRegexp = regexp.MustCompile(`^([A-Za-z_]+)\s*\(\s*([^()]*?)\s*\)`)
+
+// parseHints parses the text of a hint comment, without the leading "+".
+// pos is the position of the comment, used by the errors.
+func parseHints(text string, pos Pos) (Hints, error) {
+	h := make(Hints)
+	for {
+		text = strings.TrimLeft(text, " \t\n,")
+		if text == "" {
+			if len(h) == 0 {
+				return nil, nil
+			}
+			return h, nil
+		}
+		m := hintRegexp.FindStringSubmatch(text)
+		if m == nil {
+			return nil, &ParseError{Message: fmt.Sprintf("invalid hint %s, expected name(value)", strings.Fields(text)[0]), Pos: pos}
+		}
+		text = text[len(m[0]):]
+
+		name, arg := strings.ToLower(m[1]), strings.Trim(m[2], `'"`)
+		hi, ok := hints[name]
+		if !ok {
+			return nil, &ParseError{Message: fmt.Sprintf("unknown hint %s", m[1]), Pos: pos, Suggestion: closest(name, hintNames())}
+		}
+		if _, ok := h[name]; ok {
+			return nil, &ParseError{Message: fmt.Sprintf("duplicate hint %s", name), Pos: pos}
+		}
+		if _, err := hi.value(arg); err != nil {
+			return nil, &ParseError{Message: fmt.Sprintf("invalid %s hint, %s", name, err), Pos: pos}
+		}
+		h[name] = arg
+	}
+}
+
+// apply sets the hints of the statement in the request body, the url
+// params of the translation and the terms aggregations. Export hints are
+// read by the pagination of the statement.
+func (h Hints) apply(js *simplejson.Json, baggs Aggs, t *Translation) {
+	for _, name := range h.names() {
+		hi := hints[name]
+		v, _ := hi.value(h[name])
+		switch hi.place {
+		case hintBody:
+			js.Set(name, v)
+		case hintParam:
+			if t.Params == nil {
+				t.Params = make(map[string]string)
+			}
+			t.Params[name] = h[name]
+		case hintTerms:
+			applied := false
+			for _, a := range baggs {
+				if a.typ == Terms {
+					a.params[name] = v
+					applied = true
+				}
+			}
+			if !applied {
+				t.Warnings = append(t.Warnings, fmt.Sprintf("%s hint is ignored without a terms aggregation", name))
+			}
+		case hintExport:
+			if t.Pagination == nil {
+				t.Warnings = append(t.Warnings, fmt.Sprintf("%s hint is ignored without LIMIT ALL", name))
+			}
+		case hintJoin:
+			t.Warnings = append(t.Warnings, fmt.Sprintf("%s hint is ignored without JOIN", name))
+		case hintSubquery:
+			if len(t.Subqueries) == 0 {
+				t.Warnings = append(t.Warnings, fmt.Sprintf("%s hint is ignored without an IN subquery", name))
+			}
+		}
+	}
+}
+
+var timeRegexp = regexp.MustCompile(`^[0-9]+(nanos|micros|ms|s|m|h|d)$`)
+
+func hintTime(arg string) (interface{}, error) {
+	if !timeRegexp.MatchString(arg) {
+		return nil, fmt.Errorf("expected a time value such as 5s, got %q", arg)
+	}
+	return arg, nil
+}
+
+var bytesRegexp = regexp.MustCompile(`^([0-9]+)(b|kb|mb|gb)?$`)
+
+// hintBytes converts a size such as 64mb into bytes.
+func hintBytes(arg string) (interface{}, error) {
+	m := bytesRegexp.FindStringSubmatch(strings.ToLower(arg))
+	if m == nil {
+		return nil, fmt.Errorf("expected a size such as 64mb, got %q", arg)
+	}
+	n, _ := strconv.ParseInt(m[1], 10, 64)
+	switch m[2] {
+	case "kb":
+		n <<= 10
+	case "mb":
+		n <<= 20
+	case "gb":
+		n <<= 30
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("expected a positive size, got %q", arg)
+	}
+	return n, nil
+}
+
+func hintInt(arg string) (interface{}, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("expected a positive integer, got %q", arg)
+	}
+	return n, nil
+}
+
+func hintBool(arg string) (interface{}, error) {
+	switch strings.ToLower(arg) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return nil, fmt.Errorf("expected true or false, got %q", arg)
+}
+
+func hintString(arg string) (interface{}, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("expected a value")
+	}
+	return arg, nil
+}
+
+func hintEnum(values ...string) func(string) (interface{}, error) {
+	return func(arg string) (interface{}, error) {
+		if !containsString(values, arg) {
+			return nil, fmt.Errorf("expected one of %s, got %q", strings.Join(values, ", "), arg)
+		}
+		return arg, nil
+	}
+}
@@ -0,0 +1,483 @@
+package sp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// IDColumn is the column setting the _id of the inserted documents.
+const IDColumn = "_id"
+
+// InsertStatement indexes documents into an index, either
+// INSERT INTO index (field, ...) VALUES (value, ...) [, ...] or
+// INSERT INTO index [(field, ...)] SELECT ... copying the selected documents.
+type InsertStatement struct {
+	Target *Measurement
+	// Columns are the fields set by the values, or name the selected
+	// fields. The _id column sets the id of the documents.
+	Columns []*VarRef
+	Values  [][]Expr
+	Select  *SelectStatement
+}
+
+// String returns a string representation of the statement.
+func (s *InsertStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("INSERT INTO ")
+	_, _ = buf.WriteString(s.Target.String())
+	if len(s.Columns) > 0 {
+		_, _ = buf.WriteString(" (")
+		for i, c := range s.Columns {
+			if i > 0 {
+				_, _ = buf.WriteString(", ")
+			}
+			_, _ = buf.WriteString(c.String())
+		}
+		_, _ = buf.WriteString(")")
+	}
+	if s.Select != nil {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(s.Select.String())
+		return buf.String()
+	}
+	_, _ = buf.WriteString(" VALUES ")
+	for i, row := range s.Values {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = buf.WriteString("(")
+		for j, v := range row {
+			if j > 0 {
+				_, _ = buf.WriteString(", ")
+			}
+			_, _ = buf.WriteString(v.String())
+		}
+		_, _ = buf.WriteString(")")
+	}
+	return buf.String()
+}
+
+// parseInsertStatement parses "INSERT INTO index (field, ...) VALUES
+// (value, ...) [, ...]" or "INSERT INTO index [(field, ...)] SELECT ...",
+// the INSERT token has already been consumed.
+func (p *Parser) parseInsertStatement() (*InsertStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "into") {
+		return nil, newParseError(tokstr(tok, lit), []string{"INTO"}, pos)
+	}
+	stmt := &InsertStatement{}
+	var err error
+	if stmt.Target, err = p.parseSource(); err != nil {
+		return nil, err
+	}
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == LPAREN {
+		if stmt.Columns, err = p.parseInsertColumns(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch {
+	case tok == SELECT:
+		if stmt.Select, err = p.parseSelectStatement(); err != nil {
+			return nil, err
+		}
+	case tok == IDENT && strings.EqualFold(lit, "values"):
+		if len(stmt.Columns) == 0 {
+			return nil, &ParseError{Message: "INSERT VALUES needs the (field, ...) list of its columns", Pos: pos}
+		}
+		for {
+			row, err := p.parseInsertRow(len(stmt.Columns))
+			if err != nil {
+				return nil, err
+			}
+			stmt.Values = append(stmt.Values, row)
+			if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+				p.unscan()
+				break
+			}
+		}
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"VALUES", "SELECT"}, pos)
+	}
+	if err := p.parseEnd(); err != nil {
+		return nil, err
+	}
+	if err := stmt.validate(); err != nil {
+		return nil, p.locate(err)
+	}
+	return stmt, nil
+}
+
+// parseInsertColumns parses "field, ...)", the LPAREN token has already
+// been consumed.
+func (p *Parser) parseInsertColumns() ([]*VarRef, error) {
+	var columns []*VarRef
+	for {
+		_, pos, _ := p.scanIgnoreWhitespace()
+		p.unscan()
+		ref, err := p.parseVarRef()
+		if err != nil {
+			return nil, err
+		}
+		p.setSpan(ref, pos)
+		columns = append(columns, ref)
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok == RPAREN {
+			return columns, nil
+		}
+		if tok != COMMA {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+	}
+}
+
+// parseInsertRow parses "(value, ...)", a row of n values.
+func (p *Parser) parseInsertRow(n int) ([]Expr, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	var row []Expr
+	for {
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, expr)
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok == RPAREN {
+			break
+		}
+		if tok != COMMA {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+	}
+	if len(row) != n {
+		return nil, &ParseError{Message: fmt.Sprintf("VALUES row has %d values for %d columns", len(row), n), Pos: pos}
+	}
+	return row, nil
+}
+
+// validate checks the columns are listed once, the values are literals and
+// the select statement copies documents.
+func (s *InsertStatement) validate() error {
+	seen := make(map[string]bool)
+	for _, c := range s.Columns {
+		if seen[c.Val] {
+			return errorAt(c, fmt.Errorf("column %s is listed twice", c))
+		}
+		seen[c.Val] = true
+	}
+	for _, row := range s.Values {
+		for _, v := range row {
+			if _, ok := insertValue(v); !ok {
+				return errorAt(v, fmt.Errorf("VALUES only support literals, got %s", v))
+			}
+		}
+	}
+	if s.Select != nil {
+		return s.validateSelect()
+	}
+	return nil
+}
+
+// validateSelect checks the select statement can be run by a _reindex,
+// which copies the matching documents one by one.
+func (s *InsertStatement) validateSelect() error {
+	q := s.Select
+	switch {
+	case !q.IsRawQuery || len(q.Dimensions) > 0:
+		return errorAt(q.aggregateNode(), fmt.Errorf("INSERT SELECT only supports raw queries, _reindex can not aggregate"))
+	case q.Join != nil:
+		return errorAt(clause{q, JOIN}, fmt.Errorf("JOIN is not supported by INSERT SELECT"))
+	case len(q.SortFields) > 0:
+		return errorAt(clause{q, ORDER}, fmt.Errorf("ORDER BY is not supported by INSERT SELECT"))
+	case q.Offset > 0:
+		return errorAt(clause{q, LIMIT}, fmt.Errorf("OFFSET is not supported by INSERT SELECT"))
+	case q.LimitAll:
+		return errorAt(clause{q, LIMIT}, fmt.Errorf("LIMIT ALL is not supported by INSERT SELECT, every document is copied without LIMIT"))
+	case q.Dedupe:
+		return fmt.Errorf("DISTINCT is not supported by INSERT SELECT")
+	}
+	if q.copiesSource() {
+		if len(s.Columns) > 0 {
+			return errorAt(s.Columns[0], fmt.Errorf("INSERT SELECT * can not name its columns"))
+		}
+		return nil
+	}
+	if len(s.Columns) > 0 && len(s.Columns) != len(q.Fields) {
+		return errorAt(s.Columns[0], fmt.Errorf("INSERT has %d columns for %d selected fields", len(s.Columns), len(q.Fields)))
+	}
+	for _, f := range q.Fields {
+		if _, ok := f.Expr.(*Wildcard); ok {
+			return errorAt(f.Expr, fmt.Errorf("* can not be selected with other fields by INSERT SELECT"))
+		}
+		if len(s.Columns) == 0 && f.Alias == "" {
+			if _, ok := f.Expr.(*VarRef); !ok {
+				return errorAt(f.Expr, fmt.Errorf("%s needs an alias naming its field", f.Expr))
+			}
+		}
+	}
+	return nil
+}
+
+// copiesSource returns true for SELECT *, which copies the whole documents.
+func (s *SelectStatement) copiesSource() bool {
+	if len(s.Fields) != 1 {
+		return false
+	}
+	_, ok := s.Fields[0].Expr.(*Wildcard)
+	return ok
+}
+
+// insertValue returns the json value of a literal of VALUES, NULL is nil.
+func insertValue(expr Expr) (interface{}, bool) {
+	switch e := expr.(type) {
+	case *StringLiteral, *IntegerLiteral, *NumberLiteral, *BooleanLiteral, *ListLiteral:
+		return literalValue(e), true
+	case *BoundParameter:
+		return literalValue(e.Value), true
+	case *VarRef:
+		return nil, strings.EqualFold(e.Val, "null")
+	case *BinaryExpr:
+		// negative numbers are parsed as 0 - x
+		if l, ok := e.LHS.(*IntegerLiteral); !ok || l.Val != 0 || e.Op != SUB {
+			return nil, false
+		}
+		switch r := e.RHS.(type) {
+		case *IntegerLiteral:
+			return -r.Val, true
+		case *NumberLiteral:
+			return -r.Val, true
+		}
+	}
+	return nil, false
+}
+
+func (s *InsertStatement) validateFunctions() error {
+	if s.Select != nil {
+		return s.Select.validateFunctions()
+	}
+	return nil
+}
+
+// bind binds params to the placeholders of the values or of the select
+// statement.
+func (s *InsertStatement) bind(params Params, used map[string]bool) error {
+	if s.Select != nil {
+		return s.Select.bind(params, used)
+	}
+	for _, row := range s.Values {
+		for _, v := range row {
+			var err error
+			switch v := v.(type) {
+			case *BoundParameter:
+				err = v.bind(params, used)
+			case *ListLiteral:
+				err = v.bind(params, used)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// translate returns the _bulk request indexing the values, or the
+// _reindex request copying the selected documents.
+func (s *InsertStatement) translate() (*Translation, error) {
+	t := &Translation{Index: s.Target.Database, statement: "INSERT", write: true}
+	t.rows = func(resps [][]byte) ([][]interface{}, error) {
+		return t.Rows(resps[0])
+	}
+	if s.Select != nil {
+		return t, s.reindex(t)
+	}
+	body, err := s.bulk()
+	if err != nil {
+		return nil, err
+	}
+	t.Requests = []*Request{{Method: "POST", Path: "/" + url.PathEscape(t.Index) + "/_bulk", Body: body}}
+	for _, name := range []string{"_id", "result", "status"} {
+		t.Columns = append(t.Columns, &Column{Name: name, Path: []string{"items", "*", "index", name}})
+	}
+	t.Columns = append(t.Columns, &Column{Name: "error", Path: []string{"items", "*", "index", "error", "reason"}})
+	return t, nil
+}
+
+// bulk returns the ndjson payload of the values, an index action followed
+// by the document of every row. The values are checked against the types
+// of the fields declared in the schema.
+func (s *InsertStatement) bulk() (string, error) {
+	var buf bytes.Buffer
+	for _, row := range s.Values {
+		action := make(map[string]interface{})
+		doc := make(map[string]interface{})
+		for i, c := range s.Columns {
+			v, _ := insertValue(row[i])
+			if c.Val == IDColumn {
+				switch v.(type) {
+				case string, int64:
+					action[IDColumn] = fmt.Sprint(v)
+				default:
+					return "", errorAt(row[i], fmt.Errorf("_id expects a string or an integer, got %s", row[i]))
+				}
+				continue
+			}
+			if typ := DefaultSchema.FieldType(s.Target.Database, c.Val); !valueOfType(typ, v) {
+				return "", errorAt(row[i], fmt.Errorf("%s is a %s field, got %s", c, typ, row[i]))
+			}
+			doc[c.Val] = v
+		}
+		for _, v := range []interface{}{map[string]interface{}{"index": action}, doc} {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String(), nil
+}
+
+// valueOfType returns true if v can be indexed into a field of mapping type
+// typ. Any value can be indexed into undeclared fields or fields of types
+// that are not checked, and nil into any field.
+func valueOfType(typ string, v interface{}) bool {
+	if list, ok := v.([]interface{}); ok {
+		if typ == "geo_point" {
+			return true
+		}
+		for _, e := range list {
+			if !valueOfType(typ, e) {
+				return false
+			}
+		}
+		return true
+	}
+	if v == nil {
+		return true
+	}
+	switch typ {
+	case "long", "integer", "short", "byte", "unsigned_long":
+		_, ok := v.(int64)
+		return ok
+	case "double", "float", "half_float", "scaled_float":
+		switch v.(type) {
+		case int64, float64:
+			return true
+		}
+		return false
+	case "keyword", "text", "wildcard", "constant_keyword", "ip", "geo_point", "geo_shape":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "date", "date_nanos":
+		switch v.(type) {
+		case string, int64:
+			return true
+		}
+		return false
+	case "object", "nested":
+		return false
+	}
+	return true
+}
+
+// reindex sets the _reindex request copying the documents matching the
+// condition of the select statement. A script builds the documents of the
+// selected fields, SELECT * copies them as they are.
+func (s *InsertStatement) reindex(t *Translation) error {
+	q := whereQuery(nil, s.Select.Condition)
+	q.Sources = s.Select.Sources
+	st, err := q.translate()
+	if err != nil {
+		return err
+	}
+	t.Subqueries = st.Subqueries
+	source := map[string]interface{}{"index": st.Index}
+	if query, ok := st.Body["query"]; ok {
+		source["query"] = query
+	}
+	body := map[string]interface{}{"source": source, "dest": map[string]interface{}{"index": t.Index}}
+	if !s.Select.copiesSource() {
+		body["script"] = s.script()
+	}
+	if s.Select.Limit > 0 {
+		body["size"] = s.Select.Limit
+	}
+	t.Requests = []*Request{{Method: "POST", Path: "/_reindex", Body: body}}
+	t.Columns = byQueryColumns("created", "updated")
+	return nil
+}
+
+// nullSafeSourceRef returns the painless read of the source field of ref
+// under root, null when one of its parent objects is missing instead of
+// failing the whole reindex, e.g. (src['a'] == null ? null : src['a']['b']).
+func nullSafeSourceRef(root string, ref *VarRef) string {
+	segments := ref.Segments
+	if len(segments) == 0 {
+		segments = strings.Split(ref.Val, ".")
+	}
+	if len(segments) < 2 {
+		return sourceRef(root, ref)
+	}
+	var guards []string
+	for i := 1; i < len(segments); i++ {
+		guards = append(guards, sourceRef(root, &VarRef{Segments: segments[:i]})+" == null")
+	}
+	return fmt.Sprintf("(%s ? null : %s)", strings.Join(guards, " || "), sourceRef(root, ref))
+}
+
+// script returns the painless script replacing the source of a copied
+// document with the selected fields, named by the columns or the field
+// names. The _id field sets the id of the copy.
+func (s *InsertStatement) script() map[string]interface{} {
+	p := &scriptPrinter{params: make(map[string]interface{}), reserved: make(map[string]bool)}
+	WalkFunc(s.Select.Fields, func(n Node) {
+		if b, ok := n.(*BoundParameter); ok && !b.positional() {
+			p.reserved[b.Name] = true
+		}
+	})
+	stmts := []string{"def src = ctx._source", "ctx._source = [:]"}
+	var id string
+	for i, f := range s.Select.Fields {
+		name := f.Name()
+		if len(s.Columns) > 0 {
+			name = s.Columns[i].Val
+		}
+		expr := CloneExpr(f.Expr)
+		WalkFunc(expr, func(n Node) {
+			if ref, ok := n.(*VarRef); ok {
+				if ref.Val == IDColumn {
+					ref.Val = "ctx._id"
+				} else {
+					ref.Val = nullSafeSourceRef("src", ref)
+				}
+			}
+		})
+		if name == IDColumn {
+			// set last, the other fields may read the id of the source
+			id = fmt.Sprintf("ctx._id = String.valueOf(%s)", p.print(expr))
+			continue
+		}
+		stmts = append(stmts, fmt.Sprintf("ctx._source['%s'] = %s", qsReplacer.Replace(name), p.print(expr)))
+	}
+	if id != "" {
+		stmts = append(stmts, id)
+	}
+	m := map[string]interface{}{"inline": strings.Join(stmts, "; "), "lang": "painless"}
+	if len(p.params) > 0 {
+		m["params"] = p.params
+	}
+	return m
+}
@@ -0,0 +1,359 @@
+package sp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Default limits of the side of a join held in memory, index.max_terms_count
+// bounds the keys of the terms filter of the other side.
+const (
+	defaultJoinMaxRows   = 65536
+	defaultJoinMaxMemory = 64 << 20
+)
+
+// JoinPlan is how the executor joins the rows of the two sides of a JOIN:
+// it reads every hit of the side with fewer hits into a hash table of its
+// join keys, then searches the other side filtered by terms on these keys
+// and merges the rows of equal keys.
+type JoinPlan struct {
+	Sides [2]*JoinSide `json:"sides"`
+	// Columns are the joined columns and where their values are read.
+	Columns []*JoinColumn `json:"columns"`
+	// Limit and Offset of the joined rows, a zero limit returns every row.
+	Limit  int `json:"limit,omitempty"`
+	Offset int `json:"offset,omitempty"`
+	// MaxRows and MaxMemory limit the side held in memory, its memory is
+	// estimated from the json size of its rows.
+	MaxRows   int   `json:"max_rows"`
+	MaxMemory int64 `json:"max_memory"`
+}
+
+// JoinSide is an index of a join, the first column of its rows is its key.
+type JoinSide struct {
+	Alias string `json:"alias"`
+	Key   string `json:"key"`
+	// Search reads every hit of the side matching its conditions.
+	Search *Translation `json:"search"`
+}
+
+// JoinColumn is a joined column, read from the rows of one side.
+type JoinColumn struct {
+	Name   string `json:"name"`
+	Side   int    `json:"side"`
+	Column int    `json:"column"`
+}
+
+// Filter returns the search of the side restricted to the hits whose key is
+// one of keys.
+func (s *JoinSide) Filter(keys []interface{}) *Translation {
+	t := *s.Search
+	body := make(map[string]interface{}, len(t.Body)+1)
+	for k, v := range t.Body {
+		body[k] = v
+	}
+	filters := []interface{}{map[string]interface{}{"terms": map[string]interface{}{s.Key: keys}}}
+	if q, ok := body["query"]; ok {
+		filters = append(filters, q)
+	}
+	// a filter array, which every version since 2.0 accepts, unlike the and
+	// filter removed in 5.0
+	body["query"] = map[string]interface{}{"bool": map[string]interface{}{"filter": filters}}
+	t.Body = body
+	return &t
+}
+
+// JoinTable is the hash table of the rows of the side of a join read first.
+type JoinTable struct {
+	plan *JoinPlan
+	side int
+	rows map[string][][]interface{}
+	keys []interface{}
+	n    int
+	size int64
+}
+
+// NewTable returns an empty hash table of the rows of side.
+func (j *JoinPlan) NewTable(side int) *JoinTable {
+	return &JoinTable{plan: j, side: side, rows: make(map[string][][]interface{})}
+}
+
+// Add adds rows of the side to the table, it fails once the table holds more
+// rows or memory than the limits of the plan.
+func (t *JoinTable) Add(rows [][]interface{}) error {
+	alias := t.plan.Sides[t.side].Alias
+	for _, row := range rows {
+		key, ok := joinKey(row[0])
+		if !ok {
+			continue
+		}
+		if t.n++; t.n > t.plan.MaxRows {
+			return fmt.Errorf("join side %s has more than %d rows, raise the join_max_rows hint", alias, t.plan.MaxRows)
+		}
+		b, _ := json.Marshal(row)
+		if t.size += int64(len(b)); t.size > t.plan.MaxMemory {
+			return fmt.Errorf("join side %s holds more than %d bytes, raise the join_max_memory hint", alias, t.plan.MaxMemory)
+		}
+		if _, ok := t.rows[key]; !ok {
+			t.keys = append(t.keys, row[0])
+		}
+		t.rows[key] = append(t.rows[key], row)
+	}
+	return nil
+}
+
+// Keys returns the distinct join keys of the table.
+func (t *JoinTable) Keys() []interface{} {
+	return t.keys
+}
+
+// Join returns the joined rows of rows of the other side with the rows of
+// the table of the same key.
+func (t *JoinTable) Join(rows [][]interface{}) [][]interface{} {
+	var joined [][]interface{}
+	for _, row := range rows {
+		key, ok := joinKey(row[0])
+		if !ok {
+			continue
+		}
+		for _, match := range t.rows[key] {
+			var pair [2][]interface{}
+			pair[t.side], pair[1-t.side] = match, row
+			out := make([]interface{}, len(t.plan.Columns))
+			for i, c := range t.plan.Columns {
+				out[i] = pair[c.Side][c.Column]
+			}
+			joined = append(joined, out)
+		}
+	}
+	return joined
+}
+
+// joinKey returns the hash key of a join key value, null keys join no row.
+func joinKey(v interface{}) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	b, err := json.Marshal(v)
+	return string(b), err == nil
+}
+
+// joinAliases returns the alias of the left and right index of the join,
+// the index name when it has none.
+func (s *SelectStatement) joinAliases() [2]string {
+	var aliases [2]string
+	for i, m := range []*Measurement{s.Sources[0].(*Measurement), s.Join.Source} {
+		aliases[i] = m.Alias
+		if m.Alias == "" {
+			aliases[i] = m.Database
+		}
+	}
+	return aliases
+}
+
+// qualifier returns the side of a field qualified by the alias of a side,
+// -1 if it is not.
+func qualifier(ref *VarRef, aliases [2]string) int {
+	if len(ref.Segments) < 2 {
+		return -1
+	}
+	for i, alias := range aliases {
+		if ref.Segments[0] == alias {
+			return i
+		}
+	}
+	return -1
+}
+
+// unqualified returns a qualified field without its alias.
+func unqualified(ref *VarRef) *VarRef {
+	segments := ref.Segments[1:]
+	return &VarRef{Val: strings.Join(segments, "."), Segments: segments}
+}
+
+// joinKeys returns the key of each side, from ON a.key = b.key.
+func (s *SelectStatement) joinKeys() ([2]*VarRef, error) {
+	aliases := s.joinAliases()
+	var keys [2]*VarRef
+	if e, ok := s.Join.Condition.(*BinaryExpr); ok && e.Op == EQ {
+		lhs, ok1 := e.LHS.(*VarRef)
+		rhs, ok2 := e.RHS.(*VarRef)
+		if ok1 && ok2 {
+			l, r := qualifier(lhs, aliases), qualifier(rhs, aliases)
+			if l >= 0 && r >= 0 && l != r {
+				keys[l], keys[r] = unqualified(lhs), unqualified(rhs)
+				return keys, nil
+			}
+		}
+	}
+	return keys, errorAt(s.Join.Condition, fmt.Errorf("JOIN ON expects %s.key = %s.key, got %s", aliases[0], aliases[1], s.Join.Condition))
+}
+
+// conditionSide returns the side whose fields a WHERE condition of a join
+// reads, conditions without fields are run on the left side.
+func (s *SelectStatement) conditionSide(cond Expr) (int, error) {
+	aliases := s.joinAliases()
+	side := -1
+	var err error
+	WalkFunc(cond, func(n Node) {
+		ref, ok := n.(*VarRef)
+		if !ok || err != nil {
+			return
+		}
+		switch q := qualifier(ref, aliases); {
+		case q < 0:
+			err = errorAt(ref, fmt.Errorf("field %s must be qualified with %s or %s", ref, aliases[0], aliases[1]))
+		case side >= 0 && q != side:
+			err = errorAt(cond, fmt.Errorf("condition %s mixes fields of %s and %s", cond, aliases[0], aliases[1]))
+		default:
+			side = q
+		}
+	})
+	if side < 0 {
+		side = 0
+	}
+	return side, err
+}
+
+// validateJoin checks a JOIN selects and filters qualified fields of raw
+// documents.
+func (s *SelectStatement) validateJoin() error {
+	if len(s.Sources) != 1 {
+		return errorAt(clause{s, JOIN}, fmt.Errorf("JOIN needs a single index on its left, got %s", s.Sources))
+	}
+	aliases := s.joinAliases()
+	if aliases[0] == aliases[1] {
+		return errorAt(clause{s, JOIN}, fmt.Errorf("JOIN of %s with itself needs an alias for each side", aliases[0]))
+	}
+	if !s.IsRawQuery || len(s.Dimensions) > 0 || s.Having != nil {
+		return errorAt(s.aggregateNode(), fmt.Errorf("JOIN only supports raw queries, aggregates can not be computed across indices"))
+	}
+	if len(s.SortFields) > 0 {
+		return errorAt(clause{s, ORDER}, fmt.Errorf("ORDER BY is not supported with JOIN"))
+	}
+	if s.Dedupe {
+		return fmt.Errorf("DISTINCT is not supported with JOIN")
+	}
+	if _, err := s.joinKeys(); err != nil {
+		return err
+	}
+	for _, f := range s.Fields {
+		switch expr := f.Expr.(type) {
+		case *Wildcard:
+		case *VarRef:
+			if qualifier(expr, aliases) < 0 {
+				return errorAt(expr, fmt.Errorf("field %s must be qualified with %s or %s", expr, aliases[0], aliases[1]))
+			}
+		default:
+			return errorAt(expr, fmt.Errorf("JOIN only supports fields in SELECT, got %s", expr))
+		}
+	}
+	for _, cond := range conjuncts(s.Condition) {
+		if _, err := s.conditionSide(cond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// translateJoin plans the hash join of a JOIN statement.
+func (s *SelectStatement) translateJoin() (*Translation, error) {
+	plan, sides, err := s.joinPlan()
+	if err != nil {
+		return nil, err
+	}
+	t := &Translation{Index: strings.Join([]string{sides[0].index(), sides[1].index()}, ","), Join: plan}
+	aliases := s.joinAliases()
+	keys, _ := s.joinKeys()
+	for i, side := range sides {
+		st, err := side.translate()
+		if err != nil {
+			return nil, err
+		}
+		plan.Sides[i] = &JoinSide{Alias: aliases[i], Key: keys[i].Val, Search: st}
+	}
+	for _, c := range plan.Columns {
+		t.Columns = append(t.Columns, &Column{Name: c.Name})
+	}
+	return t, nil
+}
+
+// joinPlan returns the plan of a JOIN statement without its sides, and the
+// statement of each side: it reads its key, its selected fields and is
+// filtered by its conditions.
+func (s *SelectStatement) joinPlan() (*JoinPlan, [2]*SelectStatement, error) {
+	aliases := s.joinAliases()
+	// checked by validateJoin
+	keys, _ := s.joinKeys()
+	plan := &JoinPlan{Limit: s.Limit, Offset: s.Offset, MaxRows: defaultJoinMaxRows, MaxMemory: defaultJoinMaxMemory}
+	sideHints := make(Hints)
+	for name, arg := range s.Hints {
+		switch name {
+		case "join_max_rows":
+			plan.MaxRows, _ = strconv.Atoi(arg)
+		case "join_max_memory":
+			n, _ := hintBytes(arg)
+			plan.MaxMemory = n.(int64)
+		default:
+			sideHints[name] = arg
+		}
+	}
+	if len(sideHints) == 0 {
+		sideHints = nil
+	}
+
+	var sides [2]*SelectStatement
+	for i, m := range []*Measurement{s.Sources[0].(*Measurement), s.Join.Source} {
+		sides[i] = &SelectStatement{
+			Hints:      sideHints,
+			Fields:     Fields{{Expr: keys[i]}},
+			Sources:    Sources{&Measurement{Database: m.Database}},
+			LimitAll:   true,
+			IsRawQuery: true,
+		}
+	}
+
+	// the joined columns are named as the fields of their side
+	named := &SelectStatement{}
+	for _, f := range s.Fields {
+		switch expr := f.Expr.(type) {
+		case *Wildcard:
+			for i, side := range sides {
+				plan.Columns = append(plan.Columns, &JoinColumn{Side: i, Column: len(side.Fields)})
+				side.Fields = append(side.Fields, &Field{Expr: &Wildcard{}})
+				named.Fields = append(named.Fields, &Field{Expr: expr, Alias: aliases[i]})
+			}
+		case *VarRef:
+			i := qualifier(expr, aliases)
+			ref := unqualified(expr)
+			plan.Columns = append(plan.Columns, &JoinColumn{Side: i, Column: len(sides[i].Fields)})
+			sides[i].Fields = append(sides[i].Fields, &Field{Expr: ref})
+			named.Fields = append(named.Fields, &Field{Expr: ref, Alias: f.Alias})
+		}
+	}
+	for i, name := range named.ColumnNames() {
+		plan.Columns[i].Name = name
+	}
+
+	var conds [2][]Expr
+	for _, cond := range conjuncts(s.Condition) {
+		side, err := s.conditionSide(cond)
+		if err != nil {
+			return nil, sides, err
+		}
+		c := CloneExpr(cond)
+		WalkFunc(c, func(n Node) {
+			if ref, ok := n.(*VarRef); ok && qualifier(ref, aliases) >= 0 {
+				*ref = *unqualified(ref)
+			}
+		})
+		conds[side] = append(conds[side], c)
+	}
+
+	for i, side := range sides {
+		side.Condition = conjunction(conds[i])
+	}
+	return plan, sides, nil
+}
@@ -0,0 +1,87 @@
+package sp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MappingField is a field of an index mapping.
+type MappingField struct {
+	// Name is the dotted path of the field.
+	Name string `json:"name"`
+	// Type is the mapping type, e.g. keyword, long or nested.
+	Type string `json:"type"`
+}
+
+// ParseMapping returns the fields of a get mapping response, with or
+// without mapping types, sorted by name. Fields of several indices or types
+// are merged.
+func ParseMapping(body []byte) ([]*MappingField, error) {
+	var indices map[string]interface{}
+	if err := json.Unmarshal(body, &indices); err != nil {
+		return nil, fmt.Errorf("invalid mapping, %s", err)
+	}
+	types := make(map[string]string)
+	for _, index := range indices {
+		m, _ := index.(map[string]interface{})
+		mappings, ok := m["mappings"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping, missing mappings")
+		}
+		if _, ok := mappings["properties"]; ok {
+			mappingFields(types, "", mappings)
+			continue
+		}
+		for _, typ := range mappings {
+			if typ, ok := typ.(map[string]interface{}); ok {
+				mappingFields(types, "", typ)
+			}
+		}
+	}
+
+	fields := make([]*MappingField, 0, len(types))
+	for name, typ := range types {
+		fields = append(fields, &MappingField{Name: name, Type: typ})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields, nil
+}
+
+// mappingFields collects the types of the properties of m under prefix,
+// object fields without a type are walked but not collected.
+func mappingFields(types map[string]string, prefix string, m map[string]interface{}) {
+	props, _ := m["properties"].(map[string]interface{})
+	for name, prop := range props {
+		prop, ok := prop.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := strings.TrimPrefix(prefix+"."+name, ".")
+		if typ, ok := prop["type"].(string); ok {
+			types[path] = typ
+		}
+		mappingFields(types, path, prop)
+	}
+}
+
+// NestedPaths returns the names of the nested fields.
+func NestedPaths(fields []*MappingField) []string {
+	var paths []string
+	for _, f := range fields {
+		if f.Type == "nested" {
+			paths = append(paths, f.Name)
+		}
+	}
+	return paths
+}
+
+// MappingTypes returns the types of the fields by name.
+func MappingTypes(fields []*MappingField) map[string]string {
+	types := make(map[string]string, len(fields))
+	for _, f := range fields {
+		types[f.Name] = f.Type
+	}
+	return types
+}
@@ -0,0 +1,52 @@
+package sp_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// Ensure the fields of get mapping responses are flattened.
+func TestParseMapping(t *testing.T) {
+	var tests = []struct {
+		mapping string
+		fields  string
+		nested  []string
+		err     string
+	}{
+		{
+			mapping: `{"blog": {"mappings": {"properties": {
+			  "title": {"type": "text", "fields": {"raw": {"type": "keyword"}}},
+			  "author": {"properties": {"name": {"type": "keyword"}}},
+			  "comments": {"type": "nested", "properties": {"stars": {"type": "integer"}}}}}}}`,
+			fields: `[{"name":"author.name","type":"keyword"},{"name":"comments","type":"nested"},{"name":"comments.stars","type":"integer"},{"name":"title","type":"text"}]`,
+			nested: []string{"comments"},
+		},
+		{
+			mapping: `{"symbol": {"mappings": {"doc": {"properties": {"name": {"type": "string"}}}}}}`,
+			fields:  `[{"name":"name","type":"string"}]`,
+		},
+		{
+			mapping: `{"symbol": {}}`,
+			err:     `invalid mapping, missing mappings`,
+		},
+	}
+
+	for i, tt := range tests {
+		fields, err := sp.ParseMapping([]byte(tt.mapping))
+		if errstring(err) != tt.err {
+			t.Errorf("%d. error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if b, _ := json.Marshal(fields); string(b) != tt.fields {
+			t.Errorf("%d. fields mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.fields, b)
+		}
+		if nested := sp.NestedPaths(fields); !reflect.DeepEqual(nested, tt.nested) {
+			t.Errorf("%d. nested mismatch:\n\nexp=%v\n\ngot=%v\n\n", i, tt.nested, nested)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package sp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Schema holds the mapping knowledge that can not be inferred from sql,
+// e.g. which object paths are mapped as nested documents.
+type Schema struct {
+	lock   sync.RWMutex
+	nested []string
+	// types maps an index to the mapping types of its fields.
+	types map[string]map[string]string
+}
+
+// NewSchema returns an empty schema.
+func NewSchema() *Schema {
+	return &Schema{}
+}
+
+// DefaultSchema is the schema used by EsDsl.
+var DefaultSchema = NewSchema()
+
+// AddNested declares paths as nested object paths.
+func (s *Schema) AddNested(paths ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range paths {
+		p = strings.Trim(p, ".")
+		if p == "" || s.isNested(p) {
+			continue
+		}
+		s.nested = append(s.nested, p)
+	}
+}
+
+// AddTypes declares the mapping types of fields of index, the values
+// inserted into them are checked against their type.
+func (s *Schema) AddTypes(index string, types map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.types == nil {
+		s.types = make(map[string]map[string]string)
+	}
+	if s.types[index] == nil {
+		s.types[index] = make(map[string]string)
+	}
+	for field, typ := range types {
+		s.types[index][field] = typ
+	}
+}
+
+// FieldType returns the mapping type of field in index, or "" if it is
+// not declared.
+func (s *Schema) FieldType(index, field string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.types[index][field]
+}
+
+func (s *Schema) isNested(path string) bool {
+	for _, p := range s.nested {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// NestedPath returns the deepest nested path containing field,
+// or "" if the field lives in the root document.
+func (s *Schema) NestedPath(field string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var path string
+	for _, p := range s.nested {
+		if strings.HasPrefix(field, p+".") && len(p) > len(path) {
+			path = p
+		}
+	}
+	return path
+}
+
+// isNestedCall returns true if expr is a nested(path, cond) function call.
+func isNestedCall(expr Expr) bool {
+	c, ok := expr.(*Call)
+	return ok && c.Name == "nested"
+}
+
+// validateNestedCall checks the arguments of nested(path, cond), its arity
+// is checked by validateCall.
+func validateNestedCall(c *Call) error {
+	switch c.Args[0].(type) {
+	case *VarRef, *StringLiteral:
+	default:
+		return fmt.Errorf("expected nested path in nested(), got %s", c.Args[0].String())
+	}
+	return validateCondition(c.Args[1], ILLEGAL)
+}
+
+// nestedCallPath returns the path argument of nested(path, cond).
+func nestedCallPath(c *Call) string {
+	if lit, ok := c.Args[0].(*StringLiteral); ok {
+		return lit.Val
+	}
+	return c.Args[0].String()
+}
+
+// nestedScope returns the nested path shared by all fields referenced in expr,
+// or "" if they are in the root document or spread across several paths.
+func nestedScope(expr Expr) string {
+	var scope string
+	for i, name := range walkNames(expr) {
+		p := DefaultSchema.NestedPath(cleanDocString(name))
+		if i == 0 {
+			scope = p
+		} else if p != scope {
+			return ""
+		}
+	}
+	return scope
+}
+
+// mixedNestedPath returns the nested path of a field of expr when the
+// other fields of expr are not all in it, or "".
+func mixedNestedPath(expr Expr) string {
+	if nestedScope(expr) != "" {
+		return ""
+	}
+	for _, name := range walkNames(expr) {
+		if p := DefaultSchema.NestedPath(cleanDocString(name)); p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+// nestedQuery wraps query into a nested query on path.
+func nestedQuery(path string, query interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"nested": map[string]interface{}{
+			"path":  path,
+			"query": query,
+		},
+	}
+}
+
+// scriptQuery returns a bool filter script query for the condition.
+func scriptQuery(cond Expr) map[string]interface{} {
+	rewriteCondition(cond)
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": map[string]interface{}{
+				"script": map[string]interface{}{
+					"script": script(cond),
+				},
+			},
+		},
+	}
+}
+
+// scopeTransition returns the single bucket aggregations needed to move the
+// aggregation context from nested path from into nested path to.
+func scopeTransition(from, to string) Aggs {
+	if from == to {
+		return nil
+	}
+	var chain Aggs
+	if from != "" && !strings.HasPrefix(to, from+".") {
+		chain = append(chain, &Agg{
+			name:   "reverse_nested",
+			typ:    ReverseNested,
+			params: make(map[string]interface{}),
+		})
+	}
+	if to != "" {
+		chain = append(chain, &Agg{
+			name:   to,
+			typ:    Nested,
+			params: map[string]interface{}{"path": to},
+		})
+	}
+	return chain
+}
@@ -0,0 +1,273 @@
+package sp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Params are the values bound to the placeholders of a statement, by name
+// for :name placeholders and by position, counted from 1, for ? placeholders.
+type Params map[string]interface{}
+
+// PositionalParams returns the params binding values to the ? placeholders in order.
+func PositionalParams(values ...interface{}) Params {
+	params := make(Params, len(values))
+	for i, v := range values {
+		params[strconv.Itoa(i+1)] = v
+	}
+	return params
+}
+
+// BoundParameter is a ? or :name placeholder of a WHERE condition.
+type BoundParameter struct {
+	// Name is the placeholder name, or its position for ? placeholders.
+	Name string
+	// Value is the literal bound to the placeholder.
+	Value Expr
+}
+
+// String returns a string representation of the placeholder.
+func (p *BoundParameter) String() string {
+	if p.positional() {
+		return "?"
+	}
+	return ":" + p.Name
+}
+
+func (p *BoundParameter) positional() bool {
+	_, err := strconv.Atoi(p.Name)
+	return err == nil
+}
+
+// parseBoundParameter parses the placeholder token at pos, lit is the name of
+// a :name placeholder and empty for ?.
+func (p *Parser) parseBoundParameter(pos Pos, lit string) (*BoundParameter, error) {
+	if lit == "" {
+		p.positional++
+		lit = strconv.Itoa(p.positional)
+	} else {
+		p.named = true
+	}
+	if p.positional > 0 && p.named {
+		return nil, &ParseError{Message: "can not mix ? and :name placeholders", Pos: pos}
+	}
+	return &BoundParameter{Name: lit}, nil
+}
+
+func hasBoundParameter(expr Expr) bool {
+	found := false
+	WalkFunc(expr, func(n Node) {
+		if _, ok := n.(*BoundParameter); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+// Bind binds params to the placeholders of the statement. Values of the
+// arguments of predicate functions replace their placeholders, the others
+// are kept by the placeholders to be passed as script params.
+func (s *SelectStatement) Bind(params Params) error {
+	used := make(map[string]bool)
+	if err := s.bind(params, used); err != nil {
+		return err
+	}
+	return checkUnused(params, used)
+}
+
+// bind binds params to the placeholders of the statement, recording the
+// names of the params used.
+func (s *SelectStatement) bind(params Params, used map[string]bool) error {
+	for _, n := range []Node{s.Fields, s.Dimensions, s.Having} {
+		var err error
+		WalkFunc(n, func(n Node) {
+			if p, ok := n.(*BoundParameter); ok && err == nil {
+				err = errorAt(p, fmt.Errorf("placeholder %s is only supported in WHERE", p))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var bind func(expr Expr, inline bool) (Expr, error)
+	bind = func(expr Expr, inline bool) (Expr, error) {
+		var err error
+		switch e := expr.(type) {
+		case *BoundParameter:
+			if err := e.bind(params, used); err != nil {
+				return nil, err
+			}
+			if inline {
+				return e.Value, nil
+			}
+		case *BinaryExpr:
+			if e.LHS, err = bind(e.LHS, inline); err != nil {
+				return nil, err
+			}
+			if e.RHS, err = bind(e.RHS, inline); err != nil {
+				return nil, err
+			}
+		case *ParenExpr:
+			if e.Expr, err = bind(e.Expr, inline); err != nil {
+				return nil, err
+			}
+		case *ListLiteral:
+			if err := e.bind(params, used); err != nil {
+				return nil, err
+			}
+		case *SubqueryExpr:
+			if err := e.Statement.bind(params, used); err != nil {
+				return nil, err
+			}
+		case *Call:
+			var query func(*Call) (map[string]interface{}, error)
+			if f := lookupFunction(e); f != nil && f.Kind == PredicateFunction {
+				query = f.Query
+			}
+			for i := range e.Args {
+				if e.Args[i], err = bind(e.Args[i], inline || query != nil); err != nil {
+					return nil, err
+				}
+			}
+			if query != nil {
+				if _, err := query(e); err != nil {
+					return nil, errorAt(e, err)
+				}
+			}
+		}
+		return expr, nil
+	}
+	cond, err := bind(s.Condition, false)
+	if err != nil {
+		return err
+	}
+	s.Condition = cond
+	if s.Union != nil {
+		for _, stmt := range s.Union.Statements {
+			if err := stmt.bind(params, used); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bind sets the value of the placeholder to the one of its param.
+func (p *BoundParameter) bind(params Params, used map[string]bool) error {
+	v, ok := params[p.Name]
+	if !ok {
+		return errorAt(p, fmt.Errorf("no value bound to placeholder %s", p))
+	}
+	used[p.Name] = true
+	var err error
+	if p.Value, err = bindLiteral(v); err != nil {
+		return errorAt(p, fmt.Errorf("invalid value of placeholder %s, %s", p, err))
+	}
+	return nil
+}
+
+// bind replaces the placeholders of the list by the values of their params.
+func (l *ListLiteral) bind(params Params, used map[string]bool) error {
+	for i, v := range l.Vals {
+		p, ok := v.(*BoundParameter)
+		if !ok {
+			continue
+		}
+		if err := p.bind(params, used); err != nil {
+			return err
+		}
+		switch p.Value.(type) {
+		case *StringLiteral, *IntegerLiteral, *NumberLiteral:
+			l.Vals[i] = literalValue(p.Value)
+		default:
+			return errorAt(p, fmt.Errorf("invalid value of placeholder %s, lists hold strings and numbers, got %s", p, p.Value))
+		}
+	}
+	return nil
+}
+
+// checkUnused returns an error if one of params is not used.
+func checkUnused(params Params, used map[string]bool) error {
+	for name := range params {
+		if !used[name] {
+			return fmt.Errorf("param %s is not used by any placeholder", name)
+		}
+	}
+	return nil
+}
+
+// bindLiteral returns the literal of a bound value.
+func bindLiteral(v interface{}) (Expr, error) {
+	switch v := v.(type) {
+	case string:
+		return &StringLiteral{Val: v}, nil
+	case bool:
+		return &BooleanLiteral{Val: v}, nil
+	case int:
+		return &IntegerLiteral{Val: int64(v)}, nil
+	case int64:
+		return &IntegerLiteral{Val: v}, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return &IntegerLiteral{Val: int64(v)}, nil
+		}
+		return &NumberLiteral{Val: v}, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return &IntegerLiteral{Val: n}, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return &NumberLiteral{Val: f}, nil
+	case []interface{}:
+		list := &ListLiteral{}
+		for _, item := range v {
+			lit, err := bindLiteral(item)
+			if err != nil {
+				return nil, err
+			}
+			switch lit := lit.(type) {
+			case *StringLiteral:
+				list.Vals = append(list.Vals, lit.Val)
+			case *IntegerLiteral:
+				list.Vals = append(list.Vals, lit.Val)
+			case *NumberLiteral:
+				list.Vals = append(list.Vals, lit.Val)
+			default:
+				return nil, fmt.Errorf("lists hold strings and numbers, got %v", item)
+			}
+		}
+		return list, nil
+	case []string:
+		list := &ListLiteral{}
+		for _, item := range v {
+			list.Vals = append(list.Vals, item)
+		}
+		return list, nil
+	case nil:
+		return nil, fmt.Errorf("null is not supported")
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+// literalValue returns the json value of a literal.
+func literalValue(expr Expr) interface{} {
+	switch e := expr.(type) {
+	case *StringLiteral:
+		return e.Val
+	case *IntegerLiteral:
+		return e.Val
+	case *NumberLiteral:
+		return e.Val
+	case *BooleanLiteral:
+		return e.Val
+	case *ListLiteral:
+		return e.Vals
+	}
+	return nil
+}
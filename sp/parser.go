@@ -12,11 +12,36 @@ import (
 // Parser represents an InfluxQL parser.
 type Parser struct {
 	s *bufScanner
+
+	// source spans of the parsed expressions, to locate validation errors.
+	spans map[Node]span
+
+	// number of the positional placeholders parsed, and whether named ones were.
+	positional int
+	named      bool
+
+	// depth of the subquery being parsed, its statement ends at a ).
+	depth int
+}
+
+// span is the source range of a node, end is exclusive.
+type span struct {
+	pos, end Pos
 }
 
+// clause is the tok clause of a select statement or of a union, e.g. its
+// LIMIT, which locates the errors on the clause as a whole.
+type clause struct {
+	owner interface{}
+	tok   Token
+}
+
+func (clause) node()            {}
+func (c clause) String() string { return c.tok.String() }
+
 // NewParser returns a new instance of Parser.
 func NewParser(r io.Reader) *Parser {
-	return &Parser{s: newBufScanner(r)}
+	return &Parser{s: newBufScanner(r), spans: make(map[Node]span)}
 }
 
 // ParseStatement parses a statement string and returns its AST representation.
@@ -24,16 +49,194 @@ func ParseStatement(s string) (Statement, error) {
 	return NewParser(strings.NewReader(s)).ParseStatement()
 }
 
+// ParseStatements parses a string of ;-separated statements, empty ones are skipped.
+func ParseStatements(s string) (Statements, error) {
+	return NewParser(strings.NewReader(s)).ParseStatements()
+}
+
 // ParseStatement parses an InfluxQL string and returns a Statement AST object.
+// The statement may end with a semicolon.
 func (p *Parser) ParseStatement() (Statement, error) {
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == SEMICOLON {
+		tok, pos, lit = p.scanIgnoreWhitespace()
+	}
+	if tok != EOF {
+		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
+	}
+	return stmt, nil
+}
+
+// ParseStatements parses ;-separated statements until EOF.
+func (p *Parser) ParseStatements() (Statements, error) {
+	var stmts Statements
+	for {
+		switch tok, _, _ := p.scanIgnoreWhitespace(); tok {
+		case EOF:
+			return stmts, nil
+		case SEMICOLON:
+			continue
+		}
+		p.unscan()
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+}
+
+// statementParsers parse the statements other than SELECT and EXPLAIN,
+// from the keyword following the first one.
+var statementParsers = []struct {
+	tok   Token
+	parse func(*Parser) (Statement, error)
+}{
+	{SHOW, (*Parser).parseShowStatement},
+	{DESCRIBE, (*Parser).parseDescribeStatement},
+	{DESC, (*Parser).parseDescribeStatement},
+	{DELETE, func(p *Parser) (Statement, error) { return p.parseDeleteStatement() }},
+	{UPDATE, func(p *Parser) (Statement, error) { return p.parseUpdateStatement() }},
+	{INSERT, func(p *Parser) (Statement, error) { return p.parseInsertStatement() }},
+	{CREATE, (*Parser).parseCreateStatement},
+	{DROP, (*Parser).parseDropStatement},
+}
+
+// statementKeywords returns the keywords a statement can start with.
+func statementKeywords() []string {
+	names := []string{SELECT.String(), EXPLAIN.String()}
+	for _, e := range statementParsers {
+		names = append(names, e.tok.String())
+	}
+	return names
+}
+
+// parseStatement parses a statement, stopping before the token following it.
+// The keyword of the statements other than SELECT is the span of the statement.
+func (p *Parser) parseStatement() (Statement, error) {
 	// Inspect the first token.
 	tok, pos, lit := p.scanIgnoreWhitespace()
+	keyword := span{pos: pos, end: p.s.end()}
 	switch tok {
-	case SELECT:
-		return p.parseSelectStatement()
-	default:
-		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+	case SELECT, LPAREN:
+		p.unscan()
+		return p.parseUnion()
+	case EXPLAIN:
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SELECT && tok != LPAREN {
+			return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+		}
+		p.unscan()
+		stmt, err := p.parseUnion()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Explain = true
+		p.spans[clause{stmt, EXPLAIN}] = keyword
+		return stmt, nil
+	}
+	var parse func(*Parser) (Statement, error)
+	for _, e := range statementParsers {
+		if e.tok == tok {
+			parse = e.parse
+		}
+	}
+	if parse == nil {
+		return nil, newParseError(tokstr(tok, lit), statementKeywords(), pos)
+	}
+	stmt, err := parse(p)
+	if err != nil {
+		return nil, err
+	}
+	p.spans[stmt] = keyword
+	return stmt, nil
+}
+
+// parseUnion parses a select statement and the ones of its UNION ALL. The
+// ORDER BY and LIMIT of an unparenthesized last statement are the ones of
+// the union.
+func (p *Parser) parseUnion() (*SelectStatement, error) {
+	first, parens, err := p.parseUnionStatement()
+	if err != nil {
+		return nil, err
+	}
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != UNION {
+		p.unscan()
+		return first, nil
+	}
+	p.unscan()
+
+	u := &Union{}
+	last := first
+	for {
+		tok, union, _ := p.scanIgnoreWhitespace()
+		if tok != UNION {
+			p.unscan()
+			break
+		}
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "all") {
+			return nil, newParseError(tokstr(tok, lit), []string{"ALL"}, pos)
+		}
+		if len(u.Statements) == 0 {
+			p.setSpan(clause{u, UNION}, union)
+		}
+		if last, parens, err = p.parseUnionStatement(); err != nil {
+			return nil, err
+		}
+		u.Statements = append(u.Statements, last)
+	}
+
+	if parens {
+		if u.SortFields, err = p.parseOrderBy(u); err != nil {
+			return nil, err
+		}
+		if u.Limit, u.Offset, u.LimitAll, err = p.parseLimit(u); err != nil {
+			return nil, err
+		}
+	} else {
+		u.SortFields, u.Limit, u.Offset, u.LimitAll = last.SortFields, last.Limit, last.Offset, last.LimitAll
+		last.SortFields, last.Limit, last.Offset, last.LimitAll = nil, 0, 0, false
+		for _, tok := range []Token{ORDER, LIMIT} {
+			if sp, ok := p.spans[clause{last, tok}]; ok {
+				p.spans[clause{u, tok}] = sp
+				delete(p.spans, clause{last, tok})
+			}
+		}
+	}
+	first.Union = u
+	if err := first.validateUnion(); err != nil {
+		return nil, p.locate(err)
+	}
+	return first, nil
+}
+
+// parseUnionStatement parses a statement of a UNION ALL, "(SELECT ...)" when
+// it has an ORDER BY or LIMIT of its own, and reports if it is parenthesized.
+func (p *Parser) parseUnionStatement() (*SelectStatement, bool, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == SELECT {
+		stmt, err := p.parseSelectStatement()
+		return stmt, false, err
+	}
+	if tok == LPAREN {
+		tok, pos, lit = p.scanIgnoreWhitespace()
+	}
+	if tok != SELECT {
+		return nil, false, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+	}
+	p.depth++
+	stmt, err := p.parseSelectStatement()
+	p.depth--
+	if err != nil {
+		return nil, false, err
 	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, false, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return stmt, true, nil
 }
 
 // parseInt parses a string and returns an integer literal.
@@ -90,9 +293,10 @@ func (p *Parser) parseUInt64() (uint64, error) {
 }
 
 // parseIdent parses an identifier.
+// A single quoted string is accepted too, e.g. the alias of AS 'name'.
 func (p *Parser) parseIdent() (string, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
-	if tok != IDENT {
+	if tok != IDENT && tok != STRING {
 		return "", newParseError(tokstr(tok, lit), []string{"identifier"}, pos)
 	}
 	return lit, nil
@@ -188,6 +392,11 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 	stmt := &SelectStatement{}
 	var err error
 
+	// Parse hints: "/*+ HINT(VALUE)* */".
+	if stmt.Hints, err = p.parseHints(stmt); err != nil {
+		return nil, err
+	}
+
 	// Parse fields: "FIELD+".
 	if stmt.Fields, err = p.parseFields(); err != nil {
 		return nil, err
@@ -198,6 +407,11 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		return nil, err
 	}
 
+	// Parse join: "JOIN SOURCE [AS] ALIAS ON EXPR".
+	if stmt.Join, err = p.parseJoin(stmt); err != nil {
+		return nil, err
+	}
+
 	// Parse condition: "WHERE EXPR".
 	if stmt.Condition, err = p.parseCondition(); err != nil {
 		return nil, err
@@ -214,29 +428,36 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 	}
 
 	// Parse sort: "ORDER BY FIELD+".
-	if stmt.SortFields, err = p.parseOrderBy(); err != nil {
+	if stmt.SortFields, err = p.parseOrderBy(stmt); err != nil {
 		return nil, err
 	}
 
-	// Parse limit: "LIMIT <m>,<n>".
-	if stmt.Limit, stmt.Offset, err = p.parseLimit(); err != nil {
+	// Parse limit: "LIMIT <m>,<n>" or "LIMIT ALL".
+	if stmt.Limit, stmt.Offset, stmt.LimitAll, err = p.parseLimit(stmt); err != nil {
 		return nil, err
 	}
 
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EOF {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); (tok == RPAREN && p.depth > 0) || (tok == UNION && p.depth == 0) {
+		p.unscan()
+	} else if tok != EOF && tok != SEMICOLON {
+		if p.depth > 0 {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
 		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
+	} else {
+		p.unscan()
 	}
 
 	// Set if the query is a raw data query or one with an aggregate
 	stmt.IsRawQuery = true
-	WalkFunc(stmt.Fields, func(n Node) {
-		if _, ok := n.(*Call); ok {
+	for _, f := range stmt.Fields {
+		if len(aggregateCalls(f.Expr)) > 0 {
 			stmt.IsRawQuery = false
 		}
-	})
+	}
 
 	if err := stmt.validate(); err != nil {
-		return nil, err
+		return nil, p.locate(err)
 	}
 
 	return stmt, nil
@@ -299,6 +520,10 @@ type validateField struct {
 }
 
 func (c *validateField) Visit(n Node) Visitor {
+	if e, ok := n.(Expr); ok && isConditional(e) {
+		// conditions are checked by validateConditional
+		return nil
+	}
 	e, ok := n.(*BinaryExpr)
 	if !ok {
 		return c
@@ -329,6 +554,24 @@ func (p *Parser) parseAlias() (string, error) {
 	return lit, nil
 }
 
+// parseHints parses the hint comment following SELECT, if it exists, and
+// records it as the COMMENT clause of stmt.
+func (p *Parser) parseHints(stmt *SelectStatement) (Hints, error) {
+	for {
+		tok, pos, lit := p.scan()
+		switch {
+		case tok == WS:
+		case tok == COMMENT && strings.HasPrefix(lit, "+"):
+			p.setSpan(clause{stmt, COMMENT}, pos)
+			return parseHints(lit[1:], pos)
+		case tok == COMMENT:
+		default:
+			p.unscan()
+			return nil, nil
+		}
+	}
+}
+
 // parseSources parses a comma delimited list of sources.
 func (p *Parser) parseSources() (Sources, error) {
 	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
@@ -341,6 +584,9 @@ func (p *Parser) parseSources() (Sources, error) {
 		if err != nil {
 			return nil, err
 		}
+		if s.Alias, err = p.parseSourceAlias(); err != nil {
+			return nil, err
+		}
 		sources = append(sources, s)
 
 		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
@@ -352,6 +598,44 @@ func (p *Parser) parseSources() (Sources, error) {
 	return sources, nil
 }
 
+// sourceFollowers are the keywords following a source, a bare word close
+// to one of them is a misspelled clause rather than an alias.
+var sourceFollowers = []string{"JOIN", "ON", "WHERE", "GROUP", "ORDER", "LIMIT", "UNION"}
+
+// parseSourceAlias parses the alias of a source, with or without AS.
+func (p *Parser) parseSourceAlias() (string, error) {
+	if tok, _, lit := p.scanIgnoreWhitespace(); tok == IDENT && closest(strings.ToUpper(lit), sourceFollowers) == "" {
+		return lit, nil
+	}
+	p.unscan()
+	return p.parseAlias()
+}
+
+// parseJoin parses the "JOIN" clause of the query, if it exists.
+func (p *Parser) parseJoin(stmt *SelectStatement) (*Join, error) {
+	tok, pos, _ := p.scanIgnoreWhitespace()
+	if tok != JOIN {
+		p.unscan()
+		return nil, nil
+	}
+	p.setSpan(clause{stmt, JOIN}, pos)
+	src, err := p.parseSource()
+	if err != nil {
+		return nil, err
+	}
+	if src.Alias, err = p.parseSourceAlias(); err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != ON {
+		return nil, newParseError(tokstr(tok, lit), []string{"ON"}, pos)
+	}
+	cond, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &Join{Source: src, Condition: cond}, nil
+}
+
 // peekRune returns the next rune that would be read by the scanner.
 func (p *Parser) peekRune() rune {
 	r, _, _ := p.s.s.r.ReadRune()
@@ -362,16 +646,34 @@ func (p *Parser) peekRune() rune {
 	return r
 }
 
-func (p *Parser) parseSource() (Source, error) {
-	m := &Measurement{}
+// parseSource parses an index name, either quoted or a bare index pattern
+// such as logstash-2017.01.* made of adjacent tokens.
+func (p *Parser) parseSource() (*Measurement, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case STRING:
+		return &Measurement{Database: lit}, nil
+	case IDENT, MUL, DOT:
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"identifier"}, pos)
+	}
 
-	// Didn't find a regex so parse segmented identifiers.
-	ident, err := p.parseIdent()
-	if err != nil {
-		return nil, err
+	var buf bytes.Buffer
+	for {
+		switch {
+		case tok == IDENT || tok == INTEGER || tok == NUMBER:
+			buf.WriteString(lit)
+		case tok == MUL || tok == DOT || tok == SUB:
+			buf.WriteString(tok.String())
+		case tok.isWord():
+			// index names are lower case, e.g. logs-order-*
+			buf.WriteString(strings.ToLower(tok.String()))
+		default:
+			p.unscan()
+			return &Measurement{Database: buf.String()}, nil
+		}
+		tok, _, lit = p.scan()
 	}
-	m.Database = ident
-	return m, nil
 }
 
 // parseCondition parses the "WHERE" clause of the query, if it exists.
@@ -469,53 +771,65 @@ func (p *Parser) parseHaving() (Expr, error) {
 }
 
 // parseLimit parses the specified token followed
-// by an int, if it exists.
-func (p *Parser) parseLimit() (int, int, error) {
+// by an int or ALL, if it exists, and records it as the LIMIT clause of owner.
+func (p *Parser) parseLimit(owner interface{}) (int, int, bool, error) {
 	// Check if the token exists.
-	if tok, _, _ := p.scanIgnoreWhitespace(); tok != LIMIT {
+	tok, limit, _ := p.scanIgnoreWhitespace()
+	if tok != LIMIT {
 		p.unscan()
-		return 0, 0, nil
+		return 0, 0, false, nil
 	}
 
 	// Scan the number.
 	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == IDENT && strings.EqualFold(lit, "all") {
+		// the end of an identifier ending the statement is past its text
+		end := pos
+		end.Char += len(lit)
+		p.spans[clause{owner, LIMIT}] = span{pos: limit, end: end}
+		return 0, 0, true, nil
+	}
 	if tok != INTEGER {
-		return 0, 0, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+		return 0, 0, false, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
 	}
 
 	// Parse number.
 	n, _ := strconv.ParseInt(lit, 10, 64)
 	if n < 0 {
 		msg := fmt.Sprintf("%s must be >= 0", LIMIT.String())
-		return 0, 0, &ParseError{Message: msg, Pos: pos}
+		return 0, 0, false, &ParseError{Message: msg, Pos: pos}
 	}
+	p.setSpan(clause{owner, LIMIT}, limit)
 
 	// Parse offset
 	if _tok, _, _ := p.scanIgnoreWhitespace(); _tok != COMMA {
 		p.unscan()
-		return int(n), 0, nil
+		return int(n), 0, false, nil
 	}
 
 	// Scan the number.
 	tok, pos, lit = p.scanIgnoreWhitespace()
 	if tok != INTEGER {
-		return 0, 0, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+		return 0, 0, false, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
 	}
 
 	// Parse number.
 	m, _ := strconv.ParseInt(lit, 10, 64)
 	if m < 0 {
 		msg := fmt.Sprintf("offset must be >= 0")
-		return 0, 0, &ParseError{Message: msg, Pos: pos}
+		return 0, 0, false, &ParseError{Message: msg, Pos: pos}
 	}
+	p.setSpan(clause{owner, LIMIT}, limit)
 
-	return int(n), int(m), nil
+	return int(n), int(m), false, nil
 }
 
-// parseOrderBy parses the "ORDER BY" clause of a query, if it exists.
-func (p *Parser) parseOrderBy() (SortFields, error) {
+// parseOrderBy parses the "ORDER BY" clause of a query, if it exists, and
+// records its keywords as the ORDER clause of owner.
+func (p *Parser) parseOrderBy(owner interface{}) (SortFields, error) {
 	// Return nil result and nil error if no ORDER token at this position.
-	if tok, _, _ := p.scanIgnoreWhitespace(); tok != ORDER {
+	tok, order, _ := p.scanIgnoreWhitespace()
+	if tok != ORDER {
 		p.unscan()
 		return nil, nil
 	}
@@ -524,6 +838,7 @@ func (p *Parser) parseOrderBy() (SortFields, error) {
 	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BY {
 		return nil, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
 	}
+	p.setSpan(clause{owner, ORDER}, order)
 
 	// Parse the ORDER BY fields.
 	fields, err := p.parseSortFields()
@@ -582,12 +897,24 @@ func (p *Parser) parseSortFields() (SortFields, error) {
 func (p *Parser) parseSortField() (*SortField, error) {
 	field := &SortField{}
 
-	// Parse sort field name.
+	// Parse sort field name, or the aggregate to sort buckets on.
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
 	ident, err := p.parseIdent()
 	if err != nil {
 		return nil, err
 	}
 	field.Name = ident
+	if tok, _, _ := p.scan(); tok == LPAREN {
+		if field.Call, err = p.parseCall(ident); err != nil {
+			return nil, err
+		}
+		field.Name = field.Call.String()
+		p.setSpan(field.Call, pos)
+	} else {
+		p.unscan()
+	}
+	p.setSpan(field, pos)
 
 	// Check for optional ASC or DESC clause. Default is ASC.
 	tok, _, _ := p.scanIgnoreWhitespace()
@@ -612,12 +939,17 @@ func (p *Parser) parseVarRef() (*VarRef, error) {
 }
 
 func (p *Parser) parseList() (*ListLiteral, error) {
-	list := &ListLiteral{}
-
 	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LBRACKET {
 		p.unscan()
 		return nil, newParseError(tokstr(tok, lit), []string{"["}, pos)
 	}
+	return p.parseListValues(RBRACKET)
+}
+
+// parseListValues parses the values of a list up to its closing token end.
+// This function assumes the opening token has been consumed.
+func (p *Parser) parseListValues(end Token) (*ListLiteral, error) {
+	list := &ListLiteral{}
 
 	for {
 		// Read next token.
@@ -625,6 +957,14 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 		switch tok {
 		case STRING:
 			list.Vals = append(list.Vals, lit)
+		case BOUNDPARAM:
+			// bound to a string or a number by Bind
+			param, err := p.parseBoundParameter(pos, lit)
+			if err != nil {
+				return nil, err
+			}
+			p.setSpan(param, pos)
+			list.Vals = append(list.Vals, param)
 		case NUMBER:
 			v, err := strconv.ParseFloat(lit, 64)
 			if err != nil {
@@ -637,9 +977,33 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 				return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
 			}
 			list.Vals = append(list.Vals, v)
+		case SUB:
+			// negative number, e.g. a longitude.
+			tok, pos, lit := p.scan()
+			switch tok {
+			case NUMBER:
+				v, err := strconv.ParseFloat(lit, 64)
+				if err != nil {
+					return nil, &ParseError{Message: "unable to parse number", Pos: pos}
+				}
+				list.Vals = append(list.Vals, -v)
+			case INTEGER:
+				v, err := strconv.ParseInt(lit, 10, 64)
+				if err != nil {
+					return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
+				}
+				list.Vals = append(list.Vals, -v)
+			default:
+				return nil, newParseError(tokstr(tok, lit), []string{"float", "integer"}, pos)
+			}
 		default:
 			p.unscan()
-			return nil, newParseError(tokstr(tok, lit), []string{"string", "float", "integer"}, pos)
+			expected := []string{"string", "float", "integer", "placeholder"}
+			if end == RPAREN && len(list.Vals) == 0 {
+				// IN ( also opens a subquery
+				expected = append([]string{"SELECT"}, expected...)
+			}
+			return nil, newParseError(tokstr(tok, lit), expected, pos)
 		}
 
 		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
@@ -648,9 +1012,9 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 		}
 	}
 
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RBRACKET {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != end {
 		p.unscan()
-		return nil, newParseError(tokstr(tok, lit), []string{"]"}, pos)
+		return nil, newParseError(tokstr(tok, lit), []string{tokstr(end, "")}, pos)
 	}
 	return list, nil
 }
@@ -692,7 +1056,25 @@ func (p *Parser) ParseExpr() (Expr, error) {
 			}
 		} else if IsListOp(op) {
 			p.consumeWhitespace()
-			if rhs, err = p.parseList(); err != nil {
+			// a list, a placeholder bound to one, a subquery or a
+			// parenthesized list
+			tok, _, _ := p.scanIgnoreWhitespace()
+			p.unscan()
+			if tok == BOUNDPARAM {
+				rhs, err = p.parseUnaryExpr()
+			} else if tok == LPAREN {
+				_, pos, _ := p.scanIgnoreWhitespace()
+				next, _, _ := p.scanIgnoreWhitespace()
+				p.unscan()
+				if next == SELECT {
+					rhs, err = p.parseSubquery(pos)
+				} else {
+					rhs, err = p.parseListValues(RPAREN)
+				}
+			} else {
+				rhs, err = p.parseList()
+			}
+			if err != nil {
 				return nil, err
 			}
 		} else {
@@ -717,8 +1099,78 @@ func (p *Parser) ParseExpr() (Expr, error) {
 	}
 }
 
-// parseUnaryExpr parses an non-binary expression.
+// parseSubquery parses a parenthesized select statement and records its span.
+// This function assumes the LPAREN at pos has been consumed.
+func (p *Parser) parseSubquery(pos Pos) (*SubqueryExpr, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SELECT {
+		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+	}
+	p.depth++
+	stmt, err := p.parseSelectStatement()
+	p.depth--
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	expr := &SubqueryExpr{Statement: stmt}
+	p.setSpan(expr, pos)
+	return expr, nil
+}
+
+// parseUnaryExpr parses an non-binary expression and records its span.
 func (p *Parser) parseUnaryExpr() (Expr, error) {
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	expr, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	p.setSpan(expr, pos)
+	return expr, nil
+}
+
+// setSpan records the span of n, from pos to the last scanned token.
+func (p *Parser) setSpan(n Node, pos Pos) {
+	p.spans[n] = span{pos: pos, end: p.s.end()}
+}
+
+// span returns the source span of a parsed node.
+func (p *Parser) span(n Node) (span, bool) {
+	if sp, ok := p.spans[n]; ok {
+		return sp, true
+	}
+	if e, ok := n.(*BinaryExpr); ok {
+		l, ok1 := p.span(e.LHS)
+		r, ok2 := p.span(e.RHS)
+		switch {
+		case ok1 && ok2:
+			return span{pos: l.pos, end: r.end}, true
+		case ok1:
+			return l, true
+		case ok2:
+			return r, true
+		}
+	}
+	return span{}, false
+}
+
+// locate turns a validation error into a parse error at the offending node.
+func (p *Parser) locate(err error) error {
+	e, ok := err.(*nodeError)
+	if !ok {
+		return err
+	}
+	pe := &ParseError{Message: e.msg, Suggestion: e.suggestion}
+	if sp, ok := p.span(e.node); ok {
+		pe.Pos, pe.End = sp.pos, sp.end
+	}
+	return pe
+}
+
+// parseUnary parses an non-binary expression.
+func (p *Parser) parseUnary() (Expr, error) {
 	// If the first token is a LPAREN then parse it as its own grouped expression.
 	if tok, _, _ := p.scanIgnoreWhitespace(); tok == LPAREN {
 		expr, err := p.ParseExpr()
@@ -751,7 +1203,14 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		// If the next immediate token is a left parentheses, parse as function call.
 		// Otherwise parse as a variable reference.
 		if tok0, _, _ := p.scan(); tok0 == LPAREN {
-			return p.parseCall(lit)
+			if strings.ToLower(lit) == "cast" {
+				return p.parseCast()
+			}
+			c, err := p.parseCall(lit)
+			if err != nil {
+				return nil, err
+			}
+			return conditionalCall(c, pos)
 		}
 
 		p.unscan() // unscan the last token (wasn't an LPAREN)
@@ -775,9 +1234,16 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		return &IntegerLiteral{Val: v}, nil
 	case TRUE, FALSE:
 		return &BooleanLiteral{Val: (tok == TRUE)}, nil
+	case CASE:
+		return p.parseCase()
+	case BOUNDPARAM:
+		return p.parseBoundParameter(pos, lit)
 	case MUL:
 		wc := &Wildcard{}
 		return wc, nil
+	case LBRACKET:
+		p.unscan()
+		return p.parseList()
 	case REGEX:
 		re, err := regexp.Compile(lit)
 		if err != nil {
@@ -789,6 +1255,121 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 	}
 }
 
+// parseCase parses a CASE expression.
+// This function assumes the CASE keyword has been consumed.
+func (p *Parser) parseCase() (*CaseExpr, error) {
+	expr := &CaseExpr{}
+	switch tok, pos, lit := p.scanIgnoreWhitespace(); tok {
+	case WHEN:
+		p.unscan()
+	case THEN, ELSE, END, EOF:
+		return nil, newParseError(tokstr(tok, lit), []string{"WHEN"}, pos)
+	default:
+		p.unscan()
+		operand, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Operand = operand
+	}
+
+	// Parse "WHEN EXPR THEN EXPR" clauses until ELSE or END.
+	for {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok != WHEN {
+			if len(expr.Whens) == 0 {
+				return nil, newParseError(tokstr(tok, lit), []string{"WHEN"}, pos)
+			}
+			p.unscan()
+			break
+		}
+		cond, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != THEN {
+			return nil, newParseError(tokstr(tok, lit), []string{"THEN"}, pos)
+		}
+		result, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Whens = append(expr.Whens, &WhenClause{Cond: cond, Result: result})
+	}
+
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == ELSE {
+		e, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Else = e
+	} else {
+		p.unscan()
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != END {
+		expected := []string{"WHEN", "ELSE", "END"}
+		if expr.Else != nil {
+			expected = []string{"END"}
+		}
+		return nil, newParseError(tokstr(tok, lit), expected, pos)
+	}
+	return expr, nil
+}
+
+// parseCast parses the "expr AS type)" rest of a CAST expression.
+func (p *Parser) parseCast() (*CastExpr, error) {
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != AS {
+		return nil, newParseError(tokstr(tok, lit), []string{"AS"}, pos)
+	}
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return nil, newParseError(tokstr(tok, lit), []string{"type"}, pos)
+	}
+	typ := strings.ToLower(lit)
+	if name, ok := castAliases[typ]; ok {
+		typ = name
+	}
+	if _, ok := castTypes[typ]; !ok {
+		return nil, &ParseError{Message: fmt.Sprintf("unknown cast type %s, expected one of %s", lit, strings.Join(castTypeNames(), ", ")), Pos: pos}
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return &CastExpr{Expr: expr, Type: typ}, nil
+}
+
+// conditionalCall turns the if(), coalesce() and nullif() calls into their
+// expressions, other calls are returned with their options split.
+func conditionalCall(c *Call, pos Pos) (Expr, error) {
+	signatures := map[string]*Function{
+		"if":       {Args: []string{"condition", "then", "else"}},
+		"coalesce": {Args: []string{"value"}, Variadic: true},
+		"nullif":   {Args: []string{"value", "null_value"}},
+	}
+	f, ok := signatures[c.Name]
+	if !ok {
+		splitOptions(c)
+		return c, nil
+	}
+	if !f.accepts(len(c.Args)) {
+		err := arityError(c.Name, []string{f.signature()}, len(c.Args))
+		return nil, &ParseError{Message: err.Error(), Pos: pos}
+	}
+	switch c.Name {
+	case "if":
+		return &IfExpr{Cond: c.Args[0], Then: c.Args[1], Else: c.Args[2]}, nil
+	case "coalesce":
+		return &CoalesceExpr{Args: c.Args}, nil
+	default:
+		return &NullIfExpr{Expr: c.Args[0], Value: c.Args[1]}, nil
+	}
+}
+
 // parseRegex parses a regular expression.
 func (p *Parser) parseRegex() (*RegexLiteral, error) {
 	nextRune := p.peekRune()
@@ -883,10 +1464,10 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 // scan returns the next token from the underlying scanner.
 func (p *Parser) scan() (tok Token, pos Pos, lit string) { return p.s.Scan() }
 
-// scanIgnoreWhitespace scans the next non-whitespace token.
+// scanIgnoreWhitespace scans the next token that is neither whitespace nor a comment.
 func (p *Parser) scanIgnoreWhitespace() (tok Token, pos Pos, lit string) {
 	tok, pos, lit = p.scan()
-	if tok == WS {
+	for tok == WS || tok == COMMENT {
 		tok, pos, lit = p.scan()
 	}
 	return
@@ -960,17 +1541,72 @@ type ParseError struct {
 	Found    string
 	Expected []string
 	Pos      Pos
+	// End is the position after the offending text.
+	End Pos
+	// Suggestion is the keyword or function probably meant.
+	Suggestion string
 }
 
 // newParseError returns a new instance of ParseError.
 func newParseError(found string, expected []string, pos Pos) *ParseError {
-	return &ParseError{Found: found, Expected: expected, Pos: pos}
+	end := pos
+	if found != EOF.String() {
+		end.Char += len([]rune(found))
+	}
+	return &ParseError{Found: found, Expected: expected, Pos: pos, End: end, Suggestion: suggestKeyword(found, expected)}
 }
 
 // Error returns the string representation of the error.
 func (e *ParseError) Error() string {
+	var msg string
 	if e.Message != "" {
-		return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+		msg = fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+	} else {
+		msg = fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
+	}
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %s?", e.Suggestion)
+	}
+	return msg
+}
+
+// Offset moves the error from its statement to a source holding the
+// statement at start, as returned by SplitStatementsPos.
+func (e *ParseError) Offset(start Pos) {
+	e.Pos, e.End = start.add(e.Pos), start.add(e.End)
+}
+
+// Snippet returns the line of src where the error is, with carets under the
+// offending text.
+func (e *ParseError) Snippet(src string) string {
+	lines := strings.Split(src, "\n")
+	if e.Pos.Line >= len(lines) {
+		return ""
+	}
+	line := []rune(strings.TrimRight(lines[e.Pos.Line], "\r"))
+	start := e.Pos.Char
+	if start > len(line) {
+		start = len(line)
 	}
-	return fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
+	width := len(line) - start
+	if e.End.Line == e.Pos.Line && e.End.Char > start {
+		width = e.End.Char - start
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(string(line))
+	buf.WriteByte('\n')
+	for _, ch := range line[:start] {
+		// keep tabs so the carets line up
+		if ch == '\t' {
+			buf.WriteRune(ch)
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteString(strings.Repeat("^", width))
+	return buf.String()
 }
@@ -36,6 +36,106 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT from index patterns, with comments and quoted identifiers
+		{
+			s: "SELECT `geo.location` AS \"geo-loc\" -- the point\nFROM \"my-index-*\", logstash-2017.01.*, .kibana /* all */ LIMIT 1",
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.VarRef{Val: "geo.location", Segments: []string{"geo.location"}}, Alias: "geo-loc"},
+				},
+				Sources: []sp.Source{
+					&sp.Measurement{Database: "my-index-*"},
+					&sp.Measurement{Database: "logstash-2017.01.*"},
+					&sp.Measurement{Database: ".kibana"},
+				},
+				Limit: 1,
+			},
+		},
+
+		// SELECT double quoted identifiers as fields, conditions and dimensions
+		{
+			s: `SELECT "my-field", count(*) FROM x WHERE "my-field" = 'a' GROUP BY "my-field"`,
+			stmt: &sp.SelectStatement{
+				Fields: []*sp.Field{
+					{Expr: &sp.VarRef{Val: "my-field", Segments: []string{"my-field"}}},
+					{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "x"}},
+				Condition: &sp.BinaryExpr{
+					Op:  sp.EQ,
+					LHS: &sp.VarRef{Val: "my-field", Segments: []string{"my-field"}},
+					RHS: &sp.StringLiteral{Val: "a"},
+				},
+				Dimensions: []*sp.Dimension{
+					{Expr: &sp.VarRef{Val: "my-field", Segments: []string{"my-field"}}},
+				},
+			},
+		},
+
+		// SELECT with placeholders
+		{
+			s: `SELECT * FROM myseries WHERE host = ? AND region IN ?`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.Wildcard{}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "myseries"}},
+				Condition: &sp.BinaryExpr{
+					Op: sp.AND,
+					LHS: &sp.BinaryExpr{
+						Op:  sp.EQ,
+						LHS: &sp.VarRef{Val: "host", Segments: []string{"host"}},
+						RHS: &sp.BoundParameter{Name: "1"},
+					},
+					RHS: &sp.BinaryExpr{
+						Op:  sp.IN,
+						LHS: &sp.VarRef{Val: "region", Segments: []string{"region"}},
+						RHS: &sp.BoundParameter{Name: "2"},
+					},
+				},
+			},
+		},
+
+		// SELECT with placeholders in IN lists
+		{
+			s: `SELECT * FROM myseries WHERE region IN (?, ?) AND host IN ['a', ?]`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.Wildcard{}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "myseries"}},
+				Condition: &sp.BinaryExpr{
+					Op: sp.AND,
+					LHS: &sp.BinaryExpr{
+						Op:  sp.IN,
+						LHS: &sp.VarRef{Val: "region", Segments: []string{"region"}},
+						RHS: &sp.ListLiteral{Vals: []interface{}{&sp.BoundParameter{Name: "1"}, &sp.BoundParameter{Name: "2"}}},
+					},
+					RHS: &sp.BinaryExpr{
+						Op:  sp.IN,
+						LHS: &sp.VarRef{Val: "host", Segments: []string{"host"}},
+						RHS: &sp.ListLiteral{Vals: []interface{}{"a", &sp.BoundParameter{Name: "3"}}},
+					},
+				},
+			},
+		},
+
+		// SELECT with hints
+		{
+			s: `SELECT /*+ timeout(5s) routing(u1) */ * FROM myseries`,
+			stmt: &sp.SelectStatement{
+				Hints:      sp.Hints{"timeout": "5s", "routing": "u1"},
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.Wildcard{}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "myseries"}},
+			},
+		},
+
 		// SELECT group by having statement
 		{
 			s: `SELECT ipo_year, COUNT(*) AS ipo_count FROM symbol GROUP BY ipo_year HAVING ipo_count > 200`,
@@ -57,6 +157,182 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// JOIN of two aliased indices
+		{
+			s: `SELECT q.close, s.name FROM quote AS q JOIN symbol s ON q.symbol = s.name`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.VarRef{Val: "q.close", Segments: []string{"q", "close"}}},
+					{Expr: &sp.VarRef{Val: "s.name", Segments: []string{"s", "name"}}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "quote", Alias: "q"}},
+				Join: &sp.Join{
+					Source: &sp.Measurement{Database: "symbol", Alias: "s"},
+					Condition: &sp.BinaryExpr{
+						Op:  sp.EQ,
+						LHS: &sp.VarRef{Val: "q.symbol", Segments: []string{"q", "symbol"}},
+						RHS: &sp.VarRef{Val: "s.name", Segments: []string{"s", "name"}},
+					},
+				},
+			},
+		},
+
+		// UNION ALL, the ORDER BY and LIMIT of a parenthesized statement are its own
+		{
+			s: `(SELECT a FROM x LIMIT 5) UNION ALL SELECT b FROM y ORDER BY a DESC LIMIT 3`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.VarRef{Val: "a", Segments: []string{"a"}}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "x"}},
+				Limit:      5,
+				Union: &sp.Union{
+					Statements: []*sp.SelectStatement{{
+						IsRawQuery: true,
+						Fields:     []*sp.Field{{Expr: &sp.VarRef{Val: "b", Segments: []string{"b"}}}},
+						Sources:    []sp.Source{&sp.Measurement{Database: "y"}},
+					}},
+					SortFields: []*sp.SortField{{Name: "a"}},
+					Limit:      3,
+				},
+			},
+		},
+
+		// EXPLAIN of a statement
+		{
+			s: `EXPLAIN SELECT a FROM x`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.VarRef{Val: "a", Segments: []string{"a"}}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "x"}},
+				Explain:    true,
+			},
+		},
+
+		// metadata statements
+		{
+			s:    `SHOW TABLES`,
+			stmt: &sp.ShowTablesStatement{},
+		},
+		{
+			s:    `show tables like 'logs-%'`,
+			stmt: &sp.ShowTablesStatement{Pattern: "logs-%"},
+		},
+		{
+			s:    `DESCRIBE logs-2017.01.*`,
+			stmt: &sp.DescribeStatement{Source: &sp.Measurement{Database: "logs-2017.01.*"}},
+		},
+		{
+			s:    `DESC "my index"`,
+			stmt: &sp.DescribeStatement{Source: &sp.Measurement{Database: "my index"}},
+		},
+		{
+			s:    `SHOW COLUMNS FROM symbol;`,
+			stmt: &sp.ShowColumnsStatement{Source: &sp.Measurement{Database: "symbol"}},
+		},
+
+		// DELETE and UPDATE
+		{
+			s: `DELETE FROM logs-* WHERE level = 'debug'`,
+			stmt: &sp.DeleteStatement{
+				Source: &sp.Measurement{Database: "logs-*"},
+				Condition: &sp.BinaryExpr{
+					Op:  sp.EQ,
+					LHS: &sp.VarRef{Val: "level", Segments: []string{"level"}},
+					RHS: &sp.StringLiteral{Val: "debug"},
+				},
+			},
+		},
+		{
+			s: `UPDATE symbol SET price = price * 2, tcp.tag = 'x'`,
+			stmt: &sp.UpdateStatement{
+				Source: &sp.Measurement{Database: "symbol"},
+				Assignments: []*sp.Assignment{
+					{
+						Field: &sp.VarRef{Val: "price", Segments: []string{"price"}},
+						Expr: &sp.BinaryExpr{
+							Op:  sp.MUL,
+							LHS: &sp.VarRef{Val: "price", Segments: []string{"price"}},
+							RHS: &sp.IntegerLiteral{Val: 2},
+						},
+					},
+					{
+						Field: &sp.VarRef{Val: "tcp.tag", Segments: []string{"tcp", "tag"}},
+						Expr:  &sp.StringLiteral{Val: "x"},
+					},
+				},
+			},
+		},
+
+		// CREATE and DROP
+		{
+			s: `CREATE TABLE symbol (name keyword, price double, ts date FORMAT 'epoch_millis', tags nested(name keyword, n long)) WITH (shards = 3, replicas = 1)`,
+			stmt: &sp.CreateTableStatement{
+				Source: &sp.Measurement{Database: "symbol"},
+				Columns: []*sp.ColumnDef{
+					{Name: &sp.VarRef{Val: "name", Segments: []string{"name"}}, Type: "keyword"},
+					{Name: &sp.VarRef{Val: "price", Segments: []string{"price"}}, Type: "double"},
+					{Name: &sp.VarRef{Val: "ts", Segments: []string{"ts"}}, Type: "date", Format: "epoch_millis"},
+					{
+						Name: &sp.VarRef{Val: "tags", Segments: []string{"tags"}},
+						Type: "nested",
+						Columns: []*sp.ColumnDef{
+							{Name: &sp.VarRef{Val: "name", Segments: []string{"name"}}, Type: "keyword"},
+							{Name: &sp.VarRef{Val: "n", Segments: []string{"n"}}, Type: "long"},
+						},
+					},
+				},
+				Options: []*sp.TableOption{
+					{Name: "shards", Value: &sp.IntegerLiteral{Val: 3}},
+					{Name: "replicas", Value: &sp.IntegerLiteral{Val: 1}},
+				},
+			},
+		},
+		{
+			s: `CREATE INDEX TEMPLATE logs ON logs-*, metrics-* (ts date) WITH (order = 1)`,
+			stmt: &sp.CreateTemplateStatement{
+				Name:     "logs",
+				Patterns: []string{"logs-*", "metrics-*"},
+				Columns:  []*sp.ColumnDef{{Name: &sp.VarRef{Val: "ts", Segments: []string{"ts"}}, Type: "date"}},
+				Options:  []*sp.TableOption{{Name: "order", Value: &sp.IntegerLiteral{Val: 1}}},
+			},
+		},
+		{
+			s:    `DROP TABLE logs-2017`,
+			stmt: &sp.DropTableStatement{Source: &sp.Measurement{Database: "logs-2017"}},
+		},
+		{
+			s:    `DROP INDEX TEMPLATE logs`,
+			stmt: &sp.DropTemplateStatement{Name: "logs"},
+		},
+
+		// INSERT
+		{
+			s: `INSERT INTO fixtures (_id, tcp.port) VALUES ('a', 80), ('b', null)`,
+			stmt: &sp.InsertStatement{
+				Target: &sp.Measurement{Database: "fixtures"},
+				Columns: []*sp.VarRef{
+					{Val: "_id", Segments: []string{"_id"}},
+					{Val: "tcp.port", Segments: []string{"tcp", "port"}},
+				},
+				Values: [][]sp.Expr{
+					{&sp.StringLiteral{Val: "a"}, &sp.IntegerLiteral{Val: 80}},
+					{&sp.StringLiteral{Val: "b"}, &sp.VarRef{Val: "null", Segments: []string{"null"}}},
+				},
+			},
+		},
+		{
+			s: `INSERT INTO symbol_copy SELECT * FROM symbol`,
+			stmt: &sp.InsertStatement{
+				Target: &sp.Measurement{Database: "symbol_copy"},
+				Select: &sp.SelectStatement{
+					IsRawQuery: true,
+					Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+					Sources:    []sp.Source{&sp.Measurement{Database: "symbol"}},
+				},
+			},
+		},
+
 		{
 			s: `SELECT * FROM myseries GROUP BY *`,
 			stmt: &sp.SelectStatement{
@@ -95,7 +371,7 @@ func TestParser_ParseStatement(t *testing.T) {
 
 		// SELECT statement
 		{
-			s: fmt.Sprintf(`SELECT mean(field1), sum(field2) ,count(field3) AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' and time > %d GROUP BY time("10h") ORDER BY DESC LIMIT 20, 10`, now.Unix()),
+			s: fmt.Sprintf(`SELECT mean(field1), sum(field2) ,count(field3) AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' and time > %d GROUP BY time('10h') ORDER BY DESC LIMIT 20, 10`, now.Unix()),
 			stmt: &sp.SelectStatement{
 				IsRawQuery: false,
 				Fields: []*sp.Field{
@@ -226,6 +502,17 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement reading every row
+		{
+			s: `SELECT field1 FROM myseries LIMIT ALL`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.VarRef{Val: "field1", Segments: []string{"field1"}}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "myseries"}},
+				LimitAll:   true,
+			},
+		},
+
 		// SELECT statement with multiple ORDER BY fields
 		{
 			skip: true,
@@ -358,15 +645,21 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 		// Errors
-		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
+		{s: ``, err: `found EOF, expected SELECT, EXPLAIN, SHOW, DESCRIBE, DESC, DELETE, UPDATE, INSERT, CREATE, DROP at line 1, char 1`},
 		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
-		{s: `blah blah`, err: `found blah, expected SELECT at line 1, char 1`},
+		{s: `blah blah`, err: `found blah, expected SELECT, EXPLAIN, SHOW, DESCRIBE, DESC, DELETE, UPDATE, INSERT, CREATE, DROP at line 1, char 1`},
 		{s: `SELECT field1 X`, err: `found X, expected FROM at line 1, char 15`},
-		{s: `SELECT field1 FROM "series" WHERE X`, err: `found series, expected identifier at line 1, char 19`},
+		{s: `SELECT /*+ timeot(5s) */ * FROM myseries`, err: `unknown hint timeot at line 1, char 8, did you mean timeout?`},
+		{s: `SELECT /*+ routing */ * FROM myseries`, err: `invalid hint routing, expected name(value) at line 1, char 8`},
+		{s: `SELECT /*+ routing(a) routing(b) */ * FROM myseries`, err: `duplicate hint routing at line 1, char 8`},
+		{s: `SELECT * FROM myseries WHERE a = ? AND b = :b`, err: `can not mix ? and :name placeholders at line 1, char 44`},
+		{s: `SELECT field1 FROM WHERE X`, err: `found WHERE, expected identifier at line 1, char 20`},
+		{s: `SELECT field1 FROM myseries /* x`, err: `found /* x, expected EOF at line 1, char 29`},
 		{s: `SELECT field1 FROM myseries GROUP`, err: `found EOF, expected BY at line 1, char 35`},
 		{s: `SELECT field1 FROM myseries LIMIT`, err: `found EOF, expected integer at line 1, char 35`},
 		{s: `SELECT field1 FROM myseries LIMIT 10.5`, err: `found 10.5, expected integer at line 1, char 35`},
-		{s: `SELECT top() FROM myseries`, err: `invalid number of arguments for top, expected at least 1, got 0`},
+		{s: `SELECT field1 FROM myseries LIMIT ALL, 10`, err: `found ,, expected EOF at line 1, char 38`},
+		{s: `SELECT top() FROM myseries`, err: `top expects (field), got 0 arguments at line 1, char 8`},
 		{s: `SELECT field1 FROM myseries ORDER`, err: `found EOF, expected BY at line 1, char 35`},
 		{s: `SELECT field1 FROM myseries ORDER BY`, err: `found EOF, expected identifier, ASC, DESC at line 1, char 38`},
 		{s: `SELECT field1 FROM myseries ORDER BY /`, err: `found /, expected identifier, ASC, DESC at line 1, char 38`},
@@ -376,8 +669,82 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `SELECT field1 FROM 12`, err: `found 12, expected identifier at line 1, char 20`},
 		{s: `SELECT 1000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000 FROM myseries`, err: `unable to parse integer at line 1, char 8`},
 		{s: `SELECT 10.5h FROM myseries`, err: `found h, expected FROM at line 1, char 12`},
-		{s: `SELECT value > 2 FROM cpu`, err: `invalid operator > in SELECT field, only support +-*/`},
-		{s: `SELECT value = 2 FROM cpu`, err: `invalid operator = in SELECT field, only support +-*/`},
+		{s: `SELECT value > 2 FROM cpu`, err: `invalid operator > in SELECT field, only support +-*/ at line 1, char 8`},
+		{s: `SELECT value = 2 FROM cpu`, err: `invalid operator = in SELECT field, only support +-*/ at line 1, char 8`},
+		{s: `SELECT * FROM blog WHERE nested(comments)`, err: `nested expects (path, condition), got 1 argument at line 1, char 26`},
+		{s: `SELECT * FROM blog WHERE nested(1, comments.author = 'x')`, err: `expected nested path in nested(), got 1 at line 1, char 26`},
+		{s: `SELECT * FROM shop WHERE geo_distance(location, 40.7, -74.0)`, err: `geo_distance expects (field, lat, lon, distance), got 3 arguments at line 1, char 26`},
+		{s: `SELECT * FROM shop WHERE geo_distance(location, 91, -74.0, '1km')`, err: `invalid latitude 91 in geo_distance(), must be -90 <= lat <= 90 at line 1, char 26`},
+		{s: `SELECT * FROM shop WHERE geo_polygon(location, [40, -70, 30])`, err: `expected lat, lon pairs in geo_polygon(), got 3 numbers at line 1, char 26`},
+		{s: `SELECT geo_centroid(location + 1) FROM shop`, err: `expected field argument in geo_centroid() at line 1, char 8`},
+		{s: `SELECT * FROM t WHERE a IN (b)`, err: `found b, expected SELECT, string, float, integer, placeholder at line 1, char 29`},
+		{s: `SELECT * FROM t WHERE a IN (1, b)`, err: `found b, expected string, float, integer, placeholder at line 1, char 32`},
+		{s: `SELECT * FROM t WHERE a IN (?, :b)`, err: `can not mix ? and :name placeholders at line 1, char 32`},
+		{s: `SELECT * FROM t WHERE a IN (1, 2`, err: `found EOF, expected ) at line 1, char 33`},
+		{s: `SELECT * FROM t WHERE a IN (SELECT b FROM u`, err: `found EOF, expected ) at line 1, char 45`},
+		{s: `SELECT * FROM t WHERE a IN (SELECT b FROM u LIMIT 1 x)`, err: `found x, expected ) at line 1, char 53`},
+		{s: `SELECT * FROM t WHERE a IN (SELECT b, c FROM u)`, err: `subquery must select exactly one column, got b, c at line 1, char 28`},
+		{s: `SELECT * FROM t WHERE a = 1 OR a IN (SELECT b FROM u)`, err: `IN (SELECT ...) must be used as a top level AND condition at line 1, char 37`},
+		{s: `SELECT * FROM t WHERE a + 1 IN (SELECT b FROM u)`, err: `IN (SELECT ...) expects a field on its left, got a + 1 at line 1, char 23`},
+		{s: `SELECT a FROM t GROUP BY a ORDER BY lower(x)`, err: `ORDER BY only supports fields and aggregate functions, got lower() at line 1, char 37`},
+		{s: `SELECT a FROM t ORDER BY sum(x)`, err: `ORDER BY sum(x) needs a GROUP BY at line 1, char 26`},
+		{s: `SELECT q.close FROM quote q JOIN symbol s q.symbol = s.name`, err: `found q, expected ON at line 1, char 43`},
+		{s: `SELECT close FROM quote q JOIN symbol s ON q.symbol = s.name`, err: `field close must be qualified with q or s at line 1, char 8`},
+		{s: `SELECT q.close + 1 FROM quote q JOIN symbol s ON q.symbol = s.name`, err: `JOIN only supports fields in SELECT, got q.close + 1 at line 1, char 8`},
+		{s: `SELECT q.close FROM quote q JOIN symbol s ON q.symbol = 1`, err: `JOIN ON expects q.key = s.key, got q.symbol = 1 at line 1, char 46`},
+		{s: `SELECT q.close FROM quote q JOIN symbol s ON q.symbol = s.name WHERE q.close > s.open`, err: `condition q.close > s.open mixes fields of q and s at line 1, char 70`},
+		{s: `SELECT * FROM quote JOIN quote ON quote.symbol = quote.symbol`, err: `JOIN of quote with itself needs an alias for each side at line 1, char 21`},
+		{s: `SELECT count(*) FROM quote q JOIN symbol s ON q.symbol = s.name`, err: `JOIN only supports raw queries, aggregates can not be computed across indices at line 1, char 8`},
+		{s: `SELECT q.close FROM quote q JOIN symbol s ON q.symbol = s.name ORDER BY close`, err: `ORDER BY is not supported with JOIN at line 1, char 64`},
+		{s: `SELECT a FROM x UNION SELECT b FROM y`, err: `found SELECT, expected ALL at line 1, char 23`},
+		{s: `SELECT a FROM x UNION ALL (SELECT b FROM y`, err: `found EOF, expected ) at line 1, char 44`},
+		{s: `SELECT a, b FROM x UNION ALL SELECT c FROM y`, err: `UNION ALL statements must select the same number of columns, got 2 and 1 at line 1, char 37`},
+		{s: `SELECT a FROM x UNION ALL SELECT b FROM y ORDER BY b`, err: `ORDER BY b is not a column of UNION ALL, expected one of a at line 1, char 52`},
+		{s: `SELECT a FROM x UNION ALL SELECT b FROM y LIMIT ALL`, err: `LIMIT ALL is not supported with UNION ALL at line 1, char 43`},
+		{s: `SELECT a FROM x WHERE a IN (SELECT b FROM y UNION ALL SELECT c FROM z)`, err: `found UNION, expected ) at line 1, char 45`},
+		{s: `EXPLAIN`, err: `found EOF, expected SELECT at line 1, char 9`},
+		{s: `EXPLAIN EXPLAIN SELECT a FROM x`, err: `found EXPLAIN, expected SELECT at line 1, char 9`},
+		{s: `SHOW`, err: `found EOF, expected TABLES, COLUMNS at line 1, char 6`},
+		{s: `SHOW TABLES LIKE logs`, err: `found logs, expected string at line 1, char 18`},
+		{s: `SHOW TABLES logs`, err: `found logs, expected EOF at line 1, char 13`},
+		{s: `SHOW COLUMNS symbol`, err: `found symbol, expected FROM at line 1, char 14`},
+		{s: `DESCRIBE`, err: `found EOF, expected identifier at line 1, char 10`},
+		{s: `DELETE logs`, err: `found logs, expected FROM at line 1, char 8`},
+		{s: `DELETE FROM logs LIMIT 10`, err: `found LIMIT, expected EOF at line 1, char 18`},
+		{s: `UPDATE x a = 1`, err: `found a, expected SET at line 1, char 10`},
+		{s: `UPDATE x SET a 1`, err: `found 1, expected = at line 1, char 16`},
+		{s: `UPDATE x SET a = 1, a = 2`, err: `a is set twice at line 1, char 21`},
+		{s: `UPDATE x SET a = sum(b)`, err: `sum() can not be used in SET, only scalar expressions can at line 1, char 18`},
+		{s: `UPDATE x SET a = substring(b)`, err: `substring expects (string, integer[, integer]), got 1 argument at line 1, char 18`},
+		{s: `CREATE INDEX logs (a long)`, err: `found logs, expected TEMPLATE at line 1, char 14`},
+		{s: `CREATE TABLE x a long`, err: `found a, expected ( at line 1, char 16`},
+		{s: `CREATE TABLE x (a lnog)`, err: `unknown type lnog at line 1, char 19, did you mean long?`},
+		{s: `CREATE TABLE x (a long, a text)`, err: `column a is listed twice at line 1, char 25`},
+		{s: `CREATE TABLE x (a long FORMAT 'epoch_millis')`, err: `FORMAT only applies to date columns, a is a long column at line 1, char 24`},
+		{s: `CREATE TABLE x (a keyword(b long))`, err: `keyword columns have no fields, only object and nested ones have at line 1, char 26`},
+		{s: `CREATE TABLE x (a nested)`, err: `found ), expected ( at line 1, char 25`},
+		{s: `CREATE TABLE x (a long) WITH (shard = 1)`, err: `unknown option shard at line 1, char 31, did you mean shards?`},
+		{s: `CREATE TABLE x (a long) WITH (order = 1)`, err: `unknown option ORDER at line 1, char 31`},
+		{s: `CREATE TABLE x (a long) WITH (shards = '1')`, err: `found 1, expected integer at line 1, char 39`},
+		{s: `CREATE TABLE x (a long) WITH (shards = 1, shards = 2)`, err: `duplicate option shards at line 1, char 43`},
+		{s: `CREATE INDEX TEMPLATE logs (a long)`, err: `found (, expected ON at line 1, char 28`},
+		{s: `DROP TABLE x y`, err: `found y, expected EOF at line 1, char 14`},
+		{s: `DROP TABLE logs-*`, err: `DROP TABLE deletes one index, logs-* names many at line 1, char 12`},
+		{s: `DROP TABLE "a,b"`, err: `DROP TABLE deletes one index, a,b names many at line 1, char 12`},
+		{s: `DROP TABLE _all`, err: `DROP TABLE deletes one index, _all names many at line 1, char 12`},
+		{s: `DROP TABLE a, b`, err: `DROP TABLE deletes one index, not a list at line 1, char 13`},
+		{s: `INSERT fixtures (a) VALUES (1)`, err: `found fixtures, expected INTO at line 1, char 8`},
+		{s: `INSERT INTO fixtures VALUES (1)`, err: `INSERT VALUES needs the (field, ...) list of its columns at line 1, char 22`},
+		{s: `INSERT INTO fixtures (a) (1)`, err: `found (, expected VALUES, SELECT at line 1, char 26`},
+		{s: `INSERT INTO fixtures (a b) VALUES (1)`, err: `found b, expected ) at line 1, char 25`},
+		{s: `INSERT INTO fixtures (a, b) VALUES (1, 2), (3)`, err: `VALUES row has 1 values for 2 columns at line 1, char 44`},
+		{s: `INSERT INTO fixtures (a, a) VALUES (1, 2)`, err: `column a is listed twice at line 1, char 26`},
+		{s: `INSERT INTO fixtures (a) VALUES (b + 1)`, err: `VALUES only support literals, got b + 1 at line 1, char 34`},
+		{s: `INSERT INTO x SELECT count(*) FROM y`, err: `INSERT SELECT only supports raw queries, _reindex can not aggregate at line 1, char 22`},
+		{s: `INSERT INTO x SELECT * FROM y ORDER BY a`, err: `ORDER BY is not supported by INSERT SELECT at line 1, char 31`},
+		{s: `INSERT INTO x (a) SELECT * FROM y`, err: `INSERT SELECT * can not name its columns at line 1, char 16`},
+		{s: `INSERT INTO x (a) SELECT b, c FROM y`, err: `INSERT has 1 columns for 2 selected fields at line 1, char 16`},
+		{s: `INSERT INTO x SELECT a + 1 FROM y`, err: `a + 1 needs an alias naming its field at line 1, char 22`},
 	}
 
 	for i, tt := range tests {
@@ -445,9 +812,12 @@ func TestParseStatement(t *testing.T) {
 		err  string
 	}{
 		// Errors
-		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
-		{s: `CREATE`, err: `found CREATE, expected SELECT at line 1, char 1`},
+		{s: ``, err: `found EOF, expected SELECT, EXPLAIN, SHOW, DESCRIBE, DESC, DELETE, UPDATE, INSERT, CREATE, DROP at line 1, char 1`},
+		{s: `GRANT`, err: `found GRANT, expected SELECT, EXPLAIN, SHOW, DESCRIBE, DESC, DELETE, UPDATE, INSERT, CREATE, DROP at line 1, char 1`},
+		{s: `CREATE`, err: `found EOF, expected TABLE, INDEX at line 1, char 8`},
 		{s: `SELECT sum(x) FROM Packetbeat`, err: ``},
+		{s: `SELECT sum(x) FROM Packetbeat ;`, err: ``},
+		{s: `SELECT a FROM b; SELECT c FROM d`, err: `found SELECT, expected EOF at line 1, char 18`},
 	}
 	for i, tt := range tests {
 		p := sp.NewParser(strings.NewReader(tt.s))
@@ -459,6 +829,30 @@ func TestParseStatement(t *testing.T) {
 	}
 }
 
+// Ensure the parser can parse ;-separated statements.
+func TestParseStatements(t *testing.T) {
+	var tests = []struct {
+		s     string
+		stmts string
+		err   string
+	}{
+		{s: ``, stmts: ``},
+		{s: `SELECT a FROM b`, stmts: `SELECT a FROM b`},
+		{s: ";SELECT a FROM b;;\nselect c from d; -- done", stmts: "SELECT a FROM b;\nSELECT c FROM d"},
+		{s: "show tables; describe b", stmts: "SHOW TABLES;\nDESCRIBE b"},
+		{s: `SELECT a FROM b SELECT c FROM d`, err: `found SELECT, expected EOF at line 1, char 17`},
+		{s: `SELECT a FROM b; DROP d`, err: `found d, expected TABLE, INDEX at line 1, char 23`},
+	}
+	for i, tt := range tests {
+		stmts, err := sp.ParseStatements(tt.s)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if err == nil && stmts.String() != tt.stmts {
+			t.Errorf("%d. %q: statements mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.stmts, stmts)
+		}
+	}
+}
+
 // Ensure the parser can parse expressions into an AST.
 func TestParser_ParseExpr(t *testing.T) {
 	var tests = []struct {
@@ -595,6 +989,73 @@ func TestParser_ParseExpr(t *testing.T) {
 			},
 		},
 
+		// Function call with list argument
+		{
+			s: `geo_polygon(location, [40, -70.5, 'drm3btev3e86'])`,
+			expr: &sp.Call{
+				Name: "geo_polygon",
+				Args: []sp.Expr{
+					&sp.VarRef{Val: "location", Segments: []string{"location"}},
+					&sp.ListLiteral{Vals: []interface{}{int64(40), float64(-70.5), "drm3btev3e86"}},
+				},
+			},
+		},
+
+		// CASE expression
+		{
+			s: `CASE WHEN x > 1 THEN 'a' ELSE 'b' END`,
+			expr: &sp.CaseExpr{
+				Whens: []*sp.WhenClause{{
+					Cond:   &sp.BinaryExpr{Op: sp.GT, LHS: &sp.VarRef{Val: "x", Segments: []string{"x"}}, RHS: &sp.IntegerLiteral{Val: 1}},
+					Result: &sp.StringLiteral{Val: "a"},
+				}},
+				Else: &sp.StringLiteral{Val: "b"},
+			},
+		},
+
+		// simple CASE expression
+		{
+			s: `CASE x WHEN 1 THEN 'a' END`,
+			expr: &sp.CaseExpr{
+				Operand: &sp.VarRef{Val: "x", Segments: []string{"x"}},
+				Whens:   []*sp.WhenClause{{Cond: &sp.IntegerLiteral{Val: 1}, Result: &sp.StringLiteral{Val: "a"}}},
+			},
+		},
+
+		// Conditional functions
+		{
+			s: `coalesce(x, IF(y, 1, 2), nullif(z, 0))`,
+			expr: &sp.CoalesceExpr{
+				Args: []sp.Expr{
+					&sp.VarRef{Val: "x", Segments: []string{"x"}},
+					&sp.IfExpr{Cond: &sp.VarRef{Val: "y", Segments: []string{"y"}}, Then: &sp.IntegerLiteral{Val: 1}, Else: &sp.IntegerLiteral{Val: 2}},
+					&sp.NullIfExpr{Expr: &sp.VarRef{Val: "z", Segments: []string{"z"}}, Value: &sp.IntegerLiteral{Val: 0}},
+				},
+			},
+		},
+		{s: `CASE END`, err: `found END, expected WHEN at line 1, char 6`},
+		{s: `CASE WHEN x THEN 1`, err: `found EOF, expected WHEN, ELSE, END at line 1, char 19`},
+
+		// CAST expression
+		{
+			s:    `CAST(x + 1 AS Integer)`,
+			expr: &sp.CastExpr{Expr: &sp.BinaryExpr{Op: sp.ADD, LHS: &sp.VarRef{Val: "x", Segments: []string{"x"}}, RHS: &sp.IntegerLiteral{Val: 1}}, Type: "integer"},
+		},
+		{
+			s:    `cast(x AS int)`,
+			expr: &sp.CastExpr{Expr: &sp.VarRef{Val: "x", Segments: []string{"x"}}, Type: "integer"},
+		},
+		{
+			s:    `cast(x AS BIGINT)`,
+			expr: &sp.CastExpr{Expr: &sp.VarRef{Val: "x", Segments: []string{"x"}}, Type: "long"},
+		},
+		{
+			s:    `cast(x AS float)`,
+			expr: &sp.CastExpr{Expr: &sp.VarRef{Val: "x", Segments: []string{"x"}}, Type: "float"},
+		},
+		{s: `cast(x integer)`, err: `found integer, expected AS at line 1, char 8`},
+		{s: `cast(x AS date)`, err: `unknown cast type date, expected one of integer, int, long, bigint, float, double, string, varchar, keyword, text, boolean, bool at line 1, char 11`},
+
 		// Function call (multi-arg)
 		{
 			s: `my_func(1, 2 + 3)`,
@@ -663,6 +1124,94 @@ func TestQuoteIdent(t *testing.T) {
 }
 
 // MustParseSelectStatement parses a select statement. Panic on error.
+// Ensure errors point at the offending text and suggest what was meant.
+func TestParseError_Snippet(t *testing.T) {
+	var tests = []struct {
+		sql        string
+		line, col  int
+		suggestion string
+		snippet    string
+	}{
+		{
+			sql:        `select count(*) from symbol GROPU BY exchange`,
+			line:       1,
+			col:        29,
+			suggestion: "GROUP BY",
+			snippet:    "select count(*) from symbol GROPU BY exchange\n                            ^^^^^",
+		},
+		{
+			sql:        "select *\nFORM symbol",
+			line:       2,
+			col:        1,
+			suggestion: "FROM",
+			snippet:    "FORM symbol\n^^^^",
+		},
+		{
+			sql:        `select count(*) from t group by date_histgram(a, '1d')`,
+			line:       1,
+			col:        33,
+			suggestion: "date_histogram()",
+			snippet:    "select count(*) from t group by date_histgram(a, '1d')\n                                ^^^^^^^^^^^^^^^^^^^^^^",
+		},
+		{
+			sql:        `select date_histgram(ts, '1d') from t`,
+			line:       1,
+			col:        8,
+			suggestion: "date_histogram()",
+			snippet:    "select date_histgram(ts, '1d') from t\n       ^^^^^^^^^^^^^^^^^^^^^^^",
+		},
+		{
+			sql:        `selct a from x`,
+			line:       1,
+			col:        1,
+			suggestion: "SELECT",
+			snippet:    "selct a from x\n^^^^^",
+		},
+		{
+			sql:     `select * from blog where a = 1 or nested(comments, comments.a = 1) limit 1`,
+			line:    1,
+			col:     35,
+			snippet: "select * from blog where a = 1 or nested(comments, comments.a = 1) limit 1\n                                  ^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^",
+		},
+		{
+			sql:     `SELECT value > 2 FROM cpu`,
+			line:    1,
+			col:     8,
+			snippet: "SELECT value > 2 FROM cpu\n       ^^^^^^^^^",
+		},
+		{
+			sql:     `select exchange from symbol group by exchange limit all`,
+			line:    1,
+			col:     47,
+			snippet: "select exchange from symbol group by exchange limit all\n                                              ^^^^^^^^^",
+		},
+		{
+			sql:     `insert into x select a from y order by a limit 5`,
+			line:    1,
+			col:     31,
+			snippet: "insert into x select a from y order by a limit 5\n                              ^^^^^^^^",
+		},
+	}
+
+	for i, tt := range tests {
+		_, err := sp.EsDsl(tt.sql)
+		e, ok := err.(*sp.ParseError)
+		if !ok {
+			t.Errorf("%d. %q: expected parse error, got %v", i, tt.sql, err)
+			continue
+		}
+		if e.Pos.Line+1 != tt.line || e.Pos.Char+1 != tt.col {
+			t.Errorf("%d. %q: position mismatch: exp=%d:%d got=%d:%d", i, tt.sql, tt.line, tt.col, e.Pos.Line+1, e.Pos.Char+1)
+		}
+		if e.Suggestion != tt.suggestion {
+			t.Errorf("%d. %q: suggestion mismatch: exp=%q got=%q", i, tt.sql, tt.suggestion, e.Suggestion)
+		}
+		if snippet := e.Snippet(tt.sql); snippet != tt.snippet {
+			t.Errorf("%d. %q: snippet mismatch:\n\nexp=\n%s\n\ngot=\n%s", i, tt.sql, tt.snippet, snippet)
+		}
+	}
+}
+
 func MustParseSelectStatement(s string) *sp.SelectStatement {
 	stmt, err := sp.NewParser(strings.NewReader(s)).ParseStatement()
 	if err != nil {
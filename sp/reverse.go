@@ -0,0 +1,1458 @@
+package sp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DslToSQL converts an elasticsearch query body on index back into an esql
+// select statement. It also returns the parts of the body which can not be
+// represented in sql, as json paths with a reason.
+func DslToSQL(index, dsl string) (string, []string, error) {
+	stmt, unsupported, err := DslToStatement(index, dsl)
+	if err != nil {
+		return "", nil, err
+	}
+	sql := stmt.String()
+	if _, err := ParseStatement(sql); err != nil {
+		unsupported = append(unsupported, fmt.Sprintf("sql: generated statement does not parse, %s", err))
+	}
+	return sql, unsupported, nil
+}
+
+// DslToStatement parses an elasticsearch query body on index into a select statement.
+func DslToStatement(index, dsl string) (*SelectStatement, []string, error) {
+	dec := json.NewDecoder(strings.NewReader(dsl))
+	dec.UseNumber()
+	var body map[string]interface{}
+	if err := dec.Decode(&body); err != nil {
+		return nil, nil, fmt.Errorf("invalid dsl, %s", err)
+	}
+
+	r := &reverser{
+		stmt:    &SelectStatement{Sources: Sources{&Measurement{Database: index}}},
+		metrics: make(map[string]*metricField),
+	}
+	r.body(body)
+	return r.stmt, r.unsupported, nil
+}
+
+// reverser collects the parts of a query body into a select statement.
+type reverser struct {
+	stmt        *SelectStatement
+	unsupported []string
+
+	// exists filters, implied by group by fields
+	exists []string
+
+	// aggregations
+	hasAggs  bool
+	dims     []*Dimension
+	dimNames []string
+	metrics  map[string]*metricField
+	scripts  []*Field
+	having   Expr
+	count    *Field
+	limit    int
+	orders   SortFields
+}
+
+// metricField is a metric aggregation turned into a select field.
+type metricField struct {
+	field *Field
+	// natural is true if the agg name is the generated name of the call.
+	natural bool
+	// used is true if a bucket script reads the metric.
+	used bool
+}
+
+// skip records a part of the body that can not be represented.
+func (r *reverser) skip(path string, format string, a ...interface{}) {
+	r.unsupported = append(r.unsupported, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, a...)))
+}
+
+// hint records v as the argument of the name hint.
+func (r *reverser) hint(path, name string, v interface{}) {
+	arg := fmt.Sprint(v)
+	if _, err := hints[name].value(arg); err != nil {
+		r.skip(path, "invalid %s hint, %s", name, err)
+		return
+	}
+	if old, ok := r.stmt.Hints[name]; ok && old != arg {
+		r.skip(path, "%s differs from the other terms aggregations", name)
+		return
+	}
+	if r.stmt.Hints == nil {
+		r.stmt.Hints = make(Hints)
+	}
+	r.stmt.Hints[name] = arg
+}
+
+func (r *reverser) body(body map[string]interface{}) {
+	for _, k := range sortedKeys(body) {
+		v := body[k]
+		switch k {
+		case "query", "filter", "post_filter":
+			r.stmt.Condition = andExpr(r.stmt.Condition, r.query(k, v))
+		case "aggs", "aggregations":
+			r.hasAggs = true
+			if m, ok := v.(map[string]interface{}); ok {
+				r.aggs(k, m)
+			}
+		case "size":
+			if n, ok := intValue(v); ok {
+				r.stmt.Limit = n
+			}
+		case "from":
+			if n, ok := intValue(v); ok {
+				r.stmt.Offset = n
+			}
+		case "sort":
+			r.sort(k, v)
+		case "_source", "fields", "stored_fields":
+			r.source(k, v)
+		case "timeout", "terminate_after", "track_total_hits":
+			r.hint(k, k, v)
+		default:
+			r.skip(k, "not supported")
+		}
+	}
+
+	if paren, ok := r.stmt.Condition.(*ParenExpr); ok {
+		r.stmt.Condition = paren.Expr
+	}
+	if r.hasAggs {
+		r.finishAggs()
+	} else {
+		if _, ok := body["size"]; !ok {
+			// elasticsearch default size
+			r.stmt.Limit = 10
+		}
+		if r.stmt.Limit == 0 && r.stmt.Offset > 0 {
+			r.skip("from", "offset without size can not be represented")
+			r.stmt.Offset = 0
+		}
+	}
+	for _, f := range r.exists {
+		if !containsString(r.dimFields(), f) {
+			r.skip("query", "exists filter on %s can not be represented", f)
+		}
+	}
+
+	if len(r.stmt.Fields) == 0 {
+		r.stmt.Fields = Fields{{Expr: &Wildcard{}}}
+	}
+	r.stmt.IsRawQuery = true
+	WalkFunc(r.stmt.Fields, func(n Node) {
+		if _, ok := n.(*Call); ok {
+			r.stmt.IsRawQuery = false
+		}
+	})
+}
+
+func (r *reverser) source(path string, v interface{}) {
+	var names []string
+	switch v := v.(type) {
+	case string:
+		names = []string{v}
+	case []interface{}:
+		for _, n := range v {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	default:
+		r.skip(path, "only field lists are supported")
+		return
+	}
+	for _, n := range names {
+		if n == "*" || n == "_source" {
+			continue
+		}
+		r.stmt.Fields = append(r.stmt.Fields, &Field{Expr: varRef(n)})
+	}
+}
+
+func (r *reverser) sort(path string, v interface{}) {
+	var items []interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		items = v
+	default:
+		items = []interface{}{v}
+	}
+	for i, item := range items {
+		p := fmt.Sprintf("%s.%d", path, i)
+		switch item := item.(type) {
+		case string:
+			// elasticsearch sorts _score descending, fields ascending.
+			r.stmt.SortFields = append(r.stmt.SortFields, &SortField{Name: item, Ascending: item != "_score"})
+		case map[string]interface{}:
+			for _, name := range sortedKeys(item) {
+				switch o := item[name].(type) {
+				case string:
+					r.stmt.SortFields = append(r.stmt.SortFields, &SortField{Name: name, Ascending: o != "desc"})
+				case map[string]interface{}:
+					order, _ := o["order"].(string)
+					r.stmt.SortFields = append(r.stmt.SortFields, &SortField{Name: name, Ascending: order != "desc"})
+					for _, k := range sortedKeys(o) {
+						if k != "order" {
+							r.skip(p+"."+name+"."+k, "not supported")
+						}
+					}
+				default:
+					r.skip(p+"."+name, "invalid sort")
+				}
+			}
+		default:
+			r.skip(p, "invalid sort")
+		}
+	}
+}
+
+// query converts a query clause into a condition, nil if it matches everything.
+func (r *reverser) query(path string, v interface{}) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid query")
+		return nil
+	}
+	var cond Expr
+	for _, k := range sortedKeys(m) {
+		p := path + "." + k
+		body := m[k]
+		var expr Expr
+		switch k {
+		case "match_all":
+		case "bool":
+			expr = r.boolQuery(p, body)
+		case "and":
+			expr = conjunction(r.queries(p, body))
+		case "or":
+			expr = disjunction(r.queries(p, body))
+		case "not":
+			if q, ok := body.(map[string]interface{}); ok {
+				if f, ok := q["filter"]; ok {
+					body = f
+				} else if f, ok := q["query"]; ok {
+					body = f
+				}
+			}
+			expr = r.negate(p, r.query(p, body))
+		case "filtered":
+			if q, ok := body.(map[string]interface{}); ok {
+				for _, c := range []string{"query", "filter"} {
+					if sub, ok := q[c]; ok {
+						expr = andExpr(expr, r.query(p+"."+c, sub))
+					}
+				}
+			}
+		case "constant_score":
+			if q, ok := body.(map[string]interface{}); ok {
+				expr = r.query(p+".filter", q["filter"])
+			}
+		case "match", "match_phrase":
+			// analyzed full text queries are not term equalities
+			r.skip(p, "full text queries are not supported")
+		case "term":
+			expr = r.fieldQuery(p, body, func(f string, v interface{}) Expr {
+				lit := r.literal(p+"."+f, v, "value", "query")
+				if lit == nil {
+					return nil
+				}
+				return &BinaryExpr{Op: EQ, LHS: varRef(f), RHS: lit}
+			})
+		case "terms":
+			expr = r.termsQuery(p, body)
+		case "range":
+			expr = r.fieldQuery(p, body, func(f string, v interface{}) Expr {
+				return r.rangeQuery(p+"."+f, f, v)
+			})
+		case "prefix", "wildcard", "regexp":
+			expr = r.fieldQuery(p, body, func(f string, v interface{}) Expr {
+				return r.regexQuery(p+"."+f, k, f, v)
+			})
+		case "exists":
+			if q, ok := body.(map[string]interface{}); ok {
+				if f, ok := q["field"].(string); ok {
+					r.exists = append(r.exists, f)
+					continue
+				}
+			}
+			r.skip(p, "invalid exists")
+		case "script":
+			expr = r.scriptQuery(p, body)
+		case "query_string":
+			expr = r.queryString(p, body)
+		case "nested":
+			expr = r.nestedQuery(p, body)
+		case "geo_distance", "geo_bounding_box", "geo_polygon":
+			expr = r.geoQuery(p, k, body)
+		default:
+			r.skip(p, "not supported")
+		}
+		cond = andExpr(cond, expr)
+	}
+	return cond
+}
+
+// queries converts a query or a list of queries.
+func (r *reverser) queries(path string, v interface{}) []Expr {
+	var exprs []Expr
+	switch v := v.(type) {
+	case nil:
+	case []interface{}:
+		for i, q := range v {
+			if expr := r.query(fmt.Sprintf("%s.%d", path, i), q); expr != nil {
+				exprs = append(exprs, expr)
+			}
+		}
+	case map[string]interface{}:
+		if filters, ok := v["filters"]; ok {
+			return r.queries(path+".filters", filters)
+		}
+		if expr := r.query(path, v); expr != nil {
+			exprs = append(exprs, expr)
+		}
+	default:
+		r.skip(path, "invalid query")
+	}
+	return exprs
+}
+
+func (r *reverser) boolQuery(path string, v interface{}) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid bool query")
+		return nil
+	}
+	var parts []Expr
+	parts = append(parts, r.queries(path+".must", m["must"])...)
+	parts = append(parts, r.queries(path+".filter", m["filter"])...)
+	for _, q := range r.queries(path+".must_not", m["must_not"]) {
+		if expr := r.negate(path+".must_not", q); expr != nil {
+			parts = append(parts, expr)
+		}
+	}
+	// the clauses written decide, not the ones converted: should clauses of a
+	// bool whose must clause was dropped still only score documents.
+	scoring := hasClauses(m["must"]) || hasClauses(m["filter"]) || hasClauses(m["must_not"])
+	if should := r.queries(path+".should", m["should"]); len(should) > 0 {
+		if _, ok := m["minimum_should_match"]; scoring && !ok {
+			// should clauses only score documents when there are other clauses.
+			r.skip(path+".should", "scoring clauses can not be represented")
+		} else {
+			parts = append(parts, disjunction(should))
+		}
+	}
+	for _, k := range sortedKeys(m) {
+		switch k {
+		case "must", "filter", "must_not", "should":
+		case "minimum_should_match":
+			if n, ok := intValue(m[k]); !ok || n != 1 {
+				r.skip(path+"."+k, "only 1 is supported")
+			}
+		default:
+			r.skip(path+"."+k, "not supported")
+		}
+	}
+	return conjunction(parts)
+}
+
+// hasClauses reports whether v holds at least one query of a bool clause.
+func hasClauses(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// fieldQuery converts the single field query {field: value}.
+func (r *reverser) fieldQuery(path string, v interface{}, fn func(string, interface{}) Expr) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid query")
+		return nil
+	}
+	var parts []Expr
+	var fields int
+	for _, f := range sortedKeys(m) {
+		if f == "boost" || f == "_name" {
+			continue
+		}
+		fields++
+		if expr := fn(f, m[f]); expr != nil {
+			parts = append(parts, expr)
+		}
+	}
+	if fields == 0 {
+		r.skip(path, "missing field")
+	}
+	return conjunction(parts)
+}
+
+func (r *reverser) termsQuery(path string, v interface{}) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid terms query")
+		return nil
+	}
+	var parts []Expr
+	for _, f := range sortedKeys(m) {
+		if f == "boost" || f == "_name" {
+			continue
+		}
+		vals, ok := m[f].([]interface{})
+		if !ok {
+			r.skip(path+"."+f, "terms lookup is not supported")
+			continue
+		}
+		list := &ListLiteral{}
+		for _, v := range vals {
+			switch lit := r.literal(path+"."+f, v).(type) {
+			case *StringLiteral:
+				list.Vals = append(list.Vals, lit.Val)
+			case *IntegerLiteral:
+				list.Vals = append(list.Vals, lit.Val)
+			case *NumberLiteral:
+				list.Vals = append(list.Vals, lit.Val)
+			}
+		}
+		parts = append(parts, &BinaryExpr{Op: IN, LHS: varRef(f), RHS: list})
+	}
+	return conjunction(parts)
+}
+
+func (r *reverser) rangeQuery(path, field string, v interface{}) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid range")
+		return nil
+	}
+	ops := map[string]Token{"gt": GT, "gte": GTE, "lt": LT, "lte": LTE}
+	// legacy from/to form, inclusive by default
+	if from, ok := m["from"]; ok && from != nil {
+		if b, ok := m["include_lower"].(bool); ok && !b {
+			m["gt"] = from
+		} else {
+			m["gte"] = from
+		}
+	}
+	if to, ok := m["to"]; ok && to != nil {
+		if b, ok := m["include_upper"].(bool); ok && !b {
+			m["lt"] = to
+		} else {
+			m["lte"] = to
+		}
+	}
+	var parts []Expr
+	for _, k := range sortedKeys(m) {
+		if op, ok := ops[k]; ok {
+			if lit := r.literal(path+"."+k, m[k]); lit != nil {
+				parts = append(parts, &BinaryExpr{Op: op, LHS: varRef(field), RHS: lit})
+			}
+			continue
+		}
+		switch k {
+		case "from", "to", "include_lower", "include_upper", "boost":
+		default:
+			r.skip(path+"."+k, "not supported")
+		}
+	}
+	return conjunction(parts)
+}
+
+func (r *reverser) regexQuery(path, typ, field string, v interface{}) Expr {
+	if m, ok := v.(map[string]interface{}); ok {
+		for _, k := range []string{"value", typ} {
+			if s, ok := m[k]; ok {
+				v = s
+			}
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		r.skip(path, "invalid %s", typ)
+		return nil
+	}
+	var pattern string
+	switch typ {
+	case "prefix":
+		pattern = "^" + regexp.QuoteMeta(s)
+	case "wildcard":
+		pattern = "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(s)) + "$"
+	default:
+		// lucene regular expressions are anchored
+		pattern = "^" + s + "$"
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		r.skip(path, "invalid regex %s", err)
+		return nil
+	}
+	return &BinaryExpr{Op: EQREGEX, LHS: varRef(field), RHS: &RegexLiteral{Val: re}}
+}
+
+func (r *reverser) scriptQuery(path string, v interface{}) Expr {
+	if m, ok := v.(map[string]interface{}); ok {
+		v = m["script"]
+	}
+	src, ok := r.scriptSource(path, v)
+	if !ok {
+		return nil
+	}
+	return r.script(path, src)
+}
+
+// scriptSource returns the source of the script v with its params replaced
+// by their literals, failures are skipped.
+func (r *reverser) scriptSource(path string, v interface{}) (string, bool) {
+	src, ok := scriptSource(v)
+	if !ok {
+		r.skip(path, "invalid script")
+		return "", false
+	}
+	if m, ok := v.(map[string]interface{}); ok && m["params"] != nil {
+		params, _ := m["params"].(map[string]interface{})
+		var err error
+		if src, err = inlineScriptParams(src, params); err != nil {
+			r.skip(path+".params", "%s", err)
+			return "", false
+		}
+	}
+	return src, true
+}
+
+// scriptParamRef matches the params read by a script.
+var scriptParamRef = regexp.MustCompile(`params\.(\w+)|params\['(\w+)'\]`)
+
+// scriptParamName returns the name of the param read by ref.
+func scriptParamName(ref string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(ref, "params."), "params['"), "']")
+}
+
+// inlineScriptParams replaces the params read by a script with their literals.
+func inlineScriptParams(src string, params map[string]interface{}) (string, error) {
+	var err error
+	src = scriptParamRef.ReplaceAllStringFunc(src, func(ref string) string {
+		name := scriptParamName(ref)
+		v, ok := params[name]
+		if !ok {
+			err = fmt.Errorf("script param %s is not set", name)
+			return ref
+		}
+		lit, e := bindLiteral(v)
+		if e != nil {
+			err = fmt.Errorf("script param %s: %s", name, e)
+			return ref
+		}
+		if n, ok := lit.(*NumberLiteral); ok {
+			return strconv.FormatFloat(n.Val, 'f', -1, 64)
+		}
+		return lit.String()
+	})
+	return src, err
+}
+
+// script parses a groovy/expression script into an expression.
+func (r *reverser) script(path, src string) Expr {
+	expr, err := NewParser(strings.NewReader(scriptToSQL(src))).ParseExpr()
+	if err != nil {
+		r.skip(path, "can not parse script %q, %s", src, err)
+		return nil
+	}
+	return expr
+}
+
+// queryString converts a query_string made of field:value terms.
+func (r *reverser) queryString(path string, v interface{}) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid query_string")
+		return nil
+	}
+	q, _ := m["query"].(string)
+	var buf bytes.Buffer
+	var prev string
+	for _, term := range queryStringTerms(q) {
+		switch strings.ToUpper(term) {
+		case "AND", "&&", "OR", "||":
+			if prev == "" || prev == "op" {
+				r.skip(path, "can not represent query %q", q)
+				return nil
+			}
+			if term == "&&" || strings.ToUpper(term) == "AND" {
+				buf.WriteString(" AND ")
+			} else {
+				buf.WriteString(" OR ")
+			}
+			prev = "op"
+			continue
+		}
+		i := strings.Index(term, ":")
+		if i <= 0 || i == len(term)-1 {
+			r.skip(path, "can not represent query %q", q)
+			return nil
+		}
+		if prev == "term" {
+			// the default operator of query_string is OR
+			buf.WriteString(" OR ")
+		}
+		field, value := term[:i], term[i+1:]
+		if strings.HasPrefix(value, `"`) {
+			value = QuoteString(strings.Trim(value, `"`))
+		} else if _, err := strconv.ParseFloat(value, 64); err != nil {
+			value = QuoteString(value)
+		}
+		fmt.Fprintf(&buf, "%s = %s", field, value)
+		prev = "term"
+	}
+	for _, k := range sortedKeys(m) {
+		if k != "query" && k != "analyze_wildcard" {
+			r.skip(path+"."+k, "not supported")
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	expr, err := NewParser(strings.NewReader(buf.String())).ParseExpr()
+	if err != nil {
+		r.skip(path, "can not represent query %q", q)
+		return nil
+	}
+	return expr
+}
+
+// queryStringTerms splits a query_string on whitespace outside of quotes.
+func queryStringTerms(q string) []string {
+	var terms []string
+	var buf bytes.Buffer
+	var quoted bool
+	for _, ch := range q {
+		switch {
+		case ch == '"':
+			quoted = !quoted
+			buf.WriteRune(ch)
+		case isWhitespace(ch) && !quoted:
+			if buf.Len() > 0 {
+				terms = append(terms, buf.String())
+				buf.Reset()
+			}
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+	if buf.Len() > 0 {
+		terms = append(terms, buf.String())
+	}
+	return terms
+}
+
+func (r *reverser) nestedQuery(path string, v interface{}) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid nested query")
+		return nil
+	}
+	p, _ := m["path"].(string)
+	if p == "" {
+		r.skip(path, "nested query without path")
+		return nil
+	}
+	cond := r.query(path+".query", m["query"])
+	if cond == nil {
+		return nil
+	}
+	return &Call{Name: "nested", Args: []Expr{varRef(p), cond}}
+}
+
+func (r *reverser) geoQuery(path, typ string, v interface{}) Expr {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		r.skip(path, "invalid %s", typ)
+		return nil
+	}
+	for _, f := range sortedKeys(m) {
+		switch f {
+		case "distance", "distance_type", "validation_method", "_name", "type":
+			continue
+		}
+		p := path + "." + f
+		args := []Expr{varRef(f)}
+		switch typ {
+		case "geo_distance":
+			lat, lon, ok := geoLatLon(m[f])
+			if !ok {
+				r.skip(p, "invalid point")
+				return nil
+			}
+			distance, ok := m["distance"].(string)
+			if !ok {
+				r.skip(path+".distance", "invalid distance")
+				return nil
+			}
+			args = append(args, lat, lon, &StringLiteral{Val: distance})
+		case "geo_bounding_box":
+			box, _ := m[f].(map[string]interface{})
+			tlat, tlon, ok1 := geoLatLon(box["top_left"])
+			blat, blon, ok2 := geoLatLon(box["bottom_right"])
+			if !ok1 || !ok2 {
+				r.skip(p, "only top_left and bottom_right are supported")
+				return nil
+			}
+			args = append(args, tlat, tlon, blat, blon)
+			typ = "geo_bbox"
+		case "geo_polygon":
+			poly, _ := m[f].(map[string]interface{})
+			points, _ := poly["points"].([]interface{})
+			list := &ListLiteral{}
+			for _, pt := range points {
+				if s, ok := pt.(string); ok {
+					list.Vals = append(list.Vals, s)
+					continue
+				}
+				lat, lon, ok := geoLatLon(pt)
+				if !ok {
+					r.skip(p, "invalid point")
+					return nil
+				}
+				list.Vals = append(list.Vals, lat.(*NumberLiteral).Val, lon.(*NumberLiteral).Val)
+			}
+			args = append(args, list)
+		}
+		return &Call{Name: typ, Args: args}
+	}
+	r.skip(path, "missing geo field")
+	return nil
+}
+
+// geoLatLon returns the latitude and longitude of a geo point in its
+// object, array and string formats.
+func geoLatLon(v interface{}) (Expr, Expr, bool) {
+	var lat, lon float64
+	var err1, err2 error
+	switch v := v.(type) {
+	case map[string]interface{}:
+		lat, err1 = floatValue(v["lat"])
+		lon, err2 = floatValue(v["lon"])
+	case []interface{}:
+		if len(v) != 2 {
+			return nil, nil, false
+		}
+		// geojson order
+		lon, err1 = floatValue(v[0])
+		lat, err2 = floatValue(v[1])
+	case string:
+		parts := strings.Split(v, ",")
+		if len(parts) != 2 {
+			return nil, nil, false
+		}
+		lat, err1 = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lon, err2 = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	default:
+		return nil, nil, false
+	}
+	if err1 != nil || err2 != nil {
+		return nil, nil, false
+	}
+	return &NumberLiteral{Val: lat}, &NumberLiteral{Val: lon}, true
+}
+
+// negate returns the negation of a condition.
+func (r *reverser) negate(path string, expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	if neg := negateExpr(expr); neg != nil {
+		return neg
+	}
+	r.skip(path, "can not negate %s", expr.String())
+	return nil
+}
+
+func negateExpr(expr Expr) Expr {
+	switch e := expr.(type) {
+	case *ParenExpr:
+		if neg := negateExpr(e.Expr); neg != nil {
+			return &ParenExpr{Expr: neg}
+		}
+	case *BinaryExpr:
+		inverse := map[Token]Token{
+			EQ: NEQ, NEQ: EQ, LT: GTE, GTE: LT, GT: LTE, LTE: GT,
+			EQREGEX: NEQREGEX, NEQREGEX: EQREGEX, IN: NI, NI: IN,
+		}
+		if op, ok := inverse[e.Op]; ok {
+			return &BinaryExpr{Op: op, LHS: e.LHS, RHS: e.RHS}
+		}
+		lhs, rhs := negateExpr(e.LHS), negateExpr(e.RHS)
+		if lhs == nil || rhs == nil {
+			return nil
+		}
+		switch e.Op {
+		case AND:
+			return &ParenExpr{Expr: &BinaryExpr{Op: OR, LHS: lhs, RHS: rhs}}
+		case OR:
+			return conjunction([]Expr{lhs, rhs})
+		}
+	}
+	return nil
+}
+
+// aggs walks one level of aggregations.
+func (r *reverser) aggs(path string, m map[string]interface{}) {
+	var bucket bool
+	var pipelines []string
+	for _, name := range sortedKeys(m) {
+		p := path + "." + name
+		body, ok := m[name].(map[string]interface{})
+		if !ok {
+			r.skip(p, "invalid aggregation")
+			continue
+		}
+		typ, params := aggType(body)
+		sub, _ := body["aggs"].(map[string]interface{})
+		if sub == nil {
+			sub, _ = body["aggregations"].(map[string]interface{})
+		}
+
+		switch {
+		case typ == "nested" || typ == "reverse_nested":
+			// a metric wrapped to reach a nested path has the metric name
+			if inner, ok := sub[name].(map[string]interface{}); ok && len(sub) == 1 {
+				r.aggs(p+".aggs", map[string]interface{}{name: inner})
+				continue
+			}
+			if np, _ := params["path"].(string); typ == "nested" && DefaultSchema.NestedPath(np+".") != np {
+				r.skip(p, "nested path %s has to be declared in the schema", np)
+			}
+			r.aggs(p+".aggs", sub)
+		case typ == "terms" || typ == "histogram" || typ == "date_histogram" || typ == "range":
+			if bucket {
+				r.skip(p, "only one bucket aggregation per level is supported")
+				continue
+			}
+			bucket = true
+			r.bucket(p, name, typ, params)
+			r.aggs(p+".aggs", sub)
+		case typ == "bucket_script" || typ == "bucket_selector":
+			// pipelines read the metrics of their level
+			pipelines = append(pipelines, name)
+		case metricType(typ) != IllegalAgg:
+			r.metric(p, name, typ, params)
+		default:
+			r.skip(p, "aggregation %s is not supported", typ)
+		}
+	}
+	for _, name := range pipelines {
+		typ, params := aggType(m[name].(map[string]interface{}))
+		if typ == "bucket_script" {
+			r.bucketScript(path+"."+name, name, params)
+		} else {
+			r.bucketSelector(path+"."+name, params)
+		}
+	}
+}
+
+// aggType returns the type and the params of an aggregation body.
+func aggType(body map[string]interface{}) (string, map[string]interface{}) {
+	for _, k := range sortedKeys(body) {
+		switch k {
+		case "aggs", "aggregations", "meta":
+			continue
+		}
+		params, _ := body[k].(map[string]interface{})
+		if params == nil {
+			params = make(map[string]interface{})
+		}
+		return k, params
+	}
+	return "", nil
+}
+
+// metricType returns the metric aggregation for an elasticsearch agg name.
+func metricType(name string) ESAgg {
+	for i := metricBegin + 1; i < metricEnd; i++ {
+		if aggs[i] == name {
+			return i
+		}
+	}
+	return IllegalAgg
+}
+
+// aggField returns the field or script expression of agg params.
+func (r *reverser) aggField(path string, params map[string]interface{}) Expr {
+	if f, ok := params["field"].(string); ok {
+		return varRef(f)
+	}
+	if _, ok := params["script"]; !ok {
+		r.skip(path, "missing field or script")
+		return nil
+	}
+	if s, ok := r.scriptSource(path+".script", params["script"]); ok {
+		return r.script(path+".script", s)
+	}
+	return nil
+}
+
+func (r *reverser) bucket(path, name, typ string, params map[string]interface{}) {
+	expr := r.aggField(path+"."+typ, params)
+	if expr == nil {
+		return
+	}
+	known := map[string]bool{"field": true, "script": true}
+
+	switch typ {
+	case "terms":
+		known["size"], known["order"] = true, true
+		if n, ok := intValue(params["size"]); ok && n > 0 && r.limit == 0 {
+			r.limit = n
+		}
+		for _, h := range []string{"shard_size", "execution_hint"} {
+			if v, ok := params[h]; ok {
+				known[h] = true
+				r.hint(path+".terms."+h, h, v)
+			}
+		}
+		r.termsOrder(path+".terms.order", name, params["order"])
+	case "histogram":
+		known["interval"], known["min_doc_count"] = true, true
+		interval := r.literal(path+".histogram.interval", params["interval"])
+		if s, ok := interval.(*StringLiteral); ok {
+			if n, err := strconv.ParseInt(s.Val, 10, 64); err == nil {
+				interval = &IntegerLiteral{Val: n}
+			}
+		}
+		if interval == nil {
+			return
+		}
+		expr = &Call{Name: "histogram", Args: []Expr{expr, interval}}
+	case "date_histogram":
+		known["interval"] = true
+		ref, ok := expr.(*VarRef)
+		interval, _ := params["interval"].(string)
+		if !ok || interval == "" {
+			r.skip(path, "only field and interval are supported")
+			return
+		}
+		expr = &Call{Name: "date_histogram", Args: []Expr{&StringLiteral{Val: ref.Val}, &StringLiteral{Val: interval}}}
+	case "range":
+		known["ranges"], known["keyed"] = true, true
+		args, ok := rangeBounds(params["ranges"])
+		if !ok {
+			r.skip(path+".range.ranges", "only contiguous ranges are supported")
+			return
+		}
+		expr = &Call{Name: "range", Args: append([]Expr{expr}, args...)}
+	}
+	for _, k := range sortedKeys(params) {
+		if !known[k] {
+			r.skip(path+"."+typ+"."+k, "not supported")
+		}
+	}
+	if typ == "histogram" {
+		if n, ok := intValue(params["min_doc_count"]); ok && n != 0 {
+			r.skip(path+".histogram.min_doc_count", "only 0 is supported")
+		}
+	}
+
+	dim := &Dimension{Expr: expr}
+	natural := cleanDocString(expr.String())
+	if name != natural {
+		dim.Alias = name
+	}
+	r.dims = append(r.dims, dim)
+	r.dimNames = append(r.dimNames, name)
+}
+
+// rangeBounds returns the boundaries of contiguous ranges.
+func rangeBounds(v interface{}) ([]Expr, bool) {
+	ranges, ok := v.([]interface{})
+	if !ok || len(ranges) < 2 {
+		return nil, false
+	}
+	var bounds []interface{}
+	for i, rg := range ranges {
+		m, ok := rg.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		from, hasFrom := m["from"]
+		to, hasTo := m["to"]
+		switch {
+		case i == 0 && !hasFrom && hasTo:
+		case i == len(ranges)-1 && hasFrom && !hasTo:
+		case hasFrom && hasTo:
+		default:
+			return nil, false
+		}
+		if i > 0 && fmt.Sprint(from) != fmt.Sprint(bounds[len(bounds)-1]) {
+			return nil, false
+		}
+		if hasTo {
+			bounds = append(bounds, to)
+		}
+	}
+	var args []Expr
+	for _, b := range bounds {
+		f, err := floatValue(b)
+		if err != nil {
+			return nil, false
+		}
+		if f == float64(int64(f)) {
+			args = append(args, &IntegerLiteral{Val: int64(f)})
+		} else {
+			args = append(args, &NumberLiteral{Val: f})
+		}
+	}
+	return args, true
+}
+
+func (r *reverser) termsOrder(path, name string, v interface{}) {
+	if v == nil {
+		return
+	}
+	var items []interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		items = v
+	default:
+		items = []interface{}{v}
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			r.skip(path, "invalid order")
+			continue
+		}
+		for _, k := range sortedKeys(m) {
+			dir, _ := m[k].(string)
+			sf := &SortField{Name: k, Ascending: dir != "desc"}
+			switch k {
+			case "_term", "_key":
+				sf.Name = name
+			case "_count":
+				sf.Name = r.countAlias()
+			}
+			if !r.hasOrder(sf.Name) {
+				r.orders = append(r.orders, sf)
+			}
+		}
+	}
+}
+
+func (r *reverser) hasOrder(name string) bool {
+	for _, sf := range r.orders {
+		if sf.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reverser) metric(path, name, typ string, params map[string]interface{}) {
+	arg := r.aggField(path+"."+typ, params)
+	if arg == nil {
+		return
+	}
+	fn := &Call{Name: typ, Args: []Expr{arg}}
+	if typ == "value_count" {
+		fn.Name = "count"
+	}
+	for _, k := range sortedKeys(params) {
+		switch {
+		case k == "field" || k == "script":
+		case k == "wrap_longitude" && typ == "geo_bounds":
+			if b, ok := params[k].(bool); ok {
+				fn.Args = append(fn.Args, &BooleanLiteral{Val: b})
+			}
+		default:
+			r.skip(path+"."+typ+"."+k, "not supported")
+		}
+	}
+	f := &Field{Expr: fn}
+	natural := fmt.Sprintf("%s(%s)", fn.Name, fn.Args[0].String())
+	if name != natural {
+		f.Alias = name
+	}
+	r.metrics[name] = &metricField{field: f, natural: name == natural}
+}
+
+func (r *reverser) bucketScript(path, name string, params map[string]interface{}) {
+	paths, _ := params["buckets_path"].(map[string]interface{})
+	src, ok := r.pipelineSource(path+".script", params["script"], paths)
+	if !ok {
+		return
+	}
+	lits := r.expressionParams(path+".script", params["script"])
+	expr := r.script(path+".script", src)
+	if expr == nil {
+		return
+	}
+	var unknown bool
+	expr = replaceRefs(expr, func(ref *VarRef) Expr {
+		target, ok := paths[ref.Val].(string)
+		if !ok {
+			if lit, ok := lits[ref.Val]; ok {
+				return lit
+			}
+			r.skip(path+".script", "%s is not a buckets_path variable", ref.Val)
+			unknown = true
+			return ref
+		}
+		if target == "_count" {
+			r.countAlias()
+			return &Call{Name: "count", Args: []Expr{&Wildcard{}}}
+		}
+		target = metricPathName(target)
+		m, ok := r.metrics[target]
+		if !ok {
+			r.skip(path+".buckets_path."+ref.Val, "unknown metric %s", target)
+			return ref
+		}
+		m.used = true
+		if m.field.Alias != "" {
+			return varRef(m.field.Alias)
+		}
+		return m.field.Expr
+	})
+	if unknown {
+		return
+	}
+	r.scripts = append(r.scripts, &Field{Expr: expr, Alias: name})
+}
+
+func (r *reverser) bucketSelector(path string, params map[string]interface{}) {
+	paths, _ := params["buckets_path"].(map[string]interface{})
+	src, ok := r.pipelineSource(path+".script", params["script"], paths)
+	if !ok {
+		return
+	}
+	lits := r.expressionParams(path+".script", params["script"])
+	expr := r.script(path+".script", src)
+	if expr == nil {
+		return
+	}
+	var unknown bool
+	having := replaceRefs(expr, func(ref *VarRef) Expr {
+		target, ok := paths[ref.Val].(string)
+		if !ok {
+			if lit, ok := lits[ref.Val]; ok {
+				return lit
+			}
+			r.skip(path+".script", "%s is not a buckets_path variable", ref.Val)
+			unknown = true
+			return ref
+		}
+		if target == "_count" {
+			if r.count == nil || r.count.Alias == "" {
+				r.count = &Field{Expr: &Call{Name: "count", Args: []Expr{&Wildcard{}}}, Alias: ref.Val}
+			}
+			return varRef(r.count.Alias)
+		}
+		target = metricPathName(target)
+		m, ok := r.metrics[target]
+		if !ok {
+			r.skip(path+".buckets_path."+ref.Val, "unknown metric %s", target)
+			return ref
+		}
+		if m.field.Alias == "" {
+			m.field.Alias = ref.Val
+		}
+		return varRef(m.field.Alias)
+	})
+	if !unknown {
+		r.having = having
+	}
+}
+
+// pipelineSource returns the source of the script of a pipeline aggregation,
+// its params.<var> reads of buckets_path variables are read by name as in
+// lucene expressions.
+func (r *reverser) pipelineSource(path string, v interface{}, paths map[string]interface{}) (string, bool) {
+	src, ok := scriptSource(v)
+	if !ok {
+		r.skip(path, "invalid script")
+		return "", false
+	}
+	src = scriptParamRef.ReplaceAllStringFunc(src, func(ref string) string {
+		if name := scriptParamName(ref); paths[name] != nil {
+			return name
+		}
+		return ref
+	})
+	script := map[string]interface{}{"inline": src}
+	if m, ok := v.(map[string]interface{}); ok && m["params"] != nil {
+		script["params"] = m["params"]
+	}
+	return r.scriptSource(path, script)
+}
+
+// expressionParams returns the literals of the params of a lucene
+// expression script, which refers to them by name.
+func (r *reverser) expressionParams(path string, v interface{}) map[string]Expr {
+	m, _ := v.(map[string]interface{})
+	params, _ := m["params"].(map[string]interface{})
+	lits := make(map[string]Expr, len(params))
+	for _, name := range sortedKeys(params) {
+		lit, err := bindLiteral(params[name])
+		if err != nil {
+			r.skip(path+".params."+name, "%s", err)
+			continue
+		}
+		lits[name] = lit
+	}
+	return lits
+}
+
+// countAlias returns the alias of the count(*) field, adding it if needed.
+func (r *reverser) countAlias() string {
+	if r.count == nil {
+		r.count = &Field{Expr: &Call{Name: "count", Args: []Expr{&Wildcard{}}}}
+	}
+	if r.count.Alias == "" {
+		r.count.Alias = "doc_count"
+	}
+	return r.count.Alias
+}
+
+// dimFields returns the fields referenced by group by dimensions.
+func (r *reverser) dimFields() []string {
+	var names []string
+	for _, d := range r.dims {
+		for _, n := range walkNames(d.Expr) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+func (r *reverser) finishAggs() {
+	if r.stmt.Limit > 0 && len(r.dims) > 0 {
+		r.skip("size", "hits can not be returned together with group by")
+	}
+	r.stmt.Limit, r.stmt.Offset = r.limit, 0
+	if len(r.stmt.SortFields) > 0 {
+		r.skip("sort", "hits can not be sorted together with aggregations")
+	}
+	r.stmt.SortFields = r.orders
+	r.stmt.Dimensions = r.dims
+	r.stmt.Having = r.having
+
+	var fields Fields
+	for _, name := range r.dimNames {
+		fields = append(fields, &Field{Expr: varRef(name)})
+	}
+	var metrics []*Field
+	for _, name := range sortedMetricNames(r.metrics) {
+		m := r.metrics[name]
+		// bucket scripts generate the metrics they read
+		if m.used && m.natural && m.field.Alias == "" {
+			continue
+		}
+		metrics = append(metrics, m.field)
+	}
+	if r.count == nil && len(metrics) == 0 && len(r.scripts) == 0 {
+		// doc_count is always there
+		r.count = &Field{Expr: &Call{Name: "count", Args: []Expr{&Wildcard{}}}}
+	}
+	if r.count != nil {
+		fields = append(fields, r.count)
+	}
+	fields = append(fields, metrics...)
+	fields = append(fields, r.scripts...)
+	r.stmt.Fields = fields
+}
+
+func sortedMetricNames(m map[string]*metricField) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// metricPathName returns the metric name of a buckets_path, e.g. a>a is a.
+func metricPathName(path string) string {
+	parts := strings.Split(path, ">")
+	return parts[len(parts)-1]
+}
+
+// replaceRefs returns expr with its variable references replaced by fn.
+func replaceRefs(expr Expr, fn func(*VarRef) Expr) Expr {
+	switch e := expr.(type) {
+	case *VarRef:
+		return fn(e)
+	case *BinaryExpr:
+		return &BinaryExpr{Op: e.Op, LHS: replaceRefs(e.LHS, fn), RHS: replaceRefs(e.RHS, fn)}
+	case *ParenExpr:
+		return &ParenExpr{Expr: replaceRefs(e.Expr, fn)}
+	case *Call:
+		c := &Call{Name: e.Name, Options: e.Options}
+		for _, arg := range e.Args {
+			c.Args = append(c.Args, replaceRefs(arg, fn))
+		}
+		return c
+	}
+	return expr
+}
+
+// scriptSource returns the source of a script in its string and object formats.
+func scriptSource(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case map[string]interface{}:
+		for _, k := range []string{"inline", "source", "script"} {
+			if s, ok := v[k].(string); ok {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// scriptToSQL converts a groovy or lucene expression script into esql syntax.
+func scriptToSQL(src string) string {
+	src = regexp.MustCompile(`doc\["(.+?)"\]\.value`).ReplaceAllString(src, "doc['${1}'].value")
+	src = cleanDocString(src)
+
+	var buf bytes.Buffer
+	var quote rune
+	rs := []rune(src)
+	for i := 0; i < len(rs); i++ {
+		ch := rs[i]
+		var next rune
+		if i+1 < len(rs) {
+			next = rs[i+1]
+		}
+		if quote != 0 {
+			buf.WriteRune(ch)
+			if ch == '\\' && next != 0 {
+				buf.WriteRune(next)
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case ch == '\'' || ch == '"':
+			quote = ch
+			buf.WriteRune(ch)
+		case ch == '&' && next == '&':
+			buf.WriteString("AND")
+			i++
+		case ch == '|' && next == '|':
+			buf.WriteString("OR")
+			i++
+		case ch == '=' && next == '=':
+			buf.WriteRune('=')
+			i++
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+	return buf.String()
+}
+
+// literal converts a json value into a literal, for objects the first of keys is used.
+func (r *reverser) literal(path string, v interface{}, keys ...string) Expr {
+	if m, ok := v.(map[string]interface{}); ok {
+		for _, k := range keys {
+			if val, ok := m[k]; ok {
+				return r.literal(path, val)
+			}
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return &StringLiteral{Val: v}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return &IntegerLiteral{Val: n}
+		}
+		if f, err := v.Float64(); err == nil {
+			return &NumberLiteral{Val: f}
+		}
+	case bool:
+		return &BooleanLiteral{Val: v}
+	}
+	r.skip(path, "invalid value %v", v)
+	return nil
+}
+
+func varRef(name string) *VarRef {
+	return &VarRef{Val: name, Segments: strings.Split(name, ".")}
+}
+
+func andExpr(lhs, rhs Expr) Expr {
+	var exprs []Expr
+	for _, e := range []Expr{lhs, rhs} {
+		if e != nil {
+			exprs = append(exprs, e)
+		}
+	}
+	return conjunction(exprs)
+}
+
+// disjunction joins exprs with OR.
+func disjunction(exprs []Expr) Expr {
+	var cond Expr
+	for _, expr := range exprs {
+		if cond == nil {
+			cond = expr
+			continue
+		}
+		cond = &BinaryExpr{Op: OR, LHS: cond, RHS: expr}
+	}
+	return cond
+}
+
+func intValue(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func floatValue(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
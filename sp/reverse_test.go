@@ -0,0 +1,186 @@
+package sp_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/chenyoufu/esql/sp"
+)
+
+// Ensure the dsl generated for a statement converts back into an equivalent statement.
+func TestReverse_RoundTrip(t *testing.T) {
+	useSchema(t)
+	sp.DefaultSchema.AddNested("comments")
+
+	var tests = []string{
+		`select * from symbol limit 5`,
+		`select * from symbol order by name desc limit 1`,
+		`select * from symbol where exchange='nyse' and sector='Technology' limit 3`,
+		`select * from symbol where exchange='nyse' OR sector!='Technology' limit 1`,
+		`select * from quote where @timestamp > 1482908284586 limit 1`,
+		`select count(*) from quote`,
+		`select count(ipo_year) AS xx from symbol`,
+		`select sum(market_cap) from symbol where ipo_year=1998`,
+		`select exchange, count(*) from symbol group by exchange`,
+		`SELECT shares_count, COUNT(*) FROM symbol GROUP BY floor(market_cap / last_sale / 1000000) AS shares_count ORDER BY shares_count LIMIT 3`,
+		`select ipo_year_range, count(*) from symbol group by histogram(ipo_year, 5) as ipo_year_range`,
+		`select year, max(adj_close) from quote where symbol='AAPL' group by date_histogram('@timestamp','1y') as year`,
+		`SELECT ipo_year_range, COUNT(*) FROM symbol GROUP BY range(ipo_year, 1980, 1990, 2000) AS ipo_year_range`,
+		`select exchange, sector, max(market_cap) from symbol group by exchange, sector`,
+		`select exchange, sum(ipo_year+last_sale) from symbol group by exchange`,
+		`SELECT ipo_year_rem, COUNT(*) FROM symbol GROUP BY ipo_year % 5 AS ipo_year_rem`,
+		`SELECT ipo_year, COUNT(*) AS ipo_count FROM symbol GROUP BY ipo_year ORDER BY ipo_count LIMIT 2`,
+		`SELECT ipo_year, MAX(market_cap) AS max_market_cap FROM symbol GROUP BY ipo_year ORDER BY max_market_cap LIMIT 2`,
+		`SELECT ipo_year, COUNT(*) AS ipo_count, MAX(last_sale) AS max_last_sale FROM symbol GROUP BY ipo_year HAVING ipo_count > 100 AND max_last_sale <= 10000`,
+		`select exchange, sum(ipo_year), sum(ipo_year*2)/avg(last_sale) AS yyyy from symbol group by exchange`,
+		`select  -5*sum(ipo_year+last_sale*2)  AS yyyy from symbol group by exchange`,
+		`select * from blog where comments.author='kimchy' and title='es' and comments.stars > 3 limit 1`,
+		`select comments.author, avg(comments.stars), sum(price) from blog group by comments.author`,
+		`select * from shop where geo_bbox(location, 41, -75, 40.5, -73) and name='x' limit 1`,
+		`select * from shop where geo_polygon(location, [40, -70, 30, -80, 20, -90]) limit 1`,
+		`select city, geo_bounds(location, true), geo_centroid(location) from shop group by city`,
+		`select /*+ timeout(5s) terminate_after(10) */ * from symbol limit 1`,
+		`select /*+ shard_size(100) execution_hint(map) */ exchange, sector, count(*) from symbol group by exchange, sector`,
+	}
+
+	for i, sql := range tests {
+		dsl, err := sp.EsDsl(sql)
+		if err != nil {
+			t.Errorf("%d. %s: error\n\n %s", i, sql, err)
+			continue
+		}
+		reversed, unsupported, err := sp.DslToSQL("symbol", dsl)
+		if err != nil {
+			t.Errorf("%d. %s: reverse error\n\n %s", i, sql, err)
+			continue
+		}
+		if len(unsupported) > 0 {
+			t.Errorf("%d. %s: unexpected unsupported parts %q", i, sql, unsupported)
+		}
+		dsl2, err := sp.EsDsl(reversed)
+		if err != nil {
+			t.Errorf("%d. %s: reversed %s: error\n\n %s", i, sql, reversed, err)
+			continue
+		}
+		_dsl, _ := simplejson.NewJson([]byte(dsl))
+		_dsl2, _ := simplejson.NewJson([]byte(dsl2))
+		if !reflect.DeepEqual(_dsl.MustMap(), _dsl2.MustMap()) {
+			t.Errorf("%d. %q reversed %q\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, sql, reversed, dsl, dsl2)
+		}
+	}
+}
+
+// Ensure hand written query bodies are converted into sql.
+func TestReverse_DslToSQL(t *testing.T) {
+	var tests = []struct {
+		dsl         string
+		sql         string
+		unsupported []string
+		err         string
+	}{
+		{
+			dsl: `{"query": {"match_all": {}}}`,
+			sql: `SELECT * FROM logs LIMIT 10`,
+		},
+		{
+			dsl: `{"query": {"bool": {
+                    "must": [{"match": {"title": "es"}}, {"terms": {"tag": ["a", "b"]}}],
+                    "must_not": [{"term": {"status": "x"}}, {"range": {"age": {"gte": 3, "lt": 9}}}]
+                  }}, "size": 20, "from": 5, "sort": [{"age": {"order": "desc"}}]}`,
+			sql:         `SELECT * FROM logs WHERE tag IN ['a', 'b'] AND status != 'x' AND (age < 3 OR age >= 9) ORDER BY age DESC LIMIT 20, 5`,
+			unsupported: []string{"query.bool.must.0.match: full text queries are not supported"},
+		},
+		{
+			dsl: `{"query": {"bool": {"should": [{"prefix": {"name": "ab"}}, {"wildcard": {"host": "web-?.*"}}]}}, "size": 1}`,
+			sql: `SELECT * FROM logs WHERE name =~ /^ab/ OR host =~ /^web-.\..*$/ LIMIT 1`,
+		},
+		{
+			dsl: `{"query": {"bool": {"must": [{"match": {"title": "es"}}], "should": [{"term": {"b": 1}}, {"term": {"c": 2}}]}}, "size": 1}`,
+			sql: `SELECT * FROM logs LIMIT 1`,
+			unsupported: []string{
+				"query.bool.must.0.match: full text queries are not supported",
+				"query.bool.should: scoring clauses can not be represented",
+			},
+		},
+		{
+			dsl: `{"query": {"query_string": {"query": "guid:31 AND status:\"not found\""}}, "size": 1}`,
+			sql: `SELECT * FROM logs WHERE guid = 31 AND status = 'not found' LIMIT 1`,
+		},
+		{
+			dsl: `{"query": {"script": {"script": "doc['a'].value > 1 && doc[\"b\"].value == 'x'"}}, "size": 1}`,
+			sql: `SELECT * FROM logs WHERE a > 1 AND b = 'x' LIMIT 1`,
+		},
+		{
+			dsl: `{"query": {"script": {"script": {"inline": "doc['a'].value == params.x && doc['b'].value > params.y", "params": {"x": "k", "y": 2}}}}, "size": 1}`,
+			sql: `SELECT * FROM logs WHERE a = 'k' AND b > 2 LIMIT 1`,
+		},
+		{
+			dsl: `{"size": 0, "aggs": {"by_host": {
+                    "terms": {"field": "host", "size": 5, "order": {"_count": "desc"}},
+                    "aggs": {"avg_lat": {"avg": {"field": "latency"}}, "top": {"top_hits": {}}}
+                  }}}`,
+			sql:         `SELECT by_host, count(*) AS doc_count, avg(latency) AS avg_lat FROM logs GROUP BY host AS by_host ORDER BY doc_count DESC LIMIT 5`,
+			unsupported: []string{"aggs.by_host.aggs.top: aggregation top_hits is not supported"},
+		},
+		{
+			dsl: `{"size": 500, "sort": [{"@timestamp": {"order": "desc", "unmapped_type": "boolean"}}],
+                   "query": {"filtered": {
+                     "query": {"query_string": {"query": "guid:31 AND in_pkts:1", "analyze_wildcard": true}},
+                     "filter": {"bool": {"must": [{"range": {"@timestamp": {"gte": 1482901901667, "lte": 1482902801667}}}], "must_not": []}}
+                   }},
+                   "aggs": {"per_30s": {"date_histogram": {"field": "@timestamp", "interval": "30s", "time_zone": "Asia/Shanghai"}}}}`,
+			sql: `SELECT per_30s, count(*) FROM logs WHERE guid = 31 AND in_pkts = 1 AND @timestamp >= 1482901901667 AND @timestamp <= 1482902801667 GROUP BY date_histogram('@timestamp', '30s') AS per_30s`,
+			unsupported: []string{
+				"aggs.per_30s.date_histogram.time_zone: not supported",
+				"sort.0.@timestamp.unmapped_type: not supported",
+				"size: hits can not be returned together with group by",
+				"sort: hits can not be sorted together with aggregations",
+			},
+		},
+		{
+			dsl: `{"query": {"ids": {"values": [1]}, "exists": {"field": "host"}}, "size": 1}`,
+			sql: `SELECT * FROM logs LIMIT 1`,
+			unsupported: []string{
+				"query.ids: not supported",
+				"query: exists filter on host can not be represented",
+			},
+		},
+		{
+			dsl:         `{"query": {"term": {}}, "size": 1}`,
+			sql:         `SELECT * FROM logs LIMIT 1`,
+			unsupported: []string{"query.term: missing field"},
+		},
+		{
+			dsl: `{"size": 0, "aggs": {"by_host": {"terms": {"field": "host"}, "aggs": {
+                    "s": {"sum": {"field": "bytes"}},
+                    "r": {"bucket_script": {"buckets_path": {"s": "s"}, "script": {"inline": "params.s / params.n", "params": {"n": 2}}}},
+                    "f": {"bucket_selector": {"buckets_path": {"s": "s"}, "script": {"inline": "params.s > params.min", "params": {"min": 10}}}}
+                  }}}}`,
+			sql: `SELECT by_host, sum(bytes) AS s, s / 2 AS r FROM logs GROUP BY host AS by_host HAVING s > 10`,
+		},
+		{
+			dsl: `{"size": 0, "aggs": {"by_host": {"terms": {"field": "host"}, "aggs": {
+                    "s": {"sum": {"field": "bytes"}},
+                    "f": {"bucket_selector": {"buckets_path": {"s": "s"}, "script": "_value > 10"}}
+                  }}}}`,
+			sql:         `SELECT by_host, sum(bytes) AS s FROM logs GROUP BY host AS by_host`,
+			unsupported: []string{"aggs.by_host.aggs.f.script: _value is not a buckets_path variable"},
+		},
+		{
+			dsl: `{"query": `,
+			err: `invalid dsl, unexpected EOF`,
+		},
+	}
+
+	for i, tt := range tests {
+		sql, unsupported, err := sp.DslToSQL("logs", tt.dsl)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.dsl, tt.err, err)
+		} else if err == nil && sql != tt.sql {
+			t.Errorf("%d. %s\n\nsql mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.dsl, tt.sql, sql)
+		} else if err == nil && !reflect.DeepEqual(tt.unsupported, unsupported) {
+			t.Errorf("%d. %s\n\nunsupported mismatch:\n\nexp=%q\n\ngot=%q\n\n", i, tt.dsl, tt.unsupported, unsupported)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package sp
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // GroovyWrapped ...
 func (tok Token) GroovyWrapped() string {
@@ -26,6 +29,12 @@ func (r *VarRef) GroovyWrapped() string {
 
 //RewriteConditions ...
 func (s *SelectStatement) RewriteConditions() {
+	rewriteCondition(s.Condition)
+}
+
+// rewriteCondition rewrites the variable references of a filter expression
+// into groovy doc values, use script to print it.
+func rewriteCondition(cond Expr) {
 
 	// Rewrite all variable references in the fields with their types if one
 	// hasn't been specified.
@@ -33,12 +42,10 @@ func (s *SelectStatement) RewriteConditions() {
 		switch expr := n.(type) {
 		case *VarRef:
 			expr.Val = expr.GroovyWrapped()
-		case *BinaryExpr:
-			tokens[expr.Op] = expr.Op.GroovyWrapped()
 		}
 		return
 	}
-	WalkFunc(s.Condition, rewrite)
+	WalkFunc(cond, rewrite)
 }
 
 //RewriteMetricArgs ...
@@ -71,10 +78,10 @@ func (s *SelectStatement) RewriteDimensions() {
 	}
 	for _, d := range s.Dimensions {
 		switch expr := d.Expr.(type) {
-		case *BinaryExpr:
+		case *BinaryExpr, *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr, *CastExpr:
 			WalkFunc(d, rewrite)
 		case *Call:
-			if _, ok := expr.Args[0].(*BinaryExpr); ok {
+			if _, ok := expr.Args[0].(*BinaryExpr); ok || isScalarCall(expr) {
 				WalkFunc(d, rewrite)
 			}
 		default:
@@ -82,16 +89,137 @@ func (s *SelectStatement) RewriteDimensions() {
 	}
 }
 
-//RewriteHaving ...
-func (s *SelectStatement) RewriteHaving() {
-	// Rewrite all variable references in the fields with their types if one
-	// hasn't been specified.
-	rewrite := func(n Node) {
-		switch expr := n.(type) {
-		case *BinaryExpr:
-			tokens[expr.Op] = expr.Op.GroovyWrapped()
+// scriptPrinter prints groovy scripts with their literals lifted into script
+// params, so statements differing only by their values share one compiled
+// script in the elasticsearch script cache.
+type scriptPrinter struct {
+	params map[string]interface{}
+	// reserved holds the names of the named placeholders of the script.
+	reserved map[string]bool
+	n        int
+	// painless is set once a scalar function or a cast is printed as painless.
+	painless bool
+	// expression is set for lucene expressions, which refer to their params
+	// by name.
+	expression bool
+}
+
+// script returns the groovy script of expr, as its source when it has no
+// literals, else as an inline script with its params. Scripts calling
+// scalar functions are painless scripts.
+func script(expr Expr) interface{} {
+	p := &scriptPrinter{params: make(map[string]interface{}), reserved: make(map[string]bool)}
+	WalkFunc(expr, func(n Node) {
+		if b, ok := n.(*BoundParameter); ok && !b.positional() {
+			p.reserved[b.Name] = true
+		}
+	})
+	src := p.print(expr)
+	if p.painless {
+		m := map[string]interface{}{"inline": src, "lang": "painless"}
+		if len(p.params) > 0 {
+			m["params"] = p.params
+		}
+		return m
+	}
+	if len(p.params) == 0 {
+		return src
+	}
+	return map[string]interface{}{"inline": src, "params": p.params}
+}
+
+// expressionScript returns the lucene expression script of src with the
+// params lifted by p.
+func (p *scriptPrinter) expressionScript(src string) map[string]interface{} {
+	m := map[string]interface{}{"inline": src, "lang": "expression"}
+	if len(p.params) > 0 {
+		m["params"] = p.params
+	}
+	return m
+}
+
+func (p *scriptPrinter) print(expr Expr) string {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		return fmt.Sprintf("%s %s %s", p.print(expr.LHS), expr.Op.GroovyWrapped(), p.print(expr.RHS))
+	case *ParenExpr:
+		return fmt.Sprintf("(%s)", p.print(expr.Expr))
+	case *Call:
+		if f := scalarFunctionOf(expr); f != nil && f.painless != nil {
+			p.painless = true
+			return f.painless(expr, p.print)
+		}
+		args := make([]string, len(expr.Args))
+		for i, arg := range expr.Args {
+			args[i] = p.print(arg)
+		}
+		return fmt.Sprintf("%s(%s)", expr.Name, strings.Join(args, ", "))
+	case *CastExpr:
+		p.painless = true
+		return castPainless(expr.Type, p.print(expr.Expr))
+	case *CaseExpr:
+		return p.printCase(expr, 0)
+	case *IfExpr:
+		return fmt.Sprintf("(%s ? %s : %s)", p.print(expr.Cond), p.print(expr.Then), p.print(expr.Else))
+	case *CoalesceExpr:
+		// the last argument is the fallback of the others
+		args := expr.Args
+		src := p.print(args[len(args)-1])
+		for i := len(args) - 2; i >= 0; i-- {
+			src = fmt.Sprintf("(%s ? %s : %s)", p.isNull(args[i]), src, p.print(args[i]))
+		}
+		return src
+	case *NullIfExpr:
+		v := p.print(expr.Expr)
+		return fmt.Sprintf("(%s == %s ? null : %s)", v, p.print(expr.Value), v)
+	case *BoundParameter:
+		if expr.positional() {
+			return p.lift(expr.Value)
+		}
+		p.params[expr.Name] = literalValue(expr.Value)
+		return "params." + expr.Name
+	case *StringLiteral, *IntegerLiteral, *NumberLiteral, *BooleanLiteral, *ListLiteral:
+		return p.lift(expr)
+	default:
+		return expr.String()
+	}
+}
+
+// printCase prints the WHEN clauses of e from i on as nested ternaries.
+func (p *scriptPrinter) printCase(e *CaseExpr, i int) string {
+	if i == len(e.Whens) {
+		if e.Else == nil {
+			return "null"
+		}
+		return p.print(e.Else)
+	}
+	w := e.Whens[i]
+	cond := p.print(w.Cond)
+	if e.Operand != nil {
+		cond = fmt.Sprintf("%s == %s", p.print(e.Operand), cond)
+	}
+	return fmt.Sprintf("(%s ? %s : %s)", cond, p.print(w.Result), p.printCase(e, i+1))
+}
+
+// isNull prints the test of expr being null, a field without value is empty.
+func (p *scriptPrinter) isNull(expr Expr) string {
+	if ref, ok := expr.(*VarRef); ok && strings.HasPrefix(ref.Val, "doc[") && strings.HasSuffix(ref.Val, "].value") {
+		return strings.TrimSuffix(ref.Val, ".value") + ".empty"
+	}
+	return p.print(expr) + " == null"
+}
+
+// lift stores the value of lit in the next free pN param.
+func (p *scriptPrinter) lift(lit Expr) string {
+	for {
+		name := fmt.Sprintf("p%d", p.n)
+		p.n++
+		if !p.reserved[name] {
+			p.params[name] = literalValue(lit)
+			if p.expression {
+				return name
+			}
+			return "params." + name
 		}
-		return
 	}
-	WalkFunc(s.Having, rewrite)
 }
@@ -0,0 +1,550 @@
+package sp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scalarFunction is a function computed by scripts from the values of a
+// document, or of a bucket in HAVING.
+type scalarFunction struct {
+	// args are the types of the arguments, Unknown accepts any type. The
+	// optional trailing arguments may be omitted, variadic repeats the last.
+	args     []DataType
+	optional int
+	variadic bool
+	// ret is the type of the result, Unknown when it depends on the arguments.
+	ret DataType
+	// check validates the literal arguments, e.g. the date_trunc unit.
+	check func(c *Call) error
+	// expression renders the lucene expression, nil when there is none.
+	expression func(c *Call, print func(Expr) string) string
+	// painless renders the painless script, nil when there is none.
+	painless func(c *Call, print func(Expr) string) string
+	// target renders the _sql or ES|QL function, nil for the upper cased name.
+	target func(t Target, c *Call, args []string) string
+}
+
+// numeric is the type of the arguments accepting integers and floats.
+const numeric = Float
+
+var scalarFunctions = map[string]*scalarFunction{
+	"acosh": {
+		args:       []DataType{numeric},
+		ret:        Float,
+		expression: sameFunction,
+		painless: func(c *Call, print func(Expr) string) string {
+			x := print(c.Args[0])
+			return fmt.Sprintf("Math.log(%s + Math.sqrt(%s * %s - 1))", x, x, x)
+		},
+	},
+	"asinh": {
+		args:       []DataType{numeric},
+		ret:        Float,
+		expression: sameFunction,
+		painless: func(c *Call, print func(Expr) string) string {
+			x := print(c.Args[0])
+			return fmt.Sprintf("Math.log(%s + Math.sqrt(%s * %s + 1))", x, x, x)
+		},
+	},
+	"atanh": {
+		args:       []DataType{numeric},
+		ret:        Float,
+		expression: sameFunction,
+		painless: func(c *Call, print func(Expr) string) string {
+			x := print(c.Args[0])
+			return fmt.Sprintf("0.5 * Math.log((1 + %s) / (1 - %s))", x, x)
+		},
+	},
+	"haversin": {
+		args:       []DataType{numeric, numeric, numeric, numeric},
+		ret:        Float,
+		expression: sameFunction,
+	},
+	"ln": {
+		args:       []DataType{numeric},
+		ret:        Float,
+		expression: sameFunction,
+		painless:   mathMethod("log"),
+		target:     renamed("LOG", "LOG"),
+	},
+	"log": {
+		args:       []DataType{numeric},
+		ret:        Float,
+		expression: renamedExpression("ln"),
+		painless:   mathMethod("log"),
+	},
+	"logn": {
+		args:       []DataType{numeric, numeric},
+		ret:        Float,
+		expression: sameFunction,
+		painless: func(c *Call, print func(Expr) string) string {
+			return fmt.Sprintf("Math.log(%s) / Math.log(%s)", print(c.Args[1]), print(c.Args[0]))
+		},
+		target: func(t Target, c *Call, args []string) string {
+			if t == TargetSQL {
+				return fmt.Sprintf("LOG(%s) / LOG(%s)", args[1], args[0])
+			}
+			return fmt.Sprintf("LOG(%s)", strings.Join(args, ", "))
+		},
+	},
+	"pow": {
+		args:       []DataType{numeric, numeric},
+		ret:        Float,
+		expression: sameFunction,
+		painless:   mathMethod("pow"),
+		target:     renamed("POWER", "POW"),
+	},
+	"round": {
+		args:     []DataType{numeric, Integer},
+		optional: 1,
+		ret:      Float,
+		expression: func(c *Call, print func(Expr) string) string {
+			x := print(c.Args[0])
+			if len(c.Args) == 1 {
+				return fmt.Sprintf("floor(%s + 0.5)", x)
+			}
+			n := print(c.Args[1])
+			return fmt.Sprintf("floor(%s * pow(10, %s) + 0.5) / pow(10, %s)", x, n, n)
+		},
+		painless: func(c *Call, print func(Expr) string) string {
+			x := print(c.Args[0])
+			if len(c.Args) == 1 {
+				return fmt.Sprintf("Math.round(%s)", x)
+			}
+			n := print(c.Args[1])
+			return fmt.Sprintf("Math.round(%s * Math.pow(10, %s)) / Math.pow(10, %s)", x, n, n)
+		},
+	},
+
+	"lower": {
+		args:     []DataType{String},
+		ret:      String,
+		painless: stringMethod("toLowerCase()"),
+		target:   renamed("LCASE", "TO_LOWER"),
+	},
+	"upper": {
+		args:     []DataType{String},
+		ret:      String,
+		painless: stringMethod("toUpperCase()"),
+		target:   renamed("UCASE", "TO_UPPER"),
+	},
+	"length": {
+		args:     []DataType{String},
+		ret:      Integer,
+		painless: stringMethod("length()"),
+	},
+	"substring": {
+		args:     []DataType{String, Integer, Integer},
+		optional: 1,
+		ret:      String,
+		// sql positions start at 1
+		painless: func(c *Call, print func(Expr) string) string {
+			s, start := operand(c.Args[0], print), print(c.Args[1])
+			if len(c.Args) == 2 {
+				return fmt.Sprintf("%s.substring(%s - 1)", s, start)
+			}
+			return fmt.Sprintf("%s.substring(%s - 1, %s - 1 + %s)", s, start, start, print(c.Args[2]))
+		},
+	},
+	"concat": {
+		args:     []DataType{Unknown, Unknown},
+		variadic: true,
+		ret:      String,
+		painless: func(c *Call, print func(Expr) string) string {
+			parts := make([]string, len(c.Args))
+			for i, arg := range c.Args {
+				parts[i] = fmt.Sprintf("String.valueOf(%s)", print(arg))
+			}
+			return "(" + strings.Join(parts, " + ") + ")"
+		},
+		target: func(t Target, c *Call, args []string) string {
+			if t == TargetESQL {
+				return fmt.Sprintf("CONCAT(%s)", strings.Join(args, ", "))
+			}
+			// _sql concatenates two strings
+			s := args[0]
+			for _, arg := range args[1:] {
+				s = fmt.Sprintf("CONCAT(%s, %s)", s, arg)
+			}
+			return s
+		},
+	},
+
+	"year":        datePart("getYear()", "YEAR", "year"),
+	"month":       datePart("getMonthValue()", "MONTH_OF_YEAR", "month_of_year"),
+	"day_of_week": datePart("getDayOfWeek().getValue()", "ISO_DAY_OF_WEEK", "day_of_week"),
+	"hour":        datePart("getHour()", "HOUR_OF_DAY", "hour_of_day"),
+	"date_trunc": {
+		args: []DataType{String, Unknown},
+		ret:  Unknown,
+		check: func(c *Call) error {
+			unit, ok := c.Args[0].(*StringLiteral)
+			if !ok || dateTruncUnits[unit.Val] == "" {
+				return fmt.Errorf("invalid date_trunc unit %s, expected one of %s", c.Args[0].String(), strings.Join(dateTruncUnitNames(), ", "))
+			}
+			return nil
+		},
+		painless: func(c *Call, print func(Expr) string) string {
+			unit := c.Args[0].(*StringLiteral).Val
+			return operand(c.Args[1], print) + "." + dateTruncUnits[unit]
+		},
+		target: func(t Target, c *Call, args []string) string {
+			if t == TargetESQL {
+				return fmt.Sprintf("DATE_TRUNC(1 %s, %s)", c.Args[0].(*StringLiteral).Val, args[1])
+			}
+			return fmt.Sprintf("DATE_TRUNC(%s)", strings.Join(args, ", "))
+		},
+	},
+}
+
+// addMathFunctions adds the math functions shared by lucene expressions and
+// java.lang.Math.
+func addMathFunctions() {
+	for _, name := range []string{"abs", "acos", "asin", "atan", "cbrt", "ceil", "cos", "cosh", "exp", "floor", "log10", "sin", "sinh", "sqrt", "tan", "tanh"} {
+		scalarFunctions[name] = &scalarFunction{
+			args:       []DataType{numeric},
+			ret:        Float,
+			expression: sameFunction,
+			painless:   mathMethod(name),
+		}
+	}
+	for _, name := range []string{"atan2", "max", "min"} {
+		scalarFunctions[name] = &scalarFunction{
+			args:       []DataType{numeric, numeric},
+			ret:        Float,
+			expression: sameFunction,
+			painless:   mathMethod(name),
+		}
+	}
+	// max and min of two values are GREATEST and LEAST in _sql and ES|QL
+	scalarFunctions["max"].target = renamed("GREATEST", "GREATEST")
+	scalarFunctions["min"].target = renamed("LEAST", "LEAST")
+}
+
+// dateTruncUnits maps the date_trunc units to the painless truncation of a date.
+var dateTruncUnits = map[string]string{
+	"second": "truncatedTo(ChronoUnit.SECONDS)",
+	"minute": "truncatedTo(ChronoUnit.MINUTES)",
+	"hour":   "truncatedTo(ChronoUnit.HOURS)",
+	"day":    "truncatedTo(ChronoUnit.DAYS)",
+	"month":  "withDayOfMonth(1).truncatedTo(ChronoUnit.DAYS)",
+	"year":   "withDayOfYear(1).truncatedTo(ChronoUnit.DAYS)",
+}
+
+func dateTruncUnitNames() []string {
+	return []string{"second", "minute", "hour", "day", "month", "year"}
+}
+
+// sameFunction renders a call with its own name.
+func sameFunction(c *Call, print func(Expr) string) string {
+	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(printArgs(c, print), ", "))
+}
+
+func renamedExpression(name string) func(c *Call, print func(Expr) string) string {
+	return func(c *Call, print func(Expr) string) string {
+		return fmt.Sprintf("%s(%s)", name, strings.Join(printArgs(c, print), ", "))
+	}
+}
+
+// mathMethod renders a call of a java.lang.Math method.
+func mathMethod(name string) func(c *Call, print func(Expr) string) string {
+	return func(c *Call, print func(Expr) string) string {
+		return fmt.Sprintf("Math.%s(%s)", name, strings.Join(printArgs(c, print), ", "))
+	}
+}
+
+// stringMethod renders a call of a method of the string argument.
+func stringMethod(method string) func(c *Call, print func(Expr) string) string {
+	return func(c *Call, print func(Expr) string) string {
+		return operand(c.Args[0], print) + "." + method
+	}
+}
+
+// datePart is a function returning a field of a date, from the java.time
+// method of painless, the _sql function or the ES|QL date_extract part.
+func datePart(method, sqlName, esqlPart string) *scalarFunction {
+	return &scalarFunction{
+		args: []DataType{Unknown},
+		ret:  Integer,
+		painless: func(c *Call, print func(Expr) string) string {
+			return operand(c.Args[0], print) + "." + method
+		},
+		target: func(t Target, c *Call, args []string) string {
+			if t == TargetESQL {
+				return fmt.Sprintf(`DATE_EXTRACT("%s", %s)`, esqlPart, args[0])
+			}
+			return fmt.Sprintf("%s(%s)", sqlName, args[0])
+		},
+	}
+}
+
+// renamed renders the function with its _sql or ES|QL name.
+func renamed(sqlName, esqlName string) func(t Target, c *Call, args []string) string {
+	return func(t Target, c *Call, args []string) string {
+		name := sqlName
+		if t == TargetESQL {
+			name = esqlName
+		}
+		return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+	}
+}
+
+func printArgs(c *Call, print func(Expr) string) []string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = print(arg)
+	}
+	return args
+}
+
+// operand prints expr as the receiver of a method call.
+func operand(expr Expr, print func(Expr) string) string {
+	s := print(expr)
+	if _, ok := expr.(*BinaryExpr); ok {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// scalarFunctionOf returns the scalar function called by c, nil for other
+// functions. max() and min() of one argument are aggregates.
+func scalarFunctionOf(c *Call) *scalarFunction {
+	f := lookupFunction(c)
+	if f == nil || f.Kind != ScalarFunction {
+		return nil
+	}
+	return f.scalar
+}
+
+// isScalarCall returns true if expr calls a scalar function.
+func isScalarCall(expr Expr) bool {
+	c, ok := expr.(*Call)
+	return ok && scalarFunctionOf(c) != nil
+}
+
+// scalarNames returns the names of the scalar functions, sorted.
+func scalarNames() []string {
+	return functionNames(ScalarFunction)
+}
+
+// validateScalar checks the types of the arguments of a scalar function
+// call, its arity is checked by validateCall.
+func validateScalar(c *Call) error {
+	f := scalarFunctionOf(c)
+	for i, arg := range c.Args {
+		want := f.args[len(f.args)-1]
+		if i < len(f.args) {
+			want = f.args[i]
+		}
+		if got := typeOf(arg); !acceptsType(want, got) {
+			return errorAt(arg, fmt.Errorf("invalid argument %s for %s(), expected %s, got %s", arg.String(), c.Name, typeName(want), typeName(got)))
+		}
+	}
+	if f.check != nil {
+		return errorAt(c, f.check(c))
+	}
+	return nil
+}
+
+// typeOf returns the type of the value of expr, Unknown for fields.
+func typeOf(expr Expr) DataType {
+	switch e := expr.(type) {
+	case *IntegerLiteral:
+		return Integer
+	case *NumberLiteral:
+		return Float
+	case *StringLiteral:
+		return String
+	case *BooleanLiteral:
+		return Boolean
+	case *BoundParameter:
+		if e.Value != nil {
+			return typeOf(e.Value)
+		}
+	case *ParenExpr:
+		return typeOf(e.Expr)
+	case *Call:
+		if f := scalarFunctionOf(e); f != nil {
+			return f.ret
+		}
+	case *CastExpr:
+		return castTypes[e.Type]
+	case *BinaryExpr:
+		switch e.Op {
+		case ADD:
+			// strings are concatenated
+			if typeOf(e.LHS) == String || typeOf(e.RHS) == String {
+				return String
+			}
+			return Float
+		case SUB, MUL, DIV, MOD:
+			return Float
+		default:
+			return Boolean
+		}
+	}
+	return Unknown
+}
+
+func acceptsType(want, got DataType) bool {
+	return want == Unknown || got == Unknown || want == got || (want == numeric && got == Integer)
+}
+
+func typeName(t DataType) string {
+	switch t {
+	case numeric:
+		return "number"
+	case Unknown:
+		return "value"
+	}
+	return t.String()
+}
+
+// aggregateCalls returns the calls of expr which are not scalar functions,
+// looking into the arguments of scalar functions, casts and conditional
+// expressions.
+func aggregateCalls(expr Expr) []*Call {
+	switch e := expr.(type) {
+	case *Call:
+		if !isScalarCall(e) {
+			return []*Call{e}
+		}
+		var calls []*Call
+		for _, arg := range e.Args {
+			calls = append(calls, aggregateCalls(arg)...)
+		}
+		return calls
+	case *BinaryExpr:
+		return append(aggregateCalls(e.LHS), aggregateCalls(e.RHS)...)
+	case *ParenExpr:
+		return aggregateCalls(e.Expr)
+	case *CastExpr:
+		return aggregateCalls(e.Expr)
+	case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+		var calls []*Call
+		for _, arg := range conditionalArgs(e) {
+			calls = append(calls, aggregateCalls(arg)...)
+		}
+		return calls
+	}
+	return nil
+}
+
+// isComputed returns true for the expressions computed by a script from
+// the fields of every document: conditional expressions, casts and scalar
+// function calls without aggregates.
+func isComputed(expr Expr) bool {
+	switch expr.(type) {
+	case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr, *CastExpr:
+	default:
+		if !isScalarCall(expr) {
+			return false
+		}
+	}
+	return len(aggregateCalls(expr)) == 0
+}
+
+// castTypes maps the types of CAST to the type of their values.
+var castTypes = map[string]DataType{
+	"integer": Integer,
+	"long":    Integer,
+	"float":   Float,
+	"double":  Float,
+	"string":  String,
+	"keyword": String,
+	"text":    String,
+	"boolean": Boolean,
+}
+
+// castAliases maps the sql names of the types of CAST to their name.
+var castAliases = map[string]string{
+	"int":     "integer",
+	"bigint":  "long",
+	"varchar": "string",
+	"bool":    "boolean",
+}
+
+func castTypeNames() []string {
+	return []string{"integer", "int", "long", "bigint", "float", "double", "string", "varchar", "keyword", "text", "boolean", "bool"}
+}
+
+// castPainless returns the painless conversion of the value v into typ,
+// values are converted through their string so that strings are parsed.
+func castPainless(typ, v string) string {
+	s := fmt.Sprintf("String.valueOf(%s)", v)
+	switch typ {
+	case "integer":
+		return fmt.Sprintf("(int) Double.parseDouble(%s)", s)
+	case "long":
+		return fmt.Sprintf("(long) Double.parseDouble(%s)", s)
+	case "float":
+		return fmt.Sprintf("(float) Double.parseDouble(%s)", s)
+	case "double":
+		return fmt.Sprintf("Double.parseDouble(%s)", s)
+	case "boolean":
+		return fmt.Sprintf("Boolean.parseBoolean(%s)", s)
+	}
+	return s
+}
+
+// castTarget returns the _sql or ES|QL conversion of the value v into typ.
+func castTarget(t Target, typ, v string) string {
+	if t == TargetESQL {
+		fn := map[string]string{
+			"integer": "TO_INTEGER", "long": "TO_LONG", "float": "TO_DOUBLE", "double": "TO_DOUBLE",
+			"string": "TO_STRING", "keyword": "TO_STRING", "text": "TO_STRING", "boolean": "TO_BOOLEAN",
+		}[typ]
+		return fmt.Sprintf("%s(%s)", fn, v)
+	}
+	if typ == "string" {
+		typ = "keyword"
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", v, strings.ToUpper(typ))
+}
+
+// expression returns the lucene expression of expr. Calls of other
+// functions, e.g. the aggregates of HAVING, are printed as they are. When p
+// is not nil the literals outside of them are lifted into its params.
+func expression(expr Expr, p *scriptPrinter) (string, error) {
+	var err error
+	var print func(Expr) string
+	print = func(expr Expr) string {
+		switch e := expr.(type) {
+		case *BinaryExpr:
+			return fmt.Sprintf("%s %s %s", print(e.LHS), e.Op.GroovyWrapped(), print(e.RHS))
+		case *ParenExpr:
+			return fmt.Sprintf("(%s)", print(e.Expr))
+		case *Call:
+			f := scalarFunctionOf(e)
+			if f == nil {
+				// aggregates keep their literals, they are replaced by their
+				// buckets_path by name
+				lift := p
+				p = nil
+				src := fmt.Sprintf("%s(%s)", e.Name, strings.Join(printArgs(e, print), ", "))
+				p = lift
+				return src
+			}
+			if f.expression == nil {
+				if err == nil {
+					err = errorAt(e, fmt.Errorf("%s() is not supported by lucene expressions", e.Name))
+				}
+				return ""
+			}
+			return f.expression(e, print)
+		case *CastExpr, *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+			if err == nil {
+				err = errorAt(e, fmt.Errorf("%s is not supported by lucene expressions", e.String()))
+			}
+			return ""
+		case *IntegerLiteral, *NumberLiteral, *BooleanLiteral:
+			if p != nil {
+				return p.lift(e)
+			}
+		}
+		return expr.String()
+	}
+	s := print(expr)
+	return s, err
+}
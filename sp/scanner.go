@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"unicode"
 )
 
 // Scanner represents a lexical scanner for InfluxQL.
@@ -42,19 +44,36 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	switch ch0 {
 	case eof:
 		return EOF, pos, ""
-	case '"':
-		return s.scanString()
+	case '"', '`':
+		return s.scanQuotedIdent(ch0)
 	case '\'':
 		return s.scanString()
+	case '?':
+		return BOUNDPARAM, pos, ""
+	case ':':
+		if ch1, _ := s.r.read(); isLetter(ch1) || ch1 == '_' {
+			s.r.unread()
+			_, _, lit := s.scanIdent(false)
+			return BOUNDPARAM, pos, lit
+		}
+		s.r.unread()
 	case '.':
 		return DOT, pos, ""
 	case '-':
+		if ch1, _ := s.r.read(); ch1 == '-' {
+			return s.scanLineComment(pos)
+		}
+		s.r.unread()
 		return SUB, pos, ""
 	case '+':
 		return ADD, pos, ""
 	case '*':
 		return MUL, pos, ""
 	case '/':
+		if ch1, _ := s.r.read(); ch1 == '*' {
+			return s.scanBlockComment(pos)
+		}
+		s.r.unread()
 		return DIV, pos, ""
 	case '%':
 		return MOD, pos, ""
@@ -95,6 +114,8 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return RBRACKET, pos, ""
 	case ',':
 		return COMMA, pos, ""
+	case ';':
+		return SEMICOLON, pos, ""
 	}
 
 	return ILLEGAL, pos, string(ch0)
@@ -124,6 +145,61 @@ func (s *Scanner) scanWhitespace() (tok Token, pos Pos, lit string) {
 	return WS, pos, buf.String()
 }
 
+// scanLineComment consumes a "--" comment up to the end of the line,
+// the literal is the comment text.
+func (s *Scanner) scanLineComment(pos Pos) (Token, Pos, string) {
+	var buf bytes.Buffer
+	for {
+		ch, _ := s.r.read()
+		if ch == eof {
+			break
+		} else if ch == '\n' {
+			s.r.unread()
+			break
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+	return COMMENT, pos, buf.String()
+}
+
+// scanBlockComment consumes a "/* */" comment, the literal is the text
+// between the delimiters. An unterminated comment is ILLEGAL.
+func (s *Scanner) scanBlockComment(pos Pos) (Token, Pos, string) {
+	var buf bytes.Buffer
+	for {
+		ch, _ := s.r.read()
+		if ch == eof {
+			return ILLEGAL, pos, "/*" + buf.String()
+		} else if ch == '*' {
+			if ch1, _ := s.r.read(); ch1 == '/' {
+				return COMMENT, pos, buf.String()
+			}
+			s.r.unread()
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+}
+
+// scanQuotedIdent consumes a backtick or double quoted identifier, the
+// quote is escaped by doubling it.
+func (s *Scanner) scanQuotedIdent(quote rune) (tok Token, pos Pos, lit string) {
+	_, pos = s.r.curr()
+
+	var buf bytes.Buffer
+	for {
+		ch, _ := s.r.read()
+		if ch == eof || ch == '\n' {
+			return BADSTRING, pos, buf.String()
+		} else if ch == quote {
+			if ch1, _ := s.r.read(); ch1 != quote {
+				s.r.unread()
+				return IDENT, pos, buf.String()
+			}
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+}
+
 func (s *Scanner) scanIdent(lookup bool) (tok Token, pos Pos, lit string) {
 	// Save the starting position of the identifier.
 	_, pos = s.r.read()
@@ -216,7 +292,10 @@ func (s *Scanner) ScanNumber() (tok Token, pos Pos, lit string) {
 			_, _ = buf.WriteRune(ch1)
 			_, _ = buf.WriteString(s.scanDigits())
 		} else {
+			// not a fraction, leave the dot, e.g. logs-2017.*
 			s.r.unread()
+			s.r.unread()
+			isDecimal = false
 		}
 	} else {
 		s.r.unread()
@@ -256,7 +335,7 @@ func isDigit(ch rune) bool { return (ch >= '0' && ch <= '9') }
 func isIdentChar(ch rune) bool { return isLetter(ch) || isDigit(ch) || ch == '_' || ch == '@' }
 
 // isIdentFirstChar returns true if the rune can be used as the first char in an unquoted identifer.
-func isIdentFirstChar(ch rune) bool { return isLetter(ch) || ch == '_' }
+func isIdentFirstChar(ch rune) bool { return isLetter(ch) || ch == '_' || ch == '@' }
 
 // bufScanner represents a wrapper for scanner to add a buffer.
 // It provides a fixed-length circular buffer that can be unread.
@@ -267,6 +346,7 @@ type bufScanner struct {
 	buf [3]struct {
 		tok Token
 		pos Pos
+		end Pos // position after the token
 		lit string
 	}
 }
@@ -298,6 +378,8 @@ func (s *bufScanner) scanFunc(scan func() (Token, Pos, string)) (tok Token, pos
 	s.i = (s.i + 1) % len(s.buf)
 	buf := &s.buf[s.i]
 	buf.tok, buf.pos, buf.lit = scan()
+	_, buf.end = s.s.r.curr()
+	buf.end.Char++
 
 	return s.curr()
 }
@@ -311,6 +393,11 @@ func (s *bufScanner) curr() (tok Token, pos Pos, lit string) {
 	return buf.tok, buf.pos, buf.lit
 }
 
+// end returns the position after the last read token.
+func (s *bufScanner) end() Pos {
+	return s.buf[(s.i-s.n+len(s.buf))%len(s.buf)].end
+}
+
 // reader represents a buffered rune reader used by the scanner.
 // It provides a fixed-length circular buffer that can be unread.
 type reader struct {
@@ -512,6 +599,62 @@ func ScanBareIdent(r io.RuneScanner) string {
 
 var errInvalidIdentifier = errors.New("invalid identifier")
 
+// SplitStatements splits src at the semicolons ending its statements,
+// semicolons of strings, quoted identifiers and comments are kept.
+// Statements are trimmed, the ones holding only comments are dropped.
+func SplitStatements(src string) []string {
+	stmts, _ := SplitStatementsPos(src)
+	return stmts
+}
+
+// SplitStatementsPos splits src as SplitStatements does and also returns
+// the position in src of the first character of each statement.
+func SplitStatementsPos(src string) ([]string, []Pos) {
+	var stmts []string
+	var starts []Pos
+	rs := []rune(src)
+	start, code := 0, false
+	add := func(end int) {
+		stmt := strings.TrimSpace(string(rs[start:end]))
+		if !code || stmt == "" {
+			return
+		}
+		var pos Pos
+		for i := 0; i < start || unicode.IsSpace(rs[i]); i++ {
+			pos.Char++
+			if rs[i] == '\n' {
+				pos.Line, pos.Char = pos.Line+1, 0
+			}
+		}
+		stmts, starts = append(stmts, stmt), append(starts, pos)
+	}
+	for i := 0; i < len(rs); i++ {
+		switch ch := rs[i]; {
+		case ch == '\'' || ch == '"' || ch == '`':
+			code = true
+			for i++; i < len(rs) && rs[i] != ch; i++ {
+				if rs[i] == '\\' && ch != '`' {
+					i++
+				}
+			}
+		case ch == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			for i += 3; i < len(rs) && !(rs[i-1] == '*' && rs[i] == '/'); i++ {
+			}
+		case ch == ';':
+			add(i)
+			start, code = i+1, false
+		case !isWhitespace(ch):
+			code = true
+		}
+	}
+	add(len(rs))
+	return stmts, starts
+}
+
 // IsRegexOp returns true if the operator accepts a regex operand.
 func IsRegexOp(t Token) bool {
 	return (t == EQREGEX || t == NEQREGEX)
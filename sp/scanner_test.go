@@ -73,14 +73,34 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `test"`, tok: sp.BADSTRING, lit: "", pos: sp.Pos{Line: 0, Char: 3}},
 		{s: `"test`, tok: sp.BADSTRING, lit: `test`},
 
+		{s: "`geo.location`", tok: sp.IDENT, lit: `geo.location`},
+		{s: "`my-index-*`", tok: sp.IDENT, lit: `my-index-*`},
+		{s: "`a``b`", tok: sp.IDENT, lit: "a`b"},
+		{s: "`test", tok: sp.BADSTRING, lit: `test`},
+		{s: `"my-field"`, tok: sp.IDENT, lit: `my-field`},
+		{s: `"a""b"`, tok: sp.IDENT, lit: `a"b`},
+		{s: `"select"`, tok: sp.IDENT, lit: `select`},
+
+		// Placeholders
+		{s: `?`, tok: sp.BOUNDPARAM},
+		{s: `:name`, tok: sp.BOUNDPARAM, lit: `name`},
+		{s: `:1`, tok: sp.ILLEGAL, lit: `:`},
+
+		// Comments
+		{s: `-- note`, tok: sp.COMMENT, lit: ` note`},
+		{s: "--a\nb", tok: sp.COMMENT, lit: `a`},
+		{s: `/* block */`, tok: sp.COMMENT, lit: ` block `},
+		{s: "/*+ timeout(5s)\n **/", tok: sp.COMMENT, lit: "+ timeout(5s)\n *"},
+		{s: `/* open`, tok: sp.ILLEGAL, lit: `/* open`},
+		{s: `-1`, tok: sp.SUB},
+
 		{s: `true`, tok: sp.TRUE},
 		{s: `false`, tok: sp.FALSE},
 
 		// Strings
-		{s: `"foo"`, tok: sp.STRING, lit: `foo`},
-		{s: `"foo\\bar"`, tok: sp.STRING, lit: `foo\bar`},
-		{s: `"foo\bar"`, tok: sp.BADESCAPE, lit: `\b`, pos: sp.Pos{Line: 0, Char: 5}},
-		{s: `"foo\"bar\""`, tok: sp.STRING, lit: `foo"bar"`},
+		{s: `'foo'`, tok: sp.STRING, lit: `foo`},
+		{s: `'foo\bar'`, tok: sp.BADESCAPE, lit: `\b`, pos: sp.Pos{Line: 0, Char: 5}},
+		{s: `'foo\'bar\''`, tok: sp.STRING, lit: `foo'bar'`},
 		{s: `'testing 123!'`, tok: sp.STRING, lit: `testing 123!`},
 		{s: `'foo\nbar'`, tok: sp.STRING, lit: "foo\nbar"},
 		{s: `'foo\\bar'`, tok: sp.STRING, lit: "foo\\bar"},
@@ -91,6 +111,7 @@ func TestScanner_Scan(t *testing.T) {
 		// Numbers
 		{s: `100`, tok: sp.INTEGER, lit: `100`},
 		{s: `10.3`, tok: sp.NUMBER, lit: `10.3`},
+		{s: `2017.*`, tok: sp.INTEGER, lit: `2017`},
 		// Keywords
 		{s: `AS`, tok: sp.AS},
 		{s: `ASC`, tok: sp.ASC},
@@ -119,6 +140,36 @@ func TestScanner_Scan(t *testing.T) {
 	}
 }
 
+// Ensure index patterns, quoted identifiers and comments scan into the expected tokens.
+func TestScanner_Scan_IndexPattern(t *testing.T) {
+	type result struct {
+		tok sp.Token
+		lit string
+	}
+	exp := []result{
+		{sp.FROM, ""}, {sp.WS, " "},
+		{sp.IDENT, "logstash"}, {sp.SUB, ""}, {sp.NUMBER, "2017.01"}, {sp.DOT, ""}, {sp.MUL, ""},
+		{sp.COMMA, ""}, {sp.IDENT, "my-index"}, {sp.WS, " "},
+		{sp.COMMENT, " where is \"it\""}, {sp.WS, "\n"},
+		{sp.WHERE, ""}, {sp.WS, " "}, {sp.IDENT, "@timestamp"}, {sp.COMMENT, "x"}, {sp.GT, ""}, {sp.INTEGER, "0"},
+		{sp.EOF, ""},
+	}
+
+	v := "FROM logstash-2017.01.*,`my-index` -- where is \"it\"\nWHERE @timestamp/*x*/>0"
+	s := sp.NewScanner(strings.NewReader(v))
+	var act []result
+	for {
+		tok, _, lit := s.Scan()
+		act = append(act, result{tok, lit})
+		if tok == sp.EOF {
+			break
+		}
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Fatalf("token mismatch:\n\nexp=%v\n\ngot=%v", exp, act)
+	}
+}
+
 // Ensure the scanner can scan a series of tokens correctly.
 func TestScanner_Scan_Multi(t *testing.T) {
 	type result struct {
@@ -253,3 +304,55 @@ func TestScanRegex(t *testing.T) {
 		}
 	}
 }
+
+// Ensure statements are split at the semicolons ending them.
+func TestSplitStatements(t *testing.T) {
+	var tests = []struct {
+		s     string
+		stmts []string
+	}{
+		{s: ``, stmts: nil},
+		{s: `select a from b`, stmts: []string{`select a from b`}},
+		{s: "select a from b;\n\nselect c from d;\n", stmts: []string{`select a from b`, `select c from d`}},
+		{s: `select a from b where c = 'x;y' and "d;" = 1; select 1 from e`, stmts: []string{`select a from b where c = 'x;y' and "d;" = 1`, `select 1 from e`}},
+		{s: `select 'it''s; fine', ` + "`a;b`" + ` from c`, stmts: []string{`select 'it''s; fine', ` + "`a;b`" + ` from c`}},
+		{s: `select 'a\';b' from c`, stmts: []string{`select 'a\';b' from c`}},
+		{s: "-- panel 1; old\nselect a from b; /* done; */ ;\n-- trailing", stmts: []string{"-- panel 1; old\nselect a from b"}},
+	}
+
+	for i, tt := range tests {
+		if stmts := sp.SplitStatements(tt.s); !reflect.DeepEqual(stmts, tt.stmts) {
+			t.Errorf("%d. %q: statements mismatch:\n  exp=%q\n  got=%q\n\n", i, tt.s, tt.stmts, stmts)
+		}
+	}
+}
+
+// Ensure errors of split statements are located in the source they were split from.
+func TestSplitStatementsPos(t *testing.T) {
+	src := "select a from b;\n\n-- second\nselect c\nfrom d;  select e from f\nwhere g = ;"
+	stmts, starts := sp.SplitStatementsPos(src)
+	if exp := []sp.Pos{{Line: 0, Char: 0}, {Line: 2, Char: 0}, {Line: 4, Char: 9}}; !reflect.DeepEqual(starts, exp) {
+		t.Fatalf("positions mismatch:\n  exp=%v\n  got=%v", exp, starts)
+	}
+
+	var tests = []struct {
+		stmt int
+		err  string
+	}{
+		{stmt: 1, err: `found form, expected FROM at line 5, char 1, did you mean FROM?`},
+		{stmt: 2, err: `found EOF, expected identifier, string, number, bool at line 6, char 10`},
+	}
+	stmts[1] = strings.Replace(stmts[1], "from", "form", 1)
+	for i, tt := range tests {
+		_, err := sp.NewParser(strings.NewReader(stmts[tt.stmt])).ParseStatement()
+		e, ok := err.(*sp.ParseError)
+		if !ok {
+			t.Errorf("%d. expected parse error, got %v", i, err)
+			continue
+		}
+		e.Offset(starts[tt.stmt])
+		if e.Error() != tt.err {
+			t.Errorf("%d. error mismatch:\n  exp=%s\n  got=%s", i, tt.err, e)
+		}
+	}
+}
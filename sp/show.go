@@ -0,0 +1,243 @@
+package sp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ShowTablesStatement lists the indices and aliases, SHOW TABLES [LIKE pattern].
+type ShowTablesStatement struct {
+	// Pattern is the LIKE pattern of the names, % matches any characters
+	// and _ one character. Empty lists every index.
+	Pattern string
+}
+
+// String returns a string representation of the statement.
+func (s *ShowTablesStatement) String() string {
+	if s.Pattern == "" {
+		return "SHOW TABLES"
+	}
+	return "SHOW TABLES LIKE " + QuoteString(s.Pattern)
+}
+
+// DescribeStatement lists the fields of the mapping of an index, DESCRIBE index.
+type DescribeStatement struct {
+	Source *Measurement
+}
+
+// String returns a string representation of the statement.
+func (s *DescribeStatement) String() string {
+	return "DESCRIBE " + s.Source.String()
+}
+
+// ShowColumnsStatement lists the field capabilities of an index,
+// SHOW COLUMNS FROM index.
+type ShowColumnsStatement struct {
+	Source *Measurement
+}
+
+// String returns a string representation of the statement.
+func (s *ShowColumnsStatement) String() string {
+	return "SHOW COLUMNS FROM " + s.Source.String()
+}
+
+// parseShowStatement parses "SHOW TABLES [LIKE 'pattern']" or
+// "SHOW COLUMNS FROM index", the SHOW token has already been consumed.
+func (p *Parser) parseShowStatement() (Statement, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch {
+	case tok == IDENT && strings.EqualFold(lit, "tables"):
+		stmt := &ShowTablesStatement{}
+		if tok, _, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.EqualFold(lit, "like") {
+			pattern, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Pattern = pattern
+		} else {
+			p.unscan()
+		}
+		return stmt, p.parseEnd()
+	case tok == IDENT && strings.EqualFold(lit, "columns"):
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+			return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+		}
+		src, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		return &ShowColumnsStatement{Source: src}, p.parseEnd()
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"TABLES", "COLUMNS"}, pos)
+}
+
+// parseDescribeStatement parses "DESCRIBE index", the DESCRIBE or DESC
+// token has already been consumed.
+func (p *Parser) parseDescribeStatement() (Statement, error) {
+	src, err := p.parseSource()
+	if err != nil {
+		return nil, err
+	}
+	return &DescribeStatement{Source: src}, p.parseEnd()
+}
+
+// parseEnd checks the statement ends at a semicolon or EOF.
+func (p *Parser) parseEnd() error {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	p.unscan()
+	if tok != EOF && tok != SEMICOLON {
+		return newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
+	}
+	return nil
+}
+
+// Table types of the rows of SHOW TABLES.
+const (
+	TableIndex = "index"
+	TableAlias = "alias"
+)
+
+// translate lists the indices and the aliases matching the pattern with
+// _cat requests, the names are filtered again with the exact LIKE pattern.
+func (s *ShowTablesStatement) translate() (*Translation, error) {
+	pattern := strings.NewReplacer("%", "*", "_", "*").Replace(s.Pattern)
+	var like *regexp.Regexp
+	if s.Pattern != "" {
+		like = likeRegexp(s.Pattern)
+	}
+	suffix := ""
+	if pattern != "" {
+		suffix = "/" + url.PathEscape(pattern)
+	}
+	t := &Translation{
+		Index:     pattern,
+		Columns:   []*Column{{Name: "name"}, {Name: "type"}, {Name: "index"}},
+		statement: "SHOW TABLES",
+		Requests: []*Request{
+			{Method: "GET", Path: "/_cat/indices" + suffix + "?format=json&h=index"},
+			{Method: "GET", Path: "/_cat/aliases" + suffix + "?format=json&h=alias,index"},
+		},
+	}
+	t.rows = func(resps [][]byte) ([][]interface{}, error) {
+		var indices []struct {
+			Index string `json:"index"`
+		}
+		var aliases []struct {
+			Alias string `json:"alias"`
+			Index string `json:"index"`
+		}
+		if err := json.Unmarshal(resps[0], &indices); err != nil {
+			return nil, fmt.Errorf("invalid _cat/indices response, %s", err)
+		}
+		if err := json.Unmarshal(resps[1], &aliases); err != nil {
+			return nil, fmt.Errorf("invalid _cat/aliases response, %s", err)
+		}
+		var rows [][]interface{}
+		for _, i := range indices {
+			if like == nil || like.MatchString(i.Index) {
+				rows = append(rows, []interface{}{i.Index, TableIndex, i.Index})
+			}
+		}
+		for _, a := range aliases {
+			if like == nil || like.MatchString(a.Alias) {
+				rows = append(rows, []interface{}{a.Alias, TableAlias, a.Index})
+			}
+		}
+		sortRows(rows)
+		return rows, nil
+	}
+	return t, nil
+}
+
+// translate reads the mapping of the index, a row for every field.
+func (s *DescribeStatement) translate() (*Translation, error) {
+	t := &Translation{
+		Index:     s.Source.Database,
+		Columns:   []*Column{{Name: "name"}, {Name: "type"}},
+		statement: "DESCRIBE",
+		Requests:  []*Request{{Method: "GET", Path: "/" + url.PathEscape(s.Source.Database) + "/_mapping"}},
+	}
+	t.rows = func(resps [][]byte) ([][]interface{}, error) {
+		fields, err := ParseMapping(resps[0])
+		if err != nil {
+			return nil, err
+		}
+		rows := make([][]interface{}, 0, len(fields))
+		for _, f := range fields {
+			rows = append(rows, []interface{}{f.Name, f.Type})
+		}
+		return rows, nil
+	}
+	return t, nil
+}
+
+// translate reads the field capabilities of the index, a row for every
+// type of a field, metadata fields are skipped.
+func (s *ShowColumnsStatement) translate() (*Translation, error) {
+	t := &Translation{
+		Index:     s.Source.Database,
+		Columns:   []*Column{{Name: "name"}, {Name: "type"}, {Name: "searchable"}, {Name: "aggregatable"}},
+		statement: "SHOW COLUMNS",
+		Requests:  []*Request{{Method: "GET", Path: "/" + url.PathEscape(s.Source.Database) + "/_field_caps?fields=*"}},
+	}
+	t.rows = func(resps [][]byte) ([][]interface{}, error) {
+		var caps struct {
+			Fields map[string]map[string]struct {
+				Type         string `json:"type"`
+				Searchable   bool   `json:"searchable"`
+				Aggregatable bool   `json:"aggregatable"`
+			} `json:"fields"`
+		}
+		if err := json.Unmarshal(resps[0], &caps); err != nil {
+			return nil, fmt.Errorf("invalid _field_caps response, %s", err)
+		}
+		var rows [][]interface{}
+		for name, types := range caps.Fields {
+			if strings.HasPrefix(name, "_") {
+				continue
+			}
+			for _, c := range types {
+				rows = append(rows, []interface{}{name, c.Type, c.Searchable, c.Aggregatable})
+			}
+		}
+		sortRows(rows)
+		return rows, nil
+	}
+	return t, nil
+}
+
+// likeRegexp returns the regexp matching the whole names of a LIKE pattern.
+func likeRegexp(pattern string) *regexp.Regexp {
+	var buf bytes.Buffer
+	buf.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			buf.WriteString(".*")
+		case '_':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.MustCompile(buf.String())
+}
+
+// sortRows sorts rows of strings by their first columns.
+func sortRows(rows [][]interface{}) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k := 0; k < 2; k++ {
+			a, b := rows[i][k].(string), rows[j][k].(string)
+			if a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,176 @@
+package sp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// defaultSubqueryMaxTerms is the default of index.max_terms_count, the most
+// values elasticsearch accepts in a terms query.
+const defaultSubqueryMaxTerms = 65536
+
+// Subquery is an IN condition whose values are the rows of another search,
+// which the executor runs before the search of the statement.
+type Subquery struct {
+	// Field is the field matched against the values.
+	Field string `json:"field"`
+	// Search is the inner search, the values are its first column.
+	Search *Translation `json:"search"`
+	// MaxTerms is the most values inlined, set by the subquery_max_terms
+	// hint of the outer statement.
+	MaxTerms int `json:"max_terms"`
+
+	// terms is the terms query of the outer search holding the values,
+	// inlined is set once they are.
+	terms   map[string]interface{}
+	inlined bool
+}
+
+// Inline adds the first column of rows, rows of its search, to the values of
+// the subquery, null values are skipped. It may be called with every page of
+// the search, and fails once there are more than MaxTerms values.
+func (q *Subquery) Inline(rows [][]interface{}) error {
+	values := q.terms[q.Field].([]interface{})
+	for _, row := range rows {
+		if len(row) == 0 || row[0] == nil {
+			continue
+		}
+		if len(values) >= q.MaxTerms {
+			return fmt.Errorf("subquery of %s has more than %d values, raise the subquery_max_terms hint and index.max_terms_count", q.Field, q.MaxTerms)
+		}
+		values = append(values, row[0])
+	}
+	q.terms[q.Field] = values
+	q.inlined = true
+	return nil
+}
+
+// subqueryCondition returns expr if it is a field IN or NOT IN a subquery.
+func subqueryCondition(expr Expr) (*BinaryExpr, bool) {
+	e, ok := expr.(*BinaryExpr)
+	if !ok || !IsListOp(e.Op) {
+		return nil, false
+	}
+	_, ok = e.RHS.(*SubqueryExpr)
+	return e, ok
+}
+
+// subqueries returns the statements of the IN subqueries of the condition.
+func (s *SelectStatement) subqueries() []*SelectStatement {
+	var stmts []*SelectStatement
+	WalkFunc(s.Condition, func(n Node) {
+		if e, ok := n.(*SubqueryExpr); ok {
+			stmts = append(stmts, e.Statement)
+		}
+	})
+	return stmts
+}
+
+// validateSubqueries checks the IN subqueries are top level AND conditions
+// on a field and select one column.
+func validateSubqueries(cond Expr) error {
+	for _, c := range conjuncts(cond) {
+		e, ok := subqueryCondition(c)
+		if !ok {
+			var err error
+			WalkFunc(c, func(n Node) {
+				if e, ok := n.(*SubqueryExpr); ok && err == nil {
+					err = errorAt(e, fmt.Errorf("IN (SELECT ...) must be used as a top level AND condition"))
+				}
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := e.LHS.(*VarRef); !ok {
+			return errorAt(e.LHS, fmt.Errorf("IN (SELECT ...) expects a field on its left, got %s", e.LHS))
+		}
+		sub := e.RHS.(*SubqueryExpr)
+		fields := sub.Statement.Fields
+		if _, ok := fields[0].Expr.(*Wildcard); len(fields) != 1 || ok {
+			return errorAt(sub, fmt.Errorf("subquery must select exactly one column, got %s", fields))
+		}
+	}
+	return nil
+}
+
+// subqueryFilters returns the terms queries of the IN subqueries of the
+// condition, and the subqueries whose values the executor inlines into them.
+func (s *SelectStatement) subqueryFilters() ([]interface{}, []*Subquery, error) {
+	var filters []interface{}
+	var subqueries []*Subquery
+	maxTerms := defaultSubqueryMaxTerms
+	if arg, ok := s.Hints["subquery_max_terms"]; ok {
+		maxTerms, _ = strconv.Atoi(arg)
+	}
+	for _, cond := range conjuncts(s.Condition) {
+		e, ok := subqueryCondition(cond)
+		if !ok {
+			continue
+		}
+		field := e.LHS.(*VarRef).Val
+		stmt := e.RHS.(*SubqueryExpr).Statement
+		terms := make(map[string]interface{})
+		if lookup, ok := termsLookup(stmt); ok {
+			terms[field] = lookup
+		} else {
+			if stmt.IsRawQuery && len(stmt.Dimensions) == 0 && stmt.Limit == 0 {
+				// a raw search without LIMIT has a size of 0, read every
+				// hit as LIMIT ALL does
+				all := *stmt
+				all.LimitAll = true
+				stmt = &all
+			}
+			t, err := stmt.translate()
+			if err != nil {
+				return nil, nil, err
+			}
+			terms[field] = []interface{}{}
+			subqueries = append(subqueries, &Subquery{Field: field, Search: t, MaxTerms: maxTerms, terms: terms})
+		}
+		var query interface{} = map[string]interface{}{"terms": terms}
+		if path := DefaultSchema.NestedPath(field); path != "" {
+			query = nestedQuery(path, query)
+		}
+		if e.Op == NI {
+			query = map[string]interface{}{"bool": map[string]interface{}{"must_not": query}}
+		}
+		filters = append(filters, query)
+	}
+	return filters, subqueries, nil
+}
+
+// termsLookup returns the terms lookup of a subquery reading the values of
+// one document, SELECT path FROM index WHERE _id = 'id'.
+func termsLookup(s *SelectStatement) (map[string]interface{}, bool) {
+	path, ok := s.Fields[0].Expr.(*VarRef)
+	if !ok || len(s.Sources) != 1 || len(s.Dimensions) > 0 || !s.IsRawQuery {
+		return nil, false
+	}
+	cond, ok := s.Condition.(*BinaryExpr)
+	if !ok || cond.Op != EQ {
+		return nil, false
+	}
+	if ref, ok := cond.LHS.(*VarRef); !ok || ref.Val != "_id" {
+		return nil, false
+	}
+	rhs := cond.RHS
+	if p, ok := rhs.(*BoundParameter); ok {
+		rhs = p.Value
+	}
+	var id string
+	switch lit := rhs.(type) {
+	case *StringLiteral:
+		id = lit.Val
+	case *IntegerLiteral:
+		id = strconv.FormatInt(lit.Val, 10)
+	default:
+		return nil, false
+	}
+	return map[string]interface{}{
+		"index": s.index(),
+		"id":    id,
+		"path":  path.Val,
+	}, true
+}
@@ -0,0 +1,196 @@
+package sp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// nodeError is a validation error caused by a node of a statement,
+// the parser turns it into a ParseError at the node position.
+type nodeError struct {
+	node       Node
+	msg        string
+	suggestion string
+}
+
+func (e *nodeError) Error() string {
+	if e.suggestion != "" {
+		return fmt.Sprintf("%s, did you mean %s?", e.msg, e.suggestion)
+	}
+	return e.msg
+}
+
+// errorAt attaches err to node, errors already attached to a deeper node are kept.
+func errorAt(node Node, err error) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case *nodeError, *ParseError:
+		return err
+	}
+	return &nodeError{node: node, msg: err.Error()}
+}
+
+// suggestFunction sets the closest of candidates to name as the suggestion of
+// err, or else the closest of every function, usable elsewhere.
+func suggestFunction(err error, name string, candidates []string) error {
+	if e, ok := err.(*nodeError); ok {
+		s := closest(name, candidates)
+		if s == "" {
+			s = closest(name, FunctionNames())
+		}
+		if s != "" {
+			e.suggestion = s + "()"
+		}
+	}
+	return err
+}
+
+// aggregateFunctions returns the functions usable as select fields.
+func aggregateFunctions() []string {
+	return functionNames(MetricFunction, PipelineFunction)
+}
+
+func isAggregateFunction(name string) bool {
+	return containsString(aggregateFunctions(), name)
+}
+
+// conditionFunctions returns the functions usable as WHERE conditions.
+func conditionFunctions() []string {
+	return functionNames(PredicateFunction)
+}
+
+// validateFunctions checks the statement only calls functions the translator knows.
+func (s *SelectStatement) validateFunctions() error {
+	for _, f := range s.Fields {
+		for _, c := range aggregateCalls(f.Expr) {
+			if !isAggregateFunction(c.Name) {
+				err := fmt.Errorf("unknown aggregate function %s()", c.Name)
+				return suggestFunction(errorAt(c, err), c.Name, append(aggregateFunctions(), scalarNames()...))
+			}
+		}
+	}
+
+	names := functionNames(BucketFunction, ScalarFunction)
+	var err error
+	for _, d := range s.Dimensions {
+		WalkFunc(d.Expr, func(n Node) {
+			if c, ok := n.(*Call); ok && err == nil && !containsString(names, c.Name) {
+				err = suggestFunction(errorAt(c, fmt.Errorf("unknown group by function %s()", c.Name)), c.Name, names)
+			}
+		})
+	}
+	if err != nil {
+		return err
+	}
+	for _, sub := range s.subqueries() {
+		if err := sub.validateFunctions(); err != nil {
+			return err
+		}
+	}
+	if s.Union != nil {
+		for _, stmt := range s.Union.Statements {
+			if err := stmt.validateFunctions(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Keywords returns the reserved words of the language, upper cased and sorted.
+func Keywords() []string {
+	var names []string
+	for name := range keywords {
+		names = append(names, strings.ToUpper(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FunctionNames returns the names of the functions the translator knows, sorted.
+func FunctionNames() []string {
+	names := append(functionNames(MetricFunction, BucketFunction, PipelineFunction, ScalarFunction, PredicateFunction), "cast")
+	sort.Strings(names)
+	return names
+}
+
+// clauseKeywords are suggested for misspelled words between clauses.
+var clauseKeywords = []string{"SELECT", "FROM", "JOIN", "ON", "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "UNION", "AND", "OR", "AS", "ASC", "DESC", "BY"}
+
+// suggestKeyword returns the keyword probably meant by found, preferring the
+// expected keywords.
+func suggestKeyword(found string, expected []string) string {
+	if found == "" || Lookup(found) != IDENT || strings.IndexFunc(found, func(r rune) bool { return !isLetter(r) }) >= 0 {
+		return ""
+	}
+	var candidates []string
+	for _, e := range expected {
+		if Lookup(e) != IDENT {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = clauseKeywords
+	}
+	s := closest(strings.ToUpper(found), candidates)
+	if s == "GROUP" || s == "ORDER" {
+		s += " BY"
+	}
+	return s
+}
+
+// closest returns the candidate nearest to word by edit distance, or "" if
+// none is close enough to be a typo.
+func closest(word string, candidates []string) string {
+	max := 1
+	if len(word) > 4 {
+		max = 2
+	}
+	best, bestDist := "", max+1
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(word), strings.ToLower(c))
+		if d < bestDist && d < len(word) {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the optimal string alignment distance of a and b,
+// the levenshtein distance counting adjacent transpositions as one edit.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,820 @@
+package sp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Target is an output syntax of the translator.
+type Target int
+
+const (
+	// TargetDSL is the elasticsearch query dsl.
+	TargetDSL Target = iota
+	// TargetSQL is a request body of the elasticsearch _sql endpoint.
+	TargetSQL
+	// TargetESQL is an ES|QL pipe query.
+	TargetESQL
+	// TargetMSearch is an _msearch payload of ;-separated statements.
+	TargetMSearch
+)
+
+var targets = [...]string{
+	TargetDSL:     "dsl",
+	TargetSQL:     "sql",
+	TargetESQL:    "esql",
+	TargetMSearch: "msearch",
+}
+
+// String returns the name of the target.
+func (t Target) String() string {
+	if t >= 0 && int(t) < len(targets) {
+		return targets[t]
+	}
+	return fmt.Sprintf("target(%d)", int(t))
+}
+
+// ParseTarget returns the target of name, the dsl if name is empty.
+func ParseTarget(name string) (Target, error) {
+	if name == "" {
+		return TargetDSL, nil
+	}
+	for i, t := range targets {
+		if strings.EqualFold(t, name) {
+			return Target(i), nil
+		}
+	}
+	return TargetDSL, fmt.Errorf("unknown target %s, expected one of %s", name, strings.Join(targets[:], ", "))
+}
+
+// Translate translates a select statement into the target syntax.
+func Translate(sql string, target Target) (string, error) {
+	return TranslateParams(sql, target, nil)
+}
+
+// TranslateParams translates a select statement into the target, binding
+// params to the placeholders of the statement.
+func TranslateParams(sql string, target Target, params Params) (string, error) {
+	if target == TargetMSearch {
+		ts, err := TranslateDSLStatements(sql, params)
+		if err != nil {
+			return "", err
+		}
+		return MSearch(ts)
+	}
+	stmt, p, err := parseStatement(sql, params)
+	if err != nil {
+		return "", err
+	}
+	s, ok := stmt.(*SelectStatement)
+	if !ok {
+		t, err := stmt.translate()
+		if err != nil {
+			return "", p.locate(err)
+		}
+		if target != TargetDSL {
+			return "", p.locate(errorAt(stmt, fmt.Errorf("%s is not supported by the %s target", t.statement, target)))
+		}
+		return t.JSON()
+	}
+	if target != TargetDSL {
+		switch {
+		case len(s.Hints) > 0:
+			err = errorAt(clause{s, COMMENT}, fmt.Errorf("hints are not supported by the %s target", target))
+		case s.Join != nil:
+			err = errorAt(clause{s, JOIN}, fmt.Errorf("JOIN is not supported by the %s target", target))
+		case s.Explain:
+			err = errorAt(clause{s, EXPLAIN}, fmt.Errorf("EXPLAIN is not supported by the %s target", target))
+		case s.Union != nil:
+			err = errorAt(clause{s.Union, UNION}, fmt.Errorf("UNION ALL is not supported by the %s target", target))
+		}
+		if err != nil {
+			return "", p.locate(err)
+		}
+	}
+	var out string
+	switch target {
+	case TargetDSL:
+		out, err = s.dsl()
+	case TargetSQL:
+		out, err = s.esSQL()
+	case TargetESQL:
+		out, err = s.esql()
+	default:
+		return "", fmt.Errorf("unknown target %s", target)
+	}
+	return out, p.locate(err)
+}
+
+// esSQL returns the _sql request body of the statement.
+func (s *SelectStatement) esSQL() (string, error) {
+	e := &emitter{
+		target: TargetSQL,
+		ident:  sqlIdent,
+		str:    sqlString,
+		eq:     "=",
+	}
+	var buf bytes.Buffer
+	cols, err := e.columns(s)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, c := range cols {
+		item := c.expr
+		if c.alias != "" {
+			item += " AS " + e.ident(c.alias)
+		}
+		items = append(items, item)
+	}
+	fmt.Fprintf(&buf, "SELECT %s FROM %s", strings.Join(items, ", "), e.sources(s))
+
+	if s.Condition != nil {
+		cond, err := e.expr(s.Condition)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, " WHERE %s", cond)
+	}
+	if len(s.Dimensions) > 0 {
+		var groups []string
+		for _, d := range s.Dimensions {
+			if d.Alias != "" {
+				groups = append(groups, e.ident(d.Alias))
+				continue
+			}
+			g, err := e.bucket(d.Expr)
+			if err != nil {
+				return "", err
+			}
+			groups = append(groups, g)
+		}
+		fmt.Fprintf(&buf, " GROUP BY %s", strings.Join(groups, ", "))
+	}
+	if s.Having != nil {
+		// aliases of the select list are resolved to their aggregations
+		having, err := e.expr(replaceRefs(s.Having, func(ref *VarRef) Expr {
+			for _, f := range s.Fields {
+				if f.Alias == ref.Val {
+					return f.Expr
+				}
+			}
+			return ref
+		}))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, " HAVING %s", having)
+	}
+	if err := e.tail(&buf, s, " ORDER BY"); err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(map[string]interface{}{"query": buf.String()}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(body.String()), nil
+}
+
+// esql returns the ES|QL query of the statement.
+func (s *SelectStatement) esql() (string, error) {
+	e := &emitter{
+		target: TargetESQL,
+		ident:  esqlIdent,
+		str:    esqlString,
+		eq:     "==",
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "FROM %s", e.sources(s))
+
+	if s.Condition != nil {
+		cond, err := e.expr(s.Condition)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "\n| WHERE %s", cond)
+	}
+
+	cols, err := e.columns(s)
+	if err != nil {
+		return "", err
+	}
+	if !s.IsRawQuery {
+		var stats, by []string
+		for _, c := range cols {
+			switch {
+			case c.dim != nil:
+				if c.expr == e.ident(c.name) {
+					by = append(by, c.expr)
+				} else {
+					// computed groups are named, KEEP refers to them by name
+					by = append(by, fmt.Sprintf("%s = %s", e.ident(c.name), c.expr))
+				}
+			default:
+				stats = append(stats, fmt.Sprintf("%s = %s", e.ident(c.name), c.expr))
+			}
+		}
+
+		// aggregates are out of scope after STATS, HAVING refers to the
+		// columns computing them, unselected ones are computed but not kept
+		var having string
+		if s.Having != nil {
+			cond, err := replaceAggregates(s.Having, func(c *Call) (Expr, error) {
+				name := (&Field{Expr: c}).metricAggName()
+				for _, f := range s.Fields {
+					if f.Expr.String() == c.String() {
+						return &VarRef{Val: f.metricAggName()}, nil
+					}
+				}
+				agg, err := e.aggregate(c)
+				if err != nil {
+					return nil, err
+				}
+				stat := fmt.Sprintf("%s = %s", e.ident(name), agg)
+				if !containsString(stats, stat) {
+					stats = append(stats, stat)
+				}
+				return &VarRef{Val: name}, nil
+			})
+			if err != nil {
+				return "", err
+			}
+			if having, err = e.expr(cond); err != nil {
+				return "", err
+			}
+		}
+
+		fmt.Fprintf(&buf, "\n| STATS %s", strings.Join(stats, ", "))
+		if len(by) > 0 {
+			fmt.Fprintf(&buf, " BY %s", strings.Join(by, ", "))
+		}
+		if having != "" {
+			fmt.Fprintf(&buf, "\n| WHERE %s", having)
+		}
+	} else {
+		// computed columns of raw queries, e.g. CASE, are evaluated per row
+		var evals []string
+		for _, c := range cols {
+			if c.expr != "*" && c.expr != e.ident(c.name) {
+				evals = append(evals, fmt.Sprintf("%s = %s", e.ident(c.name), c.expr))
+			}
+		}
+		if len(evals) > 0 {
+			fmt.Fprintf(&buf, "\n| EVAL %s", strings.Join(evals, ", "))
+		}
+	}
+	if err := e.tail(&buf, s, "\n| SORT"); err != nil {
+		return "", err
+	}
+
+	var keep []string
+	for _, c := range cols {
+		if c.expr == "*" {
+			keep = nil
+			break
+		}
+		keep = append(keep, e.ident(c.name))
+	}
+	if len(keep) > 0 {
+		fmt.Fprintf(&buf, "\n| KEEP %s", strings.Join(keep, ", "))
+	}
+	return buf.String(), nil
+}
+
+// replaceAggregates returns a copy of the expression with the aggregate
+// calls replaced by the result of fn.
+func replaceAggregates(expr Expr, fn func(*Call) (Expr, error)) (Expr, error) {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		lhs, err := replaceAggregates(e.LHS, fn)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := replaceAggregates(e.RHS, fn)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: e.Op, LHS: lhs, RHS: rhs}, nil
+	case *ParenExpr:
+		inner, err := replaceAggregates(e.Expr, fn)
+		if err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: inner}, nil
+	case *Call:
+		if !isScalarCall(e) {
+			return fn(e)
+		}
+		c := &Call{Name: e.Name, Options: e.Options}
+		for _, arg := range e.Args {
+			a, err := replaceAggregates(arg, fn)
+			if err != nil {
+				return nil, err
+			}
+			c.Args = append(c.Args, a)
+		}
+		return c, nil
+	}
+	return expr, nil
+}
+
+// emitter writes expressions in the syntax of a target.
+type emitter struct {
+	target Target
+	ident  func(string) string
+	str    func(string) string
+	eq     string
+}
+
+// column is an output column of a translated statement.
+type column struct {
+	// name of the column in the results.
+	name string
+	// alias to declare, if any.
+	alias string
+	expr  string
+	dim   *Dimension
+}
+
+// columns returns the output columns of the statement in select order,
+// group by dimensions missing from the select list come first as they are
+// always part of the dsl results.
+func (e *emitter) columns(s *SelectStatement) ([]*column, error) {
+	var cols []*column
+	used := make(map[*Dimension]bool)
+	dimColumn := func(d *Dimension) (*column, error) {
+		used[d] = true
+		expr, err := e.bucket(d.Expr)
+		if err != nil {
+			return nil, err
+		}
+		name := d.Alias
+		if name == "" {
+			name = cleanDocString(d.Expr.String())
+		}
+		return &column{name: name, alias: d.Alias, expr: expr, dim: d}, nil
+	}
+
+	for _, f := range s.Fields {
+		var d *Dimension
+		if ref, ok := f.Expr.(*VarRef); ok {
+			d = s.dimension(ref.Val)
+		} else if isComputed(f.Expr) {
+			d = s.fieldDimension(f)
+		}
+		if d != nil {
+			c, err := dimColumn(d)
+			if err != nil {
+				return nil, err
+			}
+			cols = append(cols, c)
+			continue
+		}
+		expr, err := e.expr(f.Expr)
+		if err != nil {
+			return nil, err
+		}
+		c := &column{alias: f.Alias, expr: expr}
+		switch f.Expr.(type) {
+		case *Call:
+			if isScalarCall(f.Expr) {
+				c.name = cleanDocString(f.String())
+				if f.Alias != "" {
+					c.name = f.Alias
+				}
+				break
+			}
+			c.name = f.metricAggName()
+		case *VarRef, *Wildcard:
+			c.name = f.Name()
+		default:
+			c.name = cleanDocString(f.String())
+			if f.Alias != "" {
+				c.name = f.Alias
+			}
+		}
+		cols = append(cols, c)
+	}
+
+	var dims []*column
+	for _, d := range s.Dimensions {
+		if used[d] {
+			continue
+		}
+		c, err := dimColumn(d)
+		if err != nil {
+			return nil, err
+		}
+		dims = append(dims, c)
+	}
+	return append(dims, cols...), nil
+}
+
+// fieldDimension returns the group by dimension computing the same
+// expression as the field f.
+func (s *SelectStatement) fieldDimension(f *Field) *Dimension {
+	expr := cleanDocString(f.Expr.String())
+	for _, d := range s.Dimensions {
+		if cleanDocString(d.Expr.String()) == expr {
+			return d
+		}
+	}
+	return nil
+}
+
+// dimension returns the group by dimension named name.
+func (s *SelectStatement) dimension(name string) *Dimension {
+	for _, d := range s.Dimensions {
+		if d.Alias == name || (d.Alias == "" && cleanDocString(d.Expr.String()) == name) {
+			return d
+		}
+	}
+	return nil
+}
+
+func (e *emitter) sources(s *SelectStatement) string {
+	var names []string
+	for _, src := range s.Sources {
+		if m, ok := src.(*Measurement); ok {
+			names = append(names, e.ident(m.Database))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// tail writes the sort fields and the limit of the statement.
+func (e *emitter) tail(buf *bytes.Buffer, s *SelectStatement, sortKeyword string) error {
+	if len(s.SortFields) > 0 {
+		var items []string
+		for _, sf := range s.SortFields {
+			dir := "ASC"
+			if !sf.Ascending {
+				dir = "DESC"
+			}
+			key := e.ident(sf.Name)
+			if sf.Call != nil {
+				var err error
+				if key, err = e.sortAggregate(s, sf.Call); err != nil {
+					return err
+				}
+			}
+			items = append(items, fmt.Sprintf("%s %s", key, dir))
+		}
+		fmt.Fprintf(buf, "%s %s", sortKeyword, strings.Join(items, ", "))
+	}
+	if s.Offset > 0 {
+		return errorAt(clause{s, LIMIT}, fmt.Errorf("offset is not supported by the %s target", e.target))
+	}
+	// _sql pages every row with a cursor, ES|QL returns at most 10000 rows
+	if s.LimitAll && e.target == TargetESQL {
+		return errorAt(clause{s, LIMIT}, fmt.Errorf("LIMIT ALL is not supported by the %s target", e.target))
+	}
+	if s.Limit > 0 {
+		if e.target == TargetESQL {
+			buf.WriteString("\n|")
+		}
+		fmt.Fprintf(buf, " LIMIT %d", s.Limit)
+	}
+	return nil
+}
+
+// sortAggregate returns the sort key of an ORDER BY aggregate, ES|QL sorts
+// on the column of a selected one.
+func (e *emitter) sortAggregate(s *SelectStatement, c *Call) (string, error) {
+	if e.target == TargetSQL {
+		return e.aggregate(c)
+	}
+	for _, f := range s.Fields {
+		if f.Expr.String() == c.String() {
+			// the name STATS gives the column
+			return e.ident(f.metricAggName()), nil
+		}
+	}
+	return "", errorAt(c, fmt.Errorf("ORDER BY %s is not supported by the %s target unless it is selected", c, e.target))
+}
+
+// bucket returns the grouping expression of a dimension.
+func (e *emitter) bucket(expr Expr) (string, error) {
+	c, ok := expr.(*Call)
+	if !ok {
+		return e.expr(expr)
+	}
+	if len(c.Options) > 0 {
+		return "", errorAt(c, fmt.Errorf("options of %s() are not supported by the %s target", c.Name, e.target))
+	}
+	switch c.Name {
+	case "date_histogram":
+		if len(c.Args) != 2 {
+			return "", errorAt(c, fmt.Errorf("invalid number of arguments for date_histogram, expected 2, got %d", len(c.Args)))
+		}
+		field := strings.Trim(c.Args[0].String(), `'"`)
+		n, unit, err := parseInterval(strings.Trim(c.Args[1].String(), `'"`))
+		if err != nil {
+			return "", errorAt(c.Args[1], err)
+		}
+		if e.target == TargetSQL {
+			// _sql intervals have no weeks and quarters
+			switch unit {
+			case "week":
+				n, unit = n*7, "day"
+			case "quarter":
+				n, unit = n*3, "month"
+			}
+			return fmt.Sprintf("HISTOGRAM(%s, INTERVAL %d %s)", e.ident(field), n, strings.ToUpper(unit)), nil
+		}
+		if n != 1 {
+			unit += "s"
+		}
+		return fmt.Sprintf("BUCKET(%s, %d %s)", e.ident(field), n, unit), nil
+	case "histogram":
+		if len(c.Args) != 2 {
+			return "", errorAt(c, fmt.Errorf("invalid number of arguments for histogram, expected 2, got %d", len(c.Args)))
+		}
+		args, err := e.args(c.Args)
+		if err != nil {
+			return "", err
+		}
+		if e.target == TargetSQL {
+			return fmt.Sprintf("HISTOGRAM(%s)", strings.Join(args, ", ")), nil
+		}
+		return fmt.Sprintf("BUCKET(%s)", strings.Join(args, ", ")), nil
+	case "range":
+		return "", errorAt(c, fmt.Errorf("range() is not supported by the %s target", e.target))
+	}
+	return e.expr(expr)
+}
+
+var intervalUnits = map[string]string{
+	"s": "second", "m": "minute", "h": "hour", "d": "day",
+	"w": "week", "M": "month", "q": "quarter", "y": "year",
+}
+
+var intervalRegexp = regexp.MustCompile(`^(\d+)([smhdwMqy])$`)
+
+// parseInterval returns the length and unit of a date_histogram interval.
+func parseInterval(interval string) (int, string, error) {
+	for _, unit := range intervalUnits {
+		if interval == unit {
+			return 1, unit, nil
+		}
+	}
+	m := intervalRegexp.FindStringSubmatch(interval)
+	if m == nil {
+		return 0, "", fmt.Errorf("invalid date_histogram interval %s", interval)
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n, intervalUnits[m[2]], nil
+}
+
+// aggregate returns the target function of a metric aggregation.
+func (e *emitter) aggregate(c *Call) (string, error) {
+	if len(c.Args) == 0 {
+		return "", errorAt(c, fmt.Errorf("invalid number of arguments for %s, expected 1, got 0", c.Name))
+	}
+	args, err := e.args(c.Args)
+	if err != nil {
+		return "", err
+	}
+	arg := strings.Join(args, ", ")
+	switch c.Name {
+	case "count", "avg", "min", "max", "sum":
+		return fmt.Sprintf("%s(%s)", strings.ToUpper(c.Name), arg), nil
+	case "cardinality":
+		if e.target == TargetSQL {
+			return fmt.Sprintf("COUNT(DISTINCT %s)", arg), nil
+		}
+		return fmt.Sprintf("COUNT_DISTINCT(%s)", arg), nil
+	case "geo_centroid":
+		if e.target == TargetESQL {
+			return fmt.Sprintf("ST_CENTROID_AGG(%s)", arg), nil
+		}
+	}
+	return "", errorAt(c, fmt.Errorf("%s() is not supported by the %s target", c.Name, e.target))
+}
+
+func (e *emitter) args(exprs []Expr) ([]string, error) {
+	var args []string
+	for _, arg := range exprs {
+		a, err := e.expr(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+	}
+	return args, nil
+}
+
+// expr returns the target syntax of an expression.
+func (e *emitter) expr(expr Expr) (string, error) {
+	switch expr := expr.(type) {
+	case *VarRef:
+		return e.ident(cleanDocString(expr.Val)), nil
+	case *Wildcard:
+		return "*", nil
+	case *StringLiteral:
+		return e.str(expr.Val), nil
+	case *IntegerLiteral:
+		return expr.String(), nil
+	case *NumberLiteral:
+		return strconv.FormatFloat(expr.Val, 'f', -1, 64), nil
+	case *BooleanLiteral:
+		return expr.String(), nil
+	case *BoundParameter:
+		return e.expr(expr.Value)
+	case *ListLiteral:
+		var vals []string
+		for _, v := range expr.Vals {
+			switch v := v.(type) {
+			case string:
+				vals = append(vals, e.str(v))
+			case float64:
+				vals = append(vals, strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				vals = append(vals, fmt.Sprint(v))
+			}
+		}
+		return "(" + strings.Join(vals, ", ") + ")", nil
+	case *ParenExpr:
+		inner, err := e.expr(expr.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "(" + inner + ")", nil
+	case *Call:
+		if isPredicateCall(expr) {
+			return "", errorAt(expr, fmt.Errorf("%s() is not supported by the %s target", expr.Name, e.target))
+		}
+		if len(expr.Options) > 0 {
+			return "", errorAt(expr, fmt.Errorf("options of %s() are not supported by the %s target", expr.Name, e.target))
+		}
+		switch callKind(expr) {
+		case MetricFunction:
+			return e.aggregate(expr)
+		case PipelineFunction:
+			return "", errorAt(expr, fmt.Errorf("%s() is not supported by the %s target", expr.Name, e.target))
+		}
+		f := scalarFunctionOf(expr)
+		args, err := e.args(expr.Args)
+		if err != nil {
+			return "", err
+		}
+		if f != nil && f.target != nil {
+			return f.target(e.target, expr, args), nil
+		}
+		return fmt.Sprintf("%s(%s)", strings.ToUpper(expr.Name), strings.Join(args, ", ")), nil
+	case *CastExpr:
+		v, err := e.expr(expr.Expr)
+		if err != nil {
+			return "", err
+		}
+		return castTarget(e.target, expr.Type, v), nil
+	case *BinaryExpr:
+		return e.binary(expr)
+	case *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr:
+		return e.conditional(expr)
+	case *SubqueryExpr:
+		return "", errorAt(expr, fmt.Errorf("IN (SELECT ...) is not supported by the %s target", e.target))
+	}
+	return "", errorAt(expr, fmt.Errorf("%s is not supported by the %s target", expr.String(), e.target))
+}
+
+// conditional returns the target syntax of a conditional expression, _sql
+// has CASE, IIF, COALESCE and NULLIF, ES|QL the CASE and COALESCE functions.
+func (e *emitter) conditional(expr Expr) (string, error) {
+	args, err := e.args(conditionalArgs(expr))
+	if err != nil {
+		return "", err
+	}
+	switch expr := expr.(type) {
+	case *CoalesceExpr:
+		return fmt.Sprintf("COALESCE(%s)", strings.Join(args, ", ")), nil
+	case *IfExpr:
+		if e.target == TargetSQL {
+			return fmt.Sprintf("IIF(%s)", strings.Join(args, ", ")), nil
+		}
+		return fmt.Sprintf("CASE(%s)", strings.Join(args, ", ")), nil
+	case *NullIfExpr:
+		if e.target == TargetSQL {
+			return fmt.Sprintf("NULLIF(%s)", strings.Join(args, ", ")), nil
+		}
+		return fmt.Sprintf("CASE(%s %s %s, null, %s)", args[0], e.eq, args[1], args[0]), nil
+	case *CaseExpr:
+		operand := ""
+		if expr.Operand != nil {
+			operand, args = args[0], args[1:]
+		}
+		if e.target == TargetSQL {
+			var buf bytes.Buffer
+			buf.WriteString("CASE")
+			if operand != "" {
+				buf.WriteString(" " + operand)
+			}
+			for i := 0; i < 2*len(expr.Whens); i += 2 {
+				fmt.Fprintf(&buf, " WHEN %s THEN %s", args[i], args[i+1])
+			}
+			if expr.Else != nil {
+				fmt.Fprintf(&buf, " ELSE %s", args[len(args)-1])
+			}
+			buf.WriteString(" END")
+			return buf.String(), nil
+		}
+		// ES|QL has no simple CASE, the values are compared to the operand
+		if operand != "" {
+			for i := 0; i < 2*len(expr.Whens); i += 2 {
+				args[i] = fmt.Sprintf("%s %s %s", operand, e.eq, args[i])
+			}
+		}
+		return fmt.Sprintf("CASE(%s)", strings.Join(args, ", ")), nil
+	}
+	return "", errorAt(expr, fmt.Errorf("%s is not supported by the %s target", expr.String(), e.target))
+}
+
+func (e *emitter) binary(expr *BinaryExpr) (string, error) {
+	lhs, err := e.expr(expr.LHS)
+	if err != nil {
+		return "", err
+	}
+	switch expr.Op {
+	case EQREGEX, NEQREGEX:
+		re, ok := expr.RHS.(*RegexLiteral)
+		if !ok {
+			return "", errorAt(expr, fmt.Errorf("expected regex in %s", expr.String()))
+		}
+		s := fmt.Sprintf("%s RLIKE %s", lhs, e.str(anchoredPattern(re.Val.String())))
+		if expr.Op == NEQREGEX {
+			s = "NOT " + s
+		}
+		return s, nil
+	}
+	rhs, err := e.expr(expr.RHS)
+	if err != nil {
+		return "", err
+	}
+	var op string
+	switch expr.Op {
+	case EQ:
+		op = e.eq
+	case IN:
+		op = "IN"
+	case NI:
+		op = "NOT IN"
+	default:
+		op = expr.Op.String()
+	}
+	return fmt.Sprintf("%s %s %s", lhs, op, rhs), nil
+}
+
+// anchoredPattern turns a regex searched anywhere in the value into the
+// whole value pattern of RLIKE.
+func anchoredPattern(pattern string) string {
+	if strings.HasPrefix(pattern, "^") {
+		pattern = pattern[1:]
+	} else {
+		pattern = ".*" + pattern
+	}
+	if strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`) {
+		pattern = pattern[:len(pattern)-1]
+	} else {
+		pattern += ".*"
+	}
+	return pattern
+}
+
+var bareIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// sqlIdent quotes an identifier of the _sql syntax when needed.
+func sqlIdent(name string) string {
+	if bareIdent.MatchString(name) && !isKeyword(name) {
+		return name
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// esqlIdent quotes an identifier of the ES|QL syntax when needed.
+func esqlIdent(name string) string {
+	if bareIdent.MatchString(name) && !isKeyword(name) {
+		return name
+	}
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// esqlString returns a double quoted ES|QL string.
+// sqlString quotes s as an sql string, quotes are escaped by doubling them.
+func sqlString(s string) string {
+	return `'` + strings.Replace(s, `'`, `''`, -1) + `'`
+}
+
+func esqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func isKeyword(name string) bool {
+	return Lookup(name) != IDENT
+}
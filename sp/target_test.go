@@ -0,0 +1,155 @@
+package sp_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// Ensure statements are translated into the _sql and ES|QL targets.
+func TestTranslate_Targets(t *testing.T) {
+	var tests = []struct {
+		sql  string
+		esql string
+		body string
+		err  string
+	}{
+		{
+			sql:  `select * from symbol where exchange='nyse' and last_sale > 985.5 order by name desc limit 5`,
+			body: `{"query":"SELECT * FROM symbol WHERE exchange = 'nyse' AND last_sale > 985.5 ORDER BY name DESC LIMIT 5"}`,
+			esql: "FROM symbol\n| WHERE exchange == \"nyse\" AND last_sale > 985.5\n| SORT name DESC\n| LIMIT 5",
+		},
+		{
+			sql:  `select name, sector from symbol where name =~ /^AA/ or sector in ['x', 'y'] limit 1`,
+			body: `{"query":"SELECT name, sector FROM symbol WHERE name RLIKE 'AA.*' OR sector IN ('x', 'y') LIMIT 1"}`,
+			esql: "FROM symbol\n| WHERE name RLIKE \"AA.*\" OR sector IN (\"x\", \"y\")\n| LIMIT 1\n| KEEP name, sector",
+		},
+		{
+			sql:  `select year, max(adj_close), cardinality(symbol) as n from quote where @timestamp > 1482908284586 group by date_histogram('@timestamp','1w') as year`,
+			body: `{"query":"SELECT HISTOGRAM(\"@timestamp\", INTERVAL 7 DAY) AS year, MAX(adj_close), COUNT(DISTINCT symbol) AS n FROM quote WHERE \"@timestamp\" > 1482908284586 GROUP BY year"}`,
+			esql: "FROM quote\n| WHERE `@timestamp` > 1482908284586\n| STATS `max(adj_close)` = MAX(adj_close), n = COUNT_DISTINCT(symbol) BY year = BUCKET(`@timestamp`, 1 week)\n| KEEP year, `max(adj_close)`, n",
+		},
+		{
+			sql:  `SELECT ipo_year, COUNT(*) AS ipo_count, sum(ipo_year)/sum(last_sale) AS r FROM symbol GROUP BY histogram(ipo_year, 5) AS ipo_year HAVING ipo_count > 100 ORDER BY ipo_count DESC LIMIT 3`,
+			body: `{"query":"SELECT HISTOGRAM(ipo_year, 5) AS ipo_year, COUNT(*) AS ipo_count, SUM(ipo_year) / SUM(last_sale) AS r FROM symbol GROUP BY ipo_year HAVING COUNT(*) > 100 ORDER BY ipo_count DESC LIMIT 3"}`,
+			esql: "FROM symbol\n| STATS ipo_count = COUNT(*), r = SUM(ipo_year) / SUM(last_sale) BY ipo_year = BUCKET(ipo_year, 5)\n| WHERE ipo_count > 100\n| SORT ipo_count DESC\n| LIMIT 3\n| KEEP ipo_year, ipo_count, r",
+		},
+		{
+			sql:  `select count(*) from symbol group by exchange`,
+			body: `{"query":"SELECT exchange, COUNT(*) FROM symbol GROUP BY exchange"}`,
+			esql: "FROM symbol\n| STATS `count(*)` = COUNT(*) BY exchange\n| KEEP exchange, `count(*)`",
+		},
+		{
+			sql:  `select name, CASE exchange WHEN 'nyse' THEN 1 ELSE 0 END AS x, coalesce(sector, 'none') AS s, if(ipo_year > 2000, 'new', 'old') AS a from symbol limit 3`,
+			body: `{"query":"SELECT name, CASE exchange WHEN 'nyse' THEN 1 ELSE 0 END AS x, COALESCE(sector, 'none') AS s, IIF(ipo_year > 2000, 'new', 'old') AS a FROM symbol LIMIT 3"}`,
+			esql: "FROM symbol\n| EVAL x = CASE(exchange == \"nyse\", 1, 0), s = COALESCE(sector, \"none\"), a = CASE(ipo_year > 2000, \"new\", \"old\")\n| LIMIT 3\n| KEEP name, x, s, a",
+		},
+		{
+			sql:  `select lower(name) as n, cast(ipo_year as string) as y, concat(name, '-', sector) as c from symbol where length(name) > 3 limit 2`,
+			body: `{"query":"SELECT LCASE(name) AS n, CAST(ipo_year AS KEYWORD) AS y, CONCAT(CONCAT(name, '-'), sector) AS c FROM symbol WHERE LENGTH(name) > 3 LIMIT 2"}`,
+			esql: "FROM symbol\n| WHERE LENGTH(name) > 3\n| EVAL n = TO_LOWER(name), y = TO_STRING(ipo_year), c = CONCAT(name, \"-\", sector)\n| LIMIT 2\n| KEEP n, y, c",
+		},
+		{
+			sql:  `select year(ts) as y, round(avg(last_sale), 2) as p from symbol group by year(ts) as y`,
+			body: `{"query":"SELECT YEAR(ts) AS y, ROUND(AVG(last_sale), 2) AS p FROM symbol GROUP BY y"}`,
+			esql: "FROM symbol\n| STATS p = ROUND(AVG(last_sale), 2) BY y = DATE_EXTRACT(\"year\", ts)\n| KEEP y, p",
+		},
+		{
+			sql:  `select exchange, count(*) from symbol group by exchange having count(*) > 10 and avg(last_sale) > 5 order by count(*) desc`,
+			body: `{"query":"SELECT exchange, COUNT(*) FROM symbol GROUP BY exchange HAVING COUNT(*) > 10 AND AVG(last_sale) > 5 ORDER BY COUNT(*) DESC"}`,
+			esql: "FROM symbol\n| STATS `count(*)` = COUNT(*), `avg(last_sale)` = AVG(last_sale) BY exchange\n| WHERE `count(*)` > 10 AND `avg(last_sale)` > 5\n| SORT `count(*)` DESC\n| KEEP exchange, `count(*)`",
+		},
+		{
+			sql:  `select count(*) from quote group by date_histogram(ts, '1d')`,
+			body: `{"query":"SELECT HISTOGRAM(ts, INTERVAL 1 DAY), COUNT(*) FROM quote GROUP BY HISTOGRAM(ts, INTERVAL 1 DAY)"}`,
+			esql: "FROM quote\n| STATS `count(*)` = COUNT(*) BY `date_histogram(ts, '1d')` = BUCKET(ts, 1 day)\n| KEEP `date_histogram(ts, '1d')`, `count(*)`",
+		},
+		{
+			sql: `select * from symbol limit 5, 10`,
+			err: `offset is not supported by the sql target at line 1, char 22`,
+		},
+		{
+			sql: `select count(*) from symbol group by range(ipo_year, 1980, 1990)`,
+			err: `range() is not supported by the sql target at line 1, char 38`,
+		},
+		{
+			sql: `select * from shop where geo_distance(location, 40.7, -74.0, '10km') limit 1`,
+			err: `geo_distance() is not supported by the sql target at line 1, char 26`,
+		},
+	}
+
+	for i, tt := range tests {
+		body, err := sp.Translate(tt.sql, sp.TargetSQL)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if body != tt.body {
+			t.Errorf("%d. %s\n\nsql body mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.body, body)
+		}
+		esql, err := sp.Translate(tt.sql, sp.TargetESQL)
+		if err != nil {
+			t.Errorf("%d. %s: esql error\n\n %s", i, tt.sql, err)
+		} else if esql != tt.esql {
+			t.Errorf("%d. %s\n\nesql mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.esql, esql)
+		}
+	}
+}
+
+// Ensure target names are parsed.
+func TestParseTarget(t *testing.T) {
+	for name, exp := range map[string]sp.Target{"": sp.TargetDSL, "dsl": sp.TargetDSL, "SQL": sp.TargetSQL, "esql": sp.TargetESQL, "msearch": sp.TargetMSearch} {
+		if got, err := sp.ParseTarget(name); err != nil || got != exp {
+			t.Errorf("%q: exp=%s got=%s err=%v", name, exp, got, err)
+		}
+	}
+	if _, err := sp.ParseTarget("xml"); errstring(err) != `unknown target xml, expected one of dsl, sql, esql, msearch` {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// Ensure ;-separated statements are translated into an _msearch payload.
+func TestTranslate_MSearch(t *testing.T) {
+	var tests = []struct {
+		sql     string
+		params  sp.Params
+		msearch string
+		err     string
+	}{
+		{
+			sql: "select name from symbol limit 1;\nselect /*+ routing(u1) request_cache(true) */ count(*) from quote;",
+			msearch: `{"index":"symbol"}` + "\n" + `{"from":0,"size":1,"sort":[]}` + "\n" +
+				`{"index":"quote","request_cache":true,"routing":"u1"}` + "\n" + `{"aggs":{},"from":0,"size":0,"sort":[]}` + "\n",
+		},
+		{
+			sql:    `select name from symbol where ipo_year > ? limit 1; select name from quote where close < ? limit 1`,
+			params: sp.PositionalParams(1998, 5),
+			msearch: `{"index":"symbol"}` + "\n" + `{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['ipo_year'].value \u003e params.p0","params":{"p0":1998}}}}}},"size":1,"sort":[]}` + "\n" +
+				`{"index":"quote"}` + "\n" + `{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['close'].value \u003c params.p0","params":{"p0":5}}}}}},"size":1,"sort":[]}` + "\n",
+		},
+		{
+			sql: ` ; `,
+			err: `no statement`,
+		},
+		{
+			sql: "select name from symbol;\nselect count(*) from quote group by flor(close)",
+			err: `unknown group by function flor() at line 2, char 37, did you mean floor()?`,
+		},
+	}
+
+	for i, tt := range tests {
+		out, err := sp.TranslateParams(tt.sql, sp.TargetMSearch, tt.params)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if out != tt.msearch {
+			t.Errorf("%d. %s\n\nmsearch mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.msearch, out)
+		}
+	}
+}
@@ -9,23 +9,25 @@ type Token int
 
 // These are a comprehensive list of InfluxQL language tokens.
 const (
-	// ILLEGAL Token, EOF, WS are Special InfluxQL tokens.
+	// ILLEGAL Token, EOF, WS, COMMENT are Special InfluxQL tokens.
 	ILLEGAL Token = iota
 	EOF
 	WS
+	COMMENT // -- line or /* block */
 
 	literalBeg
 	// IDENT and the following are InfluxQL literal tokens.
-	IDENT     // main
-	NUMBER    // 12345.67
-	INTEGER   // 12345
-	STRING    // "abc"
-	BADSTRING // "abc
-	BADESCAPE // \q
-	TRUE      // true
-	FALSE     // false
-	REGEX     // Regular expressions
-	BADREGEX  // `.*
+	IDENT      // main
+	NUMBER     // 12345.67
+	INTEGER    // 12345
+	STRING     // "abc"
+	BADSTRING  // "abc
+	BADESCAPE  // \q
+	TRUE       // true
+	FALSE      // false
+	REGEX      // Regular expressions
+	BADREGEX   // `.*
+	BOUNDPARAM // ? or :name
 	literalEnd
 
 	operatorBeg
@@ -51,25 +53,43 @@ const (
 	GTE      // >=
 	operatorEnd
 
-	LBRACKET // [
-	LPAREN   // (
-	RBRACKET // ]
-	RPAREN   // )
-	COMMA    // ,
-	DOT      // .
+	LBRACKET  // [
+	LPAREN    // (
+	RBRACKET  // ]
+	RPAREN    // )
+	COMMA     // ,
+	DOT       // .
+	SEMICOLON // ;
 
 	keywordBeg
 	// ALL and the following are InfluxQL Keywords
 	AS
 	ASC
 	BY
+	CASE
+	CREATE
+	DELETE
 	DESC
+	DESCRIBE
+	DROP
+	ELSE
+	END
+	EXPLAIN
 	FROM
 	GROUP
 	HAVING
+	INSERT
+	JOIN
 	LIMIT
+	ON
 	ORDER
 	SELECT
+	SET
+	SHOW
+	THEN
+	UNION
+	UPDATE
+	WHEN
 	WHERE
 	keywordEnd
 )
@@ -78,16 +98,18 @@ var tokens = [...]string{
 	ILLEGAL: "ILLEGAL",
 	EOF:     "EOF",
 	WS:      "WS",
-
-	IDENT:     "IDENT",
-	NUMBER:    "NUMBER",
-	INTEGER:   "INTEGER",
-	STRING:    "STRING",
-	BADSTRING: "BADSTRING",
-	BADESCAPE: "BADESCAPE",
-	TRUE:      "TRUE",
-	FALSE:     "FALSE",
-	REGEX:     "REGEX",
+	COMMENT: "COMMENT",
+
+	IDENT:      "IDENT",
+	NUMBER:     "NUMBER",
+	INTEGER:    "INTEGER",
+	STRING:     "STRING",
+	BADSTRING:  "BADSTRING",
+	BADESCAPE:  "BADESCAPE",
+	TRUE:       "TRUE",
+	FALSE:      "FALSE",
+	REGEX:      "REGEX",
+	BOUNDPARAM: "BOUNDPARAM",
 
 	ADD: "+",
 	SUB: "-",
@@ -109,24 +131,42 @@ var tokens = [...]string{
 	GT:       ">",
 	GTE:      ">=",
 
-	LBRACKET: "[",
-	LPAREN:   "(",
-	RBRACKET: "]",
-	RPAREN:   ")",
-	COMMA:    ",",
-	DOT:      ".",
-
-	AS:     "AS",
-	ASC:    "ASC",
-	BY:     "BY",
-	DESC:   "DESC",
-	FROM:   "FROM",
-	GROUP:  "GROUP",
-	HAVING: "HAVING",
-	LIMIT:  "LIMIT",
-	ORDER:  "ORDER",
-	SELECT: "SELECT",
-	WHERE:  "WHERE",
+	LBRACKET:  "[",
+	LPAREN:    "(",
+	RBRACKET:  "]",
+	RPAREN:    ")",
+	COMMA:     ",",
+	DOT:       ".",
+	SEMICOLON: ";",
+
+	AS:       "AS",
+	ASC:      "ASC",
+	BY:       "BY",
+	CASE:     "CASE",
+	CREATE:   "CREATE",
+	DELETE:   "DELETE",
+	DESC:     "DESC",
+	DESCRIBE: "DESCRIBE",
+	DROP:     "DROP",
+	ELSE:     "ELSE",
+	END:      "END",
+	EXPLAIN:  "EXPLAIN",
+	FROM:     "FROM",
+	GROUP:    "GROUP",
+	HAVING:   "HAVING",
+	INSERT:   "INSERT",
+	JOIN:     "JOIN",
+	LIMIT:    "LIMIT",
+	ON:       "ON",
+	ORDER:    "ORDER",
+	SELECT:   "SELECT",
+	SET:      "SET",
+	SHOW:     "SHOW",
+	THEN:     "THEN",
+	UNION:    "UNION",
+	UPDATE:   "UPDATE",
+	WHEN:     "WHEN",
+	WHERE:    "WHERE",
 }
 
 var keywords map[string]Token
@@ -176,6 +216,9 @@ func (tok Token) Precedence() int {
 // isOperator returns true for operator tokens.
 func (tok Token) isOperator() bool { return tok > operatorBeg && tok < operatorEnd }
 
+// isWord returns true for tokens of reserved words, such as keywords and AND.
+func (tok Token) isWord() bool { return tok != IDENT && Lookup(tok.String()) == tok }
+
 // tokstr returns a literal if provided, otherwise returns the token string.
 func tokstr(tok Token, lit string) string {
 	if lit != "" {
@@ -198,3 +241,12 @@ type Pos struct {
 	Line int
 	Char int
 }
+
+// add returns the position p of a text starting at pos.
+func (pos Pos) add(p Pos) Pos {
+	if p.Line == 0 {
+		p.Char += pos.Char
+	}
+	p.Line += pos.Line
+	return p
+}
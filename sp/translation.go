@@ -0,0 +1,517 @@
+package sp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Translation is the result of translating a select statement into dsl.
+type Translation struct {
+	// Index is the index pattern to search.
+	Index string `json:"index"`
+	// Body is the search request body.
+	Body map[string]interface{} `json:"body"`
+	// Columns are the select columns and where to read them in the response.
+	Columns []*Column `json:"columns"`
+	// Params are the url parameters of the search request.
+	Params map[string]string `json:"params,omitempty"`
+	// Warnings are the parts of the statement the dsl does not honour.
+	Warnings []string `json:"warnings,omitempty"`
+	// Pagination is the plan reading every hit of a LIMIT ALL statement.
+	Pagination *Pagination `json:"pagination,omitempty"`
+	// Subqueries are the IN subqueries to run before the search, their
+	// values are inlined into its terms filters.
+	Subqueries []*Subquery `json:"subqueries,omitempty"`
+	// Join is the plan of a JOIN statement, which has no body of its own.
+	Join *JoinPlan `json:"join,omitempty"`
+	// Union is the plan of a UNION ALL statement, the searches of its
+	// statements.
+	Union *UnionPlan `json:"union,omitempty"`
+	// Explain is how an EXPLAIN statement is translated.
+	Explain *Explanation `json:"explain,omitempty"`
+	// Requests are the requests of a statement other than a select, run in
+	// order instead of a search.
+	Requests []*Request `json:"requests,omitempty"`
+
+	// statement names a statement other than a select, rows reads its rows
+	// from the responses of its requests.
+	statement string
+	rows      func(resps [][]byte) ([][]interface{}, error)
+	// write is set for statements changing documents or indices, unfiltered
+	// for the ones changing every document of an index and drops for the
+	// ones deleting an index.
+	write, unfiltered, drops bool
+
+	// baggs and maggs are the aggregations of the body, for EXPLAIN.
+	baggs, maggs Aggs
+}
+
+// Pagination modes.
+const (
+	// PaginationSearchAfter searches a point in time, every page after the
+	// sort values of the last hit of the previous one.
+	PaginationSearchAfter = "search_after"
+	// PaginationScroll reads the pages of a scroll context, for clusters
+	// older than 7.10 without point in time.
+	PaginationScroll = "scroll"
+)
+
+// Pagination is how the pages of a LIMIT ALL statement are read.
+type Pagination struct {
+	Mode     string `json:"mode"`
+	PageSize int    `json:"page_size"`
+	// KeepAlive is how long the point in time or scroll context is kept between pages.
+	KeepAlive string `json:"keep_alive"`
+}
+
+// maxResultWindow is the default index.max_result_window, the highest
+// from + size of a search.
+const maxResultWindow = 10000
+
+// pagination returns the pagination plan of a LIMIT ALL statement, set by
+// the pagination, page_size and keep_alive hints.
+func (s *SelectStatement) pagination() *Pagination {
+	if !s.LimitAll {
+		return nil
+	}
+	p := &Pagination{Mode: PaginationSearchAfter, PageSize: 1000, KeepAlive: "1m"}
+	if v, ok := s.Hints["pagination"]; ok {
+		p.Mode = v
+	}
+	if v, ok := s.Hints["page_size"]; ok {
+		p.PageSize, _ = strconv.Atoi(v)
+	}
+	if v, ok := s.Hints["keep_alive"]; ok {
+		p.KeepAlive = v
+	}
+	return p
+}
+
+// Request is an elasticsearch request other than a search.
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Body is a json body, or the ndjson payload of a string.
+	Body interface{} `json:"body,omitempty"`
+}
+
+// RequestRows reads the column values of the responses of the requests of
+// a statement other than a select, one response for every request.
+func (t *Translation) RequestRows(resps [][]byte) ([][]interface{}, error) {
+	if t.rows == nil {
+		return nil, fmt.Errorf("%s has no rows", t.statement)
+	}
+	if len(resps) != len(t.Requests) {
+		return nil, fmt.Errorf("%d responses for %d requests", len(resps), len(t.Requests))
+	}
+	return t.rows(resps)
+}
+
+// Column is a result column of a translated statement.
+type Column struct {
+	Name string `json:"name"`
+	// Path is the location of the column value in the search response,
+	// "*" stands for every element of an array.
+	Path []string `json:"path"`
+}
+
+// TranslateDSL translates a select statement into a search request.
+func TranslateDSL(sql string) (*Translation, error) {
+	return TranslateDSLParams(sql, nil)
+}
+
+// TranslateDSLParams translates a select statement into a search request,
+// binding params to the placeholders of the statement.
+func TranslateDSLParams(sql string, params Params) (*Translation, error) {
+	s, p, err := parseStatement(sql, params)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.translate()
+	return t, p.locate(err)
+}
+
+// TranslateDSLStatements translates the ;-separated select statements of
+// sql into search requests, binding params to their placeholders.
+func TranslateDSLStatements(sql string, params Params) ([]*Translation, error) {
+	stmts, p, err := parseStatements(sql, params)
+	if err != nil {
+		return nil, err
+	}
+	ts := make([]*Translation, len(stmts))
+	for i, s := range stmts {
+		if ts[i], err = s.translate(); err != nil {
+			return nil, p.locate(err)
+		}
+	}
+	return ts, nil
+}
+
+// Searches returns the searches of an _msearch running t, one for every
+// statement of a UNION ALL.
+func (t *Translation) Searches() []*Translation {
+	if t.Union != nil {
+		return t.Union.Searches
+	}
+	return []*Translation{t}
+}
+
+// MSearch returns the _msearch ndjson payload of the search requests, a
+// header line with the index and url params of each request followed by
+// its body.
+func MSearch(ts []*Translation) (string, error) {
+	var searches []*Translation
+	for _, t := range ts {
+		if t.Explain != nil {
+			return "", fmt.Errorf("EXPLAIN is not supported by msearch")
+		}
+		if t.Requests != nil {
+			return "", fmt.Errorf("%s is not supported by msearch", t.statement)
+		}
+		searches = append(searches, t.Searches()...)
+	}
+	var buf bytes.Buffer
+	for _, t := range searches {
+		if t.Pagination != nil {
+			return "", fmt.Errorf("LIMIT ALL is not supported by msearch")
+		}
+		if t.Join != nil {
+			return "", fmt.Errorf("JOIN is not supported by msearch")
+		}
+		for _, q := range t.Subqueries {
+			if !q.inlined {
+				return "", fmt.Errorf("IN (SELECT ...) has to be run before msearch")
+			}
+		}
+		header := map[string]interface{}{"index": t.Index}
+		for name, arg := range t.Params {
+			v, err := hints[name].value(arg)
+			if err != nil {
+				return "", err
+			}
+			header[name] = v
+		}
+		for _, v := range []interface{}{header, t.Body} {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String(), nil
+}
+
+// JSON returns the search request body, or the requests of a statement
+// other than a select, keys are sorted.
+func (t *Translation) JSON() (string, error) {
+	var v interface{} = t.Body
+	if t.Requests != nil {
+		v = t.Requests
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// index returns the index pattern of the statement sources.
+func (s *SelectStatement) index() string {
+	return strings.Join(s.Sources.Names(), ",")
+}
+
+// warnings returns the parts of the statement ignored by the translation.
+func (s *SelectStatement) warnings() []string {
+	var warnings []string
+	if len(s.Dimensions) == 0 {
+		if n := s.Offset + s.Limit; n > maxResultWindow {
+			warnings = append(warnings, fmt.Sprintf("from + size of %d exceeds the default index.max_result_window of %d, use LIMIT ALL to read every hit", n, maxResultWindow))
+		}
+		return warnings
+	}
+	for _, f := range s.Fields {
+		if ref, ok := f.Expr.(*VarRef); ok && s.dimension(ref.Val) == nil {
+			warnings = append(warnings, fmt.Sprintf("%s is neither grouped by nor aggregated, ignored", ref.Val))
+		}
+	}
+	if len(s.Dimensions) > 1 && s.Limit > 0 {
+		warnings = append(warnings, fmt.Sprintf("LIMIT %d applies to every GROUP BY level", s.Limit))
+	}
+	if s.Offset > 0 {
+		warnings = append(warnings, "OFFSET is ignored with GROUP BY")
+	}
+	return warnings
+}
+
+// responseColumns returns the columns of the statement located in the
+// response of the bucket and metric aggregations.
+func (s *SelectStatement) responseColumns(baggs, maggs Aggs) []*Column {
+	if s.IsRawQuery && len(s.Dimensions) == 0 {
+		var cols []*Column
+		names := s.ColumnNames()
+		for i, f := range s.Fields {
+			path := []string{"hits", "hits", "*", "_source"}
+			if ref, ok := f.Expr.(*VarRef); ok {
+				path = append(path, strings.Split(cleanDocString(ref.Val), ".")...)
+			} else if isComputed(f.Expr) {
+				path = []string{"hits", "hits", "*", "fields", names[i], "0"}
+			}
+			cols = append(cols, &Column{Name: names[i], Path: path})
+		}
+		return cols
+	}
+
+	// bucket paths of the dimensions, in order
+	prefix := []string{"aggregations"}
+	var dimPaths [][]string
+	for _, a := range baggs {
+		if a.typ == Nested || a.typ == ReverseNested {
+			prefix = branch(prefix, a.name)
+			continue
+		}
+		prefix = branch(prefix, a.name, "buckets", "*")
+		dimPaths = append(dimPaths, branch(prefix, "key"))
+	}
+
+	var cols, dimCols []*Column
+	used := make(map[*Dimension]bool)
+	names := s.ColumnNames()
+	for i, f := range s.Fields {
+		col := &Column{Name: names[i]}
+		if isComputed(f.Expr) {
+			// computed by a script of the group by
+			d := s.fieldDimension(f)
+			if d == nil {
+				continue
+			}
+			used[d] = true
+			col.Path = dimPaths[s.dimensionIndex(d)]
+			cols = append(cols, col)
+			continue
+		}
+		switch expr := f.Expr.(type) {
+		case *VarRef:
+			d := s.dimension(cleanDocString(expr.Val))
+			if d == nil {
+				continue
+			}
+			used[d] = true
+			col.Path = dimPaths[s.dimensionIndex(d)]
+		case *Call:
+			if isScalarCall(expr) {
+				// a bucket_script of its aggregates
+				col.Path = branch(prefix, cleanDocString(f.String()), "value")
+				if f.Alias != "" {
+					col.Path = branch(prefix, cleanDocString(f.Alias), "value")
+				}
+				break
+			}
+			a := maggs.find(f.metricAggName())
+			if a == nil {
+				continue
+			}
+			col.Path = metricPath(prefix, a)
+		default:
+			name := f.Alias
+			if name == "" {
+				name = f.String()
+			}
+			col.Path = branch(prefix, cleanDocString(name), "value")
+		}
+		cols = append(cols, col)
+	}
+	for i, d := range s.Dimensions {
+		if !used[d] {
+			name := d.Alias
+			if name == "" {
+				name = cleanDocString(d.Expr.String())
+			}
+			dimCols = append(dimCols, &Column{Name: name, Path: dimPaths[i]})
+		}
+	}
+	return append(dimCols, cols...)
+}
+
+func (s *SelectStatement) dimensionIndex(d *Dimension) int {
+	for i, dim := range s.Dimensions {
+		if dim == d {
+			return i
+		}
+	}
+	return -1
+}
+
+// find returns the aggregation named name.
+func (a Aggs) find(name string) *Agg {
+	for _, agg := range a {
+		if agg.name == name {
+			return agg
+		}
+	}
+	return nil
+}
+
+// metricPath returns the response path of a metric value within the bucket at prefix.
+func metricPath(prefix []string, a *Agg) []string {
+	if a.typ == StarCount {
+		if len(prefix) == 1 {
+			return []string{"hits", "total"}
+		}
+		return branch(prefix, "doc_count")
+	}
+	path := prefix
+	for range a.scope {
+		path = branch(path, a.name)
+	}
+	path = branch(path, a.name)
+	switch a.typ {
+	case ReverseNested:
+		return branch(path, "doc_count")
+	case GeoBounds:
+		return branch(path, "bounds")
+	case GeoCentroid:
+		return branch(path, "location")
+	case Percentiles, PercentileRanks:
+		return branch(path, "values")
+	case Stats, ExtendedStats, Top:
+		return path
+	}
+	return branch(path, "value")
+}
+
+// Rows reads the column values of a search response, one row for every
+// element of the deepest "*" of the column paths. Missing values are nil.
+func (t *Translation) Rows(resp []byte) ([][]interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(resp))
+	d.UseNumber()
+	var root interface{}
+	if err := d.Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid search response, %s", err)
+	}
+	if m, ok := root.(map[string]interface{}); ok && m["error"] != nil {
+		b, _ := json.Marshal(m["error"])
+		return nil, fmt.Errorf("search failed, %s", b)
+	}
+
+	// the rows iterate the path of the column with the most "*"
+	var rowPath []string
+	for _, c := range t.Columns {
+		if n := lastStar(c.Path); n > lastStar(rowPath) {
+			rowPath = c.Path[:n]
+		}
+	}
+
+	var rows [][]interface{}
+	eachElement(root, rowPath, nil, func(elems []interface{}) {
+		row := make([]interface{}, len(t.Columns))
+		for i, c := range t.Columns {
+			n := lastStar(c.Path)
+			if n > len(rowPath) || !pathHasPrefix(rowPath, c.Path[:n]) {
+				continue
+			}
+			v := root
+			if stars := countStars(c.Path[:n]); stars > 0 {
+				v = elems[stars-1]
+			}
+			row[i] = pathValue(v, c.Path[n:])
+		}
+		rows = append(rows, row)
+	})
+	return rows, nil
+}
+
+// lastStar returns the length of the path up to its last "*", 0 without one.
+func lastStar(path []string) int {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == "*" {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func countStars(path []string) int {
+	n := 0
+	for _, p := range path {
+		if p == "*" {
+			n++
+		}
+	}
+	return n
+}
+
+func pathHasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// eachElement calls fn with the elements matched by every "*" of path, for
+// each combination of them. Keyed buckets, objects instead of arrays, are
+// iterated in key order with their key set.
+func eachElement(v interface{}, path []string, elems []interface{}, fn func([]interface{})) {
+	if len(path) == 0 {
+		fn(elems)
+		return
+	}
+	if path[0] != "*" {
+		m, _ := v.(map[string]interface{})
+		eachElement(m[path[0]], path[1:], elems, fn)
+		return
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			eachElement(e, path[1:], append(elems, e), fn)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e := v[k]
+			if bucket, ok := e.(map[string]interface{}); ok && bucket["key"] == nil {
+				keyed := map[string]interface{}{"key": k}
+				for bk, bv := range bucket {
+					keyed[bk] = bv
+				}
+				e = keyed
+			}
+			eachElement(e, path[1:], append(elems, e), fn)
+		}
+	}
+}
+
+// pathValue returns the value at path within v, or nil. Numbers index
+// arrays, e.g. the values of script fields.
+func pathValue(v interface{}, path []string) interface{} {
+	for _, p := range path {
+		switch e := v.(type) {
+		case map[string]interface{}:
+			v = e[p]
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(e) {
+				return nil
+			}
+			v = e[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
@@ -58,6 +58,8 @@ const (
 	pipelineBegin
 	BucketScript
 	BucketSelector
+	CumulativeSum
+	Derivative
 	pipelineEnd
 )
 
@@ -98,13 +100,48 @@ var aggs = [...]string{
 
 	BucketScript:   "bucket_script",
 	BucketSelector: "bucket_selector",
+	CumulativeSum:  "cumulative_sum",
+	Derivative:     "derivative",
 }
 
 // Agg .
 type Agg struct {
-	name   string
-	typ    ESAgg
+	name string
+	typ  ESAgg
+	// custom is the type of the aggregations of registered functions
+	// unknown to ESAgg.
+	custom string
 	params map[string]interface{}
+	// single bucket aggregations (nested, reverse_nested) wrapping a metric
+	scope Aggs
+}
+
+// setType sets the type of the agg from its elasticsearch name.
+func (a *Agg) setType(name string) {
+	for i := range aggs {
+		if aggs[i] == name && ESAgg(i) != IllegalAgg {
+			a.typ = ESAgg(i)
+			return
+		}
+	}
+	a.typ, a.custom = IllegalAgg, name
+}
+
+// typeName returns the elasticsearch name of the agg type.
+func (a *Agg) typeName() string {
+	if a.typ == IllegalAgg {
+		return a.custom
+	}
+	return aggs[a.typ]
+}
+
+// bucketsPath returns the path of the agg relative to its parent bucket.
+func (a *Agg) bucketsPath() string {
+	if a.typ == ReverseNested {
+		// the parent documents count(*) counts under a nested bucket
+		return a.name + ">_count"
+	}
+	return strings.Repeat(a.name+">", len(a.scope)) + a.name
 }
 
 //Aggs .
@@ -133,7 +170,7 @@ func (s *SelectStatement) isStarCount(f string) bool {
 		}
 		switch fn.Name {
 		case "count":
-			if fn.Args[0].String() == "*" && field.Alias == f {
+			if isCountStar(fn) && field.Alias == f {
 				return true
 			}
 		}
@@ -147,14 +184,23 @@ func (s *SelectStatement) orders() []map[string]string {
 		if s.isGroupBySort(sf.Name) {
 			sf.Name = "_term"
 		}
+		name := sf.Name
 		if s.isStarCount(sf.Name) {
-			sf.Name = "_count"
+			name = s.starCountPath(sf.Name)
+		}
+		if sf.Call != nil {
+			name = s.sortAggName(sf.Call)
+		}
+		if name != "_term" && name != "_count" {
+			// a metric wrapped in nested or reverse_nested is sorted on
+			// through its wrappers, as HAVING reads it
+			name = s.metricBucketsPath(name)
 		}
 		m := make(map[string]string)
 		if sf.Ascending {
-			m[sf.Name] = "asc"
+			m[name] = "asc"
 		} else {
-			m[sf.Name] = "desc"
+			m[name] = "desc"
 		}
 		order = append(order, m)
 	}
@@ -163,18 +209,114 @@ func (s *SelectStatement) orders() []map[string]string {
 
 //EsDsl return dsl json string
 func EsDsl(sql string) (string, error) {
+	return Translate(sql, TargetDSL)
+}
+
+// translatable is a statement translated into elasticsearch requests.
+type translatable interface {
+	Statement
+	translate() (*Translation, error)
+}
 
-	stmt, err := ParseStatement(sql)
+// bindable is a statement with placeholders, its functions are validated
+// before its placeholders are bound.
+type bindable interface {
+	validateFunctions() error
+	bind(params Params, used map[string]bool) error
+}
+
+// parseStatement parses sql into a statement to translate and binds params
+// to its placeholders, the parser is returned to locate translation errors
+// in sql.
+func parseStatement(sql string, params Params) (translatable, *Parser, error) {
+	p := NewParser(strings.NewReader(sql))
+	stmt, err := p.ParseStatement()
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	// fmt.Println(stmt)
-	s, ok := stmt.(*SelectStatement)
+	used := make(map[string]bool)
+	s, err := prepare(stmt, params, used)
+	if err != nil {
+		return nil, nil, p.locate(err)
+	}
+	if err := checkUnused(params, used); err != nil {
+		return nil, nil, err
+	}
+	return s, p, nil
+}
+
+// parseStatements parses the ;-separated statements of sql and binds params
+// to their placeholders, ? placeholders are counted across statements.
+func parseStatements(sql string, params Params) ([]translatable, *Parser, error) {
+	p := NewParser(strings.NewReader(sql))
+	stmts, err := p.ParseStatements()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(stmts) == 0 {
+		return nil, nil, fmt.Errorf("no statement")
+	}
+	used := make(map[string]bool)
+	ts := make([]translatable, 0, len(stmts))
+	for _, stmt := range stmts {
+		s, err := prepare(stmt, params, used)
+		if err != nil {
+			return nil, nil, p.locate(err)
+		}
+		ts = append(ts, s)
+	}
+	if err := checkUnused(params, used); err != nil {
+		return nil, nil, err
+	}
+	return ts, p, nil
+}
+
+// prepare validates the functions of a statement and binds params to its
+// placeholders, recording the names of the params used.
+func prepare(stmt Statement, params Params, used map[string]bool) (translatable, error) {
+	if s, ok := stmt.(bindable); ok {
+		if err := s.validateFunctions(); err != nil {
+			return nil, err
+		}
+		if err := s.bind(params, used); err != nil {
+			return nil, err
+		}
+	}
+	s, ok := stmt.(translatable)
 	if !ok {
-		return "", fmt.Errorf("only support select")
+		return nil, fmt.Errorf("only support select")
 	}
-	s.RewriteConditions()
+	return s, nil
+}
 
+// dsl returns the elasticsearch query dsl of the statement.
+func (s *SelectStatement) dsl() (string, error) {
+	if s.Join != nil {
+		return "", fmt.Errorf("JOIN has no single dsl body, its join plan is returned by TranslateDSL")
+	}
+	if s.Union != nil {
+		return "", fmt.Errorf("UNION ALL has no single dsl body, its searches are returned by TranslateDSL")
+	}
+	t, err := s.translate()
+	if err != nil {
+		return "", err
+	}
+	return t.JSON()
+}
+
+// translate builds the query body of the statement, or the join plan of a
+// JOIN statement.
+func (s *SelectStatement) translate() (*Translation, error) {
+	if s.Explain {
+		return s.translateExplain()
+	}
+	if s.Join != nil {
+		return s.translateJoin()
+	}
+	if s.Union != nil {
+		return s.translateUnion()
+	}
+	t := &Translation{Index: s.index(), Warnings: s.warnings(), Pagination: s.pagination()}
 	js := simplejson.New()
 
 	if len(s.Dimensions) == 0 {
@@ -192,6 +334,17 @@ func EsDsl(sql string) (string, error) {
 			}
 			sort = append(sort, m)
 		}
+		// pages are read after the sort values of the last hit, which
+		// must be unique, or in index order for scroll
+		if p := t.Pagination; p != nil {
+			js.Del("from")
+			js.Set("size", p.PageSize)
+			if p.Mode == PaginationSearchAfter {
+				sort = append(sort, map[string]string{"_shard_doc": "asc"})
+			} else if len(sort) == 0 {
+				sort = append(sort, map[string]string{"_doc": "asc"})
+			}
+		}
 		js.Set("sort", sort)
 	} else {
 		js.Set("size", 0)
@@ -199,32 +352,54 @@ func EsDsl(sql string) (string, error) {
 
 	//fields
 	//scirpt fields
+	if s.IsRawQuery && len(s.Dimensions) == 0 {
+		names := s.ColumnNames()
+		for i, f := range s.Fields {
+			if isComputed(f.Expr) {
+				rewriteCondition(f.Expr)
+				js.SetPath([]string{"script_fields", names[i], "script"}, script(f.Expr))
+			}
+		}
+	}
 
 	//query
-	if s.Condition != nil {
-		branch := []string{"query", "bool", "filter", "script", "script"}
-		js.SetPath(branch, s.Condition.String())
-	}
-	fields := s.NamesInDimension()
-	// fmt.Println(fields)
-	if len(fields) > 0 {
-		fieldFilters := make([]map[string]interface{}, 0)
+	filters, cond, err := s.queryFilters()
+	if err != nil {
+		return nil, err
+	}
+	subfilters, subqueries, err := s.subqueryFilters()
+	if err != nil {
+		return nil, err
+	}
+	filters = append(filters, subfilters...)
+	t.Subqueries = subqueries
+	if cond != nil {
+		rewriteCondition(cond)
+		if len(filters) == 0 {
+			branch := []string{"query", "bool", "filter", "script", "script"}
+			js.SetPath(branch, script(cond))
+		} else {
+			sm := map[string]interface{}{"script": script(cond)}
+			filters = append(filters, map[string]interface{}{"script": sm})
+		}
+	}
+	if len(filters) > 0 {
 		branch := []string{"query", "bool", "filter", "and"}
-		for _, f := range fields {
-			_js := simplejson.New()
-			existsBranch := []string{"exists", "field"}
-			_js.SetPath(existsBranch, f)
-			fieldFilters = append(fieldFilters, _js.MustMap())
+		if t.Pagination != nil {
+			// point in time and scroll clusters have no and filter, a
+			// filter array is accepted by every version
+			branch = branch[:3]
 		}
-		js.SetPath(branch, fieldFilters)
+		js.SetPath(branch, filters)
 	}
 
 	// build Aggregations
 	path := []string{"aggs"}
 	//bucket Aggregations
 	baggs := s.bucketAggregations()
+	s.Hints.apply(js, baggs, t)
 	for _, a := range baggs {
-		_path := append(path, []string{a.name, aggs[a.typ]}...)
+		_path := append(path, []string{a.name, a.typeName()}...)
 		js.SetPath(_path, a.params)
 
 		// if a.typ == Terms {
@@ -239,59 +414,253 @@ func EsDsl(sql string) (string, error) {
 			js.SetPath(path, make(map[string]string, 0))
 			continue
 		}
-		_path := append(path, []string{a.name, aggs[a.typ]}...)
-		js.SetPath(_path, a.params)
+		_path := path
+		for _, w := range a.scope {
+			js.SetPath(branch(_path, a.name, w.typeName()), w.params)
+			_path = branch(_path, a.name, "aggs")
+		}
+		js.SetPath(branch(_path, a.name, a.typeName()), a.params)
 	}
 
-	_s, err := js.MarshalJSON()
-	if err != nil {
-		return "", err
+	t.Body = js.MustMap()
+	t.Columns = s.responseColumns(baggs, maggs)
+	t.baggs, t.maggs = baggs, maggs
+	return t, nil
+}
+
+// branch returns a copy of path extended with elems.
+func branch(path []string, elems ...string) []string {
+	b := make([]string, 0, len(path)+len(elems))
+	b = append(b, path...)
+	return append(b, elems...)
+}
+
+// queryFilters splits the where condition into native query clauses and the
+// remaining condition to be run as a script, and appends the exists clauses
+// of the group by fields.
+func (s *SelectStatement) queryFilters() ([]interface{}, Expr, error) {
+	var filters []interface{}
+	var rest []Expr
+
+	// conditions on fields of the same nested path share one nested query
+	// so that they have to match the same nested document.
+	var paths []string
+	var split bool
+	nested := make(map[string][]Expr)
+	for _, cond := range conjuncts(s.Condition) {
+		if _, ok := subqueryCondition(cond); ok {
+			// translated by subqueryFilters
+			split = true
+			continue
+		}
+		if c, ok := cond.(*Call); ok && c.Name == "nested" {
+			path := nestedCallPath(c)
+			if c := containsPredicateCall(c.Args[1]); c != nil {
+				return nil, nil, errorAt(c, fmt.Errorf("%s() can not be used inside nested()", c.Name))
+			}
+			if _, ok := nested[path]; !ok {
+				paths = append(paths, path)
+			}
+			nested[path] = append(nested[path], c.Args[1])
+			split = true
+			continue
+		}
+		if c, ok := cond.(*Call); ok && isPredicateCall(c) {
+			q, err := lookupFunction(c).Query(c)
+			if err != nil {
+				return nil, nil, errorAt(c, err)
+			}
+			if path := nestedScope(c); path != "" {
+				q = nestedQuery(path, q)
+			}
+			filters = append(filters, q)
+			split = true
+			continue
+		}
+		if c := containsPredicateCall(cond); c != nil {
+			return nil, nil, errorAt(c, fmt.Errorf("%s() must be used as a top level AND condition", c.Name))
+		}
+		if path := nestedScope(cond); path != "" {
+			if _, ok := nested[path]; !ok {
+				paths = append(paths, path)
+			}
+			nested[path] = append(nested[path], cond)
+			split = true
+			continue
+		}
+		if path := mixedNestedPath(cond); path != "" {
+			// a root document script reads no nested field
+			return nil, nil, errorAt(cond, fmt.Errorf("%s mixes fields of nested path %s with fields of other documents, only AND can combine them", cond, path))
+		}
+		rest = append(rest, cond)
+	}
+	for _, path := range paths {
+		filters = append(filters, nestedQuery(path, scriptQuery(conjunction(nested[path]))))
 	}
-	// t, _ := json.MarshalIndent(js.MustMap(), "", "  ")
-	// fmt.Println(string(t))
 
-	return string(_s), nil
+	// conditional dimensions bucket the documents missing their fields too
+	var names []string
+	for _, d := range s.Dimensions {
+		if !isConditional(d.Expr) {
+			names = append(names, walkNames(d.Expr)...)
+		}
+	}
+	for _, f := range names {
+		exists := map[string]interface{}{
+			"exists": map[string]interface{}{"field": f},
+		}
+		if path := DefaultSchema.NestedPath(f); path != "" {
+			filters = append(filters, nestedQuery(path, exists))
+			continue
+		}
+		filters = append(filters, exists)
+	}
+
+	if !split {
+		// keep the condition untouched when nothing has been split out
+		return filters, s.Condition, nil
+	}
+	return filters, conjunction(rest), nil
 }
 
-// replace all doc['xxx'].value to xxx
+// conjuncts splits expr on its top level AND operators.
+func conjuncts(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case nil:
+		return nil
+	case *BinaryExpr:
+		if e.Op == AND {
+			return append(conjuncts(e.LHS), conjuncts(e.RHS)...)
+		}
+	case *ParenExpr:
+		if b, ok := e.Expr.(*BinaryExpr); ok && b.Op == AND {
+			return conjuncts(b)
+		}
+	}
+	return []Expr{expr}
+}
+
+// conjunction joins exprs with AND, it is the reverse of conjuncts.
+func conjunction(exprs []Expr) Expr {
+	var cond Expr
+	for _, expr := range exprs {
+		if b, ok := expr.(*BinaryExpr); ok && b.Op == OR {
+			expr = &ParenExpr{Expr: b}
+		}
+		if cond == nil {
+			cond = expr
+			continue
+		}
+		cond = &BinaryExpr{Op: AND, LHS: cond, RHS: expr}
+	}
+	return cond
+}
+
+// replace all doc['xxx'].value and `xxx` to xxx
 func cleanDocString(s string) string {
 	reg := regexp.MustCompile(`doc\['(.+?)'\]\.value`)
 	l := reg.ReplaceAllString(s, "${1}")
-	return l
+	return unquoteIdents(l)
+}
+
+// unquoteIdents removes the backticks around the quoted identifiers of s.
+func unquoteIdents(s string) string {
+	if !strings.Contains(s, "`") {
+		return s
+	}
+	return regexp.MustCompile("`((?:[^`]|``)*)`").ReplaceAllStringFunc(s, func(q string) string {
+		return strings.Replace(q[1:len(q)-1], "``", "`", -1)
+	})
 }
 
 func (s *SelectStatement) BucketSelectorAggregation() *Agg {
 	if s.Having == nil {
 		return nil
 	}
-	s.RewriteHaving()
 	// fieldAsNames := s.Fields.AliasNames()
 	havingNames := s.NamesInHaving()
 	agg := &Agg{}
 	agg.name = "having"
 	agg.typ = BucketSelector
 	agg.params = make(map[string]interface{})
-	sm := make(map[string]string)
-	sm["lang"] = "expression"
-	sm["inline"] = cleanDocString(s.Having.String())
-	agg.params["script"] = sm
+	// the literals are params, so HAVING clauses differing only by their
+	// values share one compiled script
+	p := &scriptPrinter{params: make(map[string]interface{}), reserved: make(map[string]bool), expression: true}
+	for _, name := range havingNames {
+		p.reserved[name] = true
+	}
+	// checked by validateScalars
+	having, _ := expression(s.Having, p)
+	agg.params["script"] = p.expressionScript(cleanDocString(having))
 	bm := make(map[string]string)
 	for _, name := range havingNames {
 		if s.isStarCount(name) {
-			bm[name] = "_count"
+			bm[name] = s.starCountPath(name)
 			continue
 		}
-		bm[name] = name
+		bm[name] = s.metricBucketsPath(name)
 	}
 	agg.params["buckets_path"] = bm
 
 	return agg
 }
 
+// bucketScope returns the nested path the innermost bucket aggregation runs in.
+func (s *SelectStatement) bucketScope() string {
+	if len(s.Dimensions) == 0 {
+		return ""
+	}
+	return nestedScope(s.Dimensions[len(s.Dimensions)-1].Expr)
+}
+
+// metricScope returns the aggregations a metric on the argument of fn has to
+// be wrapped in to reach the nested path of its field.
+func (s *SelectStatement) metricScope(fn *Call) Aggs {
+	if len(fn.Args) == 0 {
+		return nil
+	}
+	if _, ok := fn.Args[0].(*Wildcard); ok {
+		return nil
+	}
+	return scopeTransition(s.bucketScope(), nestedScope(fn.Args[0]))
+}
+
+// metricBucketsPath returns the buckets_path of the metric field named name,
+// or of the ORDER BY aggregate named name.
+func (s *SelectStatement) metricBucketsPath(name string) string {
+	for _, field := range s.Fields {
+		fn, ok := field.Expr.(*Call)
+		if !ok || field.metricAggName() != name {
+			continue
+		}
+		if isCountStar(fn) {
+			return s.starCountPath(name)
+		}
+		agg := &Agg{name: name, scope: s.metricScope(fn)}
+		return agg.bucketsPath()
+	}
+	for _, sf := range s.SortFields {
+		if sf.Call != nil && s.sortAggName(sf.Call) == name {
+			if isCountStar(sf.Call) {
+				return s.starCountPath(name)
+			}
+			agg := &Agg{name: name, scope: s.metricScope(sf.Call)}
+			return agg.bucketsPath()
+		}
+	}
+	return name
+}
+
 func (s *SelectStatement) bucketAggregations() Aggs {
 	var aggs Aggs
+	var scope string
 	s.RewriteDimensions()
 	for _, dim := range s.Dimensions {
+		// step into or out of nested documents before bucketing on their fields
+		path := nestedScope(dim.Expr)
+		aggs = append(aggs, scopeTransition(scope, path)...)
+		scope = path
+
 		agg := &Agg{}
 		agg.params = make(map[string]interface{})
 		if dim.Alias == "" {
@@ -300,67 +669,46 @@ func (s *SelectStatement) bucketAggregations() Aggs {
 			agg.name = cleanDocString(dim.Alias)
 		}
 
+		// a CASE on ranges of one field is bucketed natively
+		if c, ok := dim.Expr.(*CaseExpr); ok {
+			if typ, params, ok := caseBuckets(c); ok {
+				agg.typ, agg.params = typ, params
+				aggs = append(aggs, agg)
+				continue
+			}
+		}
+
 		switch expr := dim.Expr.(type) {
 		case *Call:
-			fn := expr.Name
-			switch fn {
-			case "range":
-				agg.typ = Range
-				switch arg0 := expr.Args[0].(type) {
-				case *BinaryExpr:
-					agg.params["script"] = arg0.String()
-				default:
-					agg.params["field"] = cleanDocString(arg0.String())
-				}
-				agg.params["keyed"] = true
-				ranges := make([]map[string]string, 0, len(expr.Args))
-				args := expr.Args[1:]
-				for i, arg := range args {
-					m := make(map[string]string)
-					if i == 0 {
-						m["to"] = arg.String()
-					} else {
-						m["from"] = args[i-1].String()
-						m["to"] = arg.String()
-					}
-					ranges = append(ranges, m)
-				}
-				ranges = append(ranges, map[string]string{"from": args[len(args)-1].String()})
-				agg.params["ranges"] = ranges
-
-			case "histogram":
-
-				agg.typ = Histogram
-				agg.params["field"] = cleanDocString(expr.Args[0].String())
-				agg.params["interval"] = expr.Args[1].String()
-				agg.params["min_doc_count"] = 0
-				// agg.params["min"] = expr.Args[2].String()
-				// agg.params["max"] = expr.Args[3].String()
-			case "date_histogram":
-
-				agg.typ = DateHistogram
-				agg.params["field"] = strings.Trim(expr.Args[0].String(), "'")
-				//support `year`, `quarter`, `month`, `week`, `day`, `hour`, `minute`, `second`
-				agg.params["interval"] = strings.Trim(expr.Args[1].String(), "'")
-			default:
-				// terms inline expression
-				agg.typ = Terms
-				//order
-				if len(s.SortFields) > 0 {
-					agg.params["order"] = s.orders()
-				}
-				agg.params["size"] = s.Limit
+			if callKind(expr) == BucketFunction {
+				// checked by validateCall
+				typ, params, _ := callAgg(expr)
+				agg.setType(typ)
+				agg.params = params
+				break
+			}
+			// terms inline expression
+			agg.typ = Terms
+			//order
+			if len(s.SortFields) > 0 {
+				agg.params["order"] = s.orders()
+			}
+			agg.params["size"] = s.Limit
+			// a lucene expression when the functions have one, else painless
+			if src, err := expression(expr, nil); err == nil {
 				m := make(map[string]string, 0)
 				m["lang"] = "expression"
-				m["inline"] = expr.String()
+				m["inline"] = src
 				agg.params["script"] = m
+			} else {
+				agg.params["script"] = script(expr)
 			}
 
 		default:
 			agg.typ = Terms
 			switch term := expr.(type) {
-			case *BinaryExpr:
-				agg.params["script"] = term.String()
+			case *BinaryExpr, *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr, *CastExpr:
+				agg.params["script"] = script(term)
 			default:
 				agg.params["field"] = cleanDocString(term.String())
 			}
@@ -376,50 +724,38 @@ func (s *SelectStatement) bucketAggregations() Aggs {
 	return aggs
 }
 
-// bucketFunctionCalls walks the Field of function calls expr
-func bucketFunctionCalls(exp Expr) []*Call {
-	switch expr := exp.(type) {
-	case *VarRef:
-		return nil
-	case *Call:
-		return []*Call{expr}
-	case *BinaryExpr:
-		var ret []*Call
-		ret = append(ret, bucketFunctionCalls(expr.LHS)...)
-		ret = append(ret, bucketFunctionCalls(expr.RHS)...)
-		return ret
-	case *ParenExpr:
-		return bucketFunctionCalls(expr.Expr)
-	}
-
-	return nil
-}
-
 func (s *SelectStatement) bucketScriptAggs() Aggs {
 	var aggs Aggs
 	for _, f := range s.Fields {
 		switch f.Expr.(type) {
-		case *Call, *VarRef, *Wildcard:
+		case *VarRef, *Wildcard:
+			continue
+		case *Call:
+			if !isScalarCall(f.Expr) {
+				continue
+			}
+		}
+		if isComputed(f.Expr) {
 			continue
 		}
 
-		calls := bucketFunctionCalls(f.Expr)
+		calls := aggregateCalls(f.Expr)
 		bucketsPath := make(map[string]string)
-		inlineExpr := cleanDocString(f.Expr.String())
+		p := &scriptPrinter{params: make(map[string]interface{}), reserved: make(map[string]bool), expression: true}
+		// checked by validateScalars
+		src, _ := expression(f.Expr, p)
+		inlineExpr := cleanDocString(src)
 
 		for i, fn := range calls {
-			agg := &Agg{}
-
-			agg.typ = fn.metricAggType()
-			agg.params = fn.metricAggParams()
-			agg.name = fmt.Sprintf(`%s(%s)`, fn.Name, cleanDocString(fn.Args[0].String()))
+			name := fmt.Sprintf(`%s(%s)`, fn.Name, cleanDocString(fn.Args[0].String()))
+			fnAggs := s.callAggs(name, fn)
 
 			path := fmt.Sprintf("path%d", i)
-			bucketsPath[path] = agg.name
+			bucketsPath[path] = fnAggs[len(fnAggs)-1].bucketsPath()
 			//todo: ugly, should use walk tree method
-			inlineExpr = strings.Replace(inlineExpr, agg.name, path, -1)
+			inlineExpr = strings.Replace(inlineExpr, name, path, -1)
 
-			aggs = append(aggs, agg)
+			aggs = append(aggs, fnAggs...)
 		}
 
 		agg := &Agg{}
@@ -430,10 +766,7 @@ func (s *SelectStatement) bucketScriptAggs() Aggs {
 		} else {
 			agg.name = cleanDocString(f.Alias)
 		}
-		sm := make(map[string]string)
-		sm["lang"] = "expression"
-		sm["inline"] = inlineExpr
-		agg.params["script"] = sm
+		agg.params["script"] = p.expressionScript(inlineExpr)
 		agg.params["buckets_path"] = bucketsPath
 
 		aggs = append(aggs, agg)
@@ -446,10 +779,20 @@ func (c *Call) metricAggParams() map[string]interface{} {
 	params := make(map[string]interface{})
 	switch arg := c.Args[0].(type) {
 	case *VarRef:
-		params["field"] = arg.String()
-	case *BinaryExpr:
+		if c.Name == "geo_bounds" || c.Name == "geo_centroid" {
+			params, _ = geoAggParams(c)
+			break
+		}
+		params["field"] = arg.Val
+	case *BinaryExpr, *CaseExpr, *IfExpr, *CoalesceExpr, *NullIfExpr, *CastExpr:
 		c.RewriteMetricArgs()
-		params["script"] = c.Args[0].String()
+		params["script"] = script(c.Args[0])
+	case *Call:
+		if !isScalarCall(arg) {
+			panic(fmt.Errorf("not support metric argument"))
+		}
+		c.RewriteMetricArgs()
+		params["script"] = script(c.Args[0])
 	case *Wildcard:
 		params["field"] = ""
 	default:
@@ -458,21 +801,52 @@ func (c *Call) metricAggParams() map[string]interface{} {
 	return params
 }
 
-func (c *Call) metricAggType() ESAgg {
+// callAggs returns the aggregations of the metric or pipeline call fn
+// named name, a pipeline comes after the metric it reads.
+func (s *SelectStatement) callAggs(name string, fn *Call) Aggs {
+	agg := &Agg{name: name}
 	// sql use count(), es func is value_count()
-	if c.Name == "count" {
-		if _, ok := c.Args[0].(*Wildcard); ok {
-			return StarCount
+	if _, ok := fn.Args[0].(*Wildcard); ok && fn.Name == "count" {
+		agg.typ = StarCount
+		if s.bucketScope() != "" {
+			// nested buckets count nested documents, reverse_nested
+			// counts their parents
+			agg.typ, agg.params = ReverseNested, make(map[string]interface{})
 		}
-		return ValueCount
+		return Aggs{agg}
+	}
+	// checked by validateCall
+	typ, params, _ := callAgg(fn)
+	agg.setType(typ)
+	agg.params = params
+	if callKind(fn) != PipelineFunction {
+		agg.scope = s.metricScope(fn)
+		return Aggs{agg}
 	}
 
-	for i := metricBegin; i < metricEnd; i++ {
-		if aggs[i] == c.Name {
-			return ESAgg(i)
-		}
+	metric := fn.Args[0].(*Call)
+	metricAggs := s.callAggs(fmt.Sprintf(`%s(%s)`, metric.Name, unquoteIdents(metric.Args[0].String())), metric)
+	last := metricAggs[len(metricAggs)-1]
+	if last.typ == StarCount {
+		agg.params["buckets_path"] = "_count"
+		return Aggs{agg}
+	}
+	agg.params["buckets_path"] = last.bucketsPath()
+	return append(metricAggs, agg)
+}
+
+// isCountStar reports whether c is count(*).
+func isCountStar(c *Call) bool {
+	return c.Name == "count" && len(c.Args) == 1 && c.Args[0].String() == "*"
+}
+
+// starCountPath returns the buckets_path of the count(*) field named name,
+// the doc count of the bucket unless it is a nested one.
+func (s *SelectStatement) starCountPath(name string) string {
+	if s.bucketScope() == "" {
+		return "_count"
 	}
-	panic(fmt.Errorf("not support agg aggregation"))
+	return name + ">_count"
 }
 
 func (f *Field) metricAggName() string {
@@ -480,22 +854,47 @@ func (f *Field) metricAggName() string {
 		return f.Alias
 	}
 	fn, _ := f.Expr.(*Call)
-	return fmt.Sprintf(`%s(%s)`, fn.Name, fn.Args[0].String())
+	return fmt.Sprintf(`%s(%s)`, fn.Name, unquoteIdents(fn.Args[0].String()))
+}
+
+// sortAggName returns the name of the aggregation of an ORDER BY aggregate,
+// the one of the select field computing it if there is one.
+func (s *SelectStatement) sortAggName(c *Call) string {
+	if isCountStar(c) && s.bucketScope() == "" {
+		return "_count"
+	}
+	for _, f := range s.Fields {
+		if f.Expr.String() == c.String() {
+			return f.metricAggName()
+		}
+	}
+	f := &Field{Expr: c}
+	return f.metricAggName()
 }
 
 func (s *SelectStatement) metricAggs() Aggs {
 	var aggs Aggs
 	for _, field := range s.Fields {
 		fn, ok := field.Expr.(*Call)
-		if !ok {
+		if !ok || isScalarCall(fn) {
 			continue
 		}
-		agg := &Agg{}
-		agg.name = field.metricAggName()
-		agg.typ = fn.metricAggType()
-		agg.params = fn.metricAggParams()
-
-		aggs = append(aggs, agg)
+		for _, agg := range s.callAggs(field.metricAggName(), fn) {
+			// the metric of a pipeline may be a field too
+			if aggs.find(agg.name) == nil {
+				aggs = append(aggs, agg)
+			}
+		}
+	}
+	// the aggregates the buckets are sorted on without selecting them
+	for _, sf := range s.SortFields {
+		if sf.Call == nil {
+			continue
+		}
+		name := s.sortAggName(sf.Call)
+		if name != "_count" && aggs.find(name) == nil {
+			aggs = append(aggs, s.callAggs(name, sf.Call)...)
+		}
 	}
 
 	//append bucket script aggregation
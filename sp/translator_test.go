@@ -1,7 +1,11 @@
 package sp_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/bitly/go-simplejson"
@@ -60,7 +64,7 @@ func TestTranslator_EsDsl(t *testing.T) {
                       "bool": {
                         "filter": {
                           "script": {
-                            "script": "doc['exchange'].value == 'nyse'"
+                            "script": {"inline": "doc['exchange'].value == params.p0", "params": {"p0": "nyse"}}
                           }
                         }
                       }
@@ -78,7 +82,7 @@ func TestTranslator_EsDsl(t *testing.T) {
                       "bool": {
                         "filter": {
                           "script": {
-                            "script": "doc['last_sale'].value > 985"
+                            "script": {"inline": "doc['last_sale'].value > params.p0", "params": {"p0": 985}}
                           }
                         }
                       }
@@ -96,7 +100,7 @@ func TestTranslator_EsDsl(t *testing.T) {
                       "bool": {
                         "filter": {
                           "script": {
-                            "script": "doc['last_sale'].value != 985"
+                            "script": {"inline": "doc['last_sale'].value != params.p0", "params": {"p0": 985}}
                           }
                         }
                       }
@@ -114,7 +118,7 @@ func TestTranslator_EsDsl(t *testing.T) {
                         "bool": {
                           "filter": {
                             "script": {
-                              "script": "doc['exchange'].value == 'nyse' && doc['sector'].value == 'Technology'"
+                              "script": {"inline": "doc['exchange'].value == params.p0 && doc['sector'].value == params.p1", "params": {"p0": "nyse", "p1": "Technology"}}
                             }
                           }
                         }
@@ -132,7 +136,7 @@ func TestTranslator_EsDsl(t *testing.T) {
                       "bool": {
                         "filter": {
                           "script": {
-                            "script": "doc['exchange'].value == 'nyse' || doc['sector'].value != 'Technology'"
+                            "script": {"inline": "doc['exchange'].value == params.p0 || doc['sector'].value != params.p1", "params": {"p0": "nyse", "p1": "Technology"}}
                           }
                         }
                       }
@@ -150,7 +154,7 @@ func TestTranslator_EsDsl(t *testing.T) {
                     "bool": {
                       "filter": {
                         "script": {
-                          "script": "doc['@timestamp'].value > 1482908284586"
+                          "script": {"inline": "doc['@timestamp'].value > params.p0", "params": {"p0": 1482908284586}}
                         }
                       }
                     }
@@ -244,7 +248,7 @@ func TestTranslator_EsDsl(t *testing.T) {
                     "query": {
                       "bool": {
                         "filter": {
-                          "script": {"script": "doc['ipo_year'].value == 1998"}
+                          "script": {"script": {"inline": "doc['ipo_year'].value == params.p0", "params": {"p0": 1998}}}
                         }
                       }
                     },
@@ -387,7 +391,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				      "bool": {
 				        "filter": {
 				          "script": {
-				            "script": "doc['symbol'].value == 'AAPL'"
+				            "script": {"inline": "doc['symbol'].value == params.p0", "params": {"p0": "AAPL"}}
 				          }
 				        }
 				      }
@@ -506,7 +510,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				      "ipo_year_rem": {
 				        "aggs": {},
 				        "terms": {
-				          "script": "doc['ipo_year'].value % 5",
+				          "script": {"inline": "doc['ipo_year'].value % params.p0", "params": {"p0": 5}},
 				          "size": 0
 				        }
 				      }
@@ -608,8 +612,9 @@ func TestTranslator_EsDsl(t *testing.T) {
 				                "ipo_count": "_count"
 				              },
 				              "script": {
-				                "inline": "ipo_count \u003e 200",
-				                "lang": "expression"
+				                "inline": "ipo_count \u003e p0",
+				                "lang": "expression",
+				                "params": {"p0": 200}
 				              }
 				            }
 				          }
@@ -640,8 +645,9 @@ func TestTranslator_EsDsl(t *testing.T) {
 				                "max_last_sale": "max_last_sale"
 				              },
 				              "script": {
-				                "inline": "ipo_count > 100 && max_last_sale <= 10000",
-				                "lang": "expression"
+				                "inline": "ipo_count > p0 && max_last_sale <= p1",
+				                "lang": "expression",
+				                "params": {"p0": 100, "p1": 10000}
 				              }
 				            }
 				          },
@@ -719,7 +725,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          },
 				          "sum(ipo_year * 2)": {
 				            "sum": {
-				              "script": "doc['ipo_year'].value * 2"
+				              "script": {"inline": "doc['ipo_year'].value * params.p0", "params": {"p0": 2}}
 				            }
 				          },
 				          "sum(ipo_year)": {
@@ -808,7 +814,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				        "aggs": {
 				          "sum(ipo_year + last_sale * 2)": {
 				            "sum": {
-				              "script": "doc['ipo_year'].value + doc['last_sale'].value * 2"
+				              "script": {"inline": "doc['ipo_year'].value + doc['last_sale'].value * params.p0", "params": {"p0": 2}}
 				            }
 				          },
 				          "yyyy": {
@@ -817,8 +823,9 @@ func TestTranslator_EsDsl(t *testing.T) {
 				                "path0": "sum(ipo_year + last_sale * 2)"
 				              },
 				              "script": {
-				                "inline": "0 - 5 * path0",
-				                "lang": "expression"
+				                "inline": "p0 - p1 * path0",
+				                "lang": "expression",
+				                "params": {"p0": 0, "p1": 5}
 				              }
 				            }
 				          }
@@ -849,3 +856,1598 @@ func TestTranslator_EsDsl(t *testing.T) {
 		}
 	}
 }
+
+// Ensure conditions and aggregations on nested fields are wrapped into nested queries and aggregations.
+func TestTranslator_Nested(t *testing.T) {
+	useSchema(t)
+	sp.DefaultSchema.AddNested("comments")
+
+	var tests = []struct {
+		sql string
+		dsl string
+		err string
+	}{
+		//nested function condition
+		{
+			sql: `select * from blog where nested(comments, comments.author='kimchy') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "and": [
+                            {
+                              "nested": {
+                                "path": "comments",
+                                "query": {
+                                  "bool": {"filter": {"script": {"script": {"inline": "doc['comments.author'].value == params.p0", "params": {"p0": "kimchy"}}}}}
+                                }
+                              }
+                            }
+                          ]
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//nested schema conditions share one nested query
+		{
+			sql: `select * from blog where comments.author='kimchy' and title='es' and comments.stars > 3 limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "and": [
+                            {
+                              "nested": {
+                                "path": "comments",
+                                "query": {
+                                  "bool": {"filter": {"script": {"script": {"inline": "doc['comments.author'].value == params.p0 && doc['comments.stars'].value > params.p1", "params": {"p0": "kimchy", "p1": 3}}}}}
+                                }
+                              }
+                            },
+                            {"script": {"script": {"inline": "doc['title'].value == params.p0", "params": {"p0": "es"}}}}
+                          ]
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//group by nested field with parent metric
+		{
+			sql: `select comments.author, avg(comments.stars), sum(price) from blog group by comments.author`,
+			dsl: `{
+                    "aggs": {
+                      "comments": {
+                        "nested": {"path": "comments"},
+                        "aggs": {
+                          "comments.author": {
+                            "terms": {"field": "comments.author", "size": 0},
+                            "aggs": {
+                              "avg(comments.stars)": {"avg": {"field": "comments.stars"}},
+                              "sum(price)": {
+                                "reverse_nested": {},
+                                "aggs": {"sum(price)": {"sum": {"field": "price"}}}
+                              }
+                            }
+                          }
+                        }
+                      }
+                    },
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "and": [
+                            {"nested": {"path": "comments", "query": {"exists": {"field": "comments.author"}}}}
+                          ]
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//group by nested field then parent field
+		{
+			sql: `select count(*) from blog group by comments.author, category`,
+			dsl: `{
+                    "aggs": {
+                      "comments": {
+                        "nested": {"path": "comments"},
+                        "aggs": {
+                          "comments.author": {
+                            "terms": {"field": "comments.author", "size": 0},
+                            "aggs": {
+                              "reverse_nested": {
+                                "reverse_nested": {},
+                                "aggs": {
+                                  "category": {
+                                    "terms": {"field": "category", "size": 0},
+                                    "aggs": {}
+                                  }
+                                }
+                              }
+                            }
+                          }
+                        }
+                      }
+                    },
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "and": [
+                            {"nested": {"path": "comments", "query": {"exists": {"field": "comments.author"}}}},
+                            {"exists": {"field": "category"}}
+                          ]
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//buckets sorted on a nested metric through its wrapper
+		{
+			sql: `select category, avg(comments.stars) as s from blog group by category order by s desc`,
+			dsl: `{
+                    "aggs": {
+                      "category": {
+                        "terms": {"field": "category", "order": [{"s>s": "desc"}], "size": 0},
+                        "aggs": {
+                          "s": {
+                            "nested": {"path": "comments"},
+                            "aggs": {"s": {"avg": {"field": "comments.stars"}}}
+                          }
+                        }
+                      }
+                    },
+                    "query": {
+                      "bool": {"filter": {"and": [{"exists": {"field": "category"}}]}}
+                    },
+                    "size": 0
+                  }`,
+		},
+		//count(*) of a nested bucket counts the parent documents
+		{
+			sql: `select comments.author, count(*) as c from blog group by comments.author having c > 1 order by c desc`,
+			dsl: `{
+                    "aggs": {
+                      "comments": {
+                        "nested": {"path": "comments"},
+                        "aggs": {
+                          "comments.author": {
+                            "terms": {"field": "comments.author", "order": [{"c>_count": "desc"}], "size": 0},
+                            "aggs": {
+                              "c": {"reverse_nested": {}},
+                              "having": {
+                                "bucket_selector": {
+                                  "buckets_path": {"c": "c>_count"},
+                                  "script": {"inline": "c > p0", "lang": "expression", "params": {"p0": 1}}
+                                }
+                              }
+                            }
+                          }
+                        }
+                      }
+                    },
+                    "query": {
+                      "bool": {"filter": {"and": [{"nested": {"path": "comments", "query": {"exists": {"field": "comments.author"}}}}]}}
+                    },
+                    "size": 0
+                  }`,
+		},
+		{
+			sql: `select category from blog group by category order by max(comments.stars) desc`,
+			dsl: `{
+                    "aggs": {
+                      "category": {
+                        "terms": {"field": "category", "order": [{"max(comments.stars)>max(comments.stars)": "desc"}], "size": 0},
+                        "aggs": {
+                          "max(comments.stars)": {
+                            "nested": {"path": "comments"},
+                            "aggs": {"max(comments.stars)": {"max": {"field": "comments.stars"}}}
+                          }
+                        }
+                      }
+                    },
+                    "query": {
+                      "bool": {"filter": {"and": [{"exists": {"field": "category"}}]}}
+                    },
+                    "size": 0
+                  }`,
+		},
+		//a script on the root document can not read nested fields
+		{
+			sql: `select * from blog where comments.author = 'bob' or price > 1`,
+			err: `comments.author = 'bob' OR price > 1 mixes fields of nested path comments with fields of other documents, only AND can combine them at line 1, char 26`,
+		},
+		//nested function can not be ORed
+		{
+			sql: `select * from blog where title='es' or nested(comments, comments.stars > 3)`,
+			err: `nested() must be used as a top level AND condition at line 1, char 40`,
+		},
+	}
+	for i, tt := range tests {
+		dsl, err := sp.EsDsl(tt.sql)
+		if tt.err != errstring(err) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if tt.err != "" {
+			continue
+		}
+		_dsl, _ := simplejson.NewJson([]byte(dsl))
+		ttdsl, _ := simplejson.NewJson([]byte(tt.dsl))
+
+		if !reflect.DeepEqual(_dsl.MustMap(), ttdsl.MustMap()) {
+			t.Errorf("%d. %q\n\ndsl mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.sql, ttdsl, _dsl)
+		}
+	}
+}
+
+// Ensure geo functions are translated into geo filters and metrics.
+func TestTranslator_Geo(t *testing.T) {
+	var tests = []struct {
+		sql string
+		dsl string
+		err string
+	}{
+		//geo distance
+		{
+			sql: `select * from shop where geo_distance(location, 40.7, -74.0, '10km') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "and": [
+                            {"geo_distance": {"distance": "10km", "location": {"lat": 40.7, "lon": -74}}}
+                          ]
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//geo bounding box and script condition
+		{
+			sql: `select * from shop where geo_bbox(location, 41, -75, 40.5, -73) and name='x' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "and": [
+                            {
+                              "geo_bounding_box": {
+                                "location": {
+                                  "top_left": {"lat": 41, "lon": -75},
+                                  "bottom_right": {"lat": 40.5, "lon": -73}
+                                }
+                              }
+                            },
+                            {"script": {"script": {"inline": "doc['name'].value == params.p0", "params": {"p0": "x"}}}}
+                          ]
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//geo polygon
+		{
+			sql: `select * from shop where geo_polygon(location, [40, -70, 30, -80, 20, -90]) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "and": [
+                            {
+                              "geo_polygon": {
+                                "location": {
+                                  "points": [
+                                    {"lat": 40, "lon": -70},
+                                    {"lat": 30, "lon": -80},
+                                    {"lat": 20, "lon": -90}
+                                  ]
+                                }
+                              }
+                            }
+                          ]
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//geo metrics
+		{
+			sql: `select city, geo_bounds(location, true), geo_centroid(location) from shop group by city`,
+			dsl: `{
+                    "aggs": {
+                      "city": {
+                        "terms": {"field": "city", "size": 0},
+                        "aggs": {
+                          "geo_bounds(location)": {"geo_bounds": {"field": "location", "wrap_longitude": true}},
+                          "geo_centroid(location)": {"geo_centroid": {"field": "location"}}
+                        }
+                      }
+                    },
+                    "query": {
+                      "bool": {"filter": {"and": [{"exists": {"field": "city"}}]}}
+                    },
+                    "size": 0
+                  }`,
+		},
+		//geo predicate can not be ORed
+		{
+			sql: `select * from shop where name='x' or geo_distance(location, 40.7, -74.0, 100)`,
+			err: `geo_distance() must be used as a top level AND condition at line 1, char 38`,
+		},
+	}
+	for i, tt := range tests {
+		dsl, err := sp.EsDsl(tt.sql)
+		if tt.err != errstring(err) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if tt.err != "" {
+			continue
+		}
+		_dsl, _ := simplejson.NewJson([]byte(dsl))
+		ttdsl, _ := simplejson.NewJson([]byte(tt.dsl))
+
+		if !reflect.DeepEqual(_dsl.MustMap(), ttdsl.MustMap()) {
+			t.Errorf("%d. %q\n\ndsl mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.sql, ttdsl, _dsl)
+		}
+	}
+}
+
+// Ensure the structured translation locates every column in the response.
+func TestTranslator_TranslateDSL(t *testing.T) {
+	var tests = []struct {
+		sql      string
+		columns  []*sp.Column
+		warnings []string
+	}{
+		{
+			sql: `select name, comments.author from blog limit 1`,
+			columns: []*sp.Column{
+				{Name: "name", Path: []string{"hits", "hits", "*", "_source", "name"}},
+				{Name: "comments.author", Path: []string{"hits", "hits", "*", "_source", "comments", "author"}},
+			},
+		},
+		{
+			sql: `select count(*), avg(x) as a, geo_centroid(loc) from blog`,
+			columns: []*sp.Column{
+				{Name: "count", Path: []string{"hits", "total"}},
+				{Name: "a", Path: []string{"aggregations", "a", "value"}},
+				{Name: "geo_centroid", Path: []string{"aggregations", "geo_centroid(loc)", "location"}},
+			},
+		},
+		{
+			sql: `select exchange, name, max(market_cap), count(*) as n, sum(a)/sum(b) as r from symbol group by exchange, histogram(x, 5) as h limit 3`,
+			columns: []*sp.Column{
+				{Name: "h", Path: []string{"aggregations", "exchange", "buckets", "*", "h", "buckets", "*", "key"}},
+				{Name: "exchange", Path: []string{"aggregations", "exchange", "buckets", "*", "key"}},
+				{Name: "max", Path: []string{"aggregations", "exchange", "buckets", "*", "h", "buckets", "*", "max(market_cap)", "value"}},
+				{Name: "n", Path: []string{"aggregations", "exchange", "buckets", "*", "h", "buckets", "*", "doc_count"}},
+				{Name: "r", Path: []string{"aggregations", "exchange", "buckets", "*", "h", "buckets", "*", "r", "value"}},
+			},
+			warnings: []string{
+				"name is neither grouped by nor aggregated, ignored",
+				"LIMIT 3 applies to every GROUP BY level",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if err != nil {
+			t.Errorf("%d. %s: error\n\n %s", i, tt.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(tr.Columns, tt.columns) {
+			got, _ := json.Marshal(tr.Columns)
+			t.Errorf("%d. %s\n\ncolumns mismatch:\n\ngot=%s\n\n", i, tt.sql, got)
+		}
+		if !reflect.DeepEqual(tr.Warnings, tt.warnings) {
+			t.Errorf("%d. %s\n\nwarnings mismatch:\n\nexp=%q\n\ngot=%q\n\n", i, tt.sql, tt.warnings, tr.Warnings)
+		}
+		dsl, _ := sp.EsDsl(tt.sql)
+		if body, _ := tr.JSON(); body != dsl {
+			t.Errorf("%d. %s\n\nbody mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, dsl, body)
+		}
+	}
+}
+
+// Ensure LIMIT ALL statements are translated into a pagination plan.
+func TestTranslator_LimitAll(t *testing.T) {
+	var tests = []struct {
+		sql        string
+		dsl        string
+		pagination *sp.Pagination
+		warnings   []string
+		err        string
+	}{
+		{
+			sql:        `select name from symbol order by name limit all`,
+			dsl:        `{"size":1000,"sort":[{"name":"asc"},{"_shard_doc":"asc"}]}`,
+			pagination: &sp.Pagination{Mode: "search_after", PageSize: 1000, KeepAlive: "1m"},
+		},
+		{
+			sql:        `select name from symbol where exchange = 'nyse' and geo_distance(loc, 40.7, -74.0, '1km') limit all`,
+			dsl:        `{"query":{"bool":{"filter":[{"geo_distance":{"distance":"1km","loc":{"lat":40.7,"lon":-74}}},{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nyse"}}}}]}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`,
+			pagination: &sp.Pagination{Mode: "search_after", PageSize: 1000, KeepAlive: "1m"},
+		},
+		{
+			sql:        `select /*+ pagination(scroll) page_size(500) keep_alive(5m) */ name from symbol limit all`,
+			dsl:        `{"size":500,"sort":[{"_doc":"asc"}]}`,
+			pagination: &sp.Pagination{Mode: "scroll", PageSize: 500, KeepAlive: "5m"},
+		},
+		{
+			sql:      `select /*+ page_size(500) */ name from symbol limit 20000, 5`,
+			dsl:      `{"from":5,"size":20000,"sort":[]}`,
+			warnings: []string{"from + size of 20005 exceeds the default index.max_result_window of 10000, use LIMIT ALL to read every hit", "page_size hint is ignored without LIMIT ALL"},
+		},
+		{
+			sql: `select count(*) from symbol group by exchange limit all`,
+			err: `LIMIT ALL is only supported by raw queries at line 1, char 47`,
+		},
+		{
+			sql: `select /*+ pagination(pit) */ name from symbol limit all`,
+			err: `invalid pagination hint, expected one of search_after, scroll, got "pit" at line 1, char 8`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if body, _ := tr.JSON(); body != tt.dsl {
+			t.Errorf("%d. %s\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.dsl, body)
+		}
+		if !reflect.DeepEqual(tr.Pagination, tt.pagination) {
+			t.Errorf("%d. %s\n\npagination mismatch:\n\nexp=%+v\n\ngot=%+v\n\n", i, tt.sql, tt.pagination, tr.Pagination)
+		}
+		if !reflect.DeepEqual(tr.Warnings, tt.warnings) {
+			t.Errorf("%d. %s\n\nwarnings mismatch:\n\nexp=%q\n\ngot=%q\n\n", i, tt.sql, tt.warnings, tr.Warnings)
+		}
+	}
+}
+
+// Ensure conditional expressions are computed by scripts, and CASE on ranges of one field by native buckets.
+func TestTranslator_Conditional(t *testing.T) {
+	var tests = []struct {
+		sql string
+		dsl string
+		err string
+	}{
+		{
+			sql: `select count(*) from symbol group by CASE WHEN last_sale < 10 THEN 'low' WHEN last_sale >= 10 AND last_sale < 100 THEN 'mid' WHEN last_sale >= 100 THEN 'high' END AS tier`,
+			dsl: `{"aggs":{"tier":{"aggs":{},"range":{"field":"last_sale","keyed":true,"ranges":[{"key":"low","to":10},{"from":10,"key":"mid","to":100},{"from":100,"key":"high"}]}}},"size":0}`,
+		},
+		{
+			sql: `select count(*) from symbol group by CASE WHEN last_sale < 10 THEN 'low' WHEN last_sale < 100 THEN 'mid' ELSE 'high' END AS tier`,
+			dsl: `{"aggs":{"tier":{"aggs":{},"filters":{"filters":{"high":{"bool":{"must_not":[{"range":{"last_sale":{"lt":10}}},{"range":{"last_sale":{"lt":100}}}]}},"low":{"range":{"last_sale":{"lt":10}}},"mid":{"bool":{"filter":{"range":{"last_sale":{"lt":100}}},"must_not":[{"range":{"last_sale":{"lt":10}}}]}}}}}},"size":0}`,
+		},
+		{
+			sql: `select count(*) from symbol group by CASE WHEN exchange = 'nyse' THEN 'big' ELSE 'small' END AS board`,
+			dsl: `{"aggs":{"board":{"aggs":{},"terms":{"script":{"inline":"(doc['exchange'].value == params.p0 ? params.p1 : params.p2)","params":{"p0":"nyse","p1":"big","p2":"small"}},"size":0}}},"size":0}`,
+		},
+		{
+			sql: `select name, coalesce(sector, industry, 'n/a') as s from symbol limit 3`,
+			dsl: `{"from":0,"script_fields":{"s":{"script":{"inline":"(doc['sector'].empty ? (doc['industry'].empty ? params.p0 : doc['industry'].value) : doc['sector'].value)","params":{"p0":"n/a"}}}},"size":3,"sort":[]}`,
+		},
+		{
+			sql: `select if(ipo_year > 2000, 'new', 'old') as age, nullif(sector, 'n/a') as sector from symbol limit 1`,
+			dsl: `{"from":0,"script_fields":{"age":{"script":{"inline":"(doc['ipo_year'].value \u003e params.p0 ? params.p1 : params.p2)","params":{"p0":2000,"p1":"new","p2":"old"}}},"sector":{"script":{"inline":"(doc['sector'].value == params.p0 ? null : doc['sector'].value)","params":{"p0":"n/a"}}}},"size":1,"sort":[]}`,
+		},
+		{
+			sql: `select sum(CASE exchange WHEN 'nyse' THEN 1 ELSE 0 END) as nyse from symbol`,
+			dsl: `{"aggs":{"nyse":{"sum":{"script":{"inline":"(doc['exchange'].value == params.p0 ? params.p1 : params.p2)","params":{"p0":"nyse","p1":1,"p2":0}}}}},"from":0,"size":0,"sort":[]}`,
+		},
+		{
+			sql: `select CASE WHEN sum(x) > 1 THEN 1 END from t group by y`,
+			err: `aggregate function sum() can not be used in CASE, use it as the argument of the aggregate instead at line 1, char 18`,
+		},
+		{
+			sql: `select nullif(a) from t`,
+			err: `nullif expects (value, null_value), got 1 argument at line 1, char 8`,
+		},
+		{
+			sql: `select CASE WHEN a > 1 THEN 'x' ELSE 'y' from t`,
+			err: `found FROM, expected END at line 1, char 42`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if body, _ := tr.JSON(); body != tt.dsl {
+			t.Errorf("%d. %s\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.dsl, body)
+		}
+	}
+}
+
+// Ensure scalar functions and casts are scripted as lucene expressions or painless.
+func TestTranslator_Scalar(t *testing.T) {
+	var tests = []struct {
+		sql string
+		dsl string
+		err string
+	}{
+		{
+			sql: `select lower(name) as n from symbol limit 1`,
+			dsl: `{"from":0,"script_fields":{"n":{"script":{"inline":"doc['name'].value.toLowerCase()","lang":"painless"}}},"size":1,"sort":[]}`,
+		},
+		{
+			sql: `select count(*) from symbol group by floor(last_sale) as price`,
+			dsl: `{"aggs":{"price":{"aggs":{},"terms":{"script":{"inline":"floor(doc['last_sale'].value)","lang":"expression"},"size":0}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"last_sale"}}]}}},"size":0}`,
+		},
+		{
+			sql: `select year(ts) as y, count(*) from symbol group by year(ts) as y`,
+			dsl: `{"aggs":{"y":{"aggs":{},"terms":{"script":{"inline":"doc['ts'].value.getYear()","lang":"painless"},"size":0}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"ts"}}]}}},"size":0}`,
+		},
+		{
+			sql: `select count(*) from symbol where length(name) > 3`,
+			dsl: `{"aggs":{},"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['name'].value.length() \u003e params.p0","lang":"painless","params":{"p0":3}}}}}},"size":0,"sort":[]}`,
+		},
+		{
+			sql: `select sum(abs(change)) as moves from symbol`,
+			dsl: `{"aggs":{"moves":{"sum":{"script":{"inline":"Math.abs(doc['change'].value)","lang":"painless"}}}},"from":0,"size":0,"sort":[]}`,
+		},
+		{
+			sql: `select round(avg(last_sale), 2) as price from symbol group by exchange`,
+			dsl: `{"aggs":{"exchange":{"aggs":{"avg(last_sale)":{"avg":{"field":"last_sale"}},"price":{"bucket_script":{"buckets_path":{"path0":"avg(last_sale)"},"script":{"inline":"floor(path0 * pow(10, p0) + 0.5) / pow(10, p0)","lang":"expression","params":{"p0":2}}}}},"terms":{"field":"exchange","size":0}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"exchange"}}]}}},"size":0}`,
+		},
+		{
+			sql: `select exchange, avg(last_sale) as price from symbol group by exchange having round(price) > 3`,
+			dsl: `{"aggs":{"exchange":{"aggs":{"having":{"bucket_selector":{"buckets_path":{"price":"price"},"script":{"inline":"floor(price + 0.5) \u003e p0","lang":"expression","params":{"p0":3}}}},"price":{"avg":{"field":"last_sale"}}},"terms":{"field":"exchange","size":0}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"exchange"}}]}}},"size":0}`,
+		},
+		{
+			sql: `select cast(ipo_year as string) as year from symbol limit 1`,
+			dsl: `{"from":0,"script_fields":{"year":{"script":{"inline":"String.valueOf(doc['ipo_year'].value)","lang":"painless"}}},"size":1,"sort":[]}`,
+		},
+		{
+			sql: `select lower(3) from symbol`,
+			err: `invalid argument 3 for lower(), expected string, got integer at line 1, char 14`,
+		},
+		{
+			sql: `select pow(last_sale) from symbol`,
+			err: `pow expects (number, number), got 1 argument at line 1, char 8`,
+		},
+		{
+			sql: `select date_trunc('week', ts) from symbol`,
+			err: `invalid date_trunc unit 'week', expected one of second, minute, hour, day, month, year at line 1, char 8`,
+		},
+		{
+			sql: `select lower(avg(x)) from symbol group by y`,
+			err: `lower() is not supported by lucene expressions at line 1, char 8`,
+		},
+		{
+			sql: `select cast(x as date) from symbol`,
+			err: `unknown cast type date, expected one of integer, int, long, bigint, float, double, string, varchar, keyword, text, boolean, bool at line 1, char 18`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if body, _ := tr.JSON(); body != tt.dsl {
+			t.Errorf("%d. %s\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.dsl, body)
+		}
+	}
+}
+
+// Ensure function calls are checked against the registry and their options
+// are merged into the aggregations.
+func TestTranslator_Functions(t *testing.T) {
+	if err := sp.RegisterFunction(&sp.Function{
+		Name: "median",
+		Kind: sp.MetricFunction,
+		Args: []string{"field"},
+		Agg: func(c *sp.Call) (string, map[string]interface{}, error) {
+			return "percentiles", map[string]interface{}{"field": c.Args[0].String(), "percents": []int{50}}, nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		sql string
+		dsl string
+		err string
+	}{
+		{
+			sql: `select count(*) from symbol group by histogram(last_sale, 10, min_doc_count = 1)`,
+			dsl: `{"aggs":{"histogram(last_sale, 10, min_doc_count = 1)":{"aggs":{},"histogram":{"field":"last_sale","interval":"10","min_doc_count":1}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"last_sale"}}]}}},"size":0}`,
+		},
+		{
+			sql: `select percentiles(last_sale, percents = [50, 99]) from symbol`,
+			dsl: `{"aggs":{"percentiles(last_sale)":{"percentiles":{"field":"last_sale","percents":[50,99]}}},"from":0,"size":0,"sort":[]}`,
+		},
+		{
+			sql: `select derivative(sum(volume)) as d from symbol group by date_histogram('ts', '1d')`,
+			dsl: `{"aggs":{"date_histogram('ts', '1d')":{"aggs":{"d":{"derivative":{"buckets_path":"sum(volume)"}},"sum(volume)":{"sum":{"field":"volume"}}},"date_histogram":{"field":"ts","interval":"1d"}}},"size":0}`,
+		},
+		{
+			sql: `select max(last_sale, 10) as m from symbol limit 1`,
+			dsl: `{"from":0,"script_fields":{"m":{"script":{"inline":"Math.max(doc['last_sale'].value, params.p0)","lang":"painless","params":{"p0":10}}}},"size":1,"sort":[]}`,
+		},
+		{
+			sql: `select median(last_sale) from symbol`,
+			dsl: `{"aggs":{"median(last_sale)":{"percentiles":{"field":"last_sale","percents":[50]}}},"from":0,"size":0,"sort":[]}`,
+		},
+		{
+			sql: `select count(*) from symbol group by histogram(last_sale)`,
+			err: `histogram expects (field, interval), got 1 argument at line 1, char 38`,
+		},
+		{
+			sql: `select max(a, b, c) from symbol`,
+			err: `max expects (field) or (number, number), got 3 arguments at line 1, char 8`,
+		},
+		{
+			sql: `select count(*) from symbol group by histogram(last_sale, 10, min_doc = 1)`,
+			err: `unknown option min_doc for histogram(), expected one of min_doc_count, offset at line 1, char 38`,
+		},
+		{
+			sql: `select avg(last_sale, sigma = 2) from symbol`,
+			err: `avg() has no options, got sigma at line 1, char 8`,
+		},
+		{
+			sql: `select derivative(volume) from symbol group by date_histogram('ts', '1d')`,
+			err: `expected metric argument in derivative(), e.g. derivative(sum(x)) at line 1, char 8`,
+		},
+		{
+			sql: `select derivative(sum(volume)) from symbol group by exchange`,
+			err: `derivative() needs a GROUP BY histogram() or date_histogram() at line 1, char 8`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if body, _ := tr.JSON(); body != tt.dsl {
+			t.Errorf("%d. %s\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.dsl, body)
+		}
+	}
+}
+
+// Ensure functions may be registered while statements are translated.
+func TestRegisterFunction_Concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := sp.RegisterFunction(&sp.Function{
+				Name:   fmt.Sprintf("concurrent%d", i),
+				Kind:   sp.ScalarFunction,
+				Args:   []string{"x"},
+				Script: func(args []string) string { return args[0] },
+			}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := sp.TranslateDSL(`select avg(last_sale) from symbol group by histogram(last_sale, 10)`); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Ensure IN subqueries are translated into terms lookups, or run first and
+// inlined into a terms filter.
+func TestTranslator_Subqueries(t *testing.T) {
+	var tests = []struct {
+		sql    string
+		dsl    string
+		search string
+		err    string
+	}{
+		{
+			sql:    `select name from users where user_id in (select user_id from orders group by user_id order by sum(amount) desc limit 100) limit 10`,
+			dsl:    `{"from":0,"query":{"bool":{"filter":{"and":[{"terms":{"user_id":[]}}]}}},"size":10,"sort":[]}`,
+			search: `{"aggs":{"user_id":{"aggs":{"sum(amount)":{"sum":{"field":"amount"}}},"terms":{"field":"user_id","order":[{"sum(amount)":"desc"}],"size":100}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"user_id"}}]}}},"size":0}`,
+		},
+		{
+			sql:    `select exchange, count(*) from symbol where name in (select name from watchlist where owner = 'u1' limit 50) group by exchange`,
+			dsl:    `{"aggs":{"exchange":{"aggs":{},"terms":{"field":"exchange","size":0}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"exchange"}},{"terms":{"name":[]}}]}}},"size":0}`,
+			search: `{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['owner'].value == params.p0","params":{"p0":"u1"}}}}}},"size":50,"sort":[]}`,
+		},
+		{
+			sql:    `select name from users where uid in (select user_id from vips where level = 'gold') limit 10`,
+			dsl:    `{"from":0,"query":{"bool":{"filter":{"and":[{"terms":{"uid":[]}}]}}},"size":10,"sort":[]}`,
+			search: `{"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['level'].value == params.p0","params":{"p0":"gold"}}}}}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`,
+		},
+		{
+			sql: `select * from tweets where user ni (select followers from users where _id = 'u1') limit 10`,
+			dsl: `{"from":0,"query":{"bool":{"filter":{"and":[{"bool":{"must_not":{"terms":{"user":{"id":"u1","index":"users","path":"followers"}}}}}]}}},"size":10,"sort":[]}`,
+		},
+		{
+			sql: `select exchange, sum(volume) as v from symbol group by exchange order by sum(volume) desc limit 3`,
+			dsl: `{"aggs":{"exchange":{"aggs":{"v":{"sum":{"field":"volume"}}},"terms":{"field":"exchange","order":[{"v":"desc"}],"size":3}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"exchange"}}]}}},"size":0}`,
+		},
+		{
+			sql: `select * from symbol where name in (select * from watchlist)`,
+			err: `subquery must select exactly one column, got * at line 1, char 36`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if body, _ := tr.JSON(); body != tt.dsl {
+			t.Errorf("%d. %s\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.dsl, body)
+		}
+		var search string
+		if len(tr.Subqueries) > 0 {
+			search, _ = tr.Subqueries[0].Search.JSON()
+		}
+		if search != tt.search {
+			t.Errorf("%d. %s\n\nsubquery mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.search, search)
+		}
+	}
+
+	// a raw subquery without LIMIT reads every hit
+	tr, err := sp.TranslateDSL(tests[2].sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := tr.Subqueries[0].Search.Pagination; p == nil {
+		t.Errorf("expected the subquery to read every hit")
+	}
+
+	// the values of the subquery are inlined into the terms filter
+	tr, err = sp.TranslateDSL(tests[0].sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Subqueries[0].Inline([][]interface{}{{"u1"}, {nil}, {int64(7)}}); err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"from":0,"query":{"bool":{"filter":{"and":[{"terms":{"user_id":["u1",7]}}]}}},"size":10,"sort":[]}`
+	if body, _ := tr.JSON(); body != exp {
+		t.Errorf("inlined dsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", exp, body)
+	}
+
+	// the values of every page are inlined up to index.max_terms_count
+	tr, err = sp.TranslateDSL(`select /*+ subquery_max_terms(3) */ name from users where uid in (select user_id from vips) limit 10`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := tr.Subqueries[0]
+	if err := q.Inline([][]interface{}{{"u1"}, {"u2"}}); err != nil {
+		t.Fatal(err)
+	}
+	err = q.Inline([][]interface{}{{nil}, {"u3"}, {"u4"}})
+	if exp := `subquery of uid has more than 3 values, raise the subquery_max_terms hint and index.max_terms_count`; errstring(err) != exp {
+		t.Errorf("max terms error mismatch:\n  exp=%s\n  got=%s", exp, err)
+	}
+	tr, err = sp.TranslateDSL(tests[2].sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := tr.Subqueries[0].MaxTerms; n != 65536 {
+		t.Errorf("default max terms mismatch: exp=65536 got=%d", n)
+	}
+}
+
+// Ensure a JOIN is planned as the searches of its two sides.
+func TestTranslator_Join(t *testing.T) {
+	var tests = []struct {
+		sql     string
+		left    string
+		right   string
+		columns string
+		limits  [4]int64
+		err     string
+	}{
+		{
+			sql:     `SELECT q.close, s.name AS n FROM quote q JOIN symbol s ON q.symbol = s.name WHERE q.close > 10 AND s.exchange = 'nyse' LIMIT 20`,
+			left:    `{"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['close'].value \u003e params.p0","params":{"p0":10}}}}}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`,
+			right:   `{"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nyse"}}}}}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`,
+			columns: `close:0.1, n:1.1`,
+			limits:  [4]int64{20, 0, 65536, 64 << 20},
+		},
+		{
+			sql:     `SELECT /*+ join_max_rows(10) join_max_memory(1mb) timeout(5s) */ * FROM quote JOIN symbol ON quote.symbol = symbol.name`,
+			left:    `{"size":1000,"sort":[{"_shard_doc":"asc"}],"timeout":"5s"}`,
+			right:   `{"size":1000,"sort":[{"_shard_doc":"asc"}],"timeout":"5s"}`,
+			columns: `quote:0.1, symbol:1.1`,
+			limits:  [4]int64{0, 0, 10, 1 << 20},
+		},
+		{
+			sql: `SELECT q.close FROM quote q JOIN symbol s ON q.symbol = s.name GROUP BY q.close`,
+			err: `JOIN only supports raw queries, aggregates can not be computed across indices at line 1, char 73`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		j := tr.Join
+		if left, _ := j.Sides[0].Search.JSON(); left != tt.left {
+			t.Errorf("%d. %s\n\nleft mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.left, left)
+		}
+		if right, _ := j.Sides[1].Search.JSON(); right != tt.right {
+			t.Errorf("%d. %s\n\nright mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.right, right)
+		}
+		var columns []string
+		for _, c := range j.Columns {
+			columns = append(columns, fmt.Sprintf("%s:%d.%d", c.Name, c.Side, c.Column))
+		}
+		if got := strings.Join(columns, ", "); got != tt.columns {
+			t.Errorf("%d. %s: columns mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.columns, got)
+		}
+		if got := [4]int64{int64(j.Limit), int64(j.Offset), int64(j.MaxRows), j.MaxMemory}; got != tt.limits {
+			t.Errorf("%d. %s: limits mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.sql, tt.limits, got)
+		}
+	}
+
+	// the other targets have no join
+	_, err := sp.Translate(tests[0].sql, sp.TargetSQL)
+	if exp := `JOIN is not supported by the sql target at line 1, char 42`; errstring(err) != exp {
+		t.Errorf("target error mismatch:\n  exp=%s\n  got=%v", exp, err)
+	}
+}
+
+// Ensure the rows of the two sides of a join are merged on their keys.
+func TestJoinTable(t *testing.T) {
+	tr, err := sp.TranslateDSL(`SELECT q.close, s.exchange FROM quote q JOIN symbol s ON q.symbol = s.name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := tr.Join
+	table := j.NewTable(1)
+	if err := table.Add([][]interface{}{{"AAPL", "nasdaq"}, {"IBM", "nyse"}, {nil, "otc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := []interface{}{"AAPL", "IBM"}, table.Keys(); !reflect.DeepEqual(exp, got) {
+		t.Errorf("keys mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+	rows := table.Join([][]interface{}{{"IBM", 120.5}, {"MSFT", 300.0}, {"AAPL", 150.0}, {"IBM", 121.0}})
+	exp := [][]interface{}{{120.5, "nyse"}, {150.0, "nasdaq"}, {121.0, "nyse"}}
+	if !reflect.DeepEqual(exp, rows) {
+		t.Errorf("rows mismatch:\n  exp=%v\n  got=%v", exp, rows)
+	}
+
+	filtered, _ := j.Sides[0].Filter(table.Keys()).JSON()
+	if exp := `{"query":{"bool":{"filter":[{"terms":{"symbol":["AAPL","IBM"]}}]}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`; filtered != exp {
+		t.Errorf("filter mismatch:\n  exp=%s\n  got=%s", exp, filtered)
+	}
+
+	// the terms on the keys and the query of the side are filters of a bool
+	tr, err = sp.TranslateDSL(`SELECT q.close, s.exchange FROM quote q JOIN symbol s ON q.symbol = s.name WHERE q.close > 100`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, _ = tr.Join.Sides[0].Filter([]interface{}{"AAPL"}).JSON()
+	if exp := `{"query":{"bool":{"filter":[{"terms":{"symbol":["AAPL"]}},{"bool":{"filter":{"script":{"script":{"inline":"doc['close'].value \u003e params.p0","params":{"p0":100}}}}}}]}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`; filtered != exp {
+		t.Errorf("filter mismatch:\n  exp=%s\n  got=%s", exp, filtered)
+	}
+
+	j.MaxRows = 1
+	if err := j.NewTable(1).Add([][]interface{}{{"AAPL", "nasdaq"}, {"IBM", "nyse"}}); errstring(err) != `join side s has more than 1 rows, raise the join_max_rows hint` {
+		t.Errorf("unexpected max rows error: %v", err)
+	}
+	j.MaxRows, j.MaxMemory = 10, 16
+	if err := j.NewTable(1).Add([][]interface{}{{"AAPL", "nasdaq"}, {"IBM", "nyse"}}); errstring(err) != `join side s holds more than 16 bytes, raise the join_max_memory hint` {
+		t.Errorf("unexpected max memory error: %v", err)
+	}
+}
+
+// Ensure a UNION ALL is planned as the searches of its statements.
+func TestTranslator_Union(t *testing.T) {
+	var tests = []struct {
+		sql      string
+		searches []string
+		columns  string
+		err      string
+	}{
+		{
+			sql: `SELECT host, msg FROM logs_old UNION ALL SELECT hostname, message FROM logs_new WHERE severity = 'error' ORDER BY host DESC LIMIT 10, 5`,
+			searches: []string{
+				`{"from":0,"size":15,"sort":[{"host":"desc"}]}`,
+				`{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['severity'].value == params.p0","params":{"p0":"error"}}}}}},"size":15,"sort":[{"hostname":"desc"}]}`,
+			},
+			columns: `host, msg`,
+		},
+		{
+			sql: `(SELECT a FROM x ORDER BY a LIMIT 5) UNION ALL SELECT exchange FROM symbol GROUP BY exchange`,
+			searches: []string{
+				`{"from":0,"size":5,"sort":[{"a":"asc"}]}`,
+				`{"aggs":{"exchange":{"terms":{"field":"exchange","size":0}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"exchange"}}]}}},"size":0}`,
+			},
+			columns: `a`,
+		},
+		{
+			sql: `SELECT count(*) FROM x GROUP BY a UNION ALL SELECT b FROM y`,
+			err: `statement 1 of UNION ALL returns 2 columns instead of 1, select its GROUP BY dimensions and only grouped fields`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		var searches []string
+		for _, s := range tr.Union.Searches {
+			body, _ := s.JSON()
+			searches = append(searches, body)
+		}
+		if !reflect.DeepEqual(tt.searches, searches) {
+			t.Errorf("%d. %s\n\nsearches mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.searches, searches)
+		}
+		var columns []string
+		for _, c := range tr.Columns {
+			columns = append(columns, c.Name)
+		}
+		if got := strings.Join(columns, ", "); got != tt.columns {
+			t.Errorf("%d. %s: columns mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.columns, got)
+		}
+	}
+
+	// the rows of the searches are appended, sorted and limited
+	tr, err := sp.TranslateDSL(tests[0].sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Union.Offset, tr.Union.Limit = 1, 3
+	rows := tr.Union.Rows(
+		[][]interface{}{{"b", json.Number("1")}, {nil, json.Number("2")}},
+		[][]interface{}{{"c", json.Number("3")}, {"a", json.Number("4")}},
+	)
+	exp := [][]interface{}{{"b", json.Number("1")}, {"a", json.Number("4")}, {nil, json.Number("2")}}
+	if !reflect.DeepEqual(exp, rows) {
+		t.Errorf("rows mismatch:\n  exp=%v\n  got=%v", exp, rows)
+	}
+
+	// an msearch runs every statement of the union
+	payload, err := sp.MSearch([]*sp.Translation{tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(payload, "\n"); n != 4 {
+		t.Errorf("msearch lines mismatch: exp=4 got=%d\n%s", n, payload)
+	}
+}
+
+// Ensure EXPLAIN reports the strategy of the conditions and the aggregation levels.
+func TestTranslator_Explain(t *testing.T) {
+	var tests = []struct {
+		sql     string
+		explain string
+		err     string
+	}{
+		{
+			sql:     `EXPLAIN SELECT exchange, sum(volume) / count(*) AS avg_vol FROM symbol WHERE geo_distance(loc, 40.7, -74.0, '1km') AND close > 10 GROUP BY exchange`,
+			explain: `{"statement":"SELECT exchange, sum(volume) / count(*) AS avg_vol FROM symbol WHERE geo_distance(loc, 40.700, 0 - 74.000, '1km') AND close \u003e 10 GROUP BY exchange","index":"symbol","plan":"search","predicates":[{"condition":"geo_distance(loc, 40.700, 0 - 74.000, '1km')","fields":["loc"],"strategy":"query","query":"geo_distance"},{"condition":"close \u003e 10","fields":["close"],"strategy":"script","query":"script"},{"condition":"exchange exists","fields":["exchange"],"strategy":"exists","query":"exists"}],"aggregations":[{"level":1,"name":"exchange","type":"terms","path":"exchange"},{"level":2,"name":"sum(volume)","type":"sum","path":"exchange\u003esum(volume)"},{"level":2,"name":"count(*)","type":"count","path":"exchange\u003e_count"},{"level":2,"name":"avg_vol","type":"bucket_script","path":"exchange\u003eavg_vol","buckets_path":{"path0":"sum(volume)","path1":"count(*)"}}],"warnings":["terms size 0 of exchange returns every bucket on elasticsearch 2.x, it is invalid on 5.x and later, set a LIMIT"]}`,
+		},
+		{
+			sql:     `EXPLAIN SELECT * FROM tweets WHERE user NI (SELECT followers FROM users WHERE _id = 'u1') AND (a = 1 OR b = 2) LIMIT 10`,
+			explain: `{"statement":"SELECT * FROM tweets WHERE user NI (SELECT followers FROM users WHERE _id = 'u1') AND (a = 1 OR b = 2) LIMIT 10","index":"tweets","plan":"search","predicates":[{"condition":"user NI (SELECT followers FROM users WHERE _id = 'u1')","fields":["user"],"strategy":"terms_lookup","query":"terms"},{"condition":"(a = 1 OR b = 2)","fields":["a","b"],"strategy":"script","query":"script"}]}`,
+		},
+		{
+			sql:     `EXPLAIN SELECT a FROM x UNION ALL SELECT b FROM y WHERE b > 1 LIMIT 5`,
+			explain: `{"statement":"SELECT a FROM x UNION ALL SELECT b FROM y WHERE b \u003e 1 LIMIT 5","index":"x,y","plan":"union","statements":[{"statement":"SELECT a FROM x","index":"x","plan":"search"},{"statement":"SELECT b FROM y WHERE b \u003e 1","index":"y","plan":"search","predicates":[{"condition":"b \u003e 1","fields":["b"],"strategy":"script","query":"script"}]}]}`,
+		},
+		{
+			sql: `EXPLAIN SELECT a FROM x WHERE b = ?`,
+			err: `no value bound to placeholder ? at line 1, char 35`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if b, _ := json.Marshal(tr.Explain); string(b) != tt.explain {
+			t.Errorf("%d. %s\n\nexplain mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.explain, b)
+		}
+	}
+
+	if _, err := sp.Translate(tests[0].sql, sp.TargetESQL); errstring(err) != `EXPLAIN is not supported by the esql target at line 1, char 1` {
+		t.Errorf("unexpected target error: %v", err)
+	}
+}
+
+// Ensure metadata statements are translated into _cat, _mapping and _field_caps requests.
+func TestTranslator_Metadata(t *testing.T) {
+	var tests = []struct {
+		sql      string
+		requests string
+		resps    []string
+		rows     [][]interface{}
+	}{
+		{
+			sql:      `SHOW TABLES LIKE 'logs_a%'`,
+			requests: `[{"method":"GET","path":"/_cat/indices/logs%2Aa%2A?format=json\u0026h=index"},{"method":"GET","path":"/_cat/aliases/logs%2Aa%2A?format=json\u0026h=alias,index"}]`,
+			resps:    []string{`[{"index":"logs_b"},{"index":"logs-a"},{"index":"logs_a"},{"index":"logs__a"}]`, `[{"alias":"logs_all","index":"logs_a"}]`},
+			rows:     [][]interface{}{{"logs-a", "index", "logs-a"}, {"logs_a", "index", "logs_a"}, {"logs_all", "alias", "logs_a"}},
+		},
+		{
+			sql:      `DESCRIBE symbol`,
+			requests: `[{"method":"GET","path":"/symbol/_mapping"}]`,
+			resps:    []string{`{"symbol":{"mappings":{"properties":{"name":{"type":"keyword"},"quote":{"properties":{"close":{"type":"double"}}}}}}}`},
+			rows:     [][]interface{}{{"name", "keyword"}, {"quote.close", "double"}},
+		},
+		{
+			sql:      `SHOW COLUMNS FROM logs-*`,
+			requests: `[{"method":"GET","path":"/logs-%2A/_field_caps?fields=*"}]`,
+			resps:    []string{`{"indices":["logs-1"],"fields":{"_id":{"_id":{"type":"_id","searchable":true,"aggregatable":false}},"host":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true}},"bytes":{"long":{"type":"long","searchable":true,"aggregatable":true}}}}`},
+			rows:     [][]interface{}{{"bytes", "long", true, true}, {"host", "keyword", true, true}},
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.sql, err)
+			continue
+		}
+		if requests, _ := tr.JSON(); requests != tt.requests {
+			t.Errorf("%d. %s\n\nrequests mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.requests, requests)
+		}
+		var resps [][]byte
+		for _, r := range tt.resps {
+			resps = append(resps, []byte(r))
+		}
+		rows, err := tr.RequestRows(resps)
+		if err != nil {
+			t.Errorf("%d. %s: unexpected rows error: %s", i, tt.sql, err)
+		} else if !reflect.DeepEqual(tt.rows, rows) {
+			t.Errorf("%d. %s: rows mismatch:\n  exp=%v\n  got=%v", i, tt.sql, tt.rows, rows)
+		}
+	}
+
+	if _, err := sp.Translate(`SHOW TABLES`, sp.TargetSQL); errstring(err) != `SHOW TABLES is not supported by the sql target at line 1, char 1` {
+		t.Errorf("unexpected target error: %v", err)
+	}
+	if _, err := sp.Translate(`SELECT a FROM x; DESCRIBE x`, sp.TargetMSearch); errstring(err) != `DESCRIBE is not supported by msearch` {
+		t.Errorf("unexpected msearch error: %v", err)
+	}
+}
+
+// Ensure DELETE and UPDATE are translated into _delete_by_query and _update_by_query requests.
+func TestTranslator_ByQuery(t *testing.T) {
+	var tests = []struct {
+		sql      string
+		params   sp.Params
+		requests string
+		err      string
+	}{
+		{
+			sql:      `DELETE FROM logs-* WHERE level = 'debug' AND geo_distance(loc, 40.7, -74.0, '1km')`,
+			requests: `[{"method":"POST","path":"/logs-%2A/_delete_by_query","body":{"query":{"bool":{"filter":{"and":[{"geo_distance":{"distance":"1km","loc":{"lat":40.7,"lon":-74}}},{"script":{"script":{"inline":"doc['level'].value == params.p0","params":{"p0":"debug"}}}}]}}}}}]`,
+		},
+		{
+			sql:      `UPDATE symbol SET price = price * 1.1, tag = :tag, n = coalesce(n, 0) + 1 WHERE exchange = :ex`,
+			params:   sp.Params{"tag": "x", "ex": "nyse"},
+			requests: `[{"method":"POST","path":"/symbol/_update_by_query","body":{"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.ex","params":{"ex":"nyse"}}}}}},"script":{"inline":"ctx._source['price'] = ctx._source['price'] * params.p0; ctx._source['tag'] = params.tag; ctx._source['n'] = (ctx._source['n'] == null ? params.p1 : ctx._source['n']) + params.p2","lang":"painless","params":{"p0":1.1,"p1":0,"p2":1,"tag":"x"}}}}]`,
+		},
+		{
+			sql:      `UPDATE x SET tcp.src = upper(tcp.dst)`,
+			requests: `[{"method":"POST","path":"/x/_update_by_query","body":{"query":{"match_all":{}},"script":{"inline":"ctx._source['tcp']['src'] = ctx._source['tcp']['dst'].toUpperCase()","lang":"painless"}}}]`,
+		},
+		{
+			sql: `UPDATE x SET a = ? WHERE b = 1`,
+			err: `no value bound to placeholder ? at line 1, char 18`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSLParams(tt.sql, tt.params)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if requests, _ := tr.JSON(); requests != tt.requests {
+			t.Errorf("%d. %s\n\nrequests mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.requests, requests)
+		}
+		if !tr.Writes() {
+			t.Errorf("%d. %s: expected a write", i, tt.sql)
+		}
+	}
+
+	// a statement without WHERE changes every document unless forced
+	tr, err := sp.TranslateDSL(tests[2].sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Check(false); errstring(err) != `UPDATE without WHERE changes every document of x, add a WHERE or force it` {
+		t.Errorf("unexpected check error: %v", err)
+	}
+	if err := tr.Check(true); err != nil {
+		t.Errorf("unexpected forced check error: %v", err)
+	}
+	rows, err := tr.RequestRows([][]byte{[]byte(`{"took":10,"total":3,"updated":2,"noops":1,"version_conflicts":0,"failures":[]}`)})
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := [][]interface{}{{json.Number("3"), json.Number("2"), json.Number("0"), []interface{}{}}}; !reflect.DeepEqual(exp, rows) {
+		t.Errorf("rows mismatch:\n  exp=%v\n  got=%v", exp, rows)
+	}
+}
+
+// Ensure INSERT statements are translated into _bulk and _reindex requests.
+func TestTranslator_Insert(t *testing.T) {
+	useSchema(t)
+	sp.DefaultSchema.AddTypes("fixtures", map[string]string{"n": "long", "price": "double", "name": "keyword", "ok": "boolean", "ts": "date"})
+
+	var tests = []struct {
+		sql      string
+		params   sp.Params
+		requests string
+		err      string
+	}{
+		{
+			sql:      `INSERT INTO fixtures (_id, n, name, price, tags) VALUES (1, 2, 'x', 2.5, ['a', 'b']), ('k2', -3, :name, -1, null)`,
+			params:   sp.Params{"name": "y"},
+			requests: `[{"method":"POST","path":"/fixtures/_bulk","body":"{\"index\":{\"_id\":\"1\"}}\n{\"n\":2,\"name\":\"x\",\"price\":2.5,\"tags\":[\"a\",\"b\"]}\n{\"index\":{\"_id\":\"k2\"}}\n{\"n\":-3,\"name\":\"y\",\"price\":-1,\"tags\":null}\n"}]`,
+		},
+		{
+			sql:      `INSERT INTO fixtures (ts, ok, loc) VALUES (1500000000000, true, [-74.0, 40.7])`,
+			requests: `[{"method":"POST","path":"/fixtures/_bulk","body":"{\"index\":{}}\n{\"loc\":[-74,40.7],\"ok\":true,\"ts\":1500000000000}\n"}]`,
+		},
+		{
+			sql: `INSERT INTO fixtures (n) VALUES (2.5)`,
+			err: `n is a long field, got 2.500 at line 1, char 34`,
+		},
+		{
+			sql: `INSERT INTO fixtures (name, price) VALUES ('x', 1), ('y', 'cheap')`,
+			err: `price is a double field, got 'cheap' at line 1, char 58`,
+		},
+		{
+			sql: `INSERT INTO fixtures (_id, n) VALUES (true, 1)`,
+			err: `_id expects a string or an integer, got true at line 1, char 39`,
+		},
+		{
+			sql:      `INSERT INTO symbol_copy SELECT * FROM symbol WHERE exchange = 'nyse' LIMIT 1000`,
+			requests: `[{"method":"POST","path":"/_reindex","body":{"dest":{"index":"symbol_copy"},"size":1000,"source":{"index":"symbol","query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nyse"}}}}}}}}}]`,
+		},
+		{
+			sql:      `INSERT INTO quotes SELECT code AS _id, _id AS old_id, exchange, price * 1.1 AS price FROM symbol WHERE exchange = :ex`,
+			params:   sp.Params{"ex": "nyse"},
+			requests: `[{"method":"POST","path":"/_reindex","body":{"dest":{"index":"quotes"},"script":{"inline":"def src = ctx._source; ctx._source = [:]; ctx._source['old_id'] = ctx._id; ctx._source['exchange'] = src['exchange']; ctx._source['price'] = src['price'] * params.p0; ctx._id = String.valueOf(src['code'])","lang":"painless","params":{"p0":1.1}},"source":{"index":"symbol","query":{"bool":{"filter":{"script":{"script":{"inline":"doc['exchange'].value == params.ex","params":{"ex":"nyse"}}}}}}}}}]`,
+		},
+		{
+			sql:      `INSERT INTO quotes (sym, venue) SELECT code, tcp.dst FROM symbol`,
+			requests: `[{"method":"POST","path":"/_reindex","body":{"dest":{"index":"quotes"},"script":{"inline":"def src = ctx._source; ctx._source = [:]; ctx._source['sym'] = src['code']; ctx._source['venue'] = (src['tcp'] == null ? null : src['tcp']['dst'])","lang":"painless"},"source":{"index":"symbol"}}}]`,
+		},
+		{
+			// documents without geo or geo.src get a null city
+			sql:      `INSERT INTO cities SELECT geo.src.city AS city FROM flows`,
+			requests: `[{"method":"POST","path":"/_reindex","body":{"dest":{"index":"cities"},"script":{"inline":"def src = ctx._source; ctx._source = [:]; ctx._source['city'] = (src['geo'] == null || src['geo']['src'] == null ? null : src['geo']['src']['city'])","lang":"painless"},"source":{"index":"flows"}}}]`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSLParams(tt.sql, tt.params)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if requests, _ := tr.JSON(); requests != tt.requests {
+			t.Errorf("%d. %s\n\nrequests mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.requests, requests)
+		}
+		if !tr.Writes() {
+			t.Errorf("%d. %s: expected a write", i, tt.sql)
+		}
+	}
+
+	// a row for every item of the _bulk response
+	tr, err := sp.TranslateDSL(`INSERT INTO fixtures (_id, n) VALUES ('a', 1), ('b', 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := tr.RequestRows([][]byte{[]byte(`{"took":3,"errors":true,"items":[{"index":{"_id":"a","result":"created","status":201}},{"index":{"_id":"b","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse [n]"}}}]}`)})
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := [][]interface{}{{"a", "created", json.Number("201"), nil}, {"b", nil, json.Number("400"), "failed to parse [n]"}}; !reflect.DeepEqual(exp, rows) {
+		t.Errorf("rows mismatch:\n  exp=%v\n  got=%v", exp, rows)
+	}
+}
+
+// Ensure CREATE and DROP statements are translated into index and template requests.
+func TestTranslator_DDL(t *testing.T) {
+	var tests = []struct {
+		sql      string
+		requests string
+		err      string
+	}{
+		{
+			sql:      `CREATE TABLE symbol (name keyword, price double, ts date FORMAT 'epoch_millis', loc geo_point, tags nested(name keyword, n long), tcp.port integer, tcp.dst ip) WITH (shards=3, replicas=1)`,
+			requests: `[{"method":"PUT","path":"/symbol","body":{"mappings":{"properties":{"loc":{"type":"geo_point"},"name":{"type":"keyword"},"price":{"type":"double"},"tags":{"properties":{"n":{"type":"long"},"name":{"type":"keyword"}},"type":"nested"},"tcp":{"properties":{"dst":{"type":"ip"},"port":{"type":"integer"}}},"ts":{"format":"epoch_millis","type":"date"}}},"settings":{"number_of_replicas":1,"number_of_shards":3}}}]`,
+		},
+		{
+			sql:      `CREATE TABLE logs-2017 (msg text, meta object(host keyword)) WITH (type = 'doc', refresh_interval = '30s')`,
+			requests: `[{"method":"PUT","path":"/logs-2017","body":{"mappings":{"doc":{"properties":{"meta":{"properties":{"host":{"type":"keyword"}}},"msg":{"type":"text"}}}},"settings":{"refresh_interval":"30s"}}}]`,
+		},
+		{
+			sql:      `CREATE INDEX TEMPLATE logs ON logs-*, metrics-* (ts date) WITH (order = 1, shards = 1)`,
+			requests: `[{"method":"PUT","path":"/_template/logs","body":{"index_patterns":["logs-*","metrics-*"],"mappings":{"properties":{"ts":{"type":"date"}}},"order":1,"settings":{"number_of_shards":1}}}]`,
+		},
+		{
+			sql:      `DROP TABLE logs-2017`,
+			requests: `[{"method":"DELETE","path":"/logs-2017"}]`,
+		},
+		{
+			sql:      `DROP INDEX TEMPLATE logs`,
+			requests: `[{"method":"DELETE","path":"/_template/logs"}]`,
+		},
+		{
+			sql: `CREATE TABLE x (tcp keyword, tcp.port long)`,
+			err: `tcp is not an object, tcp.port can not be one of its fields at line 1, char 30`,
+		},
+		{
+			sql: `CREATE TABLE x (tcp object(port long), tcp.port long)`,
+			err: `column tcp.port is listed twice at line 1, char 40`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if requests, _ := tr.JSON(); requests != tt.requests {
+			t.Errorf("%d. %s\n\nrequests mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.requests, requests)
+		}
+		if !tr.Writes() {
+			t.Errorf("%d. %s: expected a write", i, tt.sql)
+		}
+	}
+
+	// dropping an index is only run when forced
+	tr, err := sp.TranslateDSL(`DROP TABLE logs-2017`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Check(false); errstring(err) != `DROP TABLE deletes logs-2017 and every document of it, force it` {
+		t.Errorf("unexpected check error: %v", err)
+	}
+	if err := tr.Check(true); err != nil {
+		t.Errorf("unexpected forced check error: %v", err)
+	}
+	rows, err := tr.RequestRows([][]byte{[]byte(`{"acknowledged":true}`)})
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := [][]interface{}{{true}}; !reflect.DeepEqual(exp, rows) {
+		t.Errorf("rows mismatch:\n  exp=%v\n  got=%v", exp, rows)
+	}
+}
+
+// Ensure hints are applied to the request body, the url params and the terms aggregations.
+func TestTranslator_Hints(t *testing.T) {
+	var tests = []struct {
+		sql      string
+		dsl      string
+		params   map[string]string
+		warnings []string
+		err      string
+	}{
+		{
+			sql:    `SELECT /*+ timeout(5s) terminate_after(1000) routing(u1) request_cache(true) */ * FROM symbol LIMIT 1`,
+			dsl:    `{"from":0,"size":1,"sort":[],"terminate_after":1000,"timeout":"5s"}`,
+			params: map[string]string{"request_cache": "true", "routing": "u1"},
+		},
+		{
+			sql: `SELECT /*+ shard_size(500), execution_hint('map') */ exchange, count(*) FROM symbol GROUP BY exchange`,
+			dsl: `{"aggs":{"exchange":{"aggs":{},"terms":{"execution_hint":"map","field":"exchange","shard_size":500,"size":0}}},"query":{"bool":{"filter":{"and":[{"exists":{"field":"exchange"}}]}}},"size":0}`,
+		},
+		{
+			sql:      `SELECT /* plain comment */ /*+ preference(_local) shard_size(10) */ * FROM symbol LIMIT 1`,
+			dsl:      `{"from":0,"size":1,"sort":[]}`,
+			params:   map[string]string{"preference": "_local"},
+			warnings: []string{"shard_size hint is ignored without a terms aggregation"},
+		},
+		{
+			sql: `SELECT /*+ terminate_after(-1) */ * FROM symbol`,
+			err: `invalid terminate_after hint, expected a positive integer, got "-1" at line 1, char 8`,
+		},
+		{
+			sql: `SELECT /*+ execution_hint(hash) */ * FROM symbol`,
+			err: `invalid execution_hint hint, expected one of map, global_ordinals, global_ordinals_hash, global_ordinals_low_cardinality, got "hash" at line 1, char 8`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if body, _ := tr.JSON(); body != tt.dsl {
+			t.Errorf("%d. %s\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.dsl, body)
+		}
+		if !reflect.DeepEqual(tr.Params, tt.params) {
+			t.Errorf("%d. %s\n\nparams mismatch:\n\nexp=%v\n\ngot=%v\n\n", i, tt.sql, tt.params, tr.Params)
+		}
+		if !reflect.DeepEqual(tr.Warnings, tt.warnings) {
+			t.Errorf("%d. %s\n\nwarnings mismatch:\n\nexp=%q\n\ngot=%q\n\n", i, tt.sql, tt.warnings, tr.Warnings)
+		}
+	}
+}
+
+// Ensure bound values land in script params and predicate arguments, never in script source.
+func TestTranslator_Params(t *testing.T) {
+	var tests = []struct {
+		sql    string
+		params sp.Params
+		dsl    string
+		body   string
+		err    string
+	}{
+		{
+			sql:    `select * from symbol where name = :name and ipo_year > :year limit 1`,
+			params: sp.Params{"name": `x' || true || '`, "year": 1998.0},
+			dsl:    `{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['name'].value == params.name \u0026\u0026 doc['ipo_year'].value \u003e params.year","params":{"name":"x' || true || '","year":1998}}}}}},"size":1,"sort":[]}`,
+			body:   `{"query":"SELECT * FROM symbol WHERE name = 'x'' || true || ''' AND ipo_year > 1998 LIMIT 1"}`,
+		},
+		{
+			sql:    `select * from symbol where sector in ? and last_sale > ? limit 1`,
+			params: sp.PositionalParams([]interface{}{"a", "b"}, 9.5),
+			dsl:    `{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['sector'].value IN params.p0 \u0026\u0026 doc['last_sale'].value \u003e params.p1","params":{"p0":["a","b"],"p1":9.5}}}}}},"size":1,"sort":[]}`,
+			body:   `{"query":"SELECT * FROM symbol WHERE sector IN ('a', 'b') AND last_sale > 9.5 LIMIT 1"}`,
+		},
+		{
+			sql:    `select * from symbol where sector in (?, ?) and exchange in ['nyse', ?] limit 1`,
+			params: sp.PositionalParams("a", "b", "nasdaq"),
+			dsl:    `{"from":0,"query":{"bool":{"filter":{"script":{"script":{"inline":"doc['sector'].value IN params.p0 \u0026\u0026 doc['exchange'].value IN params.p1","params":{"p0":["a","b"],"p1":["nyse","nasdaq"]}}}}}},"size":1,"sort":[]}`,
+			body:   `{"query":"SELECT * FROM symbol WHERE sector IN ('a', 'b') AND exchange IN ('nyse', 'nasdaq') LIMIT 1"}`,
+		},
+		{
+			sql:    `select * from shop where geo_polygon(location, [:lat, -70, 30, -70, 30, :lon]) limit 1`,
+			params: sp.Params{"lat": 40.5, "lon": -80},
+			dsl:    `{"from":0,"query":{"bool":{"filter":{"and":[{"geo_polygon":{"location":{"points":[{"lat":40.5,"lon":-70},{"lat":30,"lon":-70},{"lat":30,"lon":-80}]}}}]}}},"size":1,"sort":[]}`,
+		},
+		{
+			sql:    `select * from symbol where sector in (?, ?)`,
+			params: sp.PositionalParams("a", true),
+			err:    `invalid value of placeholder ?, lists hold strings and numbers, got true at line 1, char 42`,
+		},
+		{
+			sql:    `select * from shop where geo_distance(location, :lat, :lon, :d) limit 1`,
+			params: sp.Params{"lat": 40.7, "lon": -74.0, "d": "10km"},
+			dsl:    `{"from":0,"query":{"bool":{"filter":{"and":[{"geo_distance":{"distance":"10km","location":{"lat":40.7,"lon":-74}}}]}}},"size":1,"sort":[]}`,
+		},
+		{
+			sql:    `select * from shop where geo_distance(location, ?, ?, ?) limit 1`,
+			params: sp.PositionalParams(91, -74, "10km"),
+			err:    `invalid latitude 91 in geo_distance(), must be -90 <= lat <= 90 at line 1, char 26`,
+		},
+		{
+			sql: `select * from symbol where name = :name`,
+			err: `no value bound to placeholder :name at line 1, char 35`,
+		},
+		{
+			sql:    `select * from symbol where name = :name`,
+			params: sp.Params{"name": "a", "sector": "b"},
+			err:    `param sector is not used by any placeholder`,
+		},
+		{
+			sql:    `select * from symbol where name = :name`,
+			params: sp.Params{"name": nil},
+			err:    `invalid value of placeholder :name, null is not supported at line 1, char 35`,
+		},
+		{
+			sql: `select count(*) from symbol group by ?`,
+			err: `placeholder ? is only supported in WHERE at line 1, char 38`,
+		},
+	}
+
+	for i, tt := range tests {
+		dsl, err := sp.TranslateParams(tt.sql, sp.TargetDSL, tt.params)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if dsl != tt.dsl {
+			t.Errorf("%d. %s\n\ndsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.dsl, dsl)
+		}
+		if tt.body == "" {
+			continue
+		}
+		if body, err := sp.TranslateParams(tt.sql, sp.TargetSQL, tt.params); err != nil || body != tt.body {
+			t.Errorf("%d. %s\n\nsql body mismatch:\n\nexp=%s\n\ngot=%s %v\n\n", i, tt.sql, tt.body, body, err)
+		}
+	}
+}
+
+// Ensure statements differing only by their literals share the script source.
+func TestTranslator_ScriptParams(t *testing.T) {
+	var tests = []struct {
+		sqls []string
+		src  string
+	}{
+		{
+			sqls: []string{
+				`select * from symbol where ipo_year = 1998 and exchange = 'nyse'`,
+				`select * from symbol where ipo_year = 2001 and exchange = 'nasdaq'`,
+			},
+			src: `doc['ipo_year'].value == params.p0 && doc['exchange'].value == params.p1`,
+		},
+		{
+			sqls: []string{
+				`select sum(ipo_year * 2) from symbol`,
+				`select sum(ipo_year * 3) from symbol`,
+			},
+			src: `doc['ipo_year'].value * params.p0`,
+		},
+		{
+			sqls: []string{
+				`select exchange, count(*) as c from symbol group by exchange having c > 100`,
+				`select exchange, count(*) as c from symbol group by exchange having c > 200`,
+			},
+			src: `c > p0`,
+		},
+		{
+			sqls: []string{
+				`select 2 * avg(last_sale) as a from symbol group by exchange`,
+				`select 3 * avg(last_sale) as a from symbol group by exchange`,
+			},
+			src: `p0 * path0`,
+		},
+	}
+
+	for i, tt := range tests {
+		for _, sql := range tt.sqls {
+			dsl, err := sp.EsDsl(sql)
+			if err != nil {
+				t.Errorf("%d. %s: error\n\n %s", i, sql, err)
+				continue
+			}
+			var body interface{}
+			json.Unmarshal([]byte(dsl), &body)
+			if srcs := inlineScripts(body); !reflect.DeepEqual(srcs, []string{tt.src}) {
+				t.Errorf("%d. %s\n\nscript source mismatch:\n\nexp=%s\n\ngot=%v\n\n", i, sql, tt.src, srcs)
+			}
+		}
+	}
+}
+
+// useSchema replaces the default schema for the duration of the test.
+func useSchema(t *testing.T) {
+	saved := sp.DefaultSchema
+	sp.DefaultSchema = sp.NewSchema()
+	t.Cleanup(func() { sp.DefaultSchema = saved })
+}
+
+// inlineScripts returns the sources of the inline scripts of a dsl body.
+func inlineScripts(v interface{}) []string {
+	var srcs []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if src, ok := v["inline"].(string); ok {
+			srcs = append(srcs, src)
+		}
+		for _, item := range v {
+			srcs = append(srcs, inlineScripts(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			srcs = append(srcs, inlineScripts(item)...)
+		}
+	}
+	return srcs
+}
+
+// Ensure the rows of a search response are read at the column paths.
+func TestTranslation_Rows(t *testing.T) {
+	var tests = []struct {
+		sql  string
+		resp string
+		rows string
+		err  string
+	}{
+		{
+			sql:  `select name, last_sale from symbol limit 2`,
+			resp: `{"hits": {"total": 9, "hits": [{"_source": {"name": "a", "last_sale": 1.5}}, {"_source": {"name": "b"}}]}}`,
+			rows: `[["a",1.5],["b",null]]`,
+		},
+		{
+			sql: `select exchange, sector, count(*), max(last_sale) from symbol group by exchange, sector`,
+			resp: `{"aggregations": {"exchange": {"buckets": [
+			  {"key": "nyse", "doc_count": 3, "sector": {"buckets": [
+			    {"key": "tech", "doc_count": 2, "max(last_sale)": {"value": 7}},
+			    {"key": "energy", "doc_count": 1, "max(last_sale)": {"value": 3}}]}},
+			  {"key": "nasdaq", "doc_count": 1, "sector": {"buckets": []}}]}}}`,
+			rows: `[["nyse","tech",2,7],["nyse","energy",1,3]]`,
+		},
+		{
+			sql:  `select count(*), avg(last_sale) from symbol`,
+			resp: `{"hits": {"total": 9, "hits": []}, "aggregations": {"avg(last_sale)": {"value": 2.5}}}`,
+			rows: `[[9,2.5]]`,
+		},
+		{
+			sql:  `select r, count(*) from symbol group by range(ipo_year, 2000) as r`,
+			resp: `{"aggregations": {"r": {"buckets": {"*-2000.0": {"doc_count": 4}, "2000.0-*": {"doc_count": 5}}}}}`,
+			rows: `[["*-2000.0",4],["2000.0-*",5]]`,
+		},
+		{
+			sql:  `select name, coalesce(sector, 'n/a') as s from symbol limit 1`,
+			resp: `{"hits": {"total": 9, "hits": [{"_source": {"name": "a"}, "fields": {"s": ["n/a"]}}]}}`,
+			rows: `[["a","n/a"]]`,
+		},
+		{
+			sql:  `select name from symbol`,
+			resp: `{"error": {"type": "index_not_found_exception"}, "status": 404}`,
+			err:  `search failed, {"type":"index_not_found_exception"}`,
+		},
+	}
+
+	for i, tt := range tests {
+		tr, err := sp.TranslateDSL(tt.sql)
+		if err != nil {
+			t.Errorf("%d. %s: error\n\n %s", i, tt.sql, err)
+			continue
+		}
+		rows, err := tr.Rows([]byte(tt.resp))
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if b, _ := json.Marshal(rows); string(b) != tt.rows {
+			t.Errorf("%d. %s\n\nrows mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, tt.rows, b)
+		}
+	}
+}
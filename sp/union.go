@@ -0,0 +1,248 @@
+package sp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Union is the UNION ALL of a select statement with other ones, its ORDER BY
+// and LIMIT apply to the appended rows.
+type Union struct {
+	// Statements are the statements following the first one.
+	Statements []*SelectStatement
+
+	SortFields SortFields
+	Limit      int
+	Offset     int
+	LimitAll   bool
+}
+
+// string returns the UNION ALL of first with the statements of the union,
+// a statement with an ORDER BY or LIMIT of its own is parenthesized.
+func (u *Union) string(first *SelectStatement) string {
+	var buf bytes.Buffer
+	for i, s := range first.unionStatements() {
+		if i > 0 {
+			_, _ = buf.WriteString(" UNION ALL ")
+		}
+		if len(s.SortFields) > 0 || s.Limit > 0 || s.Offset > 0 || s.LimitAll {
+			_, _ = fmt.Fprintf(&buf, "(%s)", s)
+		} else {
+			_, _ = buf.WriteString(s.String())
+		}
+	}
+	if len(u.SortFields) > 0 {
+		_, _ = buf.WriteString(" ORDER BY ")
+		_, _ = buf.WriteString(u.SortFields.String())
+	}
+	if u.LimitAll {
+		_, _ = buf.WriteString(" LIMIT ALL")
+	}
+	if u.Limit > 0 {
+		_, _ = fmt.Fprintf(&buf, " LIMIT %d", u.Limit)
+	}
+	if u.Offset > 0 {
+		_, _ = buf.WriteString(", ")
+		_, _ = buf.WriteString(strconv.Itoa(u.Offset))
+	}
+	return buf.String()
+}
+
+// unionStatements returns the statements of a UNION ALL, the first one
+// without its union.
+func (s *SelectStatement) unionStatements() []*SelectStatement {
+	first := *s
+	first.Union, first.Explain = nil, false
+	return append([]*SelectStatement{&first}, s.Union.Statements...)
+}
+
+// validateUnion checks the statements of a UNION ALL select as many columns
+// and the union is sorted on its columns.
+func (s *SelectStatement) validateUnion() error {
+	stmts := s.unionStatements()
+	names := stmts[0].ColumnNames()
+	for _, stmt := range stmts {
+		if stmt.Join != nil {
+			return errorAt(clause{stmt, JOIN}, fmt.Errorf("JOIN is not supported with UNION ALL"))
+		}
+		if stmt.LimitAll {
+			return errorAt(clause{stmt, LIMIT}, fmt.Errorf("LIMIT ALL is not supported with UNION ALL"))
+		}
+		if s.Union.LimitAll {
+			return errorAt(clause{s.Union, LIMIT}, fmt.Errorf("LIMIT ALL is not supported with UNION ALL"))
+		}
+		if n := len(stmt.ColumnNames()); n != len(names) {
+			return errorAt(stmt.Fields[0].Expr, fmt.Errorf("UNION ALL statements must select the same number of columns, got %d and %d", len(names), n))
+		}
+	}
+	for _, sf := range s.Union.SortFields {
+		if sf.Call != nil {
+			return errorAt(sf.Call, fmt.Errorf("ORDER BY of UNION ALL only supports its columns, got %s", sf.Name))
+		}
+		if !containsString(names, sf.Name) {
+			return errorAt(sf, fmt.Errorf("ORDER BY %s is not a column of UNION ALL, expected one of %s", sf.Name, strings.Join(names, ", ")))
+		}
+	}
+	return nil
+}
+
+// UnionPlan is how the executor runs a UNION ALL: the searches of its
+// statements are sent in one _msearch and their rows are appended, then
+// sorted and limited.
+type UnionPlan struct {
+	Searches []*Translation `json:"searches"`
+	// Sort are the columns the appended rows are sorted on.
+	Sort   []*UnionSort `json:"sort,omitempty"`
+	Limit  int          `json:"limit,omitempty"`
+	Offset int          `json:"offset,omitempty"`
+}
+
+// UnionSort is a column the rows of a union are sorted on.
+type UnionSort struct {
+	Column    int  `json:"column"`
+	Ascending bool `json:"ascending"`
+}
+
+// Rows returns the rows of the union of the rows of its searches, in the
+// order of the searches.
+func (u *UnionPlan) Rows(rows ...[][]interface{}) [][]interface{} {
+	var all [][]interface{}
+	for _, r := range rows {
+		all = append(all, r...)
+	}
+	if len(u.Sort) > 0 {
+		sort.SliceStable(all, func(i, j int) bool {
+			for _, s := range u.Sort {
+				a, b := all[i][s.Column], all[j][s.Column]
+				// null values are last in either order, as missing values
+				// of an elasticsearch sort
+				if (a == nil) != (b == nil) {
+					return b == nil
+				}
+				if c := compareValues(a, b); c != 0 {
+					return (c < 0) == s.Ascending
+				}
+			}
+			return false
+		})
+	}
+	if u.Offset >= len(all) {
+		return nil
+	}
+	all = all[u.Offset:]
+	if u.Limit > 0 && len(all) > u.Limit {
+		all = all[:u.Limit]
+	}
+	return all
+}
+
+// compareValues compares two values of a response, numbers sort before
+// strings and booleans.
+func compareValues(a, b interface{}) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch a := a.(type) {
+	case json.Number:
+		x, _ := a.Float64()
+		y, _ := b.(json.Number).Float64()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		if a != b.(bool) {
+			if a {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+// valueRank orders the types of the values of a response.
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case json.Number:
+		return 0
+	case string:
+		return 1
+	case bool:
+		return 2
+	}
+	return 3
+}
+
+// translateUnion plans a UNION ALL: each statement is searched for the rows
+// its sorted and limited union may return.
+func (s *SelectStatement) translateUnion() (*Translation, error) {
+	u := s.Union
+	stmts := s.unionStatements()
+	names := stmts[0].ColumnNames()
+	plan := &UnionPlan{Limit: u.Limit, Offset: u.Offset}
+	for _, sf := range u.SortFields {
+		plan.Sort = append(plan.Sort, &UnionSort{Column: indexOf(names, sf.Name), Ascending: sf.Ascending})
+	}
+
+	var indices []string
+	for i, stmt := range stmts {
+		b := *stmt
+		if b.IsRawQuery && len(b.Dimensions) == 0 && b.Limit == 0 && u.Limit > 0 {
+			b.Limit = u.Offset + u.Limit
+			if len(b.SortFields) == 0 {
+				b.SortFields = u.statementSort(&b, names)
+			}
+		}
+		t, err := b.translate()
+		if err != nil {
+			return nil, err
+		}
+		if len(t.Columns) != len(names) {
+			return nil, fmt.Errorf("statement %d of UNION ALL returns %d columns instead of %d, select its GROUP BY dimensions and only grouped fields", i+1, len(t.Columns), len(names))
+		}
+		plan.Searches = append(plan.Searches, t)
+		indices = append(indices, t.Index)
+	}
+
+	t := &Translation{Index: strings.Join(indices, ","), Union: plan}
+	for _, name := range names {
+		t.Columns = append(t.Columns, &Column{Name: name})
+	}
+	return t, nil
+}
+
+// statementSort returns the ORDER BY of the union on the fields of a raw
+// statement, none unless every sorted column is a field of it.
+func (u *Union) statementSort(s *SelectStatement, names []string) SortFields {
+	var sorts SortFields
+	for _, sf := range u.SortFields {
+		ref, ok := s.Fields[indexOf(names, sf.Name)].Expr.(*VarRef)
+		if !ok {
+			return nil
+		}
+		sorts = append(sorts, &SortField{Name: ref.Val, Ascending: sf.Ascending})
+	}
+	return sorts
+}
+
+func indexOf(a []string, s string) int {
+	for i, v := range a {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
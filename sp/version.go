@@ -0,0 +1,306 @@
+package sp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the major version of the elasticsearch cluster a dsl
+// translation is written for, the zero version leaves it as translated.
+type Version int
+
+// The versions a translation is rewritten for, the translator writes 2.x
+// bodies.
+const (
+	Version2 Version = 2
+	Version5 Version = 5
+	Version6 Version = 6
+	Version7 Version = 7
+	Version8 Version = 8
+)
+
+var versions = []Version{Version2, Version5, Version6, Version7, Version8}
+
+// String returns the version as 7.x, "" for the zero version.
+func (v Version) String() string {
+	if v == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d.x", int(v))
+}
+
+// ParseVersion parses an elasticsearch version, e.g. 7.x, 7 or 7.10.2, into
+// its major version, "" is the zero version.
+func ParseVersion(s string) (Version, error) {
+	if s == "" {
+		return 0, nil
+	}
+	major := s
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		major = s[:i]
+	}
+	if n, err := strconv.Atoi(major); err == nil {
+		for _, v := range versions {
+			if int(v) == n {
+				return v, nil
+			}
+		}
+	}
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = v.String()
+	}
+	return 0, fmt.Errorf("unknown elasticsearch version %s, expected one of %s", s, strings.Join(names, ", "))
+}
+
+// allTerms is the size of the terms aggregations of a GROUP BY without LIMIT
+// from 5.x on, which rejects the size 0 of 2.x, the search.max_buckets
+// default of 7.x.
+const allTerms = 10000
+
+// ForVersion rewrites the requests of the translation for a cluster of
+// version v. From 5.x on the and filters become filter arrays and terms
+// aggregations without size get one, from 6.x on scripts name their source
+// source instead of inline, 2.x runs no painless script. LIMIT ALL scrolls
+// before 7.x, which brings point in time. The mappings created and the
+// documents inserted before 7.x are typed, see rewriteIndex.
+func (t *Translation) ForVersion(v Version) error {
+	if v == 0 {
+		return nil
+	}
+	switch t.statement {
+	case "CREATE TABLE", "CREATE INDEX TEMPLATE":
+		return v.rewriteIndex(t.Requests[0].Body.(map[string]interface{}))
+	case "INSERT":
+		if body, ok := t.Requests[0].Body.(string); ok && v < Version7 {
+			// the documents of _bulk are indexed into the mappingType
+			t.Requests[0].Body = typedBulk(body)
+		}
+	}
+	if v < Version5 {
+		for _, r := range append([]*Request{{Body: t.Body}}, t.Requests...) {
+			if hasPainless(r.Body) {
+				return fmt.Errorf("elasticsearch %s has no painless, the scripts of the statement need %s or later", v, Version5)
+			}
+		}
+	}
+	if v >= Version5 {
+		v.rewrite(t.Body)
+		for _, r := range t.Requests {
+			// the ndjson payload of a string has no query
+			v.rewrite(r.Body)
+		}
+	}
+	if p := t.Pagination; p != nil && p.Mode == PaginationSearchAfter && v < Version7 {
+		p.Mode = PaginationScroll
+		scrollSort(t.Body)
+	}
+	for _, q := range t.Subqueries {
+		if err := q.Search.ForVersion(v); err != nil {
+			return err
+		}
+	}
+	if t.Join != nil {
+		for _, side := range t.Join.Sides {
+			if err := side.Search.ForVersion(v); err != nil {
+				return err
+			}
+		}
+	}
+	if t.Union != nil {
+		for _, s := range t.Union.Searches {
+			if err := s.ForVersion(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mappingType is the mapping type of the indices and documents written for
+// clusters older than 7.x, which need one.
+const mappingType = "doc"
+
+// typedBulk sets the mappingType as the _type of the actions of a _bulk
+// payload, its lines alternate actions and documents.
+func typedBulk(body string) string {
+	lines := strings.Split(body, "\n")
+	for i := 0; i < len(lines); i += 2 {
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+			continue
+		}
+		for _, meta := range action {
+			meta["_type"] = mappingType
+		}
+		b, _ := json.Marshal(action)
+		lines[i] = string(b)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// columnTypeVersions are the first versions of the column types younger
+// than 2.x. 2.x indexes the keyword and text strings as string fields.
+var columnTypeVersions = map[string]Version{
+	"constant_keyword": Version7,
+	"date_nanos":       Version7,
+	"half_float":       Version5,
+	"unsigned_long":    Version7,
+	"wildcard":         Version7,
+}
+
+// rewriteIndex rewrites the body of an index or template creation. Before
+// 7.x the mappings are wrapped in the mappingType unless the type option
+// names one, before 6.x templates match the single pattern of their
+// template.
+func (v Version) rewriteIndex(body map[string]interface{}) error {
+	mappings := body["mappings"].(map[string]interface{})
+	props, untyped := mappings["properties"].(map[string]interface{})
+	if !untyped {
+		for _, m := range mappings {
+			props, _ = m.(map[string]interface{})["properties"].(map[string]interface{})
+		}
+	}
+	if err := v.rewriteProperties(props); err != nil {
+		return err
+	}
+	if untyped && v < Version7 {
+		body["mappings"] = map[string]interface{}{mappingType: mappings}
+	}
+	if patterns, ok := body["index_patterns"].([]string); ok && v < Version6 {
+		if len(patterns) > 1 {
+			return fmt.Errorf("templates of elasticsearch %s match one pattern, not %s", v, strings.Join(patterns, ", "))
+		}
+		delete(body, "index_patterns")
+		body["template"] = patterns[0]
+	}
+	return nil
+}
+
+// rewriteProperties checks the version knows the types of the fields, on
+// 2.x keyword and text fields become string ones.
+func (v Version) rewriteProperties(props map[string]interface{}) error {
+	for _, name := range sortedKeys(props) {
+		m := props[name].(map[string]interface{})
+		typ, _ := m["type"].(string)
+		if first, ok := columnTypeVersions[typ]; ok && v < first {
+			return fmt.Errorf("elasticsearch %s has no %s type, field %s needs %s or later", v, typ, name, first)
+		}
+		if v == Version2 {
+			switch typ {
+			case "keyword":
+				m["type"], m["index"] = "string", "not_analyzed"
+			case "text":
+				m["type"] = "string"
+			}
+		}
+		if sub, ok := m["properties"].(map[string]interface{}); ok {
+			if err := v.rewriteProperties(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewrite rewrites a request body in place, the maps of subquery terms
+// filters are kept as they are filled in later.
+func (v Version) rewrite(x interface{}) {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			switch k {
+			case "bool":
+				if b, ok := val.(map[string]interface{}); ok {
+					if f, ok := b["filter"].(map[string]interface{}); ok && len(f) == 1 && f["and"] != nil {
+						b["filter"] = f["and"]
+					}
+				}
+			case "terms":
+				if m, ok := val.(map[string]interface{}); ok && m["size"] == 0 {
+					m["size"] = allTerms
+				}
+			case "script":
+				if v >= Version6 {
+					renameInline(val)
+				}
+			}
+			v.rewrite(val)
+		}
+	case []interface{}:
+		for _, item := range x {
+			v.rewrite(item)
+		}
+	case []map[string]interface{}:
+		for _, item := range x {
+			v.rewrite(item)
+		}
+	}
+}
+
+// hasPainless reports whether a request body holds a painless script.
+func hasPainless(x interface{}) bool {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		if x["lang"] == "painless" {
+			return true
+		}
+		for _, val := range x {
+			if hasPainless(val) {
+				return true
+			}
+		}
+	case map[string]string:
+		return x["lang"] == "painless"
+	case []interface{}:
+		for _, item := range x {
+			if hasPainless(item) {
+				return true
+			}
+		}
+	case []map[string]interface{}:
+		for _, item := range x {
+			if hasPainless(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renameInline renames the inline source of a script to source.
+func renameInline(script interface{}) {
+	switch m := script.(type) {
+	case map[string]interface{}:
+		if src, ok := m["inline"]; ok {
+			delete(m, "inline")
+			m["source"] = src
+		}
+	case map[string]string:
+		if src, ok := m["inline"]; ok {
+			delete(m, "inline")
+			m["source"] = src
+		}
+	}
+}
+
+// scrollSort replaces the _shard_doc tie breaker of search_after, which
+// needs a point in time, by the index order of scroll.
+func scrollSort(body map[string]interface{}) {
+	sort, ok := body["sort"].([]map[string]string)
+	if !ok {
+		return
+	}
+	var keep []map[string]string
+	for _, s := range sort {
+		if _, ok := s["_shard_doc"]; !ok {
+			keep = append(keep, s)
+		}
+	}
+	if len(keep) == 0 {
+		keep = append(keep, map[string]string{"_doc": "asc"})
+	}
+	body["sort"] = keep
+}
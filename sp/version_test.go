@@ -0,0 +1,162 @@
+package sp_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/chenyoufu/esql/sp"
+)
+
+// Ensure translations are rewritten for the elasticsearch version of the cluster.
+func TestTranslation_ForVersion(t *testing.T) {
+	var tests = []struct {
+		sql     string
+		version string
+		body    string
+		mode    string
+	}{
+		{
+			sql:     `select * from symbol where exchange = 'nyse' and geo_distance(loc, 40.7, -74.0, '1km') limit 5`,
+			version: `2.x`,
+			body:    `{"from":0,"query":{"bool":{"filter":{"and":[{"geo_distance":{"distance":"1km","loc":{"lat":40.7,"lon":-74}}},{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nyse"}}}}]}}},"size":5,"sort":[]}`,
+		},
+		{
+			sql:     `select * from symbol where exchange = 'nyse' and geo_distance(loc, 40.7, -74.0, '1km') limit 5`,
+			version: `5.x`,
+			body:    `{"from":0,"query":{"bool":{"filter":[{"geo_distance":{"distance":"1km","loc":{"lat":40.7,"lon":-74}}},{"script":{"script":{"inline":"doc['exchange'].value == params.p0","params":{"p0":"nyse"}}}}]}},"size":5,"sort":[]}`,
+		},
+		{
+			sql:     `select exchange, sum(ipo_year * 2) from symbol where last_sale > 1 and ipo_year + 1 > 2000 group by exchange`,
+			version: `7.10.2`,
+			body:    `{"aggs":{"exchange":{"aggs":{"sum(ipo_year * 2)":{"sum":{"script":{"params":{"p0":2},"source":"doc['ipo_year'].value * params.p0"}}}},"terms":{"field":"exchange","size":10000}}},"query":{"bool":{"filter":[{"exists":{"field":"exchange"}},{"script":{"script":{"params":{"p0":1,"p1":1,"p2":2000},"source":"doc['last_sale'].value \u003e params.p0 \u0026\u0026 doc['ipo_year'].value + params.p1 \u003e params.p2"}}}]}},"size":0}`,
+		},
+		{
+			sql:     `select name from symbol where exchange = 'nyse' limit all`,
+			version: `6`,
+			body:    `{"query":{"bool":{"filter":{"script":{"script":{"params":{"p0":"nyse"},"source":"doc['exchange'].value == params.p0"}}}}},"size":1000,"sort":[{"_doc":"asc"}]}`,
+			mode:    `scroll`,
+		},
+		{
+			sql:     `select name from symbol where exchange = 'nyse' limit all`,
+			version: `8.x`,
+			body:    `{"query":{"bool":{"filter":{"script":{"script":{"params":{"p0":"nyse"},"source":"doc['exchange'].value == params.p0"}}}}},"size":1000,"sort":[{"_shard_doc":"asc"}]}`,
+			mode:    `search_after`,
+		},
+	}
+
+	for i, tt := range tests {
+		v, err := sp.ParseVersion(tt.version)
+		if err != nil {
+			t.Errorf("%d. %s: version error\n\n %s", i, tt.version, err)
+			continue
+		}
+		tr, err := sp.TranslateDSL(tt.sql)
+		if err != nil {
+			t.Errorf("%d. %s: error\n\n %s", i, tt.sql, err)
+			continue
+		}
+		if err := tr.ForVersion(v); err != nil {
+			t.Errorf("%d. %s for %s: error\n\n %s", i, tt.sql, v, err)
+			continue
+		}
+		body, _ := json.Marshal(tr.Body)
+		if string(body) != tt.body {
+			t.Errorf("%d. %s for %s\n\nbody mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, v, tt.body, body)
+		}
+		if tt.mode != "" && tr.Pagination.Mode != tt.mode {
+			t.Errorf("%d. %s for %s: pagination mode mismatch: exp=%s got=%s", i, tt.sql, v, tt.mode, tr.Pagination.Mode)
+		}
+	}
+
+	if _, err := sp.ParseVersion("3.x"); errstring(err) != `unknown elasticsearch version 3.x, expected one of 2.x, 5.x, 6.x, 7.x, 8.x` {
+		t.Errorf("unexpected version error: %s", err)
+	}
+}
+
+// Ensure the requests of statements other than selects are rewritten for the version of the cluster.
+func TestTranslation_ForVersionRequests(t *testing.T) {
+	var tests = []struct {
+		sql     string
+		version string
+		body    string
+		err     string
+	}{
+		{
+			sql:     `create table logs (host keyword, msg text, at date format 'epoch_millis') with (shards = 1)`,
+			version: `7.x`,
+			body:    `{"mappings":{"properties":{"at":{"format":"epoch_millis","type":"date"},"host":{"type":"keyword"},"msg":{"type":"text"}}},"settings":{"number_of_shards":1}}`,
+		},
+		{
+			sql:     `create table logs (host keyword, msg text)`,
+			version: `6.x`,
+			body:    `{"mappings":{"doc":{"properties":{"host":{"type":"keyword"},"msg":{"type":"text"}}}}}`,
+		},
+		{
+			sql:     `create table logs (host keyword, user object(name text)) with (type = 'log')`,
+			version: `2.x`,
+			body:    `{"mappings":{"log":{"properties":{"host":{"index":"not_analyzed","type":"string"},"user":{"properties":{"name":{"type":"string"}}}}}}}`,
+		},
+		{
+			sql:     `create index template logs on 'logs-*' (host keyword) with (order = 1)`,
+			version: `5.x`,
+			body:    `{"mappings":{"doc":{"properties":{"host":{"type":"keyword"}}}},"order":1,"template":"logs-*"}`,
+		},
+		{
+			sql:     `create index template logs on 'logs-*' (host keyword)`,
+			version: `6.x`,
+			body:    `{"index_patterns":["logs-*"],"mappings":{"doc":{"properties":{"host":{"type":"keyword"}}}}}`,
+		},
+		{
+			sql:     `create index template logs on 'logs-*', 'metrics-*' (host keyword)`,
+			version: `5.x`,
+			err:     `templates of elasticsearch 5.x match one pattern, not logs-*, metrics-*`,
+		},
+		{
+			sql:     `insert into logs (_id, host) values (1, 'a'), (2, 'b')`,
+			version: `6.x`,
+			body:    `{"index":{"_id":"1","_type":"doc"}}` + "\n" + `{"host":"a"}` + "\n" + `{"index":{"_id":"2","_type":"doc"}}` + "\n" + `{"host":"b"}` + "\n",
+		},
+		{
+			sql:     `insert into logs (host) values ('a')`,
+			version: `7.x`,
+			body:    `{"index":{}}` + "\n" + `{"host":"a"}` + "\n",
+		},
+		{
+			sql:     `update logs set n = n + 1 where host = 'a'`,
+			version: `7.x`,
+			body:    `{"query":{"bool":{"filter":{"script":{"script":{"params":{"p0":"a"},"source":"doc['host'].value == params.p0"}}}}},"script":{"lang":"painless","params":{"p0":1},"source":"ctx._source['n'] = ctx._source['n'] + params.p0"}}`,
+		},
+		{
+			sql:     `update logs set n = n + 1 where host = 'a'`,
+			version: `2.x`,
+			err:     `elasticsearch 2.x has no painless, the scripts of the statement need 5.x or later`,
+		},
+		{
+			sql:     `create table logs (at date_nanos)`,
+			version: `6.x`,
+			err:     `elasticsearch 6.x has no date_nanos type, field at needs 7.x or later`,
+		},
+	}
+
+	for i, tt := range tests {
+		v, _ := sp.ParseVersion(tt.version)
+		tr, err := sp.TranslateDSL(tt.sql)
+		if err != nil {
+			t.Errorf("%d. %s: error\n\n %s", i, tt.sql, err)
+			continue
+		}
+		if err := tr.ForVersion(v); errstring(err) != tt.err {
+			t.Errorf("%d. %s for %s: error mismatch:\n  exp=%s\n  got=%s", i, tt.sql, v, tt.err, err)
+			continue
+		} else if tt.err != "" {
+			continue
+		}
+		body, _ := json.Marshal(tr.Requests[0].Body)
+		if ndjson, ok := tr.Requests[0].Body.(string); ok {
+			body = []byte(ndjson)
+		}
+		if string(body) != tt.body {
+			t.Errorf("%d. %s for %s\n\nbody mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.sql, v, tt.body, body)
+		}
+	}
+}